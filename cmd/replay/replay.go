@@ -0,0 +1,100 @@
+// This program replays audit logs of the server (see --audit-log) against a fresh multilocker.
+// It reports the wait times of the recorded and replayed acquisitions, and the points where the acquisition order differs.
+// The exit code is 2 if the order differs, so the program can be used as a regression test of the lock engine.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	f "github.com/jessevdk/go-flags"
+)
+
+var arguments struct {
+	Help                bool    `short:"h" long:"help" description:"Show help message and exit"`
+	Speed               float64 `long:"speed" default:"1" description:"Replay speed: 1 keeps the original timing, 10 replays 10 times faster, 0 replays as fast as possible"`
+	Namespace           string  `long:"namespace" description:"Replay only the namespace. Default: all namespaces"`
+	DivergenceTimeoutMs int64   `long:"divergence-timeout-ms" default:"1000" description:"How long a recorded acquisition waits for the replayed one before the divergence is reported"`
+	JSON                bool    `long:"json" description:"Print the report as JSON"`
+	Args                struct {
+		Files []string `positional-arg-name:"FILE" required:"1" description:"Audit log files. Records of all files are ordered by time"`
+	} `positional-args:"yes"`
+}
+
+func main() {
+	p := f.NewParser(&arguments, f.PassDoubleDash)
+	p.Usage = "[OPTIONS]"
+
+	if _, err := p.Parse(); err != nil {
+		if !arguments.Help {
+			fmt.Println(fmt.Errorf("cannot parse arguments: %w", err))
+		}
+
+		p.WriteHelp(os.Stdout)
+
+		if arguments.Help {
+			os.Exit(0)
+		}
+
+		os.Exit(1)
+	}
+
+	if arguments.Speed < 0 {
+		fmt.Println("--speed should be >= 0")
+		os.Exit(1)
+	}
+
+	if arguments.DivergenceTimeoutMs <= 0 {
+		fmt.Println("--divergence-timeout-ms should be > 0")
+		os.Exit(1)
+	}
+
+	records, err := ReadRecords(arguments.Args.Files, arguments.Namespace)
+	if err != nil {
+		fmt.Println(fmt.Errorf("cannot read audit log: %w", err))
+		os.Exit(1)
+	}
+
+	report := Replay(records, Options{
+		Speed:             arguments.Speed,
+		DivergenceTimeout: time.Duration(arguments.DivergenceTimeoutMs) * time.Millisecond,
+	})
+
+	if arguments.JSON {
+		out, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(out))
+	} else {
+		printReport(report)
+	}
+
+	if len(report.Divergences) > 0 {
+		os.Exit(2)
+	}
+}
+
+func printReport(report Report) {
+	fmt.Printf("Replayed %d events of %d groups\n\n", report.Events, report.Groups)
+
+	fmt.Printf("%-10s %8s %12s %12s %12s %12s %12s\n", "waits", "count", "min", "p50", "p90", "p99", "max")
+
+	for _, d := range []struct {
+		name string
+		d    Distribution
+	}{{"recorded", report.Recorded}, {"replayed", report.Replayed}} {
+		fmt.Printf("%-10s %8d %12s %12s %12s %12s %12s\n", d.name, d.d.Count, d.d.Min, d.d.P50, d.d.P90, d.d.P99, d.d.Max)
+	}
+
+	if len(report.Divergences) == 0 {
+		fmt.Println("\nThe acquisition order matches the recorded one")
+		return
+	}
+
+	fmt.Printf("\n%d divergences:\n", len(report.Divergences))
+
+	for _, d := range report.Divergences {
+		fmt.Printf("%s namespace = %s, group = %d: %s\n", d.Time.Format(time.RFC3339Nano), d.Namespace, d.GroupID, d.Reason)
+	}
+}
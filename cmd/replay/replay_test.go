@@ -0,0 +1,80 @@
+package main_test
+
+import (
+	"testing"
+	"time"
+
+	main "github.com/locktopus-project/locktopus/cmd/replay"
+	"github.com/locktopus-project/locktopus/internal/audit"
+)
+
+// testdata/audit.log has been recorded by a server with 8 clients locking overlapping resources concurrently
+const recordedLog = "testdata/audit.log"
+
+func readRecords(t *testing.T) []audit.Record {
+	records, err := main.ReadRecords([]string{recordedLog}, "")
+	if err != nil {
+		t.Fatalf("cannot read audit log: %s", err)
+	}
+
+	return records
+}
+
+func TestReplay_RecordedOrder(t *testing.T) {
+	records := readRecords(t)
+
+	report := main.Replay(records, main.Options{Speed: 0, DivergenceTimeout: time.Second})
+
+	if len(report.Divergences) > 0 {
+		t.Fatalf("acquisition order differs from the recorded one: %+v", report.Divergences)
+	}
+
+	if report.Events != len(records) || report.Groups != 48 {
+		t.Fatalf("unexpected number of events or groups: %+v", report)
+	}
+
+	if report.Recorded.Count != 48 || report.Replayed.Count != 48 {
+		t.Fatalf("all acquisitions should be counted: %+v", report)
+	}
+
+	if report.Recorded.Max < report.Recorded.P50 || report.Recorded.P50 < report.Recorded.Min {
+		t.Fatalf("invalid wait time distribution: %+v", report.Recorded)
+	}
+}
+
+func TestReplay_ScaledTiming(t *testing.T) {
+	records := readRecords(t)
+	duration := records[len(records)-1].Time.Sub(records[0].Time)
+
+	start := time.Now()
+	report := main.Replay(records, main.Options{Speed: 4, DivergenceTimeout: time.Second})
+
+	if len(report.Divergences) > 0 {
+		t.Fatalf("acquisition order differs from the recorded one: %+v", report.Divergences)
+	}
+
+	if elapsed := time.Since(start); elapsed < duration/4 {
+		t.Fatalf("replay should take %v at least, took %v", duration/4, elapsed)
+	}
+}
+
+func TestReplay_ReportDivergences(t *testing.T) {
+	at := time.Now()
+	resources := []audit.Resource{{T: "write", Path: []string{"a"}}}
+
+	// both groups have been recorded as acquired at the same time, which is not possible for write locks of the same path
+	records := []audit.Record{
+		{Time: at, Event: audit.EventLock, Namespace: "default", GroupID: 1, Resources: resources},
+		{Time: at, Event: audit.EventAcquire, Namespace: "default", GroupID: 1, Resources: resources},
+		{Time: at, Event: audit.EventLock, Namespace: "default", GroupID: 2, Resources: resources},
+		{Time: at, Event: audit.EventAcquire, Namespace: "default", GroupID: 2, Resources: resources},
+		{Time: at, Event: audit.EventRelease, Namespace: "default", GroupID: 1, Resources: resources},
+		{Time: at, Event: audit.EventRelease, Namespace: "default", GroupID: 2, Resources: resources},
+	}
+
+	report := main.Replay(records, main.Options{Speed: 0, DivergenceTimeout: 50 * time.Millisecond})
+
+	if len(report.Divergences) != 1 || report.Divergences[0].GroupID != 2 {
+		t.Fatalf("expected the acquisition of group 2 to be reported: %+v", report.Divergences)
+	}
+}
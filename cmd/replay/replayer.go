@@ -0,0 +1,416 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/locktopus-project/locktopus/internal/audit"
+	ml "github.com/locktopus-project/locktopus/pkg/multilocker"
+)
+
+// maxRecordSize limits the length of a line of the audit log
+const maxRecordSize = 1 << 20
+
+type Options struct {
+	Speed             float64       // 1 replays with the original timing, 2 twice as fast, etc. 0 means as fast as possible
+	DivergenceTimeout time.Duration // how long a recorded acquisition waits for the replayed lock before it is reported
+}
+
+var DefaultOptions = Options{
+	Speed:             1,
+	DivergenceTimeout: time.Second,
+}
+
+// Divergence is a point where the replayed acquisition order differs from the recorded one
+type Divergence struct {
+	Time      time.Time `json:"time"` // of the recorded event
+	Namespace string    `json:"namespace"`
+	GroupID   int64     `json:"groupId"`
+	Reason    string    `json:"reason"`
+}
+
+type Report struct {
+	Groups      int          `json:"groups"`
+	Events      int          `json:"events"`
+	Recorded    Distribution `json:"recorded"` // wait times of the recorded acquisitions
+	Replayed    Distribution `json:"replayed"` // wait times of the same acquisitions in the replay
+	Divergences []Divergence `json:"divergences"`
+}
+
+type Distribution struct {
+	Count int           `json:"count"`
+	Min   time.Duration `json:"min"`
+	P50   time.Duration `json:"p50"`
+	P90   time.Duration `json:"p90"`
+	P99   time.Duration `json:"p99"`
+	Max   time.Duration `json:"max"`
+}
+
+func newDistribution(waits []time.Duration) Distribution {
+	if len(waits) == 0 {
+		return Distribution{}
+	}
+
+	sorted := append([]time.Duration(nil), waits...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	percentile := func(p int) time.Duration {
+		return sorted[(len(sorted)-1)*p/100]
+	}
+
+	return Distribution{
+		Count: len(sorted),
+		Min:   sorted[0],
+		P50:   percentile(50),
+		P90:   percentile(90),
+		P99:   percentile(99),
+		Max:   sorted[len(sorted)-1],
+	}
+}
+
+// ReadRecords reads audit log files and returns their records ordered by time.
+// If namespace is not empty, records of other namespaces are skipped
+func ReadRecords(paths []string, namespace string) ([]audit.Record, error) {
+	records := []audit.Record{}
+
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), maxRecordSize)
+
+		for line := 1; scanner.Scan(); line++ {
+			if strings.TrimSpace(scanner.Text()) == "" {
+				continue
+			}
+
+			r := audit.Record{}
+			if err = json.Unmarshal(scanner.Bytes(), &r); err != nil {
+				f.Close()
+				return nil, fmt.Errorf("%s:%d: %s", path, line, err)
+			}
+
+			if namespace == "" || r.Namespace == namespace {
+				records = append(records, r)
+			}
+		}
+
+		err = scanner.Err()
+		f.Close()
+
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+	}
+
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Time.Before(records[j].Time) })
+
+	return records, nil
+}
+
+type groupKey struct {
+	namespace string
+	id        int64
+}
+
+type group struct {
+	resources []ml.ResourceLock
+	lock      *ml.Lock
+	lockedAt  time.Time
+	ready     chan struct{} // closed when readyAt is set
+	readyAt   time.Time
+
+	// index of the last recorded event the acquisition depends on: the lock itself or the release of a conflicting group.
+	// The replayed lock should not be ready before this event is replayed
+	unblockedAt int
+
+	enqueuedAt time.Time // recorded
+	acquired   bool      // recorded acquisition has been replayed
+	released   bool
+	early      bool // reported as acquired earlier than recorded
+}
+
+type replayer struct {
+	options      Options
+	records      []audit.Record
+	multilockers map[string]*ml.MultiLocker
+	groups       map[groupKey]*group
+	waiting      map[groupKey]*group // locked, but the acquisition has not been replayed yet
+
+	recordedWaits []time.Duration
+	replayedWaits []time.Duration
+	divergences   []Divergence
+}
+
+// Replay drives fresh multilockers (one per namespace) with the recorded groups and compares the acquisition order
+func Replay(records []audit.Record, options Options) Report {
+	r := &replayer{
+		options:      options,
+		records:      records,
+		multilockers: make(map[string]*ml.MultiLocker),
+		groups:       make(map[groupKey]*group),
+		waiting:      make(map[groupKey]*group),
+	}
+
+	unblockedAt := r.findUnblockingEvents()
+
+	start := time.Now()
+
+	for i, rec := range records {
+		if options.Speed > 0 {
+			time.Sleep(time.Until(start.Add(time.Duration(float64(rec.Time.Sub(records[0].Time)) / options.Speed))))
+		}
+
+		r.checkEarlyAcquisitions(i, rec.Time)
+
+		key := groupKey{rec.Namespace, rec.GroupID}
+
+		switch rec.Event {
+		case audit.EventLock:
+			r.lock(key, rec, unblockedAt[i])
+		case audit.EventAcquire:
+			g := r.groups[key]
+			if g == nil {
+				// the group has been locked before the log starts
+				g = r.lock(key, rec, i)
+			}
+
+			r.acquire(key, g, rec)
+		case audit.EventRelease, audit.EventAbandon, audit.EventRevoke:
+			if g := r.groups[key]; g != nil {
+				r.release(key, g)
+			}
+		}
+	}
+
+	r.finish()
+
+	return Report{
+		Groups:      len(r.groups),
+		Events:      len(records),
+		Recorded:    newDistribution(r.recordedWaits),
+		Replayed:    newDistribution(r.replayedWaits),
+		Divergences: r.divergences,
+	}
+}
+
+// findUnblockingEvents returns the unblocking event index for each lock event (see group.unblockedAt)
+func (r *replayer) findUnblockingEvents() map[int]int {
+	locks := make(map[groupKey]int)
+	acquired := make(map[groupKey]bool)
+	unblockedAt := make(map[int]int)
+
+	for k, rec := range r.records {
+		key := groupKey{rec.Namespace, rec.GroupID}
+
+		switch rec.Event {
+		case audit.EventLock:
+			locks[key] = k
+		case audit.EventAcquire:
+			acquired[key] = true
+
+			i, ok := locks[key]
+			if !ok {
+				continue
+			}
+
+			unblockedAt[i] = i
+
+			for j := k - 1; j > i; j-- {
+				other := r.records[j]
+
+				// groups released while pending may have been behind this one in the queue
+				if other.Namespace != rec.Namespace || !isRelease(other.Event) || !acquired[groupKey{other.Namespace, other.GroupID}] {
+					continue
+				}
+
+				if conflict(rec.Resources, other.Resources) {
+					unblockedAt[i] = j
+					break
+				}
+			}
+		}
+	}
+
+	return unblockedAt
+}
+
+func (r *replayer) lock(key groupKey, rec audit.Record, unblockedAt int) *group {
+	resources, err := resourceLocks(rec.Resources)
+	if err != nil {
+		r.diverge(rec, err.Error())
+		return nil
+	}
+
+	m := r.multilockers[key.namespace]
+	if m == nil {
+		m = ml.NewMultilocker()
+		r.multilockers[key.namespace] = m
+	}
+
+	g := &group{resources: resources, ready: make(chan struct{}), unblockedAt: unblockedAt, enqueuedAt: rec.Time, lockedAt: time.Now()}
+
+	if rec.EnqueuedAt != nil {
+		g.enqueuedAt = *rec.EnqueuedAt
+	}
+
+	g.lock = m.Lock(resources)
+
+	go func() {
+		<-g.lock.Ready()
+
+		g.readyAt = time.Now()
+		close(g.ready)
+	}()
+
+	r.groups[key] = g
+	r.waiting[key] = g
+
+	return g
+}
+
+func (r *replayer) acquire(key groupKey, g *group, rec audit.Record) {
+	if g == nil || g.acquired {
+		return
+	}
+
+	g.acquired = true
+	delete(r.waiting, key)
+
+	select {
+	case <-g.ready:
+	case <-time.After(r.options.DivergenceTimeout):
+		r.diverge(rec, fmt.Sprintf("not acquired within %s after the recorded acquisition", r.options.DivergenceTimeout))
+		return
+	}
+
+	acquiredAt := rec.Time
+	if rec.AcquiredAt != nil {
+		acquiredAt = *rec.AcquiredAt
+	}
+
+	r.recordedWaits = append(r.recordedWaits, acquiredAt.Sub(g.enqueuedAt))
+	r.replayedWaits = append(r.replayedWaits, g.readyAt.Sub(g.lockedAt))
+}
+
+func (r *replayer) release(key groupKey, g *group) {
+	if g.released {
+		return
+	}
+
+	g.released = true
+	delete(r.waiting, key)
+
+	// groups released while pending are cancelled, as the server does
+	if !g.lock.Cancel() {
+		g.lock.Acquire().Unlock()
+	}
+}
+
+// checkEarlyAcquisitions reports the groups that are ready, though the recorded event they depend on has not been replayed yet
+func (r *replayer) checkEarlyAcquisitions(i int, now time.Time) {
+	for key, g := range r.waiting {
+		if g.early || g.unblockedAt < i {
+			continue
+		}
+
+		select {
+		case <-g.ready:
+			g.early = true
+			r.diverge(audit.Record{Time: now, Namespace: key.namespace, GroupID: key.id}, "acquired earlier than recorded")
+		default:
+		}
+	}
+}
+
+// finish releases the groups held at the end of the log and waits for the multilockers to drain
+func (r *replayer) finish() {
+	for key, g := range r.groups {
+		r.release(key, g)
+	}
+
+	done := make(chan struct{})
+
+	go func() {
+		for _, m := range r.multilockers {
+			m.Close()
+		}
+
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(r.options.DivergenceTimeout):
+		r.divergences = append(r.divergences, Divergence{Reason: "groups have not been acquired after all the other groups were released"})
+	}
+}
+
+func (r *replayer) diverge(rec audit.Record, reason string) {
+	r.divergences = append(r.divergences, Divergence{Time: rec.Time, Namespace: rec.Namespace, GroupID: rec.GroupID, Reason: reason})
+}
+
+func isRelease(event string) bool {
+	return event == audit.EventRelease || event == audit.EventAbandon || event == audit.EventRevoke
+}
+
+// conflict returns true if any resources of the groups interfere: one path contains the other and one of the locks is a write lock
+func conflict(a, b []audit.Resource) bool {
+	for _, ra := range a {
+		for _, rb := range b {
+			if !isWrite(ra.T) && !isWrite(rb.T) {
+				continue
+			}
+
+			if hasPrefix(ra.Path, rb.Path) || hasPrefix(rb.Path, ra.Path) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func hasPrefix(path, prefix []string) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+
+	for i, segment := range prefix {
+		if path[i] != segment {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isWrite(t string) bool {
+	t = strings.ToLower(t)
+	return t == "w" || t == "write"
+}
+
+func resourceLocks(resources []audit.Resource) ([]ml.ResourceLock, error) {
+	locks := make([]ml.ResourceLock, len(resources))
+
+	for i, r := range resources {
+		switch strings.ToLower(r.T) {
+		case "r", "read":
+			locks[i] = ml.NewResourceLock(ml.LockTypeRead, r.Path)
+		case "w", "write":
+			locks[i] = ml.NewResourceLock(ml.LockTypeWrite, r.Path)
+		default:
+			return nil, fmt.Errorf("invalid lock type: %s", r.T)
+		}
+	}
+
+	return locks, nil
+}
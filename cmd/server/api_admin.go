@@ -0,0 +1,403 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	ns "github.com/locktopus-project/locktopus/internal/namespace"
+	ml "github.com/locktopus-project/locktopus/pkg/multilocker"
+)
+
+// Admin API requires a token with "admin": true.
+//
+//	GET    /admin/namespaces                                  list namespaces with their policies and statistics
+//	GET    /admin/namespaces/{namespace}                      get the namespace policy and statistics. {namespace} may be a policy pattern
+//	PUT    /admin/namespaces/{namespace}                      define the namespace policy. Body: policy (see README)
+//	DELETE /admin/namespaces/{namespace}                      close the namespace once it has no connections and locks. New connections are rejected meanwhile
+//	GET    /admin/namespaces/{namespace}/connections          list connections with their current locks
+//	GET    /admin/namespaces/{namespace}/groups               list unreleased locks (groups) with the earlier groups they wait for
+//	POST   /admin/namespaces/{namespace}/groups/{id}/release  release the lock (group). Its holder gets a "revoked" notification
+//	DELETE /admin/connections/{id}                            close the connection and release its lock right away
+//	POST   /admin/namespaces/{namespace}/freeze?mode=queue    freeze the namespace. New locks are enqueued behind a barrier (mode=queue) or rejected (mode=reject)
+//	GET    /admin/namespaces/{namespace}/freeze?wait=30s      get the freeze state. With "wait", long-polls until the namespace drains
+//	DELETE /admin/namespaces/{namespace}/freeze               thaw the namespace
+//	POST   /admin/drain                                       start draining the server
+//	GET    /admin/drain                                       get the drain state
+//	DELETE /admin/drain                                       cancel draining
+//	GET    /admin/shards                                      get the shard map and the namespaces being moved away
+//	PUT    /admin/shards                                      replace the shard map with a newer version. Body: shard map (see README)
+//	POST   /admin/namespaces/{namespace}/move                 move the namespace to another server once it drains. Body: {"node": "b"}
+//	GET    /admin/webhooks                                    get webhook delivery counters
+//	GET    /admin/audit                                       get audit log counters
+//	GET    /admin/limits                                      get the rate limits and the number of rejected requests
+
+type adminNamespaceResponse struct {
+	Name       string                    `json:"name"`
+	Policy     ns.Policy                 `json:"policy"`
+	Statistics *ml.MultilockerStatistics `json:"statistics"` // nil if the namespace does not exist
+}
+
+func apiAdminRouter(r *mux.Router, s *Server) {
+	r.HandleFunc("/namespaces", s.listNamespacesHandler).Methods(http.MethodGet)
+	r.HandleFunc("/namespaces/{namespace}/connections", s.listConnectionsHandler).Methods(http.MethodGet)
+	r.HandleFunc("/namespaces/{namespace}/groups", s.listGroupsHandler).Methods(http.MethodGet)
+	r.HandleFunc("/namespaces/{namespace}/groups/{id}/release", s.releaseGroupHandler).Methods(http.MethodPost)
+	r.HandleFunc("/connections/{id}", s.disconnectHandler).Methods(http.MethodDelete)
+	r.HandleFunc("/namespaces/{namespace}/freeze", s.freezeHandler).Methods(http.MethodPost)
+	r.HandleFunc("/namespaces/{namespace}/freeze", s.getFreezeHandler).Methods(http.MethodGet)
+	r.HandleFunc("/namespaces/{namespace}/freeze", s.thawHandler).Methods(http.MethodDelete)
+	r.HandleFunc("/drain", s.drainHandler).Methods(http.MethodPost)
+	r.HandleFunc("/drain", s.getDrainHandler).Methods(http.MethodGet)
+	r.HandleFunc("/drain", s.cancelDrainHandler).Methods(http.MethodDelete)
+	r.HandleFunc("/cluster", s.clusterStatusHandler).Methods(http.MethodGet)
+	r.HandleFunc("/shards", s.getShardsHandler).Methods(http.MethodGet)
+	r.HandleFunc("/shards", s.putShardsHandler).Methods(http.MethodPut)
+	r.HandleFunc("/namespaces/{namespace}/move", s.moveNamespaceHandler).Methods(http.MethodPost)
+	r.HandleFunc("/webhooks", s.webhookStatsHandler).Methods(http.MethodGet)
+	r.HandleFunc("/audit", s.auditStatsHandler).Methods(http.MethodGet)
+	r.HandleFunc("/limits", s.limitsHandler).Methods(http.MethodGet)
+	r.HandleFunc("/namespaces/{namespace}", s.getNamespaceHandler).Methods(http.MethodGet)
+	r.HandleFunc("/namespaces/{namespace}", s.putNamespaceHandler).Methods(http.MethodPut)
+	r.HandleFunc("/namespaces/{namespace}", s.deleteNamespaceHandler).Methods(http.MethodDelete)
+}
+
+func (s *Server) listNamespacesHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+
+	statsList := ns.GetStatistics()
+
+	sort.Slice(statsList, func(i, j int) bool {
+		return statsList[i].Name < statsList[j].Name
+	})
+
+	list := make([]adminNamespaceResponse, len(statsList))
+
+	for i, stats := range statsList {
+		stats := stats
+
+		list[i] = adminNamespaceResponse{
+			Name:       stats.Name,
+			Policy:     ns.GetPolicy(stats.Name),
+			Statistics: &stats.Stats,
+		}
+	}
+
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) listConnectionsHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, namespaceConnections(mux.Vars(r)["namespace"]))
+}
+
+func (s *Server) listGroupsHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, namespaceGroups(mux.Vars(r)["namespace"]))
+}
+
+func (s *Server) releaseGroupHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+
+	vars := mux.Vars(r)
+
+	id, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid group id"))
+		return
+	}
+
+	if !revokeGroup(vars["namespace"], id) && !revokeLease(vars["namespace"], id) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Lock not found"))
+		return
+	}
+
+	mainLogger.Infof("Revoked lock [namespace = %s, group = %d]", vars["namespace"], id)
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Lock has been revoked"))
+}
+
+func (s *Server) disconnectHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid connection id"))
+		return
+	}
+
+	if !disconnectConnection(id, errDisconnectedByAdmin) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Connection not found"))
+		return
+	}
+
+	mainLogger.Infof("Disconnecting connection [id = %d]", id)
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Connection is being closed"))
+}
+
+func (s *Server) freezeHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+
+	if refuseInCluster(w, "Namespaces cannot be frozen or thawed in cluster mode") {
+		return
+	}
+
+	namespace := mux.Vars(r)["namespace"]
+
+	mode := ns.FreezeModeQueue
+	if r.URL.Query().Has("mode") {
+		mode = ns.FreezeMode(r.URL.Query().Get("mode"))
+	}
+
+	if _, err := ns.Freeze(namespace, mode); err != nil {
+		if errors.Is(err, ns.ErrNamespaceNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	mainLogger.Infof("Froze namespace %s [mode = %s]", namespace, mode)
+
+	s.writeFreezeStatus(w, r, namespace, 0)
+}
+
+func (s *Server) getFreezeHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+
+	wait := time.Duration(0)
+
+	if r.URL.Query().Has("wait") {
+		var err error
+
+		wait, err = time.ParseDuration(r.URL.Query().Get("wait"))
+		if err != nil || wait < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("URL parameter 'wait' should be a non-negative duration, e.g. 30s"))
+			return
+		}
+
+		if wait > s.maxResponseDuration() {
+			wait = s.maxResponseDuration()
+		}
+	}
+
+	s.writeFreezeStatus(w, r, mux.Vars(r)["namespace"], wait)
+}
+
+func (s *Server) thawHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+
+	if refuseInCluster(w, "Namespaces cannot be frozen or thawed in cluster mode") {
+		return
+	}
+
+	namespace := mux.Vars(r)["namespace"]
+
+	if err := ns.Thaw(namespace); err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	mainLogger.Infof("Thawed namespace %s", namespace)
+
+	s.writeFreezeStatus(w, r, namespace, 0)
+}
+
+// writeFreezeStatus waits up to wait for the frozen namespace to drain, and writes its freeze state
+func (s *Server) writeFreezeStatus(w http.ResponseWriter, r *http.Request, namespace string, wait time.Duration) {
+	status, drained, err := ns.GetFreezeStatus(namespace)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	if status.Frozen && !status.Drained && wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+
+		select {
+		case <-drained:
+		case <-timer.C:
+		case <-r.Context().Done():
+			return
+		}
+
+		if status, _, err = ns.GetFreezeStatus(namespace); err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(err.Error()))
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, status)
+}
+
+type drainResponse struct {
+	Draining    bool `json:"draining"`
+	Connections int  `json:"connections"` // number of sessions still open
+}
+
+func (s *Server) drainHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+
+	startDrain()
+
+	writeJSON(w, http.StatusOK, drainResponse{Draining: true, Connections: connectionCount()})
+}
+
+func (s *Server) getDrainHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, drainResponse{Draining: draining.Load(), Connections: connectionCount()})
+}
+
+func (s *Server) cancelDrainHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+
+	stopDrain()
+
+	writeJSON(w, http.StatusOK, drainResponse{Draining: false, Connections: connectionCount()})
+}
+
+func (s *Server) getNamespaceHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+
+	namespace := mux.Vars(r)["namespace"]
+
+	writeJSON(w, http.StatusOK, adminNamespaceResponse{
+		Name:       namespace,
+		Policy:     ns.GetPolicy(namespace),
+		Statistics: ns.GetNamespaceStatistics(namespace),
+	})
+}
+
+func (s *Server) putNamespaceHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+
+	if refuseInCluster(w, "Namespace policies cannot be changed with the admin API in cluster mode: use the namespaces file of every node") {
+		return
+	}
+
+	body, err := readBody(r, s.params.RequestLimits.MaxMessageSize)
+	if errors.Is(err, errRequestTooLarge) {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	policy := ns.Policy{}
+	if err == nil {
+		err = json.Unmarshal(body, &policy)
+	}
+
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("Cannot parse request body: %s", err)))
+		return
+	}
+
+	policy.Name = mux.Vars(r)["namespace"]
+
+	if err := ns.DefinePolicy(policy); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	mainLogger.Infof("Defined namespace policy %s: %+v", policy.Name, policy)
+
+	writeJSON(w, http.StatusOK, adminNamespaceResponse{
+		Name:       policy.Name,
+		Policy:     ns.GetPolicy(policy.Name),
+		Statistics: ns.GetNamespaceStatistics(policy.Name),
+	})
+}
+
+func (s *Server) deleteNamespaceHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+
+	if refuseInCluster(w, "Namespace policies cannot be changed with the admin API in cluster mode: use the namespaces file of every node") {
+		return
+	}
+
+	namespace := mux.Vars(r)["namespace"]
+
+	deleted, err := ns.DeleteNamespace(namespace)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	mainLogger.Infof("Deleting namespace %s once it drains", namespace)
+
+	go func() {
+		<-deleted
+		mainLogger.Infof("Deleted namespace %s", namespace)
+	}()
+
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte("Namespace will be closed once it has no connections and locks"))
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	serialized, err := json.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Cannot serialize response"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(serialized)
+}
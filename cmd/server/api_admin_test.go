@@ -0,0 +1,302 @@
+package main_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/locktopus-project/locktopus/internal/constants"
+	locktopusclient "github.com/locktopus-project/locktopus/pkg/client/v1"
+)
+
+const adminNamespaceName = "admin_namespace"
+
+type adminConnection struct {
+	ID      int64  `json:"id"`
+	GroupID int64  `json:"groupId"`
+	State   string `json:"state"`
+}
+
+func adminRequest(t *testing.T, address, method, path string, response interface{}) int {
+	req, err := http.NewRequest(method, fmt.Sprintf("http://%s/admin%s", address, path), nil)
+	if err != nil {
+		t.Fatalf("cannot make request: %s", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("cannot query Locktopus server: %s", err)
+	}
+
+	defer resp.Body.Close()
+
+	if response != nil && resp.StatusCode == http.StatusOK {
+		if err = json.NewDecoder(resp.Body).Decode(response); err != nil {
+			t.Fatalf("cannot parse response body: %s", err)
+		}
+	}
+
+	return resp.StatusCode
+}
+
+func adminConnections(t *testing.T, address string) []adminConnection {
+	list := []adminConnection{}
+	adminRequest(t, address, http.MethodGet, "/namespaces/"+adminNamespaceName+"/connections", &list)
+
+	return list
+}
+
+func TestAdmin_RevokeAndDisconnect(t *testing.T) {
+	address := startAdminTestServer(t)
+
+	makeClient := func() *locktopusclient.LocktopusClient {
+		client, err := locktopusclient.MakeClient(locktopusclient.ConnectionOptions{
+			Url:   fmt.Sprintf("ws://%s/v1?%s=%s", address, constants.NamespaceQueryParameterName, adminNamespaceName),
+			Token: authToken,
+		})
+		if err != nil {
+			t.Fatalf("cannot connect to Locktopus server: %s", err)
+		}
+
+		client.AddLockResource(locktopusclient.LockTypeWrite, "stuck")
+
+		if err = client.Lock(); err != nil {
+			t.Fatalf("cannot lock: %s", err)
+		}
+
+		return client
+	}
+
+	holder := makeClient()
+	defer holder.Close()
+
+	waiter := makeClient()
+	defer waiter.Close()
+
+	namespaces := []struct{ Name string }{}
+	adminRequest(t, address, http.MethodGet, "/namespaces", &namespaces)
+
+	found := false
+	for _, ns := range namespaces {
+		found = found || ns.Name == adminNamespaceName
+	}
+
+	if !found {
+		t.Fatalf("namespace should be listed: %v", namespaces)
+	}
+
+	connections := adminConnections(t, address)
+	if len(connections) != 2 || connections[0].State != "acquired" || connections[1].State != "enqueued" {
+		t.Fatalf("unexpected connections: %+v", connections)
+	}
+
+	status := adminRequest(t, address, http.MethodPost, fmt.Sprintf("/namespaces/%s/groups/%s/release", adminNamespaceName, holder.LockID()), nil)
+	if status != http.StatusOK {
+		t.Fatalf("Status code is not 200")
+	}
+
+	select {
+	case <-holder.Revoked():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("holder should be notified of the revoked lock")
+	}
+
+	if err := waiter.Acquire(); err != nil {
+		t.Fatalf("waiter should acquire the lock: %s", err)
+	}
+
+	if err := holder.Release(); err != nil {
+		t.Fatalf("holder should be able to release the revoked lock: %s", err)
+	}
+
+	if status = adminRequest(t, address, http.MethodDelete, fmt.Sprintf("/connections/%d", connections[1].ID), nil); status != http.StatusOK {
+		t.Fatalf("Status code is not 200")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for len(adminConnections(t, address)) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("disconnected connection should be closed")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	holder.AddLockResource(locktopusclient.LockTypeWrite, "stuck")
+
+	if err := holder.Lock(); err != nil || !holder.IsAcquired() {
+		t.Fatalf("lock of the disconnected connection should be released right away: %v", err)
+	}
+}
+
+type freezeStatus struct {
+	Frozen  bool   `json:"frozen"`
+	Mode    string `json:"mode"`
+	Drained bool   `json:"drained"`
+}
+
+const namespaceFrozenCode = 3004
+
+func TestAdmin_Freeze(t *testing.T) {
+	address := startAdminTestServer(t)
+	namespace := "admin_frozen"
+	url := fmt.Sprintf("ws://%s/v1?%s=%s", address, constants.NamespaceQueryParameterName, namespace)
+
+	holder, err := locktopusclient.MakeClient(locktopusclient.ConnectionOptions{Url: url, Token: authToken})
+	if err != nil {
+		t.Fatalf("cannot connect to Locktopus server: %s", err)
+	}
+	defer holder.Close()
+
+	holder.AddLockResource(locktopusclient.LockTypeWrite, "a")
+
+	if err = holder.Lock(); err != nil {
+		t.Fatalf("cannot lock: %s", err)
+	}
+
+	status := freezeStatus{}
+	if code := adminRequest(t, address, http.MethodPost, "/namespaces/"+namespace+"/freeze", &status); code != http.StatusOK {
+		t.Fatalf("Status code is not 200")
+	}
+
+	if !status.Frozen || status.Mode != "queue" || status.Drained {
+		t.Fatalf("namespace should be frozen and not drained: %+v", status)
+	}
+
+	waiter, err := locktopusclient.MakeClient(locktopusclient.ConnectionOptions{Url: url, Token: authToken})
+	if err != nil {
+		t.Fatalf("cannot connect to Locktopus server: %s", err)
+	}
+	defer waiter.Close()
+
+	waiter.AddLockResource(locktopusclient.LockTypeWrite, "b")
+
+	if err = waiter.Lock(); err != nil {
+		t.Fatalf("cannot lock: %s", err)
+	}
+
+	if waiter.IsAcquired() {
+		t.Fatalf("lock made after the freeze should be enqueued")
+	}
+
+	if err = holder.Release(); err != nil {
+		t.Fatalf("cannot release: %s", err)
+	}
+
+	adminRequest(t, address, http.MethodGet, "/namespaces/"+namespace+"/freeze?wait=5s", &status)
+	if !status.Drained {
+		t.Fatalf("namespace should be drained: %+v", status)
+	}
+
+	if code := adminRequest(t, address, http.MethodDelete, "/namespaces/"+namespace+"/freeze", &status); code != http.StatusOK || status.Frozen {
+		t.Fatalf("namespace should be thawed: %+v", status)
+	}
+
+	if err = waiter.Acquire(); err != nil {
+		t.Fatalf("lock should be acquired after thaw: %s", err)
+	}
+
+	waiter.Release()
+
+	adminRequest(t, address, http.MethodPost, "/namespaces/"+namespace+"/freeze?mode=reject", &status)
+
+	conn, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("%s&%s=%s", url, constants.AccessTokenQueryParameterName, authToken), nil)
+	if err != nil {
+		t.Fatalf("cannot connect to Locktopus server: %s", err)
+	}
+	defer conn.Close()
+
+	err = conn.WriteJSON(map[string]interface{}{
+		"action":    "lock",
+		"resources": []map[string]interface{}{{"type": "read", "path": []string{"c"}}},
+	})
+	if err != nil {
+		t.Fatalf("cannot write request: %s", err)
+	}
+
+	for err == nil {
+		_, _, err = conn.ReadMessage()
+	}
+
+	if !websocket.IsCloseError(err, namespaceFrozenCode) {
+		t.Fatalf("connection should be closed with code %d, got: %s", namespaceFrozenCode, err)
+	}
+
+	adminRequest(t, address, http.MethodDelete, "/namespaces/"+namespace+"/freeze", nil)
+}
+
+// Thawing before the drain ends the freeze at once, while the locks made after the freeze still wait for the drain
+func TestAdmin_ThawBeforeDrain(t *testing.T) {
+	address := startAdminTestServer(t)
+	namespace := "admin_thawed_early"
+	url := fmt.Sprintf("ws://%s/v1?%s=%s", address, constants.NamespaceQueryParameterName, namespace)
+
+	holder, err := locktopusclient.MakeClient(locktopusclient.ConnectionOptions{Url: url, Token: authToken})
+	if err != nil {
+		t.Fatalf("cannot connect to Locktopus server: %s", err)
+	}
+	defer holder.Close()
+
+	holder.AddLockResource(locktopusclient.LockTypeWrite, "a")
+
+	if err = holder.Lock(); err != nil {
+		t.Fatalf("cannot lock: %s", err)
+	}
+
+	status := freezeStatus{}
+	if code := adminRequest(t, address, http.MethodPost, "/namespaces/"+namespace+"/freeze", &status); code != http.StatusOK {
+		t.Fatalf("Status code is not 200")
+	}
+
+	waiter, err := locktopusclient.MakeClient(locktopusclient.ConnectionOptions{Url: url, Token: authToken})
+	if err != nil {
+		t.Fatalf("cannot connect to Locktopus server: %s", err)
+	}
+	defer waiter.Close()
+
+	waiter.AddLockResource(locktopusclient.LockTypeWrite, "b")
+
+	if err = waiter.Lock(); err != nil {
+		t.Fatalf("cannot lock: %s", err)
+	}
+
+	if code := adminRequest(t, address, http.MethodDelete, "/namespaces/"+namespace+"/freeze", &status); code != http.StatusOK || status.Frozen {
+		t.Fatalf("namespace should be thawed: %+v", status)
+	}
+
+	if adminRequest(t, address, http.MethodGet, "/namespaces/"+namespace+"/freeze", &status); status.Frozen {
+		t.Fatalf("namespace should not be frozen after thaw: %+v", status)
+	}
+
+	acquired := make(chan error, 1)
+
+	go func() {
+		acquired <- waiter.Acquire()
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatalf("lock made after the freeze should wait for the drain")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	if err = holder.Release(); err != nil {
+		t.Fatalf("cannot release: %s", err)
+	}
+
+	select {
+	case err = <-acquired:
+		if err != nil {
+			t.Fatalf("cannot acquire: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("lock made after the freeze should be acquired after the drain")
+	}
+}
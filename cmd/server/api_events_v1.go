@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/locktopus-project/locktopus/internal/constants"
+	ns "github.com/locktopus-project/locktopus/internal/namespace"
+)
+
+// Server-Sent Events stream of a namespace: statistics snapshots and lifecycle events of the locks enqueued after subscribing.
+// Events are queued to a bounded buffer of the subscriber without blocking, so a slow subscriber never delays locking:
+// events exceeding the buffer are dropped and counted in the next snapshot
+
+const eventStreamBufferSize = 256
+const defaultEventStreamInterval = time.Second
+const minEventStreamInterval = 100 * time.Millisecond
+
+// Streams end before the write timeout. EventSource clients reconnect after eventStreamRetry
+const eventStreamRetry = time.Second
+
+type streamedLockEvent struct {
+	Event     lockEvent  `json:"event"`
+	GroupID   int64      `json:"groupId"`
+	Resources []resource `json:"resources"`
+	Time      time.Time  `json:"time"`
+	HeldMs    *int64     `json:"heldMs,omitempty"` // for releases of acquired locks
+}
+
+type streamedStats struct {
+	statsV1Response
+	Dropped int64 // events dropped since the subscriber has connected
+}
+
+type eventSubscriber struct {
+	prefix  []string
+	events  chan streamedLockEvent
+	dropped int64
+}
+
+var eventSubscribers = make(map[string]map[*eventSubscriber]struct{})
+var eventSubscribersMx = sync.RWMutex{}
+
+func subscribeEvents(namespace string, prefix []string) *eventSubscriber {
+	s := &eventSubscriber{prefix: prefix, events: make(chan streamedLockEvent, eventStreamBufferSize)}
+
+	eventSubscribersMx.Lock()
+	defer eventSubscribersMx.Unlock()
+
+	if eventSubscribers[namespace] == nil {
+		eventSubscribers[namespace] = make(map[*eventSubscriber]struct{})
+	}
+
+	eventSubscribers[namespace][s] = struct{}{}
+
+	return s
+}
+
+func unsubscribeEvents(namespace string, s *eventSubscriber) {
+	eventSubscribersMx.Lock()
+	defer eventSubscribersMx.Unlock()
+
+	delete(eventSubscribers[namespace], s)
+
+	if len(eventSubscribers[namespace]) == 0 {
+		delete(eventSubscribers, namespace)
+	}
+}
+
+func hasEventSubscribers(namespace string) bool {
+	eventSubscribersMx.RLock()
+	defer eventSubscribersMx.RUnlock()
+
+	return len(eventSubscribers[namespace]) > 0
+}
+
+// publishLockEvent queues the event to the subscribers of the namespace whose prefix matches the resources
+func publishLockEvent(namespace string, e streamedLockEvent) {
+	eventSubscribersMx.RLock()
+	defer eventSubscribersMx.RUnlock()
+
+	if len(eventSubscribers[namespace]) == 0 {
+		return
+	}
+
+	for s := range eventSubscribers[namespace] {
+		if !underPrefix(e.Resources, s.prefix) {
+			continue
+		}
+
+		select {
+		case s.events <- e:
+		default:
+			atomic.AddInt64(&s.dropped, 1)
+		}
+	}
+}
+
+// underPrefix returns true if any of the resources is under the prefix
+func underPrefix(resources []resource, prefix []string) bool {
+	for _, r := range resources {
+		if isPathPrefix(r.Path, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func eventsV1Handler(w http.ResponseWriter, r *http.Request, s *Server) {
+	query := r.URL.Query()
+	nsParam := query.Get(constants.NamespaceQueryParameterName)
+
+	if nsParam == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("URL parameter '%s' is required", constants.NamespaceQueryParameterName)))
+		return
+	}
+
+	if redirectMisrouted(w, r, nsParam) {
+		return
+	}
+
+	if _, ok := s.authorizeNamespace(w, r, nsParam); !ok {
+		return
+	}
+
+	interval := defaultEventStreamInterval
+
+	if query.Has("interval") {
+		var err error
+
+		interval, err = time.ParseDuration(query.Get("interval"))
+		if err != nil || interval < minEventStreamInterval {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("URL parameter 'interval' should be a duration of %v at least, e.g. 5s", minEventStreamInterval)))
+			return
+		}
+	}
+
+	var prefix []string
+	if p := strings.Trim(query.Get("prefix"), "/"); p != "" {
+		prefix = strings.Split(p, "/")
+	}
+
+	if ns.GetNamespaceStatistics(nsParam) == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Namespace not found"))
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Streaming is not supported"))
+		return
+	}
+
+	subscriber := subscribeEvents(nsParam, prefix)
+	defer unsubscribeEvents(nsParam, subscriber)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprintf(w, "retry: %d\n\n", eventStreamRetry.Milliseconds())
+
+	writeEvent := func(name string, v interface{}) bool {
+		data, err := json.Marshal(v)
+		if err != nil {
+			apiLogger.Errorf("Cannot serialize %s event: %s", name, err)
+			return true
+		}
+
+		if _, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, data); err != nil {
+			return false
+		}
+
+		flusher.Flush()
+
+		return true
+	}
+
+	writeStats := func() bool {
+		stats := ns.GetNamespaceStatistics(nsParam)
+		if stats == nil {
+			// the namespace has been deleted meanwhile
+			return true
+		}
+
+		return writeEvent("stats", streamedStats{
+			statsV1Response: statsV1Response{MultilockerStatistics: *stats, Clients: namespaceClients(nsParam), Rejected: namespaceRejected(nsParam)},
+			Dropped:         atomic.LoadInt64(&subscriber.dropped),
+		})
+	}
+
+	if !writeStats() {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	deadline := time.NewTimer(s.maxResponseDuration())
+	defer deadline.Stop()
+
+	for {
+		select {
+		case e := <-subscriber.events:
+			if !writeEvent("lock", e) {
+				return
+			}
+		case <-ticker.C:
+			if !writeStats() {
+				return
+			}
+		case <-deadline.C:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
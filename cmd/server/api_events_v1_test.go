@@ -0,0 +1,138 @@
+package main_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/locktopus-project/locktopus/internal/constants"
+	locktopusclient "github.com/locktopus-project/locktopus/pkg/client/v1"
+)
+
+type streamEvent struct {
+	name string
+	data map[string]interface{}
+}
+
+// subscribeEvents reads the stream of the namespace in the background
+func subscribeEvents(t *testing.T, namespace, query string) <-chan streamEvent {
+	url := fmt.Sprintf("http://%s/events_v1?%s=%s&%s", serverAddress, constants.NamespaceQueryParameterName, namespace, query)
+
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("cannot query Locktopus server: %s", err)
+	}
+
+	t.Cleanup(func() { res.Body.Close() })
+
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("unexpected response: %s %v", res.Status, res.Header)
+	}
+
+	ch := make(chan streamEvent, 100)
+
+	go func() {
+		defer close(ch)
+
+		scanner := bufio.NewScanner(res.Body)
+		e := streamEvent{}
+
+		for scanner.Scan() {
+			line := scanner.Text()
+
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				e.name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e.data)
+			case line == "" && e.name != "":
+				ch <- e
+				e = streamEvent{}
+			}
+		}
+	}()
+
+	return ch
+}
+
+func nextStreamEvent(t *testing.T, ch <-chan streamEvent, name string) streamEvent {
+	timeout := time.After(5 * time.Second)
+
+	for {
+		select {
+		case e, ok := <-ch:
+			if !ok {
+				t.Fatalf("stream has been closed before %s event", name)
+			}
+
+			if e.name == name {
+				return e
+			}
+		case <-timeout:
+			t.Fatalf("%s event has not been streamed", name)
+		}
+	}
+}
+
+func TestEvents_NamespaceNotFound(t *testing.T) {
+	res, err := http.Get(fmt.Sprintf("http://%s/events_v1?%s=events_missing", serverAddress, constants.NamespaceQueryParameterName))
+	if err != nil {
+		t.Fatalf("cannot query Locktopus server: %s", err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404, got %s", res.Status)
+	}
+}
+
+func TestEvents_StreamStatsAndLockEvents(t *testing.T) {
+	namespace := "events_stream"
+
+	holder := connectWebhookClient(t, serverAddress, namespace)
+
+	events := subscribeEvents(t, namespace, "prefix=deploy/prod&interval=100ms")
+
+	stats := nextStreamEvent(t, events, "stats")
+	if _, ok := stats.data["LastGroupID"]; !ok || stats.data["Dropped"] != float64(0) {
+		t.Fatalf("unexpected stats event: %v", stats.data)
+	}
+
+	// not under the prefix
+	holder.AddLockResource(locktopusclient.LockTypeWrite, "deploy", "staging")
+	if err := holder.Lock(); err != nil {
+		t.Fatalf("cannot lock: %s", err)
+	}
+
+	if err := holder.Release(); err != nil {
+		t.Fatalf("cannot release: %s", err)
+	}
+
+	client := connectWebhookClient(t, serverAddress, namespace)
+
+	client.AddLockResource(locktopusclient.LockTypeWrite, "deploy", "prod", "api")
+	if err := client.Lock(); err != nil {
+		t.Fatalf("cannot lock: %s", err)
+	}
+
+	if err := client.Release(); err != nil {
+		t.Fatalf("cannot release: %s", err)
+	}
+
+	for _, expected := range []string{"enqueued", "acquired", "released"} {
+		e := nextStreamEvent(t, events, "lock")
+
+		if e.data["event"] != expected || fmt.Sprintf("%v", e.data["groupId"]) != client.LockID() {
+			t.Fatalf("expected %s event of group %s, got %v", expected, client.LockID(), e.data)
+		}
+	}
+
+	stats = nextStreamEvent(t, events, "stats")
+	if stats.data["LastGroupID"] != float64(2) {
+		t.Fatalf("stats should be streamed periodically: %v", stats.data)
+	}
+}
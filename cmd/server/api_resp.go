@@ -0,0 +1,609 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/locktopus-project/locktopus/internal/auth"
+	ns "github.com/locktopus-project/locktopus/internal/namespace"
+	"github.com/locktopus-project/locktopus/internal/resp"
+	ml "github.com/locktopus-project/locktopus/pkg/multilocker"
+)
+
+// RespServer accepts RESP (Redis protocol) connections, so redis-cli and Redis client libraries can be used as Locktopus clients.
+// Use MakeRespServer to create one.
+//
+// Supported commands:
+//
+//	AUTH <token>                                          required if the server uses tokens
+//	LOCK <namespace> <type>:<path> [<type>:<path> ...]   e.g. LOCK default w:a/b r:c
+//	     [OWNER <owner>] [LABEL <name>=<value> ...]       e.g. LOCK default w:a OWNER worker-1 LABEL job=billing
+//	RELEASE
+//	STATUS
+//	STATS <namespace>
+//	PING [message], HELLO [2|3], QUIT
+//
+// LOCK and RELEASE reply with [action, id, state]. After HELLO 3, [lock, id, acquired] is pushed when an enqueued lock gets acquired,
+// [revoked, id, ready] when the lock is released with the admin API, and [contended, id, waiters] whenever another lock becomes
+// blocked on the acquired one. RESP2 clients receive no pushes and poll STATUS.
+type RespServer struct {
+	Addr                         string
+	defaultAbandonTimeout        time.Duration
+	defaultPendingAbandonTimeout *time.Duration
+	authenticator                *auth.Authenticator
+	requestLimits                RequestLimits
+	mx                           sync.Mutex
+	listener                     net.Listener
+}
+
+func MakeRespServer(params ServerParameters) *RespServer {
+	return &RespServer{
+		Addr:                         fmt.Sprintf("%s:%s", params.Hostname, params.RespPort),
+		defaultAbandonTimeout:        params.DefaultAbandonTimeout,
+		defaultPendingAbandonTimeout: params.DefaultPendingAbandonTimeout,
+		authenticator:                params.Authenticator,
+		requestLimits:                params.RequestLimits,
+	}
+}
+
+func StartRespListening(server *RespServer) <-chan error {
+	mainLogger.Info("Starting RESP listening on", server.Addr)
+
+	ch := make(chan error, 1)
+
+	go func() {
+		if err := keepServing("resp://"+server.Addr, server.ListenAndServe); err != nil {
+			ch <- fmt.Errorf("RESP listener error: %w", err)
+		}
+	}()
+
+	return ch
+}
+
+func (s *RespServer) ListenAndServe() error {
+	listener, err := listen("resp://"+s.Addr, func() (net.Listener, error) {
+		return net.Listen("tcp", s.Addr)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mx.Lock()
+	s.listener = listener
+	s.mx.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+
+		go s.handleConnection(conn)
+	}
+}
+
+func (s *RespServer) Close() error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if s.listener == nil {
+		return nil
+	}
+
+	return s.listener.Close()
+}
+
+func (s *RespServer) handleConnection(conn net.Conn) {
+	defer conn.Close()
+
+	if draining.Load() {
+		resp.NewWriter(conn).WriteError("DRAINING " + drainingReason)
+		return
+	}
+
+	if reason := notLeaderReason(); reason != "" {
+		resp.NewWriter(conn).WriteError("NOTLEADER " + reason)
+		return
+	}
+
+	if err := acquireConnectionSlot("", conn.RemoteAddr().String()); err != nil {
+		resp.NewWriter(conn).WriteError(fmt.Sprintf("RATELIMITED %s", err))
+		return
+	}
+	defer releaseConnectionSlot(conn.RemoteAddr().String())
+
+	connID := atomic.AddInt64(&lastConnID, 1)
+
+	apiLogger.Infof("New RESP connection from %s [id = %d]", conn.RemoteAddr(), connID)
+
+	registerConnection(&connection{
+		id:         connID,
+		remoteAddr: conn.RemoteAddr().String(),
+	})
+	defer unregisterConnection(connID)
+
+	rc := &respConn{
+		id:                           connID,
+		defaultAbandonTimeout:        s.defaultAbandonTimeout,
+		defaultPendingAbandonTimeout: s.defaultPendingAbandonTimeout,
+		auth:                         s.authenticator,
+		limiter:                      newLockLimiter(),
+		limits:                       s.requestLimits,
+		r:                            resp.NewReader(conn, s.requestLimits.MaxMessageSize),
+		w:                            resp.NewWriter(conn),
+		replied:                      make(chan struct{}, 1),
+		done:                         make(chan struct{}),
+		state:                        clientStateReady,
+	}
+
+	// RESP sessions cannot be resumed, so they are not durable. The abandon policy of the namespace is passed with each lock
+	err := handleCommunication(rc, nil, "", connID, abandonPolicy{}, nil, nil)
+	close(rc.done)
+	rc.releaseNamespace()
+
+	if errors.Is(err, errDraining) {
+		rc.writeError("DRAINING " + closeReason(err))
+
+		apiLogger.Infof("Connection closed [id = %d]: %s", connID, err.Error())
+
+		return
+	}
+
+	if errors.Is(err, errRequestTooLarge) {
+		// the rest of the command is not read, so the connection cannot be used anymore
+		rc.writeError(fmt.Sprintf("TOOLARGE %s", err))
+
+		apiLogger.Infof("Connection closed [id = %d]: %s", connID, err.Error())
+
+		return
+	}
+
+	if errors.Is(err, errNotLeader) {
+		rc.writeError("NOTLEADER " + notLeaderReason())
+
+		apiLogger.Infof("Connection closed [id = %d]: %s", connID, err.Error())
+
+		return
+	}
+
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, errRespQuit) {
+		rc.writeError(fmt.Errorf("communication error: %w", err).Error())
+
+		apiLogger.Infof("Connection closed [id = %d]: %s", connID, err.Error())
+
+		return
+	}
+
+	apiLogger.Infof("Closing connection [id = %d]", connID)
+}
+
+var errRespQuit = errors.New("client sent QUIT")
+
+// respConn translates RESP commands into requestMessage's. Commands that do not change the lock state are answered right away.
+type respConn struct {
+	id                           int64
+	defaultAbandonTimeout        time.Duration
+	defaultPendingAbandonTimeout *time.Duration
+	auth                         *auth.Authenticator
+	token                        *auth.Token
+	limiter                      *lockLimiter
+	limits                       RequestLimits
+	namespaceName                string
+	namespace                    *ml.MultiLocker
+	r                            *resp.Reader
+	w                            *resp.Writer
+	wmx                          sync.Mutex
+	resp3                        bool
+
+	// replied signals that the response to the last LOCK/RELEASE has been written, so replies keep the order of commands
+	replied      chan struct{}
+	awaitReply   bool
+	done         chan struct{}
+	lastID       string
+	lastAction   action
+	state        ClientState
+	enqueuedLock string
+}
+
+func (c *respConn) ReadRequest(m *requestMessage) error {
+	if c.awaitReply {
+		select {
+		case <-c.replied:
+		case <-c.done:
+			return io.EOF
+		}
+
+		c.awaitReply = false
+	}
+
+	for {
+		args, err := c.r.ReadCommand()
+		if errors.Is(err, resp.ErrTooLarge) {
+			return fmt.Errorf("%w: %s", errRequestTooLarge, err)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		name := args[0]
+		args = args[1:]
+
+		switch strings.ToUpper(name) {
+		case "LOCK":
+			if err = c.parseLock(args, m); err != nil {
+				c.writeError(err.Error())
+				continue
+			}
+		case "RELEASE":
+			m.Action = actionRelease
+		case "STATUS":
+			c.writeStatus()
+			continue
+		case "STATS":
+			c.writeStats(args)
+			continue
+		case "AUTH":
+			c.authenticate(args)
+			continue
+		case "PING":
+			c.writePing(args)
+			continue
+		case "HELLO":
+			c.writeHello(args)
+			continue
+		case "QUIT":
+			c.write(func(w *resp.Writer) error { return w.WriteSimpleString("OK") })
+			return errRespQuit
+		default:
+			c.writeError(fmt.Sprintf("ERR unknown command '%s'", name))
+			continue
+		}
+
+		c.awaitReply = true
+
+		return nil
+	}
+}
+
+func (c *respConn) parseLock(args []string, m *requestMessage) error {
+	// the state is checked first, so a lock that would be refused does not use the namespace or a rate limit token
+	c.wmx.Lock()
+	state := c.state
+	c.wmx.Unlock()
+
+	if state != clientStateReady {
+		return fmt.Errorf("ERR cannot lock in state %s, release the lock first", state)
+	}
+
+	if len(args) < 2 {
+		return errors.New("ERR wrong number of arguments for 'lock' command")
+	}
+
+	var owner string
+	var labels map[string]string
+	resources := []resource{}
+
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+
+		switch strings.ToUpper(arg) {
+		case "OWNER", "LABEL":
+			if i+1 == len(args) {
+				return fmt.Errorf("ERR missing value of %s", strings.ToUpper(arg))
+			}
+
+			i++
+
+			if strings.EqualFold(arg, "OWNER") {
+				owner = args[i]
+				continue
+			}
+
+			name, value, ok := strings.Cut(args[i], "=")
+			if !ok {
+				return fmt.Errorf("ERR invalid label '%s', expected <name>=<value>", args[i])
+			}
+
+			if labels == nil {
+				labels = map[string]string{}
+			}
+
+			labels[name] = value
+
+			continue
+		}
+
+		t, p, ok := strings.Cut(arg, ":")
+		if !ok {
+			return fmt.Errorf("ERR invalid resource '%s', expected <type>:<path>", arg)
+		}
+
+		if _, err := parseLockType(t); err != nil {
+			return fmt.Errorf("ERR %s", err)
+		}
+
+		path := []string{}
+		if p != "" {
+			path = strings.Split(p, "/")
+		}
+
+		resources = append(resources, resource{T: t, Path: path})
+	}
+
+	if len(resources) == 0 {
+		return errors.New("ERR wrong number of arguments for 'lock' command")
+	}
+
+	if err := c.limits.checkResources(resources); err != nil {
+		return fmt.Errorf("TOOLARGE %s", err)
+	}
+
+	if err := checkMetadata(owner, labels); err != nil {
+		if errors.Is(err, errRequestTooLarge) {
+			return fmt.Errorf("TOOLARGE %s", err)
+		}
+
+		return fmt.Errorf("ERR %s", err)
+	}
+
+	resourceLocks, err := makeResourceLocks(resources)
+	if err != nil {
+		return fmt.Errorf("ERR %s", err)
+	}
+
+	if err = shardError(args[0]); err != nil {
+		return err
+	}
+
+	if err = c.authorize(args[0], resourceLocks); err != nil {
+		return err
+	}
+
+	namespace, err := c.useNamespace(args[0])
+	if err != nil {
+		return fmt.Errorf("ERR %s", err)
+	}
+
+	if err = ns.CheckLock(args[0], resourceLocks); err != nil {
+		if errors.Is(err, ns.ErrNamespaceFrozen) {
+			return fmt.Errorf("FROZEN %s", err)
+		}
+
+		return fmt.Errorf("LIMIT %s", err)
+	}
+
+	holder := connectionHolder(c.id)
+	if err = c.limiter.checkLock(args[0], holder.client, holder.remoteAddr); err != nil {
+		return fmt.Errorf("RATELIMITED %s", err)
+	}
+
+	updateConnection(c.id, func(conn *connection) {
+		conn.namespace = args[0]
+	})
+
+	m.Action = actionLock
+	m.Resources = resources
+	m.Owner = owner
+	m.Labels = labels
+	m.namespace = namespace
+	m.namespaceName = args[0]
+	if m.abandon, err = makeAbandonPolicy(ns.GetPolicy(args[0]), c.defaultAbandonTimeout, c.defaultPendingAbandonTimeout, nil); err != nil {
+		return fmt.Errorf("ERR %s", err)
+	}
+
+	return nil
+}
+
+// useNamespace keeps the last locked namespace in use until the connection locks in another one or closes
+func (c *respConn) useNamespace(name string) (*ml.MultiLocker, error) {
+	if name == c.namespaceName {
+		return c.namespace, nil
+	}
+
+	namespace, created, err := ns.GetNamespace(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if created {
+		mainLogger.Infof("Created new multilocker namespace %s", name)
+	}
+
+	c.releaseNamespace()
+
+	c.namespaceName = name
+	c.namespace = namespace
+
+	return namespace, nil
+}
+
+func (c *respConn) releaseNamespace() {
+	if c.namespaceName != "" {
+		ns.ReleaseNamespace(c.namespaceName)
+	}
+}
+
+func (c *respConn) WriteResponse(m responseMessage) error {
+	c.wmx.Lock()
+	defer c.wmx.Unlock()
+
+	if m.Action == actionContended {
+		return c.push(string(m.Action), m.ID, strconv.Itoa(m.Waiters))
+	}
+
+	c.lastID = m.ID
+	c.lastAction = m.Action
+	c.state = stateFromString(m.State)
+
+	if m.Action == actionLock && m.State == clientStateAcquired.String() && m.ID == c.enqueuedLock {
+		c.enqueuedLock = ""
+
+		return c.push(string(m.Action), m.ID, m.State)
+	}
+
+	if m.Action == actionRevoked {
+		c.enqueuedLock = ""
+
+		return c.push(string(m.Action), m.ID, m.State)
+	}
+
+	if m.Action == actionLock && m.State == clientStateEnqueued.String() {
+		c.enqueuedLock = m.ID
+	}
+
+	err := c.w.WriteArray(string(m.Action), m.ID, m.State)
+
+	c.replied <- struct{}{}
+
+	return err
+}
+
+// push writes an unsolicited message to RESP3 clients. RESP2 has no out-of-band pushes, so an unsolicited reply would be taken
+// for the reply to the next command. RESP2 clients poll STATUS instead.
+func (c *respConn) push(items ...string) error {
+	if !c.resp3 {
+		return nil
+	}
+
+	return c.w.WritePush(true, items...)
+}
+
+func (c *respConn) write(f func(w *resp.Writer) error) {
+	c.wmx.Lock()
+	defer c.wmx.Unlock()
+
+	f(c.w)
+}
+
+func (c *respConn) writeError(msg string) {
+	c.write(func(w *resp.Writer) error { return w.WriteError(msg) })
+}
+
+// authenticate accepts both AUTH <token> and AUTH <username> <token>
+func (c *respConn) authenticate(args []string) {
+	if len(args) == 0 || len(args) > 2 {
+		c.writeError("ERR wrong number of arguments for 'auth' command")
+		return
+	}
+
+	if c.auth == nil {
+		c.writeError("ERR AUTH called without any tokens configured")
+		return
+	}
+
+	token, err := c.auth.Authenticate(args[len(args)-1])
+	if err != nil {
+		c.writeError("WRONGPASS invalid token")
+		return
+	}
+
+	c.token = token
+
+	updateConnection(c.id, func(conn *connection) {
+		conn.clientIdentity = token.Name
+	})
+
+	c.write(func(w *resp.Writer) error { return w.WriteSimpleString("OK") })
+}
+
+// authorize checks access to the namespace and the permission to lock the resources
+func (c *respConn) authorize(namespace string, resourceLocks []ml.ResourceLock) error {
+	if c.auth == nil {
+		return nil
+	}
+
+	if c.token == nil {
+		return errors.New("NOAUTH Authentication required.")
+	}
+
+	if err := c.token.AuthorizeLocks(namespace, resourceLocks); err != nil {
+		return fmt.Errorf("NOPERM %s", err)
+	}
+
+	return nil
+}
+
+func (c *respConn) writeStatus() {
+	c.write(func(w *resp.Writer) error {
+		return w.WriteArray(string(c.lastAction), c.lastID, c.state.String())
+	})
+}
+
+func (c *respConn) writeStats(args []string) {
+	if len(args) != 1 {
+		c.writeError("ERR wrong number of arguments for 'stats' command")
+		return
+	}
+
+	if err := c.authorize(args[0], nil); err != nil {
+		c.writeError(err.Error())
+		return
+	}
+
+	stats := ns.GetNamespaceStatistics(args[0])
+	if stats == nil {
+		c.writeError("ERR namespace not found")
+		return
+	}
+
+	serialized, err := json.Marshal(stats)
+	if err != nil {
+		c.writeError("ERR cannot serialize namespace statistics")
+		return
+	}
+
+	c.write(func(w *resp.Writer) error { return w.WriteBulkString(string(serialized)) })
+}
+
+func (c *respConn) writePing(args []string) {
+	c.write(func(w *resp.Writer) error {
+		if len(args) > 0 {
+			return w.WriteBulkString(args[0])
+		}
+
+		return w.WriteSimpleString("PONG")
+	})
+}
+
+func (c *respConn) writeHello(args []string) {
+	c.wmx.Lock()
+	defer c.wmx.Unlock()
+
+	if len(args) > 0 {
+		switch args[0] {
+		case "2":
+			c.resp3 = false
+		case "3":
+			c.resp3 = true
+		default:
+			c.w.WriteError("NOPROTO unsupported protocol version")
+			return
+		}
+	}
+
+	proto := 2
+	if c.resp3 {
+		proto = 3
+	}
+
+	c.w.WriteMap(c.resp3, "server", "locktopus", "proto", strconv.Itoa(proto))
+}
+
+func stateFromString(s string) ClientState {
+	for i, name := range states {
+		if name == s {
+			return ClientState(i)
+		}
+	}
+
+	return clientStateReady
+}
@@ -0,0 +1,311 @@
+package main_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	main "github.com/locktopus-project/locktopus/cmd/server"
+	"github.com/locktopus-project/locktopus/internal/resp"
+	internal "github.com/locktopus-project/locktopus/internal/utils"
+)
+
+const respNamespaceName = "resp_namespace"
+
+type respTestClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+	w    *resp.Writer
+}
+
+func makeRespTestClient(t *testing.T) *respTestClient {
+	conn, err := net.Dial("tcp", respServerAddress)
+	if err != nil {
+		t.Fatalf("cannot connect to Locktopus RESP server: %s", err)
+	}
+
+	return &respTestClient{
+		conn: conn,
+		r:    bufio.NewReader(conn),
+		w:    resp.NewWriter(conn),
+	}
+}
+
+// do sends a command and reads the reply. Array and bulk replies are returned element-wise, other replies as a single raw line.
+func (c *respTestClient) do(t *testing.T, args ...string) []string {
+	if err := c.w.WriteArray(args...); err != nil {
+		t.Fatalf("cannot write command: %s", err)
+	}
+
+	return c.read(t)
+}
+
+func (c *respTestClient) read(t *testing.T) []string {
+	line := c.readLine(t)
+
+	switch line[0] {
+	case '*', '>':
+		n, _ := strconv.Atoi(line[1:])
+		reply := make([]string, n)
+
+		for i := range reply {
+			reply[i] = c.readBulkString(t, c.readLine(t))
+		}
+
+		return reply
+	case '%':
+		n, _ := strconv.Atoi(line[1:])
+		reply := make([]string, 2*n)
+
+		for i := range reply {
+			reply[i] = c.readBulkString(t, c.readLine(t))
+		}
+
+		return reply
+	case '$':
+		return []string{c.readBulkString(t, line)}
+	}
+
+	return []string{line}
+}
+
+func (c *respTestClient) readLine(t *testing.T) string {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		t.Fatalf("cannot read reply: %s", err)
+	}
+
+	return strings.TrimSuffix(line, "\r\n")
+}
+
+func (c *respTestClient) readBulkString(t *testing.T, header string) string {
+	n, err := strconv.Atoi(header[1:])
+	if err != nil {
+		t.Fatalf("invalid bulk string header: %s", header)
+	}
+
+	buf := make([]byte, n+2)
+	if _, err = io.ReadFull(c.r, buf); err != nil {
+		t.Fatalf("cannot read reply: %s", err)
+	}
+
+	return string(buf[:n])
+}
+
+func TestResp_Ping(t *testing.T) {
+	c := makeRespTestClient(t)
+	defer c.conn.Close()
+
+	if reply := c.do(t, "PING"); reply[0] != "+PONG" {
+		t.Fatalf("unexpected reply: %v", reply)
+	}
+}
+
+func TestResp_SequentialAcquire(t *testing.T) {
+	locker := makeRespTestClient(t)
+	defer locker.conn.Close()
+
+	waiter := makeRespTestClient(t)
+	defer waiter.conn.Close()
+
+	reply := locker.do(t, "LOCK", respNamespaceName, "w:a/b", "r:c")
+	if len(reply) != 3 || reply[0] != "lock" || reply[2] != "acquired" {
+		t.Fatalf("locker's lock should be acquired: %v", reply)
+	}
+
+	lockerID := reply[1]
+
+	if reply = waiter.do(t, "HELLO", "3"); len(reply) != 4 || reply[3] != "3" {
+		t.Fatalf("unexpected reply to HELLO: %v", reply)
+	}
+
+	reply = waiter.do(t, "LOCK", respNamespaceName, "w:a")
+	if len(reply) != 3 || reply[0] != "lock" || reply[2] != "enqueued" {
+		t.Fatalf("waiter's lock should be enqueued: %v", reply)
+	}
+
+	waiterID := reply[1]
+
+	reply = locker.do(t, "RELEASE")
+	if len(reply) != 3 || reply[0] != "release" || reply[1] != lockerID || reply[2] != "ready" {
+		t.Fatalf("unexpected reply to RELEASE: %v", reply)
+	}
+
+	reply = waiter.read(t)
+	if len(reply) != 3 || reply[0] != "lock" || reply[1] != waiterID || reply[2] != "acquired" {
+		t.Fatalf("waiter should be notified of acquisition: %v", reply)
+	}
+
+	reply = waiter.do(t, "STATUS")
+	if len(reply) != 3 || reply[1] != waiterID || reply[2] != "acquired" {
+		t.Fatalf("unexpected reply to STATUS: %v", reply)
+	}
+}
+
+// RESP2 has no out-of-band pushes, so the acquisition is seen with STATUS, and the next reply belongs to the next command
+func TestResp_Resp2ClientPollsStatus(t *testing.T) {
+	locker := makeRespTestClient(t)
+	defer locker.conn.Close()
+
+	waiter := makeRespTestClient(t)
+	defer waiter.conn.Close()
+
+	if reply := locker.do(t, "LOCK", respNamespaceName, "w:resp2"); reply[2] != "acquired" {
+		t.Fatalf("locker's lock should be acquired: %v", reply)
+	}
+
+	reply := waiter.do(t, "LOCK", respNamespaceName, "w:resp2")
+	if reply[2] != "enqueued" {
+		t.Fatalf("waiter's lock should be enqueued: %v", reply)
+	}
+
+	waiterID := reply[1]
+
+	if reply = locker.do(t, "RELEASE"); reply[2] != "ready" {
+		t.Fatalf("unexpected reply to RELEASE: %v", reply)
+	}
+
+	for i := 0; ; i++ {
+		reply = waiter.do(t, "STATUS")
+		if len(reply) == 3 && reply[1] == waiterID && reply[2] == "acquired" {
+			break
+		}
+
+		if i == 100 {
+			t.Fatalf("waiter's lock should be acquired: %v", reply)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if reply = waiter.do(t, "PING"); reply[0] != "+PONG" {
+		t.Fatalf("no message should be pushed to a RESP2 client, got %v", reply)
+	}
+}
+
+func TestResp_InvalidResource(t *testing.T) {
+	c := makeRespTestClient(t)
+	defer c.conn.Close()
+
+	if reply := c.do(t, "LOCK", respNamespaceName, "x:a"); !strings.HasPrefix(reply[0], "-ERR") {
+		t.Fatalf("expected error reply: %v", reply)
+	}
+
+	if reply := c.do(t, "LOCK", respNamespaceName, "w:invalid_resource"); reply[2] != "acquired" {
+		t.Fatalf("connection should be usable after error reply: %v", reply)
+	}
+}
+
+func TestResp_Stats(t *testing.T) {
+	c := makeRespTestClient(t)
+	defer c.conn.Close()
+
+	c.do(t, "LOCK", respNamespaceName, "w:stats")
+
+	reply := c.do(t, "STATS", respNamespaceName)
+
+	if err := json.Unmarshal([]byte(reply[0]), &map[string]interface{}{}); err != nil {
+		t.Fatalf("cannot parse STATS reply: %s", err)
+	}
+}
+
+func TestResp_LockMetadata(t *testing.T) {
+	c := makeRespTestClient(t)
+	defer c.conn.Close()
+
+	if reply := c.do(t, "LOCK", respNamespaceName, "w:metadata", "LABEL", "job"); !strings.HasPrefix(reply[0], "-ERR") {
+		t.Fatalf("expected error reply: %v", reply)
+	}
+
+	if reply := c.do(t, "LOCK", respNamespaceName, "w:metadata", "OWNER", strings.Repeat("x", 257)); !strings.HasPrefix(reply[0], "-TOOLARGE") {
+		t.Fatalf("expected TOOLARGE reply: %v", reply)
+	}
+
+	if reply := c.do(t, "LOCK", respNamespaceName, "OWNER", "worker-1", "w:metadata", "LABEL", "job=billing"); reply[2] != "acquired" {
+		t.Fatalf("unexpected reply: %v", reply)
+	}
+}
+
+func TestResp_CommandTooLarge(t *testing.T) {
+	port, err := internal.FindFreePort()
+	if err != nil {
+		t.Fatalf("cannot find free port: %s", err)
+	}
+
+	server := main.MakeRespServer(main.ServerParameters{Hostname: defaultHostname, RespPort: port, RequestLimits: main.RequestLimits{MaxMessageSize: 64}})
+	main.StartRespListening(server)
+	defer server.Close()
+
+	dial := func() *respTestClient {
+		deadline := time.Now().Add(time.Duration(connTimeoutMs) * time.Millisecond)
+
+		for {
+			conn, err := net.Dial("tcp", net.JoinHostPort(defaultHostname, port))
+			if err == nil {
+				return &respTestClient{conn: conn, r: bufio.NewReader(conn), w: resp.NewWriter(conn)}
+			}
+
+			if time.Now().After(deadline) {
+				t.Fatalf("cannot connect to Locktopus RESP server: %s", err)
+			}
+
+			time.Sleep(time.Duration(connPollIntervalMs) * time.Millisecond)
+		}
+	}
+
+	for name, command := range map[string][]byte{
+		// the line is never terminated, so the server must not wait for its end. It fills the read buffer of the server,
+		// so no unread data makes the server reset the connection instead of closing it
+		"inline":    []byte(strings.Repeat("x", 4096)),
+		"multibulk": []byte("*3\r\n$4\r\nLOCK\r\n$14\r\nresp_namespace\r\n$100\r\nw:" + strings.Repeat("x", 98) + "\r\n"),
+	} {
+		c := dial()
+
+		if reply := c.do(t, "PING"); reply[0] != "+PONG" {
+			t.Fatalf("unexpected reply: %v", reply)
+		}
+
+		if _, err = c.conn.Write(command); err != nil {
+			t.Fatalf("cannot write command: %s", err)
+		}
+
+		c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+		if reply := c.readLine(t); !strings.HasPrefix(reply, "-TOOLARGE") {
+			t.Fatalf("%s command should be rejected as too large: %s", name, reply)
+		}
+
+		if _, err = c.r.ReadByte(); err == nil {
+			t.Fatalf("connection should be closed after an oversized %s command, got %v", name, err)
+		}
+
+		c.conn.Close()
+	}
+}
+
+// A LOCK sent while a lock is held is refused before the namespace is used
+func TestResp_LockWhileHolding(t *testing.T) {
+	c := makeRespTestClient(t)
+	defer c.conn.Close()
+
+	if reply := c.do(t, "LOCK", respNamespaceName, "w:holding"); reply[2] != "acquired" {
+		t.Fatalf("unexpected reply: %v", reply)
+	}
+
+	if reply := c.do(t, "LOCK", "resp_unused_namespace", "w:holding"); !strings.HasPrefix(reply[0], "-ERR") {
+		t.Fatalf("expected error reply: %v", reply)
+	}
+
+	if reply := c.do(t, "STATS", "resp_unused_namespace"); !strings.HasPrefix(reply[0], "-ERR") {
+		t.Fatalf("refused lock should not create the namespace: %v", reply)
+	}
+
+	if reply := c.do(t, "RELEASE"); reply[0] != "release" {
+		t.Fatalf("lock should still be held: %v", reply)
+	}
+}
@@ -4,13 +4,20 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"time"
 
 	"github.com/locktopus-project/locktopus/internal/constants"
 	ns "github.com/locktopus-project/locktopus/internal/namespace"
+	ml "github.com/locktopus-project/locktopus/pkg/multilocker"
 )
 
-func statsV1Handler(w http.ResponseWriter, r *http.Request, abandonTimeout time.Duration) {
+type statsV1Response struct {
+	ml.MultilockerStatistics
+	Clients  map[string]int64              // number of connections by client identity (certificate subject). Anonymous clients are counted under ""
+	Rejected rejectedStats                 // requests rejected by the rate limits
+	ByLabel  map[string]ml.LabelStatistics `json:",omitempty"` // groups by the value of the label requested with the by-label parameter. Groups without the label are counted under ""
+}
+
+func statsV1Handler(w http.ResponseWriter, r *http.Request, s *Server) {
 	nsParam := r.URL.Query().Get(constants.NamespaceQueryParameterName)
 
 	if nsParam == "" {
@@ -19,6 +26,14 @@ func statsV1Handler(w http.ResponseWriter, r *http.Request, abandonTimeout time.
 		return
 	}
 
+	if redirectMisrouted(w, r, nsParam) {
+		return
+	}
+
+	if _, ok := s.authorizeNamespace(w, r, nsParam); !ok {
+		return
+	}
+
 	namespace := ns.GetNamespaceStatistics(nsParam)
 	if namespace == nil {
 		w.WriteHeader(http.StatusNotFound)
@@ -26,7 +41,17 @@ func statsV1Handler(w http.ResponseWriter, r *http.Request, abandonTimeout time.
 		return
 	}
 
-	serialized, err := json.Marshal(namespace)
+	response := statsV1Response{
+		MultilockerStatistics: *namespace,
+		Clients:               namespaceClients(nsParam),
+		Rejected:              namespaceRejected(nsParam),
+	}
+
+	if label := r.URL.Query().Get(constants.ByLabelQueryParameterName); label != "" {
+		response.ByLabel = ns.GetNamespaceStatisticsByLabel(nsParam, label)
+	}
+
+	serialized, err := json.Marshal(response)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte("Cannot serialize namespace statistics"))
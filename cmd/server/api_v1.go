@@ -1,8 +1,11 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"sync/atomic"
@@ -10,22 +13,70 @@ import (
 
 	"github.com/gorilla/websocket"
 
+	"github.com/locktopus-project/locktopus/internal/auth"
 	"github.com/locktopus-project/locktopus/internal/constants"
 	ns "github.com/locktopus-project/locktopus/internal/namespace"
 	ml "github.com/locktopus-project/locktopus/pkg/multilocker"
 )
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
+const invalidInputCode = 3000
+const unauthorizedCode = 3001
+const limitExceededCode = 3002
+const disconnectedCode = 3003
+const namespaceFrozenCode = 3004
+const serverDrainingCode = 3005
+const notLeaderCode = 3006
+const rateLimitedCode = 3007
+const requestTooLargeCode = 3008
+
+var errDisconnected = errors.New("disconnected by server")
+var errDisconnectedByAdmin = fmt.Errorf("%w: disconnected by administrator", errDisconnected)
+var errDraining = fmt.Errorf("%w: server draining", errDisconnected)
+
+// closeReason returns the close frame text. Draining servers give a retry hint, former cluster leaders point to the new one,
+// upgrading servers ask to reconnect to the new process right away
+func closeReason(err error) string {
+	switch {
+	case errors.Is(err, errUpgrading):
+		return upgradingReason
+	case errors.Is(err, errDraining):
+		return drainingReason
+	case errors.Is(err, errNotLeader):
+		return notLeaderReason()
+	}
+
+	return ""
 }
 
-const invalidInputCode = 3000
+func closeCode(err error) int {
+	switch {
+	case errors.Is(err, errDraining):
+		return serverDrainingCode
+	case errors.Is(err, errNotLeader):
+		return notLeaderCode
+	case errors.Is(err, errDisconnected):
+		return disconnectedCode
+	case errors.Is(err, auth.ErrForbidden):
+		return unauthorizedCode
+	case errors.Is(err, ns.ErrLimitExceeded):
+		return limitExceededCode
+	case errors.Is(err, ns.ErrNamespaceFrozen):
+		return namespaceFrozenCode
+	case errors.Is(err, errRateLimited):
+		return rateLimitedCode
+	case errors.Is(err, errRequestTooLarge):
+		return requestTooLargeCode
+	}
+
+	return invalidInputCode
+}
+
+func apiV1Handler(w http.ResponseWriter, r *http.Request, s *Server) {
+	if refuseDraining(w) {
+		return
+	}
 
-func apiV1Handler(w http.ResponseWriter, r *http.Request, defAbandonTimeout time.Duration) {
 	namespace := r.URL.Query().Get(constants.NamespaceQueryParameterName)
-	var abandonTimeout = defAbandonTimeout
 
 	if namespace == "" {
 		w.WriteHeader(http.StatusBadRequest)
@@ -33,21 +84,67 @@ func apiV1Handler(w http.ResponseWriter, r *http.Request, defAbandonTimeout time
 		return
 	}
 
-	if r.URL.Query().Has(constants.AbandonTimeoutQueryParameterName) {
-		timeoutParam := r.URL.Query().Get(constants.AbandonTimeoutQueryParameterName)
+	if redirectMisrouted(w, r, namespace) {
+		return
+	}
+
+	token, ok := s.authorizeNamespace(w, r, namespace)
+	if !ok {
+		return
+	}
+
+	multilocker, created, err := ns.GetNamespace(namespace)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	defer ns.ReleaseNamespace(namespace)
+
+	if created {
+		mainLogger.Infof("Created new multilocker namespace %s", namespace)
+	}
 
-		timeoutMs, err := strconv.Atoi(timeoutParam)
+	abandon, err := makeAbandonPolicy(ns.GetPolicy(namespace), s.params.DefaultAbandonTimeout, s.params.DefaultPendingAbandonTimeout, r.URL.Query())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
 
-		if err != nil || timeoutMs < 0 {
+	notifyContention := false
+	if value := r.URL.Query().Get(constants.NotifyContentionQueryParameterName); value != "" {
+		if notifyContention, err = strconv.ParseBool(value); err != nil {
 			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte(fmt.Sprintf("URL parameter '%s' should be integer value >= 0 representing broken connection timeout (in milliseconds)", constants.AbandonTimeoutQueryParameterName)))
+			w.Write([]byte(fmt.Sprintf("Invalid value of URL parameter '%s'", constants.NotifyContentionQueryParameterName)))
+			return
+		}
+	}
+
+	if err = acquireConnectionSlot(namespace, r.RemoteAddr); err != nil {
+		writeRateLimited(w, err)
+		return
+	}
+	defer releaseConnectionSlot(r.RemoteAddr)
+
+	var resumed *restoredSession
+
+	if key := r.URL.Query().Get(constants.ResumeQueryParameterName); key != "" {
+		if resumed = takeRestoredSession(namespace, key); resumed == nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("Session not found. It may have been released after the resume grace period"))
 			return
 		}
 
-		abandonTimeout = time.Duration(timeoutMs) * time.Millisecond
+		abandon.acquired = resumed.abandonTimeout
+	}
+
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil && resumed != nil {
+		// the client may retry resuming
+		putRestoredSession(resumed)
 	}
 
-	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		apiLogger.Error(fmt.Errorf("upgrade error: %w", err))
 		return
@@ -55,19 +152,29 @@ func apiV1Handler(w http.ResponseWriter, r *http.Request, defAbandonTimeout time
 	defer conn.Close()
 
 	connID := atomic.AddInt64(&lastConnID, 1)
-
-	apiLogger.Infof("New connection from %s [id = %d]", conn.RemoteAddr(), connID)
-
-	ns, created := ns.GetNamespace(namespace)
-	if created {
-		mainLogger.Infof("Created new multilocker namespace %s", namespace)
+	identity := clientIdentity(r, token)
+
+	apiLogger.Infof("New connection from %s [id = %d, client = %s]", conn.RemoteAddr(), connID, identity)
+
+	registerConnection(&connection{
+		id:             connID,
+		namespace:      namespace,
+		remoteAddr:     conn.RemoteAddr().String(),
+		clientIdentity: identity,
+	})
+	defer unregisterConnection(connID)
+
+	// a resumed lock keeps being written where it has been restored from
+	journal := journalFor(namespace)
+	if resumed != nil {
+		journal = resumed.journal
 	}
 
-	err = handleCommunication(conn, ns, connID, abandonTimeout)
+	err = handleCommunication(wsConn{Conn: conn, namespace: namespace, token: token, identity: identity, limiter: newLockLimiter(), limits: s.params.RequestLimits, notifyContention: notifyContention}, multilocker, namespace, connID, abandon, journal, resumed)
 
 	if err != nil {
 		conn.WriteMessage(websocket.TextMessage, []byte(fmt.Errorf("communication error: %w", err).Error()))
-		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(invalidInputCode, ""), time.Now().Add(time.Second))
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(closeCode(err), closeReason(err)), time.Now().Add(time.Second))
 
 		apiLogger.Infof("Connection closed [id = %d]: %s", connID, err.Error())
 
@@ -82,13 +189,64 @@ func apiV1Handler(w http.ResponseWriter, r *http.Request, defAbandonTimeout time
 type action string
 
 const (
-	actionLock    action = "lock"
-	actionRelease action = "release"
+	actionLock      action = "lock"
+	actionRelease   action = "release"
+	actionRevoked   action = "revoked"   // sent by the server when the lock has been released with the admin API
+	actionContended action = "contended" // sent by the server when another lock becomes blocked on the acquired lock
 )
 
 type requestMessage struct {
-	Action    action     `json:"action"`
-	Resources []resource `json:"resources,omitempty"`
+	Action    action            `json:"action"`
+	Resources []resource        `json:"resources,omitempty"`
+	Owner     string            `json:"owner,omitempty"`  // describes the holder of the lock
+	Labels    map[string]string `json:"labels,omitempty"` // e.g. job name, host or trace ID
+
+	// namespace and abandon override the connection's ones for protocols that pass the namespace with each lock (RESP)
+	namespace     *ml.MultiLocker
+	namespaceName string
+	abandon       abandonPolicy
+}
+
+// abandonPolicy tells how long the lock of a disconnected client is kept, depending on its state
+type abandonPolicy struct {
+	acquired time.Duration
+	pending  time.Duration // 0 cancels the enqueued lock right away, so it does not block the queue
+}
+
+// makeAbandonPolicy takes the timeouts from the query parameters, the namespace policy or the server defaults.
+// If the pending timeout is configured nowhere, the acquired one is used for both states.
+func makeAbandonPolicy(policy ns.Policy, defaultTimeout time.Duration, defaultPendingTimeout *time.Duration, query url.Values) (abandonPolicy, error) {
+	var err error
+
+	a := abandonPolicy{acquired: policy.AbandonTimeout(defaultTimeout)}
+
+	if a.acquired, err = parseAbandonTimeout(policy, query, constants.AbandonTimeoutQueryParameterName, a.acquired); err != nil {
+		return a, err
+	}
+
+	a.pending = a.acquired
+	if defaultPendingTimeout != nil {
+		a.pending = *defaultPendingTimeout
+	}
+
+	a.pending, err = parseAbandonTimeout(policy, query, constants.PendingAbandonTimeoutQueryParameterName, policy.PendingAbandonTimeout(a.pending))
+
+	return a, err
+}
+
+func parseAbandonTimeout(policy ns.Policy, query url.Values, name string, def time.Duration) (time.Duration, error) {
+	if !query.Has(name) {
+		return def, nil
+	}
+
+	timeoutMs, err := strconv.Atoi(query.Get(name))
+	if err != nil || timeoutMs < 0 {
+		return 0, fmt.Errorf("URL parameter '%s' should be integer value >= 0 representing broken connection timeout (in milliseconds)", name)
+	}
+
+	timeout := time.Duration(timeoutMs) * time.Millisecond
+
+	return timeout, policy.CheckAbandonTimeout(timeout)
 }
 
 type resource struct {
@@ -97,9 +255,12 @@ type resource struct {
 }
 
 type responseMessage struct {
-	ID     string `json:"id"`
-	Action action `json:"action"`
-	State  string `json:"state"`
+	ID      string     `json:"id"`
+	Action  action     `json:"action"`
+	State   string     `json:"state"`
+	Session string     `json:"session,omitempty"` // key for resuming the lock after server restart (durable namespaces only)
+	Waiters int        `json:"waiters,omitempty"` // number of locks blocked on the acquired lock so far (contended only)
+	Paths   [][]string `json:"paths,omitempty"`   // paths where they conflict with the lock's resources (contended only)
 }
 
 type ClientState int
@@ -116,11 +277,65 @@ func (cs ClientState) String() string {
 	return states[cs]
 }
 
-func readMessages(conn *websocket.Conn, ch chan<- requestMessage) (err error) {
+// clientConn is a connection speaking one of the client protocols
+type clientConn interface {
+	ReadRequest(*requestMessage) error
+	WriteResponse(responseMessage) error
+}
+
+type wsConn struct {
+	*websocket.Conn
+	namespace string
+	token     *auth.Token // nil if authentication is disabled
+	identity  string
+	limiter   *lockLimiter
+	limits    RequestLimits
+
+	notifyContention bool // the client has asked for contended messages
+}
+
+func (c wsConn) ReadRequest(m *requestMessage) error {
+	payload, err := readMessage(c.Conn, c.limits.MaxMessageSize)
+	if err != nil {
+		return err
+	}
+
+	if err = c.limits.checkRequest(payload); err != nil {
+		return err
+	}
+
+	if err = json.Unmarshal(payload, m); err != nil {
+		return err
+	}
+
+	if err = checkMetadata(m.Owner, m.Labels); err != nil {
+		return err
+	}
+
+	if err = checkLockRequest(c.token, c.namespace, m); err != nil {
+		return err
+	}
+
+	if m.Action != actionLock {
+		return nil
+	}
+
+	return c.limiter.checkLock(c.namespace, c.identity, c.RemoteAddr().String())
+}
+
+func (c wsConn) WriteResponse(m responseMessage) error {
+	if m.Action == actionContended && !c.notifyContention {
+		return nil
+	}
+
+	return c.WriteJSON(m)
+}
+
+func readMessages(conn clientConn, ch chan<- requestMessage) (err error) {
 	for {
 		cm := requestMessage{}
 
-		if err = conn.ReadJSON(&cm); err != nil {
+		if err = conn.ReadRequest(&cm); err != nil {
 			break
 		}
 
@@ -130,50 +345,131 @@ func readMessages(conn *websocket.Conn, ch chan<- requestMessage) (err error) {
 	return err
 }
 
-func handleCommunication(conn *websocket.Conn, multilocker *ml.MultiLocker, connID int64, timeout time.Duration) (err error) {
+// handleCommunication runs the client state machine. If journal is not nil, the locks are written to the write-ahead log.
+// If resumed is not nil, the session starts with the restored lock. Lock events are delivered to the webhooks of the namespace.
+func handleCommunication(conn clientConn, multilocker *ml.MultiLocker, namespace string, connID int64, abandon abandonPolicy, journal *lockJournal, resumed *restoredSession) (err error) {
 	var readErr error
 	var l *ml.Lock
 	var id int64
+	var session string
 	state := clientStateReady
 	ch := make(chan requestMessage)
 
+	// revoked is set when the lock has been released by the admin API, so the client's late release is not treated as an error
+	revoked := false
+	revoke, disconnect := connectionSignals(connID)
+
 	go func() {
 		readErr = readMessages(conn, ch)
 		close(ch)
 	}()
 
 	var resourceLocks []ml.ResourceLock
+	var metadata ml.Metadata
+
+	if resumed != nil {
+		l = resumed.lock
+		metadata = l.Metadata()
+		id = l.ID()
+		session = resumed.key
+		resourceLocks, _ = makeResourceLocks(resumed.resources)
+
+		// a lock acquired after the session has been taken is reported as enqueued, so its acquisition is written below
+		state = clientStateEnqueued
+		if resumed.acquired {
+			state = clientStateAcquired
+		}
 
-	for {
+		updateConnection(connID, func(c *connection) {
+			c.groupID = id
+			c.state = state
+			c.resources = resumed.resources
+			c.metadata = metadata
+		})
+
+		setLockHolder(namespace, id, connectionHolder(connID))
+
+		lockLogger.Infof("Resumed lock for connection [id = %d, group = %d%s]: %v", connID, id, formatMetadata(metadata), resourceLocks)
+
+		if err = conn.WriteResponse(responseMessage{ID: fmt.Sprintf("%d", id), Action: actionLock, State: state.String(), Session: session}); err != nil {
+			err = fmt.Errorf("cannot send JSON message: %w", err)
+		}
+	}
+
+	for err == nil {
 		incm := requestMessage{}
 		opened := true
-		received := false
 
+		var ready <-chan struct{}
+		var contended <-chan ml.Contention
 		if state == clientStateEnqueued {
-			select {
-			case <-l.Ready():
-				state = clientStateAcquired
+			ready = l.Ready()
+		} else if state == clientStateAcquired {
+			contended = l.Contended()
+		}
 
-				if err != writeResponse(conn, l.ID(), actionLock, state) {
-					err = fmt.Errorf("cannot send JSON message: %w", err)
-				}
+		select {
+		case <-ready:
+			state = clientStateAcquired
+			setConnectionState(connID, state)
 
-			case incm, opened = <-ch:
-				received = true
+			if err = journal.acquired(id); err != nil {
+				continue
 			}
-		}
 
-		if !received {
-			incm, opened = <-ch
-			if !opened {
-				break
+			notifyLock(namespace, id, lockAcquired)
+
+			if err = writeResponse(conn, l.ID(), actionLock, state); err != nil {
+				err = fmt.Errorf("cannot send JSON message: %w", err)
+			}
+
+			continue
+		case contention := <-contended:
+			if err = conn.WriteResponse(responseMessage{ID: fmt.Sprintf("%d", id), Action: actionContended, State: state.String(), Waiters: contention.Waiters, Paths: contention.Paths}); err != nil {
+				err = fmt.Errorf("cannot send JSON message: %w", err)
 			}
+
+			continue
+		case groupID := <-revoke:
+			if l == nil || groupID != id {
+				continue
+			}
+
+			journal.released(id)
+			notifyLock(namespace, id, lockRevoked)
+			releaseGroup(l)
+
+			l = nil
+			revoked = true
+			state = clientStateReady
+			setConnectionState(connID, state)
+
+			lockLogger.Infof("Revoked lock for connection [id = %d, group = %d%s]: %v", connID, id, formatMetadata(metadata), resourceLocks)
+
+			if err = writeResponse(conn, id, actionRevoked, state); err != nil {
+				err = fmt.Errorf("cannot send JSON message: %w", err)
+			}
+
+			continue
+		case err = <-disconnect:
+		case incm, opened = <-ch:
 		}
 
 		if !opened || err != nil || readErr != nil {
 			break
 		}
 
+		if incm.Action == actionRelease && state == clientStateReady && revoked {
+			revoked = false
+
+			if err = writeResponse(conn, id, incm.Action, state); err != nil {
+				err = fmt.Errorf("cannot send JSON message: %w", err)
+				break
+			}
+
+			continue
+		}
+
 		if err = assertCorrectAction(incm.Action, state); err != nil {
 			break
 		}
@@ -184,23 +480,61 @@ func handleCommunication(conn *websocket.Conn, multilocker *ml.MultiLocker, conn
 				break
 			}
 
-			lockLogger.Infof("Locking resources for connection [id = %d]: %v", connID, resourceLocks)
+			revoked = false
+
+			metadata = ml.Metadata{Owner: incm.Owner, Labels: incm.Labels}
+
+			lockLogger.Infof("Locking resources for connection [id = %d%s]: %v", connID, formatMetadata(metadata), resourceLocks)
+
+			m := multilocker
+			if incm.namespace != nil {
+				m = incm.namespace
+				namespace = incm.namespaceName
+				abandon = incm.abandon
+			}
 
-			newLock := multilocker.Lock(resourceLocks)
+			newLock := m.LockWithMetadata(resourceLocks, metadata)
 
-			lockLogger.Infof("Locked resources for connection [id = %d]: %v", connID, resourceLocks)
+			lockLogger.Infof("Locked resources for connection [id = %d, group = %d%s]: %v", connID, newLock.ID(), formatMetadata(metadata), resourceLocks)
 
 			l = newLock
 			id = l.ID()
 
+			if journal != nil {
+				session = newSessionKey()
+
+				if err = journal.enqueued(id, incm.Resources, metadata, session, abandon.acquired); err != nil {
+					break
+				}
+			}
+
+			trackLock(namespace, id, incm.Resources, metadata, connectionHolder(connID))
+			notifyLock(namespace, id, lockEnqueued)
+
 			select {
 			case <-l.Ready():
 				state = clientStateAcquired
+				err = journal.acquired(id)
 			default:
 				state = clientStateEnqueued
 			}
 
-			if err != writeResponse(conn, id, incm.Action, state) {
+			if err != nil {
+				break
+			}
+
+			if state == clientStateAcquired {
+				notifyLock(namespace, id, lockAcquired)
+			}
+
+			updateConnection(connID, func(c *connection) {
+				c.groupID = id
+				c.state = state
+				c.resources = incm.Resources
+				c.metadata = metadata
+			})
+
+			if err = conn.WriteResponse(responseMessage{ID: fmt.Sprintf("%d", id), Action: incm.Action, State: state.String(), Session: session}); err != nil {
 				err = fmt.Errorf("cannot send JSON message: %w", err)
 				break
 			}
@@ -210,39 +544,112 @@ func handleCommunication(conn *websocket.Conn, multilocker *ml.MultiLocker, conn
 
 		// Action = actionRelease
 
-		go func(l *ml.Lock) {
-			l.Acquire().Unlock()
-		}(l)
+		// the release is recorded before the dependent locks can be acquired
+		journal.released(id)
+		notifyLock(namespace, id, lockReleased)
+		releaseGroup(l)
 
 		l = nil
 
-		lockLogger.Infof("Released resources for connection [id = %d]: %v", connID, resourceLocks)
+		lockLogger.Infof("Released resources for connection [id = %d, group = %d%s]: %v", connID, id, formatMetadata(metadata), resourceLocks)
 
 		state = clientStateReady
+		setConnectionState(connID, state)
 
-		if err != writeResponse(conn, id, incm.Action, state) {
+		if err = writeResponse(conn, id, incm.Action, state); err != nil {
 			err = fmt.Errorf("cannot send JSON message: %w", err)
 			break
 		}
 	}
 
 	if l != nil {
-		// If client has not released the lock and error occurred, release lock after abandon timeout
-		if state != clientStateReady {
-			lockLogger.Infof("Connection closed in non-ready state [id = %d]. Wait %v before releasing", connID, timeout)
-			time.Sleep(timeout)
+		// If client has not released the lock and error occurred, release lock after abandon timeout.
+		// Connections closed by the admin API release their locks right away
+		if !errors.Is(err, errDisconnected) {
+			awaitAbandonTimeout(l, connID, state, abandon)
 		}
 
-		l.Acquire().Unlock()
+		// the release is recorded before the dependent locks can be acquired
+		journal.released(id)
+		notifyLock(namespace, id, lockAbandoned)
+
+		if !l.Cancel() {
+			l.Acquire().Unlock()
+		}
 	}
 
-	if readErr != nil {
+	if readErr != nil && !errors.Is(err, errDisconnected) {
 		err = readErr
 	}
 
 	return err
 }
 
+// awaitAbandonTimeout waits for the timeout of the lock state. An enqueued lock acquired meanwhile is released right away, since the client will not use it
+func awaitAbandonTimeout(l *ml.Lock, connID int64, state ClientState, abandon abandonPolicy) {
+	if state == clientStateAcquired {
+		lockLogger.Infof("Connection closed in acquired state [id = %d]. Wait %v before releasing", connID, abandon.acquired)
+		time.Sleep(abandon.acquired)
+
+		return
+	}
+
+	lockLogger.Infof("Connection closed in enqueued state [id = %d]. Wait %v before cancelling", connID, abandon.pending)
+
+	timer := time.NewTimer(abandon.pending)
+	defer timer.Stop()
+
+	select {
+	case <-l.Ready():
+	case <-timer.C:
+	}
+}
+
+// releaseGroup releases the lock in the background. Enqueued locks are cancelled, so they stop blocking the queue
+func releaseGroup(l *ml.Lock) {
+	if l.Cancel() {
+		return
+	}
+
+	go func() {
+		l.Acquire().Unlock()
+	}()
+}
+
+// setConnectionState updates the connection registry. The group is forgotten when the connection gets ready.
+func setConnectionState(connID int64, state ClientState) {
+	updateConnection(connID, func(c *connection) {
+		c.state = state
+
+		if state == clientStateReady {
+			c.groupID = 0
+			c.resources = nil
+			c.metadata = ml.Metadata{}
+		}
+	})
+}
+
+// checkLockRequest checks that the token (if authentication is enabled) allows locking the requested resources and that the namespace policy is not violated.
+// Invalid requests are left for handleCommunication to report.
+func checkLockRequest(token *auth.Token, namespace string, m *requestMessage) error {
+	if m.Action != actionLock {
+		return nil
+	}
+
+	resourceLocks, err := makeResourceLocks(m.Resources)
+	if err != nil {
+		return nil
+	}
+
+	if token != nil {
+		if err = token.AuthorizeLocks(namespace, resourceLocks); err != nil {
+			return err
+		}
+	}
+
+	return ns.CheckLock(namespace, resourceLocks)
+}
+
 func parseLockType(input string) (ml.LockType, error) {
 	lt := ml.LockTypeRead
 
@@ -293,6 +700,6 @@ func assertCorrectAction(action action, state ClientState) error {
 	return fmt.Errorf("invalid action [%s] in state [%s]", action, state)
 }
 
-func writeResponse(conn *websocket.Conn, id int64, a action, s ClientState) error {
-	return conn.WriteJSON(responseMessage{ID: fmt.Sprintf("%d", id), Action: a, State: s.String()})
+func writeResponse(conn clientConn, id int64, a action, s ClientState) error {
+	return conn.WriteResponse(responseMessage{ID: fmt.Sprintf("%d", id), Action: a, State: s.String()})
 }
@@ -3,6 +3,8 @@ package main_test
 import (
 	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"runtime"
 	"strconv"
 	"strings"
@@ -53,6 +55,113 @@ func TestClient_MakeLocktopusClient_ByParams(t *testing.T) {
 	client.Close()
 }
 
+func TestClient_MakeLocktopusClient_FollowsRedirect(t *testing.T) {
+	// a cluster follower redirects clients to the leader
+	follower := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, fmt.Sprintf("http://%s%s", serverAddress, r.URL.RequestURI()), http.StatusTemporaryRedirect)
+	}))
+	defer follower.Close()
+
+	client, err := locktopusclient.MakeClient(locktopusclient.ConnectionOptions{
+		Url: fmt.Sprintf("ws://%s/v1?%v=123", strings.TrimPrefix(follower.URL, "http://"), constants.NamespaceQueryParameterName),
+	})
+
+	if err != nil {
+		t.Fatalf("cannot connect to Locktopus server: %s", err)
+	}
+
+	client.Close()
+}
+
+func TestClient_MakeLocktopusClient_RedirectKeepsTokenOnSameHost(t *testing.T) {
+	authorizations := make(chan string, 2)
+
+	// redirects to itself, then to another port
+	var other *httptest.Server
+	other = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorizations <- r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer other.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("redirected") == "" {
+			http.Redirect(w, r, r.URL.RequestURI()+"&redirected=1", http.StatusTemporaryRedirect)
+			return
+		}
+
+		authorizations <- r.Header.Get("Authorization")
+		http.Redirect(w, r, other.URL+r.URL.RequestURI(), http.StatusTemporaryRedirect)
+	}))
+	defer server.Close()
+
+	_, err := locktopusclient.MakeClient(locktopusclient.ConnectionOptions{
+		Url:   fmt.Sprintf("ws://%s/v1?%v=123", strings.TrimPrefix(server.URL, "http://"), constants.NamespaceQueryParameterName),
+		Token: "secret",
+	})
+	if err == nil {
+		t.Fatalf("handshake should fail")
+	}
+
+	if a := <-authorizations; a != "Bearer secret" {
+		t.Fatalf("token should be sent to the same host, got '%s'", a)
+	}
+
+	if a := <-authorizations; a != "" {
+		t.Fatalf("token should not be sent to another host or port, got '%s'", a)
+	}
+}
+
+func TestClient_MakeLocktopusClient_RefusesInsecureRedirect(t *testing.T) {
+	follower := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, fmt.Sprintf("http://%s%s", serverAddress, r.URL.RequestURI()), http.StatusTemporaryRedirect)
+	}))
+	defer follower.Close()
+
+	_, err := locktopusclient.MakeClient(locktopusclient.ConnectionOptions{
+		Url:       fmt.Sprintf("wss://%s/v1?%v=123", strings.TrimPrefix(follower.URL, "https://"), constants.NamespaceQueryParameterName),
+		TLSConfig: follower.Client().Transport.(*http.Transport).TLSClientConfig,
+		Token:     "secret",
+	})
+
+	if err == nil || !strings.Contains(err.Error(), "insecure") {
+		t.Fatalf("redirect to ws:// should be refused, got %v", err)
+	}
+}
+
+func TestClient_MakeLocktopusClient_ByUnixSocket(t *testing.T) {
+	if unixSocketPath == "" {
+		t.Skip("SERVER_UNIX_SOCKET is not set")
+	}
+
+	client, err := locktopusclient.MakeClient(locktopusclient.ConnectionOptions{
+		Namespace: v1NamespaceName,
+		Host:      "unix://" + unixSocketPath,
+	})
+
+	if err != nil {
+		t.Fatalf("cannot connect to Locktopus server: %s", err)
+	}
+
+	client.AddLockResource(locktopusclient.LockTypeWrite, "testUnixSocket")
+
+	if err = client.Lock(); err != nil {
+		t.Fatalf("cannot lock: %s", err)
+	}
+
+	client.Close()
+
+	client, err = locktopusclient.MakeClient(locktopusclient.ConnectionOptions{
+		Url: fmt.Sprintf("unix://%s?%s=%s", unixSocketPath, constants.NamespaceQueryParameterName, v1NamespaceName),
+	})
+
+	if err != nil {
+		t.Fatalf("cannot connect to Locktopus server by Url: %s", err)
+	}
+
+	client.Close()
+}
+
 func TestClient_ImmediateAcquireOnLock(t *testing.T) {
 	client, err := locktopusclient.MakeClient(locktopusclient.ConnectionOptions{
 		Url: fmt.Sprintf("ws://%s/v1?%s=%s", serverAddress, constants.NamespaceQueryParameterName, v1NamespaceName),
@@ -451,3 +560,136 @@ func TestClient_ClosedConnectionTimeout_AfterRelease(t *testing.T) {
 
 	waiter.Close()
 }
+
+func TestClient_ClosedConnectionTimeout_WhileEnqueued(t *testing.T) {
+	holder, err := locktopusclient.MakeClient(locktopusclient.ConnectionOptions{
+		Url: fmt.Sprintf("ws://%s/v1?%s=%s", serverAddress, constants.NamespaceQueryParameterName, v1NamespaceName),
+	})
+
+	if err != nil {
+		t.Fatalf("cannot connect to Locktopus server: %s", err)
+	}
+
+	// the enqueued lock is cancelled right away, though an acquired one would be kept for a minute
+	locker, err := locktopusclient.MakeClient(locktopusclient.ConnectionOptions{
+		Url: fmt.Sprintf("ws://%s/v1?%s=%s&%s=60000&%s=0", serverAddress, constants.NamespaceQueryParameterName, v1NamespaceName, constants.AbandonTimeoutQueryParameterName, constants.PendingAbandonTimeoutQueryParameterName),
+	})
+
+	if err != nil {
+		t.Fatalf("cannot connect to Locktopus server: %s", err)
+	}
+
+	waiter, err := locktopusclient.MakeClient(locktopusclient.ConnectionOptions{
+		Url: fmt.Sprintf("ws://%s/v1?%s=%s", serverAddress, constants.NamespaceQueryParameterName, v1NamespaceName),
+	})
+
+	if err != nil {
+		t.Fatalf("cannot connect to Locktopus server: %s", err)
+	}
+
+	holder.AddLockResource(locktopusclient.LockTypeWrite, "test10", "a")
+	locker.AddLockResource(locktopusclient.LockTypeWrite, "test10", "a")
+	locker.AddLockResource(locktopusclient.LockTypeWrite, "test10", "b")
+	waiter.AddLockResource(locktopusclient.LockTypeWrite, "test10", "b")
+
+	if err = holder.Lock(); err != nil {
+		t.Fatalf("cannot lock: %s", err)
+	}
+
+	if err = locker.Lock(); err != nil {
+		t.Fatalf("cannot lock: %s", err)
+	}
+
+	if err = waiter.Lock(); err != nil {
+		t.Fatalf("cannot lock: %s", err)
+	}
+
+	if waiter.IsAcquired() {
+		t.Fatalf("waiter should wait for the enqueued lock")
+	}
+
+	locker.Close()
+
+	acquired := make(chan error, 1)
+
+	go func() {
+		acquired <- waiter.Acquire()
+	}()
+
+	select {
+	case err = <-acquired:
+		if err != nil {
+			t.Fatalf("cannot acquire: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("waiter should not wait for the lock of the closed connection")
+	}
+
+	holder.Close()
+	waiter.Close()
+}
+
+func TestClient_InvalidPendingAbandonTimeout(t *testing.T) {
+	res, err := http.Get(fmt.Sprintf("http://%s/v1?%s=%s&%s=-1", serverAddress, constants.NamespaceQueryParameterName, v1NamespaceName, constants.PendingAbandonTimeoutQueryParameterName))
+	if err != nil {
+		t.Fatalf("cannot query Locktopus server: %s", err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", res.StatusCode)
+	}
+}
+
+func TestClient_Contended(t *testing.T) {
+	holder, err := locktopusclient.MakeClient(locktopusclient.ConnectionOptions{
+		Url: fmt.Sprintf("ws://%s/v1?%s=%s&%s=true", serverAddress, constants.NamespaceQueryParameterName, v1NamespaceName, constants.NotifyContentionQueryParameterName),
+	})
+
+	if err != nil {
+		t.Fatalf("cannot connect to Locktopus server: %s", err)
+	}
+	defer holder.Close()
+
+	waiter, err := locktopusclient.MakeClient(locktopusclient.ConnectionOptions{
+		Url: fmt.Sprintf("ws://%s/v1?%s=%s", serverAddress, constants.NamespaceQueryParameterName, v1NamespaceName),
+	})
+
+	if err != nil {
+		t.Fatalf("cannot connect to Locktopus server: %s", err)
+	}
+	defer waiter.Close()
+
+	holder.AddLockResource(locktopusclient.LockTypeWrite, "test11", "a")
+	waiter.AddLockResource(locktopusclient.LockTypeRead, "test11", "a", "b")
+
+	if err = holder.Lock(); err != nil {
+		t.Fatalf("cannot lock: %s", err)
+	}
+
+	if err = waiter.Lock(); err != nil {
+		t.Fatalf("cannot lock: %s", err)
+	}
+
+	select {
+	case contention := <-holder.Contended():
+		if contention.Waiters != 1 || len(contention.Paths) != 1 || strings.Join(contention.Paths[0], "/") != "test11/a" {
+			t.Fatalf("unexpected contention: %+v", contention)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("holder should be notified about the waiter")
+	}
+
+	// the holder yields
+	if err = holder.Release(); err != nil {
+		t.Fatalf("cannot release: %s", err)
+	}
+
+	if err = waiter.Acquire(); err != nil {
+		t.Fatalf("cannot acquire: %s", err)
+	}
+
+	if err = waiter.Release(); err != nil {
+		t.Fatalf("cannot release: %s", err)
+	}
+}
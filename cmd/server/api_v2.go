@@ -0,0 +1,541 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	ns "github.com/locktopus-project/locktopus/internal/namespace"
+	ml "github.com/locktopus-project/locktopus/pkg/multilocker"
+)
+
+// API v2 is a plain HTTP API for clients that cannot keep a WebSocket open.
+// Locks are not bound to a connection. Instead, each lock has a lease that starts when the lock is acquired.
+// The lock is released when the lease expires unless the client releases it before.
+//
+//	POST   /v2/namespaces/{namespace}/locks              create a lock. Body: {"resources": [...], "leaseMs": 30000, "owner": "...", "labels": {...}}
+//	GET    /v2/namespaces/{namespace}/locks/{id}?wait=5s  get the lock state. With "wait", long-polls until the lock is acquired
+//	PATCH  /v2/namespaces/{namespace}/locks/{id}          renew the lease, so it expires "leaseMs" after now
+//	DELETE /v2/namespaces/{namespace}/locks/{id}          release the lock
+
+// Released and expired locks are kept for some time so clients can still query their state
+const finishedLeaseRetention = time.Minute
+
+type leaseState string
+
+const (
+	leaseStateEnqueued leaseState = "enqueued"
+	leaseStateAcquired leaseState = "acquired"
+	leaseStateReleased leaseState = "released"
+	leaseStateExpired  leaseState = "expired"
+	leaseStateRevoked  leaseState = "revoked" // released with the admin API
+)
+
+type leaseRequest struct {
+	Resources []resource        `json:"resources"`
+	LeaseMs   int64             `json:"leaseMs"`
+	Owner     string            `json:"owner,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+}
+
+type leaseResponse struct {
+	ID        string            `json:"id"`
+	State     leaseState        `json:"state"`
+	LeaseMs   int64             `json:"leaseMs"`
+	ExpiresAt *time.Time        `json:"expiresAt,omitempty"`
+	Owner     string            `json:"owner,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+}
+
+type leaseKey struct {
+	namespace string
+	id        int64
+}
+
+type leasedLock struct {
+	mx        sync.Mutex
+	key       leaseKey
+	lock      *ml.Lock
+	resources []resource
+	client    string // key of the client for the cap of pending groups. Empty for restored leases
+	ttl       time.Duration
+	state     leaseState
+	expiresAt time.Time
+	timer     *time.Timer
+	journal   *lockJournal // nil if the namespace is not durable
+}
+
+var leases = make(map[leaseKey]*leasedLock)
+var leasesMx = sync.Mutex{}
+
+func apiV2Router(r *mux.Router, s *Server) {
+	r.HandleFunc("/namespaces/{namespace}/locks", s.createLeaseHandler).Methods(http.MethodPost)
+	r.HandleFunc("/namespaces/{namespace}/locks/{id}", s.getLeaseHandler).Methods(http.MethodGet)
+	r.HandleFunc("/namespaces/{namespace}/locks/{id}", s.renewLeaseHandler).Methods(http.MethodPatch)
+	r.HandleFunc("/namespaces/{namespace}/locks/{id}", s.deleteLeaseHandler).Methods(http.MethodDelete)
+}
+
+func (s *Server) createLeaseHandler(w http.ResponseWriter, r *http.Request) {
+	if refuseDraining(w) {
+		return
+	}
+
+	namespace := mux.Vars(r)["namespace"]
+
+	if redirectMisrouted(w, r, namespace) {
+		return
+	}
+
+	token, ok := s.authorizeNamespace(w, r, namespace)
+	if !ok {
+		return
+	}
+
+	// the resources are checked before decoding
+	body, err := readBody(r, s.params.RequestLimits.MaxMessageSize)
+	if err == nil {
+		err = s.params.RequestLimits.checkRequest(body)
+	}
+
+	if errors.Is(err, errRequestTooLarge) {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	req := leaseRequest{}
+	if err == nil {
+		err = json.Unmarshal(body, &req)
+	}
+
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("Cannot parse request body: %s", err)))
+		return
+	}
+
+	if err = checkMetadata(req.Owner, req.Labels); err != nil {
+		if errors.Is(err, errRequestTooLarge) {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+		} else {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	if req.LeaseMs <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Field 'leaseMs' is required and should be integer value > 0 representing lease duration (in milliseconds)"))
+		return
+	}
+
+	resourceLocks, err := makeResourceLocks(req.Resources)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	if token != nil {
+		if err = token.AuthorizeLocks(namespace, resourceLocks); err != nil {
+			writeAuthError(w, err)
+			return
+		}
+	}
+
+	multilocker, created, err := ns.GetNamespace(namespace)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	if created {
+		mainLogger.Infof("Created new multilocker namespace %s", namespace)
+	}
+
+	if err = ns.CheckLock(namespace, resourceLocks); err != nil {
+		ns.ReleaseNamespace(namespace)
+
+		if errors.Is(err, ns.ErrNamespaceFrozen) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		} else {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	identity := clientIdentity(r, token)
+
+	// HTTP requests have no connection, so only the limits per remote IP and per client apply
+	var limiter *lockLimiter
+	if err = limiter.checkLock(namespace, identity, r.RemoteAddr); err != nil {
+		ns.ReleaseNamespace(namespace)
+		writeRateLimited(w, err)
+		return
+	}
+
+	journal := journalFor(namespace)
+	metadata := ml.Metadata{Owner: req.Owner, Labels: req.Labels}
+	lock := multilocker.LockWithMetadata(resourceLocks, metadata)
+	ttl := time.Duration(req.LeaseMs) * time.Millisecond
+
+	if err = journal.enqueued(lock.ID(), req.Resources, metadata, "", ttl); err != nil {
+		go func() {
+			lock.Acquire().Unlock()
+			ns.ReleaseNamespace(namespace)
+		}()
+
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(fmt.Sprintf("Cannot write lock: %s", err)))
+		return
+	}
+
+	trackLock(namespace, lock.ID(), req.Resources, metadata, lockHolder{connID: -1, remoteAddr: r.RemoteAddr, client: identity})
+	notifyLock(namespace, lock.ID(), lockEnqueued)
+
+	ll := startLease(namespace, lock, req.Resources, ttl, clientKey(identity, r.RemoteAddr), journal)
+
+	lockLogger.Infof("Locked resources for lease [namespace = %s, id = %d, lease = %v, client = %s%s]: %v", namespace, ll.key.id, ll.ttl, identity, formatMetadata(metadata), resourceLocks)
+
+	writeLeaseResponse(w, http.StatusCreated, ll)
+}
+
+// readBody reads the request body. Bodies exceeding maxSize (if not 0) are rejected before being read completely
+func readBody(r *http.Request, maxSize int64) ([]byte, error) {
+	if maxSize == 0 {
+		return io.ReadAll(r.Body)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(body)) > maxSize {
+		return nil, fmt.Errorf("%w: body exceeds %d bytes", errRequestTooLarge, maxSize)
+	}
+
+	return body, nil
+}
+
+// restoreLease registers the lease restored from the write-ahead log. The lease is restarted when the lock is acquired
+func restoreLease(namespace string, lock *ml.Lock, resources []resource, ttl time.Duration, journal *lockJournal) {
+	startLease(namespace, lock, resources, ttl, "", journal)
+}
+
+// startLease registers the lease. The lease starts when the lock is acquired
+func startLease(namespace string, lock *ml.Lock, resources []resource, ttl time.Duration, client string, journal *lockJournal) *leasedLock {
+	ll := &leasedLock{
+		key:       leaseKey{namespace: namespace, id: lock.ID()},
+		lock:      lock,
+		resources: resources,
+		client:    client,
+		ttl:       ttl,
+		state:     leaseStateEnqueued,
+		journal:   journal,
+	}
+
+	if client != "" {
+		countPending(client, 1)
+	}
+
+	leasesMx.Lock()
+	leases[ll.key] = ll
+	leasesMx.Unlock()
+
+	select {
+	case <-lock.Ready():
+		ll.acquire()
+	default:
+		go func() {
+			select {
+			case <-lock.Ready():
+				ll.acquire()
+			case <-lock.Cancelled():
+			}
+		}()
+	}
+
+	return ll
+}
+
+func (s *Server) getLeaseHandler(w http.ResponseWriter, r *http.Request) {
+	ll, ok := s.findLease(w, r)
+	if !ok {
+		return
+	}
+
+	if r.URL.Query().Has("wait") {
+		wait, err := time.ParseDuration(r.URL.Query().Get("wait"))
+		if err != nil || wait < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("URL parameter 'wait' should be a non-negative duration, e.g. 30s"))
+			return
+		}
+
+		if wait > s.maxResponseDuration() {
+			wait = s.maxResponseDuration()
+		}
+
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+
+		select {
+		case <-ll.lock.Ready():
+			// the lease may not have been started by the background goroutine yet
+			ll.acquire()
+		case <-timer.C:
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	writeLeaseResponse(w, http.StatusOK, ll)
+}
+
+// renewLeaseHandler restarts the lease of the acquired lock. Enqueued locks have nothing to renew, their lease starts on acquisition
+func (s *Server) renewLeaseHandler(w http.ResponseWriter, r *http.Request) {
+	ll, ok := s.findLease(w, r)
+	if !ok {
+		return
+	}
+
+	if state, ok := ll.renew(); !ok {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(fmt.Sprintf("Lock is already %s", state)))
+		return
+	}
+
+	writeLeaseResponse(w, http.StatusOK, ll)
+}
+
+func (s *Server) deleteLeaseHandler(w http.ResponseWriter, r *http.Request) {
+	ll, ok := s.findLease(w, r)
+	if !ok {
+		return
+	}
+
+	if !ll.release(leaseStateReleased) {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(fmt.Sprintf("Lock is already %s", ll.currentState())))
+		return
+	}
+
+	writeLeaseResponse(w, http.StatusOK, ll)
+}
+
+func (s *Server) findLease(w http.ResponseWriter, r *http.Request) (*leasedLock, bool) {
+	vars := mux.Vars(r)
+
+	if redirectMisrouted(w, r, vars["namespace"]) {
+		return nil, false
+	}
+
+	if _, ok := s.authorizeNamespace(w, r, vars["namespace"]); !ok {
+		return nil, false
+	}
+
+	id, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid lock id"))
+		return nil, false
+	}
+
+	leasesMx.Lock()
+	ll, ok := leases[leaseKey{namespace: vars["namespace"], id: id}]
+	leasesMx.Unlock()
+
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Lock not found"))
+		return nil, false
+	}
+
+	return ll, true
+}
+
+func writeLeaseResponse(w http.ResponseWriter, status int, ll *leasedLock) {
+	ll.mx.Lock()
+	response := leaseResponse{
+		ID:      fmt.Sprintf("%d", ll.key.id),
+		State:   ll.state,
+		LeaseMs: ll.ttl.Milliseconds(),
+		Owner:   ll.lock.Metadata().Owner,
+		Labels:  ll.lock.Metadata().Labels,
+	}
+
+	if ll.state == leaseStateAcquired {
+		expiresAt := ll.expiresAt
+		response.ExpiresAt = &expiresAt
+	}
+	ll.mx.Unlock()
+
+	serialized, err := json.Marshal(response)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Cannot serialize response"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(serialized)
+}
+
+// acquire starts the lease. It is a no-op if the lock has been released while enqueued.
+func (ll *leasedLock) acquire() {
+	ll.mx.Lock()
+	defer ll.mx.Unlock()
+
+	if ll.state != leaseStateEnqueued {
+		return
+	}
+
+	if err := ll.journal.acquired(ll.key.id); err != nil {
+		// the lock cannot be handed over without being written
+		go ll.release(leaseStateRevoked)
+		return
+	}
+
+	ll.state = leaseStateAcquired
+	ll.expiresAt = time.Now().Add(ll.ttl)
+	ll.stopPending()
+
+	notifyLock(ll.key.namespace, ll.key.id, lockAcquired)
+
+	ll.timer = time.AfterFunc(ll.ttl, func() {
+		if ll.release(leaseStateExpired) {
+			lockLogger.Infof("Lease expired [namespace = %s, id = %d%s]", ll.key.namespace, ll.key.id, formatMetadata(ll.lock.Metadata()))
+		}
+	})
+}
+
+// renew restarts the lease of the acquired lock. It returns false along with the state if the lock has already been released, expired or revoked.
+func (ll *leasedLock) renew() (leaseState, bool) {
+	ll.mx.Lock()
+	defer ll.mx.Unlock()
+
+	if ll.state != leaseStateAcquired {
+		return ll.state, ll.state == leaseStateEnqueued
+	}
+
+	// the lease is expiring in the background
+	if !ll.timer.Stop() {
+		return leaseStateExpired, false
+	}
+
+	ll.expiresAt = time.Now().Add(ll.ttl)
+	ll.timer.Reset(ll.ttl)
+
+	return ll.state, true
+}
+
+// release unlocks the group and sets the final state. It returns false if the lock has already been released, expired or revoked.
+func (ll *leasedLock) release(final leaseState) bool {
+	ll.mx.Lock()
+
+	prev := ll.state
+	if prev == leaseStateReleased || prev == leaseStateExpired || prev == leaseStateRevoked {
+		ll.mx.Unlock()
+		return false
+	}
+
+	ll.state = final
+	if ll.timer != nil {
+		ll.timer.Stop()
+	}
+
+	if prev == leaseStateEnqueued {
+		ll.stopPending()
+	}
+
+	ll.mx.Unlock()
+
+	// the release is recorded before the dependent locks can be acquired
+	ll.journal.released(ll.key.id)
+
+	switch final {
+	case leaseStateRevoked:
+		notifyLock(ll.key.namespace, ll.key.id, lockRevoked)
+	case leaseStateExpired:
+		notifyLock(ll.key.namespace, ll.key.id, lockAbandoned)
+	default:
+		notifyLock(ll.key.namespace, ll.key.id, lockReleased)
+	}
+
+	if prev == leaseStateAcquired {
+		ll.lock.Acquire().Unlock()
+	} else {
+		releaseGroup(ll.lock)
+	}
+
+	lockLogger.Infof("Released resources for lease [namespace = %s, id = %d%s]", ll.key.namespace, ll.key.id, formatMetadata(ll.lock.Metadata()))
+
+	ns.ReleaseNamespace(ll.key.namespace)
+
+	time.AfterFunc(finishedLeaseRetention, func() {
+		leasesMx.Lock()
+		// the key may have been taken by a lease restored by a new cluster leader
+		if leases[ll.key] == ll {
+			delete(leases, ll.key)
+		}
+		leasesMx.Unlock()
+	})
+
+	return true
+}
+
+// stopPending uncounts the lease from the pending groups of the client once it is no longer enqueued
+func (ll *leasedLock) stopPending() {
+	if ll.client != "" {
+		countPending(ll.client, -1)
+	}
+}
+
+// revokeLease releases the leased lock. It returns false if there is no such lease or it has already finished.
+func revokeLease(namespace string, id int64) bool {
+	leasesMx.Lock()
+	ll, ok := leases[leaseKey{namespace: namespace, id: id}]
+	leasesMx.Unlock()
+
+	return ok && ll.release(leaseStateRevoked)
+}
+
+// revokeLeases releases all the leases. It returns the number of leases released
+func revokeLeases() int {
+	leasesMx.Lock()
+	list := make([]*leasedLock, 0, len(leases))
+	for _, ll := range leases {
+		list = append(list, ll)
+	}
+	leasesMx.Unlock()
+
+	n := 0
+	for _, ll := range list {
+		if ll.release(leaseStateRevoked) {
+			n++
+		}
+	}
+
+	return n
+}
+
+func (ll *leasedLock) currentState() leaseState {
+	ll.mx.Lock()
+	defer ll.mx.Unlock()
+
+	return ll.state
+}
@@ -0,0 +1,204 @@
+package main_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"testing"
+	"time"
+)
+
+const v2NamespaceName = "v2_namespace"
+
+type v2Lease struct {
+	ID        string     `json:"id"`
+	State     string     `json:"state"`
+	LeaseMs   int64      `json:"leaseMs"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+func v2Request(t *testing.T, method, path string, body interface{}) (int, v2Lease) {
+	reader := bytes.NewReader(nil)
+
+	if body != nil {
+		serialized, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("cannot serialize request body: %s", err)
+		}
+
+		reader = bytes.NewReader(serialized)
+	}
+
+	req, err := http.NewRequest(method, fmt.Sprintf("http://%s/v2/namespaces/%s/locks%s", serverAddress, v2NamespaceName, path), reader)
+	if err != nil {
+		t.Fatalf("cannot make request: %s", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("cannot query Locktopus server: %s", err)
+	}
+
+	defer resp.Body.Close()
+
+	lease := v2Lease{}
+	if resp.StatusCode < 300 {
+		if err = json.NewDecoder(resp.Body).Decode(&lease); err != nil {
+			t.Fatalf("cannot parse response body: %s", err)
+		}
+	}
+
+	return resp.StatusCode, lease
+}
+
+func v2LockBody(leaseMs int64, path ...string) map[string]interface{} {
+	return map[string]interface{}{
+		"resources": []map[string]interface{}{{"type": "write", "path": path}},
+		"leaseMs":   leaseMs,
+	}
+}
+
+func TestV2_LeaseIsRequired(t *testing.T) {
+	status, _ := v2Request(t, http.MethodPost, "", v2LockBody(0, "v2_test1"))
+
+	if status != http.StatusBadRequest {
+		t.Fatalf("Status code is not 400")
+	}
+}
+
+func TestV2_SequentialAcquire(t *testing.T) {
+	status, locker := v2Request(t, http.MethodPost, "", v2LockBody(60000, "v2_test2"))
+	if status != http.StatusCreated {
+		t.Fatalf("Status code is not 201")
+	}
+
+	if locker.State != "acquired" || locker.ExpiresAt == nil {
+		t.Fatalf("locker's lock should be acquired")
+	}
+
+	_, waiter := v2Request(t, http.MethodPost, "", v2LockBody(60000, "v2_test2"))
+	if waiter.State != "enqueued" {
+		t.Fatalf("waiter's lock should be enqueued")
+	}
+
+	_, polled := v2Request(t, http.MethodGet, "/"+waiter.ID+"?wait=100ms", nil)
+	if polled.State != "enqueued" {
+		t.Fatalf("waiter's lock should still be enqueued")
+	}
+
+	status, released := v2Request(t, http.MethodDelete, "/"+locker.ID, nil)
+	if status != http.StatusOK || released.State != "released" {
+		t.Fatalf("locker's lock should be released")
+	}
+
+	_, polled = v2Request(t, http.MethodGet, "/"+waiter.ID+"?wait=5s", nil)
+	if polled.State != "acquired" {
+		t.Fatalf("waiter's lock should be acquired")
+	}
+
+	if status, _ = v2Request(t, http.MethodDelete, "/"+locker.ID, nil); status != http.StatusConflict {
+		t.Fatalf("Status code is not 409")
+	}
+
+	v2Request(t, http.MethodDelete, "/"+waiter.ID, nil)
+}
+
+func TestV2_LeaseExpiration(t *testing.T) {
+	leaseMs := int64(100)
+
+	_, locker := v2Request(t, http.MethodPost, "", v2LockBody(leaseMs, "v2_test3"))
+	_, waiter := v2Request(t, http.MethodPost, "", v2LockBody(60000, "v2_test3"))
+
+	now := time.Now()
+
+	_, polled := v2Request(t, http.MethodGet, "/"+waiter.ID+"?wait=5s", nil)
+	if polled.State != "acquired" {
+		t.Fatalf("waiter's lock should be acquired after locker's lease expires")
+	}
+
+	if time.Since(now) >= 5*time.Second {
+		t.Fatalf("waiter should not wait for the whole poll duration")
+	}
+
+	_, expired := v2Request(t, http.MethodGet, "/"+locker.ID, nil)
+	if expired.State != "expired" {
+		t.Fatalf("locker's lock should be expired")
+	}
+
+	v2Request(t, http.MethodDelete, "/"+waiter.ID, nil)
+}
+
+func TestV2_LeaseRenewal(t *testing.T) {
+	leaseMs := int64(300)
+
+	_, locker := v2Request(t, http.MethodPost, "", v2LockBody(leaseMs, "v2_renew"))
+	if locker.State != "acquired" {
+		t.Fatalf("locker's lock should be acquired")
+	}
+
+	for i := 0; i < 3; i++ {
+		time.Sleep(200 * time.Millisecond)
+
+		status, renewed := v2Request(t, http.MethodPatch, "/"+locker.ID, nil)
+		if status != http.StatusOK || renewed.State != "acquired" {
+			t.Fatalf("lease should be renewed before it expires")
+		}
+
+		if !renewed.ExpiresAt.After(*locker.ExpiresAt) {
+			t.Fatalf("renewed lease should expire later")
+		}
+	}
+
+	if status, released := v2Request(t, http.MethodDelete, "/"+locker.ID, nil); status != http.StatusOK || released.State != "released" {
+		t.Fatalf("lock should not expire after being renewed")
+	}
+
+	if status, _ := v2Request(t, http.MethodPatch, "/"+locker.ID, nil); status != http.StatusConflict {
+		t.Fatalf("Status code is not 409")
+	}
+}
+
+func TestV2_NotFound(t *testing.T) {
+	if status, _ := v2Request(t, http.MethodGet, "/1000000", nil); status != http.StatusNotFound {
+		t.Fatalf("Status code is not 404")
+	}
+}
+
+func TestV2_DeletedPendingLeasesDoNotLeak(t *testing.T) {
+	status, holder := v2Request(t, http.MethodPost, "", v2LockBody(60000, "v2_leak"))
+	if status != http.StatusCreated || holder.State != "acquired" {
+		t.Fatalf("holder's lock should be acquired")
+	}
+
+	before := runtime.NumGoroutine()
+
+	const waiters = 100
+
+	for i := 0; i < waiters; i++ {
+		status, waiter := v2Request(t, http.MethodPost, "", v2LockBody(60000, "v2_leak"))
+		if status != http.StatusCreated || waiter.State != "enqueued" {
+			t.Fatalf("waiter's lock should be enqueued")
+		}
+
+		if status, _ = v2Request(t, http.MethodDelete, "/"+waiter.ID, nil); status != http.StatusOK {
+			t.Fatalf("Status code is not 200")
+		}
+	}
+
+	// the cancelled locks give up their resources once the holder releases them
+	if status, _ = v2Request(t, http.MethodDelete, "/"+holder.ID, nil); status != http.StatusOK {
+		t.Fatalf("Status code is not 200")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for runtime.NumGoroutine() > before+waiters/2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("goroutines of the deleted leases should exit: %d before, %d after", before, runtime.NumGoroutine())
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
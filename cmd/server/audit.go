@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/locktopus-project/locktopus/internal/audit"
+)
+
+// Lock events are written to the audit log as JSON lines (see internal/audit and lockevents.go)
+
+const auditQueueSize = 10000
+
+// auditDropWarningInterval limits the warnings about dropped records, since records are dropped when the server is busiest.
+// The exact numbers are given by GET /admin/audit
+const auditDropWarningInterval = 10 * time.Second
+
+var auditDropsUnwarned int64 // records dropped since the last warning
+var auditDropWarnedAt int64  // unix nanoseconds
+
+// auditLog is nil if the audit log is disabled
+var auditLog *audit.Log
+var auditMx = sync.RWMutex{}
+
+// StartAudit opens the audit log. The previous log, if any, is closed
+func StartAudit(options audit.Options) error {
+	if options.QueueSize == 0 {
+		options.QueueSize = auditQueueSize
+	}
+
+	options.OnError = func(err error) {
+		mainLogger.Errorf("Cannot write audit log: %s", err)
+	}
+
+	l, err := audit.Open(options)
+	if err != nil {
+		return err
+	}
+
+	auditMx.Lock()
+	prev := auditLog
+	auditLog = l
+	auditMx.Unlock()
+
+	if prev != nil {
+		prev.Close()
+	}
+
+	return nil
+}
+
+// StopAudit writes the queued records and closes the audit log
+func StopAudit() {
+	auditMx.Lock()
+	prev := auditLog
+	auditLog = nil
+	auditMx.Unlock()
+
+	if prev != nil {
+		prev.Close()
+	}
+}
+
+func writeAudit(r audit.Record) {
+	auditMx.RLock()
+	defer auditMx.RUnlock()
+
+	if auditLog == nil {
+		return
+	}
+
+	if !auditLog.Write(r) {
+		warnAuditDrop(r)
+	}
+}
+
+// warnAuditDrop logs the dropped record along with the ones dropped since the last warning, at most once per auditDropWarningInterval
+func warnAuditDrop(r audit.Record) {
+	atomic.AddInt64(&auditDropsUnwarned, 1)
+
+	now := time.Now().UnixNano()
+	warnedAt := atomic.LoadInt64(&auditDropWarnedAt)
+
+	if now-warnedAt < int64(auditDropWarningInterval) || !atomic.CompareAndSwapInt64(&auditDropWarnedAt, warnedAt, now) {
+		return
+	}
+
+	dropped := atomic.SwapInt64(&auditDropsUnwarned, 0)
+
+	mainLogger.Warnf("Dropped %d audit records since the last warning, the last one [event = %s, namespace = %s, group = %d]: queue is full", dropped, r.Event, r.Namespace, r.GroupID)
+}
+
+func (s *Server) auditStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+
+	auditMx.RLock()
+	l := auditLog
+	auditMx.RUnlock()
+
+	if l == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Audit log is disabled"))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, l.Stats())
+}
@@ -0,0 +1,310 @@
+package main_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	main "github.com/locktopus-project/locktopus/cmd/server"
+	"github.com/locktopus-project/locktopus/internal/audit"
+	"github.com/locktopus-project/locktopus/internal/constants"
+	locktopusclient "github.com/locktopus-project/locktopus/pkg/client/v1"
+)
+
+func startAudit(t *testing.T, options audit.Options) string {
+	options.Path = filepath.Join(t.TempDir(), "audit.log")
+
+	if err := main.StartAudit(options); err != nil {
+		t.Fatalf("cannot open audit log: %s", err)
+	}
+
+	t.Cleanup(main.StopAudit)
+
+	return options.Path
+}
+
+func readAuditRecords(t *testing.T, path, namespace string) []audit.Record {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("cannot open audit log: %s", err)
+	}
+	defer f.Close()
+
+	records := []audit.Record{}
+	scanner := bufio.NewScanner(f)
+
+	for scanner.Scan() {
+		r := audit.Record{}
+		if err = json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatalf("cannot parse audit record %s: %s", scanner.Text(), err)
+		}
+
+		if r.Namespace == namespace {
+			records = append(records, r)
+		}
+	}
+
+	return records
+}
+
+func TestAudit_WriteLockEvents(t *testing.T) {
+	namespace := "audit_events"
+	path := startAudit(t, audit.Options{})
+
+	client, err := locktopusclient.MakeClient(locktopusclient.ConnectionOptions{
+		Url: fmt.Sprintf("ws://%s/v1?%s=%s&%s=0", serverAddress, constants.NamespaceQueryParameterName, namespace, constants.AbandonTimeoutQueryParameterName),
+	})
+	if err != nil {
+		t.Fatalf("cannot connect to Locktopus server: %s", err)
+	}
+
+	client.AddLockResource(locktopusclient.LockTypeWrite, "a", "b")
+	if err = client.Lock(); err != nil {
+		t.Fatalf("cannot lock: %s", err)
+	}
+
+	if err = client.Release(); err != nil {
+		t.Fatalf("cannot release: %s", err)
+	}
+
+	if err = client.Lock(); err != nil {
+		t.Fatalf("cannot lock: %s", err)
+	}
+
+	// the lock is abandoned
+	client.Close()
+
+	expected := []string{audit.EventLock, audit.EventAcquire, audit.EventRelease, audit.EventLock, audit.EventAcquire, audit.EventAbandon}
+
+	var records []audit.Record
+	deadline := time.Now().Add(5 * time.Second)
+
+	for {
+		if records = readAuditRecords(t, path, namespace); len(records) >= len(expected) {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("audit log should contain %d records, got %d", len(expected), len(records))
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for i, r := range records {
+		if r.Event != expected[i] {
+			t.Fatalf("record %d should be %s, got %+v", i, expected[i], r)
+		}
+
+		if r.ConnectionID == nil || r.RemoteAddr == "" || len(r.Resources) != 1 || len(r.Resources[0].Path) != 2 {
+			t.Fatalf("record should carry the connection and the resources: %+v", r)
+		}
+	}
+
+	if records[0].GroupID == records[3].GroupID || records[3].GroupID != records[5].GroupID {
+		t.Fatalf("records should carry the group IDs: %+v", records)
+	}
+
+	if records[2].EnqueuedAt == nil || records[2].AcquiredAt == nil || records[2].AcquiredAt.After(records[2].Time) {
+		t.Fatalf("release should carry the lock timestamps: %+v", records[2])
+	}
+}
+
+func TestAudit_Rotation(t *testing.T) {
+	namespace := "audit_rotation"
+	path := startAudit(t, audit.Options{MaxSize: 1000, MaxFiles: 2})
+
+	client, err := locktopusclient.MakeClient(locktopusclient.ConnectionOptions{
+		Url: fmt.Sprintf("ws://%s/v1?%s=%s", serverAddress, constants.NamespaceQueryParameterName, namespace),
+	})
+	if err != nil {
+		t.Fatalf("cannot connect to Locktopus server: %s", err)
+	}
+	defer client.Close()
+
+	client.AddLockResource(locktopusclient.LockTypeWrite, "a")
+
+	for i := 0; i < 20; i++ {
+		if err = client.Lock(); err != nil {
+			t.Fatalf("cannot lock: %s", err)
+		}
+
+		if err = client.Release(); err != nil {
+			t.Fatalf("cannot release: %s", err)
+		}
+	}
+
+	main.StopAudit()
+
+	files, err := audit.RotatedFiles(path)
+	if err != nil {
+		t.Fatalf("cannot list rotated files: %s", err)
+	}
+
+	if len(files) != 2 {
+		t.Fatalf("2 rotated files should be kept, got %v", files)
+	}
+
+	for _, f := range append(files, path) {
+		info, err := os.Stat(f)
+		if err != nil {
+			t.Fatalf("cannot stat %s: %s", f, err)
+		}
+
+		if info.Size() > 1000 {
+			t.Fatalf("%s exceeds the size limit: %d", f, info.Size())
+		}
+	}
+
+	// the last file continues the rotated ones
+	records := readAuditRecords(t, path, namespace)
+	if len(records) == 0 || records[len(records)-1].Event != audit.EventRelease {
+		t.Fatalf("current file should end with the last release: %+v", records)
+	}
+}
+
+// Files rotated within the same millisecond get sequence suffixes instead of overwriting each other
+func TestAudit_RotationWithinMillisecond(t *testing.T) {
+	const namespace = "audit_fast_rotation"
+	const count = 50
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	// every record is rotated out by the next one
+	l, err := audit.Open(audit.Options{Path: path, MaxSize: 1, QueueSize: count})
+	if err != nil {
+		t.Fatalf("cannot open audit log: %s", err)
+	}
+
+	for i := 1; i <= count; i++ {
+		if !l.Write(audit.Record{Time: time.Now(), Event: audit.EventLock, Namespace: namespace, GroupID: int64(i)}) {
+			t.Fatalf("record should not be dropped")
+		}
+	}
+
+	if err = l.Close(); err != nil {
+		t.Fatalf("cannot close audit log: %s", err)
+	}
+
+	files, err := audit.RotatedFiles(path)
+	if err != nil {
+		t.Fatalf("cannot list rotated files: %s", err)
+	}
+
+	if len(files) != count-1 {
+		t.Fatalf("%d rotated files should be kept, got %d", count-1, len(files))
+	}
+
+	// the rotated files are listed in the order of rotation
+	id := int64(0)
+	for _, f := range append(files, path) {
+		for _, r := range readAuditRecords(t, f, namespace) {
+			if id++; r.GroupID != id {
+				t.Fatalf("record %d should be in place of %d in %s", r.GroupID, id, f)
+			}
+		}
+	}
+
+	if id != count {
+		t.Fatalf("%d records should be kept, got %d", count, id)
+	}
+}
+
+// The release of a lock is recorded before the locks waiting for it can be acquired, so the audit log never shows a group acquired before the release it has waited for
+func TestAudit_ReleaseRecordedBeforeDependentAcquire(t *testing.T) {
+	path := startAudit(t, audit.Options{})
+
+	releases := map[string]func(holder *locktopusclient.LocktopusClient) error{
+		// the lock is abandoned right away
+		"audit_order_abandon": func(holder *locktopusclient.LocktopusClient) error {
+			return holder.Close()
+		},
+		"audit_order_release": func(holder *locktopusclient.LocktopusClient) error {
+			defer holder.Close()
+
+			return holder.Release()
+		},
+	}
+
+	for namespace, release := range releases {
+		waiter, err := locktopusclient.MakeClient(locktopusclient.ConnectionOptions{
+			Url: fmt.Sprintf("ws://%s/v1?%s=%s", serverAddress, constants.NamespaceQueryParameterName, namespace),
+		})
+		if err != nil {
+			t.Fatalf("cannot connect to Locktopus server: %s", err)
+		}
+		defer waiter.Close()
+
+		waiter.AddLockResource(locktopusclient.LockTypeWrite, "a")
+
+		const rounds = 100
+
+		for i := 0; i < rounds; i++ {
+			holder, err := locktopusclient.MakeClient(locktopusclient.ConnectionOptions{
+				Url: fmt.Sprintf("ws://%s/v1?%s=%s&%s=0", serverAddress, constants.NamespaceQueryParameterName, namespace, constants.AbandonTimeoutQueryParameterName),
+			})
+			if err != nil {
+				t.Fatalf("cannot connect to Locktopus server: %s", err)
+			}
+
+			holder.AddLockResource(locktopusclient.LockTypeWrite, "a")
+			if err = holder.Lock(); err != nil {
+				t.Fatalf("cannot lock: %s", err)
+			}
+
+			if err = waiter.Lock(); err != nil {
+				t.Fatalf("cannot lock: %s", err)
+			}
+
+			if err = release(holder); err != nil {
+				t.Fatalf("cannot release: %s", err)
+			}
+
+			if err = waiter.Acquire(); err != nil {
+				t.Fatalf("cannot acquire: %s", err)
+			}
+
+			if err = waiter.Release(); err != nil {
+				t.Fatalf("cannot release: %s", err)
+			}
+		}
+
+		// lock, acquire and release of the holder, lock, acquire and release of the waiter
+		var records []audit.Record
+		deadline := time.Now().Add(5 * time.Second)
+
+		for {
+			if records = readAuditRecords(t, path, namespace); len(records) >= rounds*6 {
+				break
+			}
+
+			if time.Now().After(deadline) {
+				t.Fatalf("%s: audit log should contain %d records, got %d", namespace, rounds*6, len(records))
+			}
+
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		// all the groups lock the same resource, so a group is acquired after the earlier ones are released
+		unreleased := map[int64]bool{}
+
+		for _, r := range records {
+			switch r.Event {
+			case audit.EventLock:
+				unreleased[r.GroupID] = true
+			case audit.EventAbandon, audit.EventRelease:
+				delete(unreleased, r.GroupID)
+			case audit.EventAcquire:
+				for id := range unreleased {
+					if id < r.GroupID {
+						t.Fatalf("%s: group %d is acquired before the release of group %d is recorded", namespace, r.GroupID, id)
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/locktopus-project/locktopus/internal/auth"
+	"github.com/locktopus-project/locktopus/internal/constants"
+)
+
+// bearerToken extracts the token from the Authorization header.
+// Browsers cannot set headers for WebSocket connections, so the URL parameter is accepted as well.
+func bearerToken(r *http.Request) string {
+	if token := headerToken(r); token != "" {
+		return token
+	}
+
+	return r.URL.Query().Get(constants.AccessTokenQueryParameterName)
+}
+
+// headerToken extracts the token from the Authorization header only
+func headerToken(r *http.Request) string {
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		return strings.TrimPrefix(h, "Bearer ")
+	}
+
+	return ""
+}
+
+// authorizeNamespace writes an error response if the request is not allowed to access the namespace.
+// The returned token is nil if authentication is disabled.
+func (s *Server) authorizeNamespace(w http.ResponseWriter, r *http.Request, namespace string) (*auth.Token, bool) {
+	if s.params.Authenticator == nil {
+		return nil, true
+	}
+
+	token, err := s.params.Authenticator.Authenticate(bearerToken(r))
+	if err == nil {
+		err = token.AuthorizeNamespace(namespace)
+	}
+
+	if err != nil {
+		writeAuthError(w, err)
+		return nil, false
+	}
+
+	return token, true
+}
+
+func writeAuthError(w http.ResponseWriter, err error) {
+	if errors.Is(err, auth.ErrUnauthenticated) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		w.WriteHeader(http.StatusUnauthorized)
+	} else {
+		w.WriteHeader(http.StatusForbidden)
+	}
+
+	w.Write([]byte(err.Error()))
+}
+
+// authorizeAdmin writes an error response if the request is not made with an admin token.
+// The admin API is disabled when authentication is disabled. The admin token is only taken from the Authorization header,
+// so it never ends up in URLs kept by access logs, proxies and browser history.
+func (s *Server) authorizeAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if s.params.Authenticator == nil {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("Admin API requires authentication to be enabled"))
+		return false
+	}
+
+	token, err := s.params.Authenticator.Authenticate(headerToken(r))
+	if err == nil {
+		err = token.AuthorizeAdmin()
+	}
+
+	if err != nil {
+		writeAuthError(w, err)
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,122 @@
+package main_test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/websocket"
+
+	main "github.com/locktopus-project/locktopus/cmd/server"
+	"github.com/locktopus-project/locktopus/internal/auth"
+	"github.com/locktopus-project/locktopus/internal/constants"
+	locktopusclient "github.com/locktopus-project/locktopus/pkg/client/v1"
+)
+
+const authNamespaceName = "auth_namespace"
+const authToken = "secret"
+
+const unauthorizedCode = 3001
+
+func startAuthTestServer(t *testing.T) string {
+	authenticator, err := auth.NewAuthenticator(auth.Config{
+		Tokens: []auth.TokenConfig{
+			{
+				Name:  "test",
+				Token: authToken,
+				Namespaces: []auth.NamespaceGrant{
+					{
+						Pattern: "auth_*",
+						Paths:   []auth.PathGrant{{Prefix: []string{"allowed"}, Types: []string{"write"}}},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("cannot make authenticator: %s", err)
+	}
+
+	return startTestServer(t, main.ServerParameters{Authenticator: authenticator})
+}
+
+func TestAuth_TokenIsRequired(t *testing.T) {
+	address := startAuthTestServer(t)
+
+	_, err := locktopusclient.MakeClient(locktopusclient.ConnectionOptions{
+		Url: fmt.Sprintf("ws://%s/v1?%s=%s", address, constants.NamespaceQueryParameterName, authNamespaceName),
+	})
+	if err == nil {
+		t.Fatalf("client without token should not be able to connect")
+	}
+
+	resp, err := http.Get(fmt.Sprintf("http://%s/stats_v1?%s=%s", address, constants.NamespaceQueryParameterName, authNamespaceName))
+	if err != nil {
+		t.Fatalf("cannot query Locktopus server: %s", err)
+	}
+
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Status code is not 401")
+	}
+}
+
+func TestAuth_ForbiddenNamespace(t *testing.T) {
+	address := startAuthTestServer(t)
+
+	_, err := locktopusclient.MakeClient(locktopusclient.ConnectionOptions{
+		Url:   fmt.Sprintf("ws://%s/v1?%s=%s", address, constants.NamespaceQueryParameterName, "other_namespace"),
+		Token: authToken,
+	})
+	if err == nil {
+		t.Fatalf("client should not be able to connect to a namespace not granted by the token")
+	}
+}
+
+func TestAuth_AllowedPath(t *testing.T) {
+	address := startAuthTestServer(t)
+
+	client, err := locktopusclient.MakeClient(locktopusclient.ConnectionOptions{
+		Url:   fmt.Sprintf("ws://%s/v1?%s=%s", address, constants.NamespaceQueryParameterName, authNamespaceName),
+		Token: authToken,
+	})
+	if err != nil {
+		t.Fatalf("cannot connect to Locktopus server: %s", err)
+	}
+	defer client.Close()
+
+	client.AddLockResource(locktopusclient.LockTypeWrite, "allowed", "resource")
+
+	if err = client.Lock(); err != nil {
+		t.Fatalf("cannot lock: %s", err)
+	}
+
+	if !client.IsAcquired() {
+		t.Fatalf("client should have acquired the lock")
+	}
+}
+
+func TestAuth_ForbiddenPath(t *testing.T) {
+	address := startAuthTestServer(t)
+
+	conn, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://%s/v1?%s=%s&%s=%s", address, constants.NamespaceQueryParameterName, authNamespaceName, constants.AccessTokenQueryParameterName, authToken), nil)
+	if err != nil {
+		t.Fatalf("cannot connect to Locktopus server: %s", err)
+	}
+	defer conn.Close()
+
+	err = conn.WriteJSON(map[string]interface{}{
+		"action":    "lock",
+		"resources": []map[string]interface{}{{"type": "read", "path": []string{"allowed"}}},
+	})
+	if err != nil {
+		t.Fatalf("cannot write request: %s", err)
+	}
+
+	for err == nil {
+		_, _, err = conn.ReadMessage()
+	}
+
+	if !websocket.IsCloseError(err, unauthorizedCode) {
+		t.Fatalf("connection should be closed with code %d, got: %s", unauthorizedCode, err)
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	ns "github.com/locktopus-project/locktopus/internal/namespace"
+	"github.com/locktopus-project/locktopus/internal/wal"
+	"github.com/locktopus-project/locktopus/pkg/raft"
+)
+
+// In cluster mode, lock records of all namespaces are replicated with Raft instead of being written to the write-ahead log.
+// Only the leader serves clients. When elected, it restores the replicated locks the same way as after restart with the write-ahead log,
+// so connection-bound locks can be resumed with their session keys, and group IDs keep growing across failovers.
+// Followers redirect HTTP and WebSocket clients to the leader. The Raft log is compacted into snapshots of the replicated lock table.
+
+// clusterProposeTimeout limits waiting for a lock record to be committed
+const clusterProposeTimeout = 5 * time.Second
+
+// clusterRetryAfter is the retry hint given to clients while there is no leader
+const clusterRetryAfter = time.Second
+
+var errNotLeader = fmt.Errorf("%w: not the cluster leader", errDisconnected)
+
+// cluster is nil unless the server runs in cluster mode
+var cluster *clusterNode
+
+type ClusterParameters struct {
+	NodeID          string
+	Peers           map[string]string // client-facing base URLs of all the nodes by ID, e.g. http://10.0.0.1:9009. Raft requests are sent there as well
+	Dir             string            // where the Raft log is stored
+	Secret          string            // if provided, nodes authenticate Raft requests with it
+	ElectionTimeout time.Duration
+	ResumeGrace     time.Duration
+}
+
+type clusterNode struct {
+	node    *raft.Node
+	storage *raft.FileStorage
+	peers   map[string]string
+	secret  string
+	serving atomic.Bool // the node is the leader and has restored the locks
+
+	mx    sync.Mutex
+	table *wal.Table
+}
+
+// StartCluster joins the cluster. The node serves clients once it is elected as the leader
+func StartCluster(params ClusterParameters) error {
+	if _, ok := params.Peers[params.NodeID]; !ok {
+		return fmt.Errorf("node %s is not in the peer list", params.NodeID)
+	}
+
+	storage, err := raft.NewFileStorage(params.Dir)
+	if err != nil {
+		return err
+	}
+
+	c := &clusterNode{
+		storage: storage,
+		peers:   params.Peers,
+		secret:  params.Secret,
+		table:   wal.NewTable(),
+	}
+
+	ids := make([]string, 0, len(params.Peers))
+	for id := range params.Peers {
+		ids = append(ids, id)
+	}
+
+	c.node, err = raft.NewNode(raft.Config{
+		ID:              params.NodeID,
+		Peers:           ids,
+		Transport:       raft.NewHTTPTransport(params.Peers, params.Secret, &http.Client{Timeout: params.ElectionTimeout}),
+		Storage:         storage,
+		Apply:           c.apply,
+		Snapshot:        c.snapshot,
+		Restore:         c.restore,
+		ElectionTimeout: params.ElectionTimeout,
+	})
+	if err != nil {
+		storage.Close()
+		return err
+	}
+
+	cluster = c
+	journalLog = c
+	walResumeGrace = params.ResumeGrace
+
+	c.node.Start()
+	go c.watchLeadership()
+
+	mainLogger.Infof("Joined the cluster as %s [peers = %d]", params.NodeID, len(params.Peers))
+
+	return nil
+}
+
+func StopCluster() {
+	if cluster != nil {
+		cluster.node.Stop()
+		cluster.storage.Close()
+	}
+}
+
+// Append replicates the record. Errors wrap errNotLeader, so connections are closed without waiting for the abandon timeout
+func (c *clusterNode) Append(r wal.Record) error {
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+
+	serialized, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), clusterProposeTimeout)
+	defer cancel()
+
+	if err = c.node.Propose(ctx, serialized); err != nil {
+		return fmt.Errorf("%w (%s)", errNotLeader, err)
+	}
+
+	return nil
+}
+
+func (c *clusterNode) apply(data []byte) {
+	r := wal.Record{}
+	if err := json.Unmarshal(data, &r); err != nil {
+		mainLogger.Errorf("Cannot parse replicated lock record: %s", err)
+		return
+	}
+
+	c.mx.Lock()
+	c.table.Apply(r)
+	c.mx.Unlock()
+}
+
+// snapshot returns the replicated lock table, so the Raft log can be compacted
+func (c *clusterNode) snapshot() []byte {
+	c.mx.Lock()
+	state := c.table.State()
+	c.mx.Unlock()
+
+	serialized, err := json.Marshal(state)
+	if err != nil {
+		mainLogger.Errorf("Cannot make snapshot of replicated locks: %s", err)
+		return nil
+	}
+
+	return serialized
+}
+
+// restore replaces the lock table with a snapshot made by this node or sent by the leader
+func (c *clusterNode) restore(data []byte) {
+	state := wal.State{}
+	if err := json.Unmarshal(data, &state); err != nil {
+		mainLogger.Errorf("Cannot parse snapshot of replicated locks: %s", err)
+		return
+	}
+
+	table := wal.NewTable()
+	table.Load(state)
+
+	c.mx.Lock()
+	c.table = table
+	c.mx.Unlock()
+}
+
+// watchLeadership starts serving when the node becomes the leader, and resets the local state when it loses leadership
+func (c *clusterNode) watchLeadership() {
+	var servingTerm uint64
+
+	for range c.node.Changed() {
+		status := c.node.Status()
+
+		if servingTerm != 0 && (!status.Ready || status.Term != servingTerm) {
+			c.serving.Store(false)
+			servingTerm = 0
+
+			mainLogger.Infof("Lost cluster leadership [term = %d, leader = %s]", status.Term, status.Leader)
+
+			c.stepDown()
+		}
+
+		if status.Ready && servingTerm == 0 {
+			c.mx.Lock()
+			state := c.table.State()
+			c.mx.Unlock()
+
+			restoreState(state, false, nil)
+
+			servingTerm = status.Term
+			c.serving.Store(true)
+
+			mainLogger.Infof("Serving as the cluster leader [term = %d, locks = %d]", status.Term, len(state.Groups))
+		}
+	}
+}
+
+// stepDown closes the sessions and releases the local locks, since the new leader restores them from the replicated log
+func (c *clusterNode) stepDown() {
+	disconnectAll(errNotLeader)
+	revokeLeases()
+	dropRestoredSessions()
+
+	<-ns.ResetNamespaces()
+}
+
+// leaderAddress returns the base URL of the leader, or an empty string if the leader is unknown
+func (c *clusterNode) leaderAddress() string {
+	return c.peers[c.node.Status().Leader]
+}
+
+// clusterRedirect makes followers redirect clients to the leader. Health checks and Raft requests are served by every node
+func clusterRedirect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cluster == nil || cluster.serving.Load() || !redirectedPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if refuseNotLeader(w) {
+			return
+		}
+
+		http.Redirect(w, r, strings.TrimSuffix(cluster.leaderAddress(), "/")+r.URL.RequestURI(), http.StatusTemporaryRedirect)
+	})
+}
+
+func redirectedPath(path string) bool {
+	for _, p := range []string{"/healthz", "/readyz", raft.HTTPPathPrefix + "/", "/admin/cluster"} {
+		if strings.HasPrefix(path, p) {
+			return false
+		}
+	}
+
+	return path != "/"
+}
+
+// refuseNotLeader writes 503 with a retry hint if the node is not the leader and does not know the leader
+func refuseNotLeader(w http.ResponseWriter) bool {
+	if cluster == nil || cluster.serving.Load() || cluster.leaderAddress() != "" {
+		return false
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(clusterRetryAfter.Seconds())))
+	w.WriteHeader(http.StatusServiceUnavailable)
+	w.Write([]byte("No cluster leader, retry later"))
+
+	return true
+}
+
+// refuseInCluster writes 409 with the reason in cluster mode. Namespace policies, freezes and the shard map are not replicated,
+// so the admin API cannot change them: a new leader would not have the changes
+func refuseInCluster(w http.ResponseWriter, reason string) bool {
+	if cluster == nil {
+		return false
+	}
+
+	w.WriteHeader(http.StatusConflict)
+	w.Write([]byte(reason))
+
+	return true
+}
+
+// notLeaderReason returns a non-empty string if the node should not serve clients
+func notLeaderReason() string {
+	if cluster == nil || cluster.serving.Load() {
+		return ""
+	}
+
+	if address := cluster.leaderAddress(); address != "" {
+		return fmt.Sprintf("not the cluster leader, the leader is %s", address)
+	}
+
+	return "not the cluster leader, no leader elected"
+}
+
+func (s *Server) clusterStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+
+	if cluster == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Server is not running in cluster mode"))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, clusterStatusResponse{
+		Status:  cluster.node.Status(),
+		Serving: cluster.serving.Load(),
+		Peers:   cluster.peers,
+	})
+}
+
+type clusterStatusResponse struct {
+	raft.Status
+	Serving bool              `json:"serving"`
+	Peers   map[string]string `json:"peers"`
+}
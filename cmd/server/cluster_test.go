@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/locktopus-project/locktopus/internal/auth"
+)
+
+// Admin changes that are not replicated would be lost on failover, so they are refused
+func TestCluster_RefusesUnreplicatedAdminChanges(t *testing.T) {
+	authenticator, err := auth.NewAuthenticator(auth.Config{Tokens: []auth.TokenConfig{{Name: "admin", Token: "admin-token", Admin: true}}})
+	if err != nil {
+		t.Fatalf("cannot make authenticator: %s", err)
+	}
+
+	s := MakeServer(ServerParameters{Authenticator: authenticator})
+
+	c := &clusterNode{}
+	c.serving.Store(true)
+
+	cluster = c
+	defer func() { cluster = nil }()
+
+	for _, r := range []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{http.MethodPut, "/admin/namespaces/cluster_refused", `{"autoCreate": true}`},
+		{http.MethodDelete, "/admin/namespaces/cluster_refused", ""},
+		{http.MethodPost, "/admin/namespaces/cluster_refused/freeze", ""},
+		{http.MethodDelete, "/admin/namespaces/cluster_refused/freeze", ""},
+		{http.MethodPut, "/admin/shards", `{"version": 2}`},
+		{http.MethodPost, "/admin/namespaces/cluster_refused/move", `{"node": "b"}`},
+	} {
+		req := httptest.NewRequest(r.method, r.path, strings.NewReader(r.body))
+		req.Header.Set("Authorization", "Bearer admin-token")
+
+		w := httptest.NewRecorder()
+		s.Handler.ServeHTTP(w, req)
+
+		if w.Code != http.StatusConflict {
+			t.Fatalf("%s %s should be refused in cluster mode, got %d: %s", r.method, r.path, w.Code, w.Body.String())
+		}
+	}
+}
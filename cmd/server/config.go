@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/locktopus-project/locktopus/internal/auth"
+	"github.com/locktopus-project/locktopus/internal/constants"
+	ns "github.com/locktopus-project/locktopus/internal/namespace"
+)
+
+// The config file is a JSON object with the long option names as keys, e.g.
+//
+//	{
+//	  "port": 9009,
+//	  "unix-socket": ["/run/locktopus/locktopus.sock"],
+//	  "lock-rate-per-ip": 100,
+//	  "auth-tokens": {"tokens": [{"name": "billing", "token": "secret", "namespaces": [{"pattern": "billing-*"}]}]},
+//	  "namespaces": {"namespaces": [{"name": "billing-*", "autoCreate": true, "maxGroupSize": 10}]}
+//	}
+//
+// Options given with flags and env vars override the file. "auth-tokens" and "namespaces" take either the path of their file
+// or its content. On SIGHUP, the file is read again and the reloadable options are applied.
+
+var configValues map[string]json.RawMessage // by env var name (without prefix). Nil without config file
+
+// inlineOptions may be given the content of their file in the config file
+var inlineOptions = []string{"AUTH_TOKENS", "NAMESPACES"}
+
+// reloadableOptions are applied again on SIGHUP. Other options require a restart
+var reloadableOptions = []string{"log-clients", "log-locks", "lock-rate-per-connection", "lock-rate-per-ip", "lock-burst", "max-connections-per-ip", "max-pending-per-client", "auth-tokens", "namespaces"}
+
+var envVarPattern = regexp.MustCompile(`env var ` + constants.EnvPrefix + `(\w+)`)
+
+type configOption struct {
+	env  string
+	list bool
+}
+
+// configOptions returns the options that can be set in the config file by name. Env var names are taken from the option descriptions
+func configOptions() map[string]configOption {
+	options := make(map[string]configOption)
+
+	t := reflect.TypeOf(arguments)
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		m := envVarPattern.FindStringSubmatch(field.Tag.Get("description"))
+		if m == nil || field.Tag.Get("long") == "config" {
+			continue
+		}
+
+		options[field.Tag.Get("long")] = configOption{env: m[1], list: field.Type.Kind() == reflect.Slice}
+	}
+
+	return options
+}
+
+// loadConfigFile reads and validates the config file. Values are returned by env var name
+func loadConfigFile(path string) (map[string]json.RawMessage, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := map[string]json.RawMessage{}
+
+	if err = json.Unmarshal(content, &raw); err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			line, column := position(content, syntaxErr.Offset)
+			return nil, fmt.Errorf("%s:%d:%d: %s", path, line, column, syntaxErr)
+		}
+
+		return nil, fmt.Errorf("%s: the config should be a JSON object with option names as keys", path)
+	}
+
+	options := configOptions()
+
+	names := make([]string, 0, len(raw))
+	for name := range raw {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	values := make(map[string]json.RawMessage, len(raw))
+
+	for _, name := range names {
+		option, ok := options[name]
+		if !ok {
+			return nil, fmt.Errorf("%s: unknown option '%s'", path, name)
+		}
+
+		value := bytes.TrimSpace(raw[name])
+
+		switch {
+		case value[0] == '[' && option.list:
+			if err = json.Unmarshal(value, &[]string{}); err != nil {
+				return nil, fmt.Errorf("%s: option '%s' should be a list of strings", path, name)
+			}
+		case value[0] == '{' && contains(inlineOptions, option.env):
+		case value[0] == '[' || value[0] == '{' || value[0] == 'n':
+			expected := "a string, number or boolean"
+			if option.list {
+				expected = "a list of strings"
+			} else if contains(inlineOptions, option.env) {
+				expected = "a path or an object"
+			}
+
+			return nil, fmt.Errorf("%s: option '%s' should be %s", path, name, expected)
+		}
+
+		values[option.env] = value
+	}
+
+	return values, nil
+}
+
+// position returns the line and column of the byte before the offset, which is where JSON syntax errors are found
+func position(content []byte, offset int64) (int, int) {
+	if offset > 0 {
+		offset--
+	}
+
+	before := content[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+
+	return line, len(before) - bytes.LastIndexByte(before, '\n')
+}
+
+// configValue returns the string, number or boolean set in the config file as a string
+func configValue(envName string) string {
+	value, ok := configValues[envName]
+	if !ok || value[0] == '[' || value[0] == '{' {
+		return ""
+	}
+
+	s := ""
+	if json.Unmarshal(value, &s) == nil {
+		return s
+	}
+
+	return string(value)
+}
+
+func configList(envName string) []string {
+	value, ok := configValues[envName]
+	if !ok {
+		return nil
+	}
+
+	list := []string{}
+	if json.Unmarshal(value, &list) == nil {
+		return list
+	}
+
+	if s := configValue(envName); s != "" {
+		return strings.Split(s, ",")
+	}
+
+	return nil
+}
+
+// configObject returns the content of the file given inline, or nil
+func configObject(envName string) json.RawMessage {
+	if value, ok := configValues[envName]; ok && value[0] == '{' {
+		return value
+	}
+
+	return nil
+}
+
+func decodeInline(value json.RawMessage, v interface{}) error {
+	d := json.NewDecoder(bytes.NewReader(value))
+	d.DisallowUnknownFields()
+
+	return d.Decode(v)
+}
+
+// applyReloadableOptions applies the options that can be changed at runtime. Options failing to apply keep their previous values
+func applyReloadableOptions() error {
+	apiLogger.SetEnabled(!resolveBoolParameter(arguments.LogClients, "LOG_CLIENTS", false))
+	lockLogger.SetEnabled(!resolveBoolParameter(arguments.LogLocks, "LOG_LOCKS", false))
+
+	errs := []string{}
+
+	if limits, err := resolveRateLimits(); err != nil {
+		errs = append(errs, err.Error())
+	} else if current, _ := currentRateLimits(); limits != current {
+		SetRateLimits(limits)
+	}
+
+	if err := applyAuthTokens(); err != nil {
+		errs = append(errs, fmt.Sprintf("cannot load auth-tokens: %s", err))
+	}
+
+	if err := applyNamespaces(); err != nil {
+		errs = append(errs, fmt.Sprintf("cannot load namespaces: %s", err))
+	}
+
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+func applyAuthTokens() error {
+	file := resolveStringParameter(arguments.AuthTokens, "AUTH_TOKENS", "")
+
+	var inline json.RawMessage
+	if file == "" {
+		inline = configObject("AUTH_TOKENS")
+	}
+
+	if enabled := file != "" || inline != nil; enabled != (authenticator != nil) {
+		return errors.New("authentication cannot be enabled or disabled without restart")
+	}
+
+	if file != "" {
+		return authenticator.Load(file)
+	}
+
+	if inline == nil {
+		return nil
+	}
+
+	config := auth.Config{}
+	if err := decodeInline(inline, &config); err != nil {
+		return err
+	}
+
+	return authenticator.Apply(config)
+}
+
+// applyNamespaces replaces the policies if the namespaces are configured. Policies defined with the admin API are replaced as well
+func applyNamespaces() error {
+	if file := resolveStringParameter(arguments.Namespaces, "NAMESPACES", ""); file != "" {
+		return ns.LoadPolicies(file)
+	}
+
+	inline := configObject("NAMESPACES")
+	if inline == nil {
+		return nil
+	}
+
+	config := ns.Config{}
+	if err := decodeInline(inline, &config); err != nil {
+		return err
+	}
+
+	return ns.SetPolicies(config.Namespaces)
+}
+
+// ReloadConfig reads the config file again and applies the reloadable options. On error, the previous options are kept
+func ReloadConfig() error {
+	var values map[string]json.RawMessage
+
+	if path := resolveStringParameter(arguments.Config, "CONFIG", ""); path != "" {
+		var err error
+
+		if values, err = loadConfigFile(path); err != nil {
+			return err
+		}
+	}
+
+	configValues = values
+
+	return applyReloadableOptions()
+}
@@ -0,0 +1,113 @@
+package main_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	main "github.com/locktopus-project/locktopus/cmd/server"
+	ns "github.com/locktopus-project/locktopus/internal/namespace"
+)
+
+func writeConfig(t *testing.T, path, content string) {
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("cannot write config: %s", err)
+	}
+}
+
+func useConfig(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "config.json")
+
+	t.Setenv("LOCKTOPUS_CONFIG", path)
+	t.Cleanup(func() {
+		os.Unsetenv("LOCKTOPUS_CONFIG")
+		main.ReloadConfig()
+		main.SetRateLimits(main.DefaultRateLimits)
+		ns.SetPolicies(nil)
+	})
+
+	return path
+}
+
+func configuredLimits(t *testing.T, address string) main.RateLimits {
+	response := struct {
+		Limits main.RateLimits `json:"limits"`
+	}{}
+
+	adminRequest(t, address, "GET", "/limits", &response)
+
+	return response.Limits
+}
+
+func TestConfig_Reload(t *testing.T) {
+	address := startAdminTestServer(t)
+	path := useConfig(t)
+
+	writeConfig(t, path, `{
+		"max-pending-per-client": 3,
+		"lock-rate-per-ip": "2.5",
+		"namespaces": {"namespaces": [{"name": "config_ns", "maxGroupSize": 1}]}
+	}`)
+
+	if err := main.ReloadConfig(); err != nil {
+		t.Fatalf("cannot load config: %s", err)
+	}
+
+	if limits := configuredLimits(t, address); limits.MaxPendingPerClient != 3 || limits.LockRatePerIP != 2.5 || limits.LockBurst != main.DefaultRateLimits.LockBurst {
+		t.Fatalf("unexpected limits: %+v", limits)
+	}
+
+	if policy := ns.GetPolicy("config_ns"); policy.MaxGroupSize != 1 {
+		t.Fatalf("unexpected policy: %+v", policy)
+	}
+
+	writeConfig(t, path, `{"max-pending-per-client": 5, "namespaces": {"namespaces": [{"name": "config_ns", "maxGroupSize": 2}]}}`)
+
+	// env vars override the config file
+	t.Setenv("LOCKTOPUS_LOCK_BURST", "7")
+
+	if err := main.ReloadConfig(); err != nil {
+		t.Fatalf("cannot reload config: %s", err)
+	}
+
+	if limits := configuredLimits(t, address); limits.MaxPendingPerClient != 5 || limits.LockRatePerIP != 0 || limits.LockBurst != 7 {
+		t.Fatalf("unexpected limits: %+v", limits)
+	}
+
+	if policy := ns.GetPolicy("config_ns"); policy.MaxGroupSize != 2 {
+		t.Fatalf("unexpected policy: %+v", policy)
+	}
+}
+
+func TestConfig_InvalidFileKeepsOptions(t *testing.T) {
+	address := startAdminTestServer(t)
+	path := useConfig(t)
+
+	writeConfig(t, path, `{"max-pending-per-client": 3}`)
+
+	if err := main.ReloadConfig(); err != nil {
+		t.Fatalf("cannot load config: %s", err)
+	}
+
+	invalid := map[string]string{
+		"{\n  \"max-pending-per-client\": 4,\n  \"lock-burst\" 1\n}":                                        "config.json:3:16",
+		`{"max-pending-per-client": 4, "no-such-option": 1}`:                                                "unknown option 'no-such-option'",
+		`{"max-pending-per-client": 4, "unix-socket": [1]}`:                                                 "option 'unix-socket' should be a list of strings",
+		`{"max-pending-per-client": 4, "port": {"a": 1}}`:                                                   "option 'port' should be a string, number or boolean",
+		`{"max-pending-per-client": "many"}`:                                                                "cannot parse max-pending-per-client value: many",
+		`{"max-pending-per-client": 3, "namespaces": {"namespaces": [{"name": "x", "maxGroupSize": "1"}]}}`: "cannot load namespaces",
+	}
+
+	for content, expected := range invalid {
+		writeConfig(t, path, content)
+
+		if err := main.ReloadConfig(); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Fatalf("expected error '%s' for %s, got: %v", expected, content, err)
+		}
+
+		if limits := configuredLimits(t, address); limits.MaxPendingPerClient != 3 {
+			t.Fatalf("previous limits should be kept: %+v", limits)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"sort"
+	"sync"
+
+	ml "github.com/locktopus-project/locktopus/pkg/multilocker"
+)
+
+// connection is a client session bound to a namespace (WebSocket, RESP)
+type connection struct {
+	id             int64
+	namespace      string
+	remoteAddr     string
+	clientIdentity string
+	groupID        int64 // ID of the current lock, 0 if there is none
+	state          ClientState
+	resources      []resource
+	metadata       ml.Metadata
+
+	revoke         chan int64 // receives the ID of the group to be released by the server
+	disconnect     chan error // receives the reason of closing the connection by the server
+	disconnectOnce sync.Once
+}
+
+// connectionInfo is a snapshot of connection for the admin API
+type connectionInfo struct {
+	ID             int64             `json:"id"`
+	RemoteAddr     string            `json:"remoteAddr"`
+	ClientIdentity string            `json:"client"`
+	GroupID        int64             `json:"groupId,omitempty"`
+	State          string            `json:"state"`
+	Resources      []resource        `json:"resources,omitempty"`
+	Owner          string            `json:"owner,omitempty"`
+	Labels         map[string]string `json:"labels,omitempty"`
+}
+
+var connections = make(map[int64]*connection)
+var connectionsMx = sync.Mutex{}
+
+func registerConnection(c *connection) {
+	connectionsMx.Lock()
+	defer connectionsMx.Unlock()
+
+	c.state = clientStateReady
+	c.revoke = make(chan int64, 1)
+	c.disconnect = make(chan error, 1)
+
+	connections[c.id] = c
+}
+
+// pending reports whether the connection has an enqueued group
+func (c *connection) pending() bool {
+	return c.state == clientStateEnqueued && c.groupID != 0
+}
+
+// connectionHolder returns the client of the connection for lock events
+func connectionHolder(id int64) lockHolder {
+	connectionsMx.Lock()
+	defer connectionsMx.Unlock()
+
+	holder := lockHolder{connID: id}
+
+	if c, ok := connections[id]; ok {
+		holder.remoteAddr = c.remoteAddr
+		holder.client = c.clientIdentity
+	}
+
+	return holder
+}
+
+func unregisterConnection(id int64) {
+	connectionsMx.Lock()
+	defer connectionsMx.Unlock()
+
+	if c, ok := connections[id]; ok && c.pending() {
+		countPending(clientKey(c.clientIdentity, c.remoteAddr), -1)
+	}
+
+	delete(connections, id)
+}
+
+// updateConnection applies the update to the registered connection. The pending groups of the client are counted here,
+// since the state, the group and the identity of the connection are only changed with this function
+func updateConnection(id int64, update func(c *connection)) {
+	connectionsMx.Lock()
+	defer connectionsMx.Unlock()
+
+	c, ok := connections[id]
+	if !ok {
+		return
+	}
+
+	if c.pending() {
+		countPending(clientKey(c.clientIdentity, c.remoteAddr), -1)
+	}
+
+	update(c)
+
+	if c.pending() {
+		countPending(clientKey(c.clientIdentity, c.remoteAddr), 1)
+	}
+}
+
+// connectionSignals returns the channels used by the admin API to control the connection. They are nil for unregistered connections.
+func connectionSignals(id int64) (revoke <-chan int64, disconnect <-chan error) {
+	connectionsMx.Lock()
+	defer connectionsMx.Unlock()
+
+	if c, ok := connections[id]; ok {
+		return c.revoke, c.disconnect
+	}
+
+	return nil, nil
+}
+
+// revokeGroup asks the connection holding the group to release it. It returns false if no connection holds the group.
+func revokeGroup(namespace string, groupID int64) bool {
+	connectionsMx.Lock()
+	defer connectionsMx.Unlock()
+
+	for _, c := range connections {
+		if c.namespace == namespace && c.groupID == groupID && c.state != clientStateReady {
+			select {
+			case c.revoke <- groupID:
+			default:
+				// a revocation is already pending
+			}
+
+			return true
+		}
+	}
+
+	return false
+}
+
+// disconnectConnection asks the connection to close with the reason wrapping errDisconnected. It returns false if there is no such connection.
+func disconnectConnection(id int64, reason error) bool {
+	connectionsMx.Lock()
+	defer connectionsMx.Unlock()
+
+	c, ok := connections[id]
+	if !ok {
+		return false
+	}
+
+	c.disconnectOnce.Do(func() {
+		c.disconnect <- reason
+	})
+
+	return true
+}
+
+// disconnectAll asks all the connections to close and returns the number of them
+func disconnectAll(reason error) int {
+	connectionsMx.Lock()
+	defer connectionsMx.Unlock()
+
+	for _, c := range connections {
+		c := c
+
+		c.disconnectOnce.Do(func() {
+			c.disconnect <- reason
+		})
+	}
+
+	return len(connections)
+}
+
+func connectionCount() int {
+	connectionsMx.Lock()
+	defer connectionsMx.Unlock()
+
+	return len(connections)
+}
+
+// namespaceConnections returns the connections to the namespace ordered by ID
+func namespaceConnections(namespace string) []connectionInfo {
+	connectionsMx.Lock()
+	defer connectionsMx.Unlock()
+
+	list := []connectionInfo{}
+
+	for _, c := range connections {
+		if c.namespace == namespace {
+			list = append(list, connectionInfo{
+				ID:             c.id,
+				RemoteAddr:     c.remoteAddr,
+				ClientIdentity: c.clientIdentity,
+				GroupID:        c.groupID,
+				State:          c.state.String(),
+				Resources:      c.resources,
+				Owner:          c.metadata.Owner,
+				Labels:         c.metadata.Labels,
+			})
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+
+	return list
+}
+
+// namespaceClients returns the number of connections to the namespace by client identity. Anonymous clients are counted under "".
+func namespaceClients(namespace string) map[string]int64 {
+	connectionsMx.Lock()
+	defer connectionsMx.Unlock()
+
+	clients := make(map[string]int64)
+
+	for _, c := range connections {
+		if c.namespace == namespace {
+			clients[c.clientIdentity]++
+		}
+	}
+
+	return clients
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"embed"
+	"net/http"
+)
+
+// The dashboard is a single page built on the admin API and the event stream.
+// The page itself holds no data, so it is served without a token. It asks for the admin token and sends it
+// in the Authorization header, since the admin API does not take tokens from URLs
+
+//go:embed dashboard/index.html
+var dashboardFS embed.FS
+
+func (s *Server) dashboardHandler(w http.ResponseWriter, r *http.Request) {
+	if s.params.Authenticator == nil {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("Admin API requires authentication to be enabled"))
+		return
+	}
+
+	page, err := dashboardFS.ReadFile("dashboard/index.html")
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Cannot read dashboard"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.WriteHeader(http.StatusOK)
+	w.Write(page)
+}
@@ -0,0 +1,134 @@
+package main_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/locktopus-project/locktopus/internal/constants"
+	locktopusclient "github.com/locktopus-project/locktopus/pkg/client/v1"
+)
+
+// The page holds no data, so it is served without a token. The admin API it calls checks the token
+func TestDashboard_PageIsServedWithoutToken(t *testing.T) {
+	address := startAdminTestServer(t)
+
+	res, err := http.Get(fmt.Sprintf("http://%s/dashboard", address))
+	if err != nil {
+		t.Fatalf("cannot query Locktopus server: %s", err)
+	}
+
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusOK || !strings.HasPrefix(res.Header.Get("Content-Type"), "text/html") || !strings.Contains(string(body), "<title>Locktopus dashboard</title>") {
+		t.Fatalf("unexpected dashboard page: %d %v %s", res.StatusCode, res.Header, body)
+	}
+}
+
+// The admin token is only taken from the Authorization header, so it does not end up in URLs
+func TestAdmin_TokenIsOnlyTakenFromHeader(t *testing.T) {
+	address := startAdminTestServer(t)
+
+	res, err := http.Get(fmt.Sprintf("http://%s/admin/namespaces?%s=%s", address, constants.AccessTokenQueryParameterName, adminToken))
+	if err != nil {
+		t.Fatalf("cannot query Locktopus server: %s", err)
+	}
+
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("token in the URL should not be accepted, got %d", res.StatusCode)
+	}
+
+	for token, status := range map[string]int{"": http.StatusUnauthorized, authToken: http.StatusForbidden, adminToken: http.StatusOK} {
+		req, _ := http.NewRequest(http.MethodGet, fmt.Sprintf("http://%s/admin/namespaces", address), nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		res, err = http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("cannot query Locktopus server: %s", err)
+		}
+
+		res.Body.Close()
+
+		if res.StatusCode != status {
+			t.Fatalf("expected %d for token '%s', got %d", status, token, res.StatusCode)
+		}
+	}
+}
+
+func TestDashboard_ListGroups(t *testing.T) {
+	namespace := "dashboard_groups"
+	address := startAdminTestServer(t)
+
+	holder := connectWebhookClient(t, address, namespace)
+	holder.AddLockResource(locktopusclient.LockTypeWrite, "a")
+	if err := holder.Lock(); err != nil {
+		t.Fatalf("cannot lock: %s", err)
+	}
+
+	waiter := connectWebhookClient(t, address, namespace)
+	waiter.AddLockResource(locktopusclient.LockTypeRead, "a", "b")
+	if err := waiter.Lock(); err != nil {
+		t.Fatalf("cannot lock: %s", err)
+	}
+
+	// does not conflict
+	reader := connectWebhookClient(t, address, namespace)
+	reader.AddLockResource(locktopusclient.LockTypeRead, "b")
+	if err := reader.Lock(); err != nil {
+		t.Fatalf("cannot lock: %s", err)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, fmt.Sprintf("http://%s/admin/namespaces/%s/groups", address, namespace), nil)
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("cannot query Locktopus server: %s", err)
+	}
+	defer res.Body.Close()
+
+	groups := []struct {
+		ID         int64   `json:"id"`
+		State      string  `json:"state"`
+		Holder     string  `json:"holder"`
+		WaitingFor []int64 `json:"waitingFor"`
+	}{}
+
+	if err = json.NewDecoder(res.Body).Decode(&groups); err != nil {
+		t.Fatalf("cannot parse groups: %s", err)
+	}
+
+	if len(groups) != 3 {
+		t.Fatalf("expected 3 groups, got %+v", groups)
+	}
+
+	if groups[0].State != "acquired" || groups[1].State != "enqueued" || groups[2].State != "acquired" || groups[0].Holder != "connection" {
+		t.Fatalf("unexpected group states: %+v", groups)
+	}
+
+	if len(groups[1].WaitingFor) != 1 || groups[1].WaitingFor[0] != groups[0].ID || len(groups[2].WaitingFor) != 0 {
+		t.Fatalf("unexpected contention graph: %+v", groups)
+	}
+}
+
+func TestGreetings_ServerParameters(t *testing.T) {
+	res, err := http.Get(fmt.Sprintf("http://%s/", serverAddress))
+	if err != nil {
+		t.Fatalf("cannot query Locktopus server: %s", err)
+	}
+
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+
+	if !strings.Contains(string(body), "Default abandon timeout: 1m0s") {
+		t.Fatalf("greetings should show the default abandon timeout: %s", body)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// While draining, the server is not ready: new WebSocket and RESP connections are refused, current sessions keep running.
+// Sessions are closed with serverDrainingCode on shutdown.
+
+// drainRetryAfter is the retry hint given to refused clients
+const drainRetryAfter = 5 * time.Second
+
+var drainingReason = fmt.Sprintf("server draining, retry after %dms", drainRetryAfter.Milliseconds())
+
+// drainCloseTimeout limits waiting for the sessions to be closed on shutdown
+const drainCloseTimeout = 5 * time.Second
+
+var draining atomic.Bool
+
+// startDrain returns false if the server is already draining
+func startDrain() bool {
+	if !draining.CompareAndSwap(false, true) {
+		return false
+	}
+
+	mainLogger.Infof("Draining: new connections are refused [connections = %d]", connectionCount())
+
+	return true
+}
+
+// stopDrain makes the server ready again. It returns false if the server is not draining
+func stopDrain() bool {
+	if !draining.CompareAndSwap(true, false) {
+		return false
+	}
+
+	mainLogger.Info("Draining has been cancelled")
+
+	return true
+}
+
+// closeSessions closes all the sessions with serverDrainingCode and waits for them to finish
+func closeSessions() {
+	if n := disconnectAll(errDraining); n > 0 {
+		mainLogger.Infof("Closing %d sessions...", n)
+	}
+
+	deadline := time.Now().Add(drainCloseTimeout)
+
+	for connectionCount() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// refuseDraining writes 503 with a retry hint if the server is draining
+func refuseDraining(w http.ResponseWriter) bool {
+	if !draining.Load() {
+		return false
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(drainRetryAfter.Seconds())))
+	w.WriteHeader(http.StatusServiceUnavailable)
+	w.Write([]byte(drainingReason))
+
+	return true
+}
+
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok"))
+}
+
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	if refuseDraining(w) {
+		return
+	}
+
+	// cluster followers redirect clients, so they are not ready to serve them
+	if reason := notLeaderReason(); reason != "" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(reason))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ready"))
+}
@@ -0,0 +1,95 @@
+package main_test
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/locktopus-project/locktopus/internal/constants"
+	locktopusclient "github.com/locktopus-project/locktopus/pkg/client/v1"
+)
+
+func TestDrain(t *testing.T) {
+	address := startAdminTestServer(t)
+	url := fmt.Sprintf("ws://%s/v1?%s=%s", address, constants.NamespaceQueryParameterName, "drain_namespace")
+
+	readyz := func() int {
+		resp, err := http.Get(fmt.Sprintf("http://%s/readyz", address))
+		if err != nil {
+			t.Fatalf("cannot query Locktopus server: %s", err)
+		}
+
+		resp.Body.Close()
+
+		return resp.StatusCode
+	}
+
+	if status := readyz(); status != http.StatusOK {
+		t.Fatalf("Status code is not 200")
+	}
+
+	session, err := locktopusclient.MakeClient(locktopusclient.ConnectionOptions{Url: url, Token: authToken})
+	if err != nil {
+		t.Fatalf("cannot connect to Locktopus server: %s", err)
+	}
+	defer session.Close()
+
+	if status := adminRequest(t, address, http.MethodPost, "/drain", nil); status != http.StatusOK {
+		t.Fatalf("Status code is not 200")
+	}
+	defer adminRequest(t, address, http.MethodDelete, "/drain", nil)
+
+	if status := readyz(); status != http.StatusServiceUnavailable {
+		t.Fatalf("Status code is not 503")
+	}
+
+	resp, err := http.Get(fmt.Sprintf("http://%s/healthz", address))
+	if err != nil {
+		t.Fatalf("cannot query Locktopus server: %s", err)
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("server should be alive while draining")
+	}
+
+	if _, err = locktopusclient.MakeClient(locktopusclient.ConnectionOptions{Url: url, Token: authToken}); err == nil {
+		t.Fatalf("new connections should be refused while draining")
+	}
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://%s/v2/namespaces/drain_namespace/locks", address), strings.NewReader(`{"resources": [{"type": "write", "path": ["b"]}], "leaseMs": 60000}`))
+	if err != nil {
+		t.Fatalf("cannot make request: %s", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+authToken)
+
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("cannot query Locktopus server: %s", err)
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") == "" {
+		t.Fatalf("new HTTP locks should be refused while draining, got %d", resp.StatusCode)
+	}
+
+	session.AddLockResource(locktopusclient.LockTypeWrite, "a")
+
+	if err = session.Lock(); err != nil || !session.IsAcquired() {
+		t.Fatalf("current sessions should keep working while draining: %v", err)
+	}
+
+	if err = session.Release(); err != nil {
+		t.Fatalf("cannot release: %s", err)
+	}
+
+	adminRequest(t, address, http.MethodDelete, "/drain", nil)
+
+	if status := readyz(); status != http.StatusOK {
+		t.Fatalf("server should be ready after draining is cancelled")
+	}
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	ns "github.com/locktopus-project/locktopus/internal/namespace"
+	"github.com/locktopus-project/locktopus/internal/wal"
+	ml "github.com/locktopus-project/locktopus/pkg/multilocker"
+)
+
+// Locks of durable namespaces are written to the write-ahead log. On startup, they are locked again in the original order.
+// Connection-bound locks can be resumed with their session key within the grace period, leases are restarted.
+
+const walSnapshotInterval = time.Minute
+
+// walLog is nil if durability is disabled
+var walLog *wal.Log
+
+// journalLog is where lock records of durable namespaces are written: walLog, or the Raft log in cluster mode. It is nil if both are disabled
+var journalLog interface {
+	Append(r wal.Record) error
+}
+
+// upgradeJournal keeps the lock records of the other namespaces in memory, so they can be handed over. It is nil if upgrades are disabled
+var upgradeJournal *memoryJournal
+
+// walResumeGrace is the time for clients to resume restored sessions
+var walResumeGrace time.Duration
+
+var restoredSessions = make(map[string]*restoredSession)
+var restoredSessionsMx = sync.Mutex{}
+
+// restoredSession is a connection-bound lock waiting for its client to reconnect
+type restoredSession struct {
+	namespace      string
+	key            string
+	lock           *ml.Lock
+	resources      []resource
+	abandonTimeout time.Duration
+	journal        *lockJournal
+	timer          *time.Timer
+	acquired       bool // the acquisition has been written while the session was waiting
+}
+
+// OpenWAL opens the write-ahead log in dir and restores the locks written there.
+// Sessions not resumed within resumeGrace are released.
+func OpenWAL(dir string, resumeGrace time.Duration) error {
+	log, state, err := wal.Open(dir)
+	if err != nil {
+		return err
+	}
+
+	journalMx.Lock()
+	walLog = log
+	journalLog = log
+	journalMx.Unlock()
+
+	walResumeGrace = resumeGrace
+
+	restoreState(state, false, nil)
+
+	log.StartSnapshots(walSnapshotInterval, func(err error) {
+		mainLogger.Errorf("Cannot write WAL snapshot: %s", err)
+	})
+
+	return nil
+}
+
+func CloseWAL() {
+	if walLog != nil {
+		walLog.Close()
+	}
+}
+
+// lockJournal writes the lock lifecycle of a durable namespace, or of any namespace if upgrades are enabled. All methods are no-op for nil journal.
+// A lock must not be reported to the client if it has not been written.
+type lockJournal struct {
+	namespace string
+	inMemory  bool // the records are written to upgradeJournal instead of journalLog
+}
+
+// journalFor returns nil if the namespace is not durable and upgrades are disabled. In cluster mode, all namespaces are durable
+func journalFor(namespace string) *lockJournal {
+	journalMx.RLock()
+	enabled := journalLog != nil
+	journalMx.RUnlock()
+
+	switch {
+	case cluster != nil:
+	case enabled && ns.GetPolicy(namespace).Durable:
+	case upgradeJournal != nil:
+		return &lockJournal{namespace: namespace, inMemory: true}
+	default:
+		return nil
+	}
+
+	return &lockJournal{namespace: namespace}
+}
+
+func (j *lockJournal) enqueued(id int64, resources []resource, metadata ml.Metadata, session string, lease time.Duration) error {
+	if j == nil {
+		return nil
+	}
+
+	walResources := make([]wal.Resource, len(resources))
+	for i, r := range resources {
+		walResources[i] = wal.Resource{T: r.T, Path: r.Path}
+	}
+
+	return j.append(wal.Record{Type: wal.RecordEnqueued, ID: id, Resources: walResources, Owner: metadata.Owner, Labels: metadata.Labels, Session: session, LeaseMs: lease.Milliseconds()})
+}
+
+func (j *lockJournal) acquired(id int64) error {
+	if j == nil {
+		return nil
+	}
+
+	return j.append(wal.Record{Type: wal.RecordAcquired, ID: id})
+}
+
+// released errors are only logged, since the lock is released anyway
+func (j *lockJournal) released(id int64) {
+	if j == nil {
+		return
+	}
+
+	j.append(wal.Record{Type: wal.RecordReleased, ID: id})
+}
+
+func (j *lockJournal) append(r wal.Record) error {
+	r.Namespace = j.namespace
+
+	journalMx.RLock()
+	defer journalMx.RUnlock()
+
+	if journalFrozen {
+		switch {
+		case r.Type != wal.RecordReleased:
+			return errUpgrading
+		case !j.inMemory:
+			// the log is closed for the handoff
+			bufferFrozenRelease(r)
+			return nil
+		}
+	}
+
+	if j.inMemory {
+		return upgradeJournal.Append(r)
+	}
+
+	err := journalLog.Append(r)
+	if err != nil {
+		mainLogger.Errorf("Cannot write lock record [namespace = %s, id = %d, type = %s]: %s", j.namespace, r.ID, r.Type, err)
+	}
+
+	return err
+}
+
+func newSessionKey() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+// restoreState makes the locks of the state. The records of the restored locks are written to upgradeJournal if inMemory is set.
+// The namespaces are frozen again after the last group made before their freeze.
+func restoreState(state wal.State, inMemory bool, freezes []ns.FrozenNamespace) {
+	frozen := make(map[string]ns.FrozenNamespace)
+	for _, f := range freezes {
+		frozen[f.Name] = f
+	}
+
+	freeze := func(name string) {
+		if f, ok := frozen[name]; ok {
+			delete(frozen, name)
+
+			if _, err := ns.Freeze(name, f.Mode); err != nil {
+				mainLogger.Errorf("Cannot freeze namespace %s again: %s", name, err)
+			}
+		}
+	}
+
+	byNamespace := make(map[string][]wal.Group)
+	for _, g := range state.Groups {
+		byNamespace[g.Namespace] = append(byNamespace[g.Namespace], g)
+	}
+
+	for name, lastID := range state.LastIDs {
+		groups := byNamespace[name]
+		journal := &lockJournal{namespace: name, inMemory: inMemory}
+
+		if len(groups) == 0 {
+			// the namespace is not created, but keeps its sequence, so group IDs are never reused as fencing tokens
+			ns.SetLastGroupID(name, lastID)
+			continue
+		}
+
+		multilocker, _, err := ns.GetNamespace(name)
+		if err != nil {
+			mainLogger.Errorf("Cannot restore %d locks of namespace %s: %s", len(groups), name, err)
+
+			for _, g := range groups {
+				journal.released(g.ID)
+			}
+
+			ns.SetLastGroupID(name, lastID)
+
+			continue
+		}
+
+		for _, g := range groups {
+			if f, ok := frozen[name]; ok && g.ID > f.After {
+				freeze(name)
+			}
+
+			resources := make([]resource, len(g.Resources))
+			for i, r := range g.Resources {
+				resources[i] = resource{T: r.T, Path: r.Path}
+			}
+
+			resourceLocks, err := makeResourceLocks(resources)
+			if err == nil {
+				err = multilocker.SetLastGroupID(g.ID - 1)
+			}
+
+			if err != nil {
+				mainLogger.Errorf("Cannot restore lock [namespace = %s, id = %d]: %s", name, g.ID, err)
+				journal.released(g.ID)
+				continue
+			}
+
+			metadata := ml.Metadata{Owner: g.Owner, Labels: g.Labels}
+			lock := multilocker.LockWithMetadata(resourceLocks, metadata)
+			trackLock(name, g.ID, resources, metadata, noHolder)
+
+			if g.Session == "" {
+				// leases keep the namespace in use until they are released
+				ns.GetNamespace(name)
+				restoreLease(name, lock, resources, time.Duration(g.LeaseMs)*time.Millisecond, journal)
+			} else {
+				restoreSession(name, g.Session, lock, resources, time.Duration(g.LeaseMs)*time.Millisecond, journal)
+			}
+
+			lockLogger.Infof("Restored lock [namespace = %s, id = %d%s]: %v", name, g.ID, formatMetadata(metadata), resourceLocks)
+		}
+
+		ns.SetLastGroupID(name, lastID)
+		ns.ReleaseNamespace(name)
+
+		mainLogger.Infof("Restored %d locks of namespace %s", len(groups), name)
+	}
+
+	// the namespaces having no locks made after the freeze
+	for name := range frozen {
+		if _, _, err := ns.GetNamespace(name); err != nil {
+			mainLogger.Errorf("Cannot freeze namespace %s again: %s", name, err)
+			continue
+		}
+
+		freeze(name)
+		ns.ReleaseNamespace(name)
+	}
+}
+
+// restoreSession makes the lock wait for its client to reconnect. The lock is released if the client does not resume it within walResumeGrace
+func restoreSession(namespace, key string, lock *ml.Lock, resources []resource, abandonTimeout time.Duration, journal *lockJournal) {
+	rs := &restoredSession{
+		namespace:      namespace,
+		key:            key,
+		lock:           lock,
+		resources:      resources,
+		abandonTimeout: abandonTimeout,
+		journal:        journal,
+	}
+
+	putRestoredSession(rs)
+
+	go func() {
+		select {
+		case <-lock.Ready():
+			restoredSessionsMx.Lock()
+			defer restoredSessionsMx.Unlock()
+
+			// a taken session is acquired by its connection, or once it is put back
+			if restoredSessions[key] == rs {
+				rs.writeAcquired()
+			}
+		case <-lock.Cancelled():
+		}
+	}()
+}
+
+// putRestoredSession makes the session wait to be resumed within walResumeGrace.
+// It is called again for a session taken by a connection that has failed to resume it, so the client may retry.
+func putRestoredSession(rs *restoredSession) {
+	restoredSessionsMx.Lock()
+	defer restoredSessionsMx.Unlock()
+
+	restoredSessions[rs.key] = rs
+
+	select {
+	case <-rs.lock.Ready():
+		rs.writeAcquired()
+	default:
+	}
+
+	rs.timer = time.AfterFunc(walResumeGrace, func() {
+		if takeRestoredSession(rs.namespace, rs.key) == nil {
+			return
+		}
+
+		rs.journal.released(rs.lock.ID())
+		notifyLock(rs.namespace, rs.lock.ID(), lockAbandoned)
+
+		if !rs.lock.Cancel() {
+			rs.lock.Acquire().Unlock()
+		}
+
+		lockLogger.Infof("Released restored lock not resumed within %v [namespace = %s, id = %d]", walResumeGrace, rs.namespace, rs.lock.ID())
+	})
+}
+
+// writeAcquired writes the acquisition of the waiting session once. It must be called with restoredSessionsMx locked
+func (rs *restoredSession) writeAcquired() {
+	if rs.acquired {
+		return
+	}
+
+	rs.acquired = true
+
+	rs.journal.acquired(rs.lock.ID())
+	notifyLock(rs.namespace, rs.lock.ID(), lockAcquired)
+}
+
+// takeRestoredSession returns nil if there is no such session waiting to be resumed
+func takeRestoredSession(namespace, key string) *restoredSession {
+	restoredSessionsMx.Lock()
+	defer restoredSessionsMx.Unlock()
+
+	rs, ok := restoredSessions[key]
+	if !ok || rs.namespace != namespace {
+		return nil
+	}
+
+	delete(restoredSessions, key)
+
+	if rs.timer != nil {
+		rs.timer.Stop()
+	}
+
+	return rs
+}
+
+// dropRestoredSessions releases the restored sessions without writing the releases
+func dropRestoredSessions() {
+	restoredSessionsMx.Lock()
+	list := restoredSessions
+	restoredSessions = make(map[string]*restoredSession)
+	restoredSessionsMx.Unlock()
+
+	for _, rs := range list {
+		rs.timer.Stop()
+
+		go func(l *ml.Lock) {
+			if !l.Cancel() {
+				l.Acquire().Unlock()
+			}
+		}(rs.lock)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	ns "github.com/locktopus-project/locktopus/internal/namespace"
+	"github.com/locktopus-project/locktopus/internal/wal"
+)
+
+type recordingJournal struct {
+	mx      sync.Mutex
+	records []wal.Record
+}
+
+func (j *recordingJournal) Append(r wal.Record) error {
+	j.mx.Lock()
+	defer j.mx.Unlock()
+
+	j.records = append(j.records, r)
+
+	return nil
+}
+
+func (j *recordingJournal) count(t wal.RecordType) int {
+	j.mx.Lock()
+	defer j.mx.Unlock()
+
+	n := 0
+	for _, r := range j.records {
+		if r.Type == t {
+			n++
+		}
+	}
+
+	return n
+}
+
+// A session taken by a connection that fails to resume it is put back, and its acquisition is still written once
+func TestDurability_PutBackSessionIsAcquiredOnce(t *testing.T) {
+	const namespace = "durability_put_back"
+
+	j := &recordingJournal{}
+
+	journalLog = j
+	walResumeGrace = time.Minute
+	t.Cleanup(func() {
+		journalLog = nil
+		dropRestoredSessions()
+	})
+
+	multilocker, _, err := ns.GetNamespace(namespace)
+	if err != nil {
+		t.Fatalf("cannot get namespace: %s", err)
+	}
+	defer ns.ReleaseNamespace(namespace)
+
+	resources := []resource{{T: "write", Path: []string{"a"}}}
+	resourceLocks, _ := makeResourceLocks(resources)
+
+	holder := multilocker.Lock(resourceLocks)
+	waiter := multilocker.Lock(resourceLocks)
+
+	restoreSession(namespace, "key", waiter, resources, 0, &lockJournal{namespace: namespace})
+
+	rs := takeRestoredSession(namespace, "key")
+	if rs == nil {
+		t.Fatalf("session should be restored")
+	}
+
+	// the lock is acquired while the session is taken
+	holder.Acquire().Unlock()
+
+	select {
+	case <-waiter.Ready():
+	case <-time.After(time.Second):
+		t.Fatalf("lock should be acquired")
+	}
+
+	putRestoredSession(rs)
+
+	if rs = takeRestoredSession(namespace, "key"); rs == nil || !rs.acquired {
+		t.Fatalf("acquisition should be written once the session is put back")
+	}
+
+	putRestoredSession(rs)
+
+	// the notifier of the restored lock would have written in the meantime
+	time.Sleep(100 * time.Millisecond)
+
+	if n := j.count(wal.RecordAcquired); n != 1 {
+		t.Fatalf("acquisition should be written once, got %d", n)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"sort"
+
+	ml "github.com/locktopus-project/locktopus/pkg/multilocker"
+)
+
+// groupInfo is a lock (group) of a namespace held by a connection, a lease or a restored session
+type groupInfo struct {
+	ID           int64             `json:"id"`
+	State        string            `json:"state"`  // enqueued or acquired
+	Holder       string            `json:"holder"` // connection, lease or restored (not resumed yet)
+	ConnectionID *int64            `json:"connectionId,omitempty"`
+	Resources    []resource        `json:"resources"`
+	Owner        string            `json:"owner,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	WaitingFor   []int64           `json:"waitingFor,omitempty"` // earlier groups with conflicting resources, for enqueued groups
+}
+
+// namespaceGroups returns the unreleased groups of the namespace ordered by ID.
+// Groups are acquired in the order of IDs, so an enqueued group waits for the earlier groups whose resources conflict with its ones
+func namespaceGroups(namespace string) []groupInfo {
+	list := []groupInfo{}
+
+	connectionsMx.Lock()
+	for _, c := range connections {
+		if c.namespace != namespace || c.groupID == 0 || (c.state != clientStateEnqueued && c.state != clientStateAcquired) {
+			continue
+		}
+
+		id := c.id
+		list = append(list, groupInfo{ID: c.groupID, State: c.state.String(), Holder: "connection", ConnectionID: &id, Resources: c.resources, Owner: c.metadata.Owner, Labels: c.metadata.Labels})
+	}
+	connectionsMx.Unlock()
+
+	leasesMx.Lock()
+	for key, ll := range leases {
+		if key.namespace != namespace {
+			continue
+		}
+
+		if state := ll.currentState(); state == leaseStateEnqueued || state == leaseStateAcquired {
+			metadata := ll.lock.Metadata()
+			list = append(list, groupInfo{ID: key.id, State: string(state), Holder: "lease", Resources: ll.resources, Owner: metadata.Owner, Labels: metadata.Labels})
+		}
+	}
+	leasesMx.Unlock()
+
+	restoredSessionsMx.Lock()
+	for _, rs := range restoredSessions {
+		if rs.namespace != namespace {
+			continue
+		}
+
+		state := clientStateEnqueued
+		select {
+		case <-rs.lock.Ready():
+			state = clientStateAcquired
+		default:
+		}
+
+		metadata := rs.lock.Metadata()
+		list = append(list, groupInfo{ID: rs.lock.ID(), State: state.String(), Holder: "restored", Resources: rs.resources, Owner: metadata.Owner, Labels: metadata.Labels})
+	}
+	restoredSessionsMx.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+
+	for i := range list {
+		if list[i].State != clientStateEnqueued.String() {
+			continue
+		}
+
+		for _, earlier := range list[:i] {
+			if conflicting(list[i].Resources, earlier.Resources) {
+				list[i].WaitingFor = append(list[i].WaitingFor, earlier.ID)
+			}
+		}
+	}
+
+	return list
+}
+
+// conflicting returns true if a path of one group contains a path of the other one, and any of the two is locked for writing
+func conflicting(a, b []resource) bool {
+	for _, ra := range a {
+		for _, rb := range b {
+			if !isWriteLock(ra) && !isWriteLock(rb) {
+				continue
+			}
+
+			if isPathPrefix(ra.Path, rb.Path) || isPathPrefix(rb.Path, ra.Path) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func isWriteLock(r resource) bool {
+	lt, err := parseLockType(r.T)
+
+	return err == nil && lt == ml.LockTypeWrite
+}
+
+func isPathPrefix(path, prefix []string) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+
+	for i, segment := range prefix {
+		if path[i] != segment {
+			return false
+		}
+	}
+
+	return true
+}
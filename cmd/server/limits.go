@@ -0,0 +1,416 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/locktopus-project/locktopus/internal/ratelimit"
+)
+
+// Rate limits protect namespaces from misbehaving clients: lock requests are limited with token buckets per connection
+// and per remote IP, and there are caps on connections per remote IP and pending groups per client identity.
+// Violations are rejected with errRateLimited: WebSocket connections are closed with code 3007, HTTP requests are answered
+// with 429 and RESP commands with -RATELIMITED. Rejections are counted per namespace.
+
+var errRateLimited = errors.New("rate limit exceeded")
+
+// Request limits bound the size of a single request regardless of the namespace. Oversized requests are rejected with
+// errRequestTooLarge: WebSocket connections are closed with code 3008, HTTP requests are answered with 413 and RESP commands
+// with -TOOLARGE.
+
+var errRequestTooLarge = errors.New("request too large")
+
+// RequestLimits are zero for no limit
+type RequestLimits struct {
+	MaxMessageSize   int64 // bytes of a WebSocket message, an HTTP API v2 request body or the arguments of a RESP command
+	MaxResources     int   // resources per lock
+	MaxPathDepth     int   // segments of a resource path
+	MaxSegmentLength int   // bytes of a path segment
+}
+
+func (l RequestLimits) checkResources(resources []resource) error {
+	if l.MaxResources > 0 && len(resources) > l.MaxResources {
+		return fmt.Errorf("%w: more than %d resources", errRequestTooLarge, l.MaxResources)
+	}
+
+	for _, r := range resources {
+		if l.MaxPathDepth > 0 && len(r.Path) > l.MaxPathDepth {
+			return fmt.Errorf("%w: path deeper than %d segments", errRequestTooLarge, l.MaxPathDepth)
+		}
+
+		for _, segment := range r.Path {
+			if l.MaxSegmentLength > 0 && len(segment) > l.MaxSegmentLength {
+				return fmt.Errorf("%w: path segment longer than %d bytes", errRequestTooLarge, l.MaxSegmentLength)
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkRequest scans the resources of a JSON lock request (WebSocket message or HTTP API v2 body) before it is decoded,
+// so an oversized request is rejected without allocating its resources. Keys are matched case-insensitively, as encoding/json does.
+// Malformed JSON is left to the decoder
+func (l RequestLimits) checkRequest(payload []byte) error {
+	if l.MaxResources == 0 && l.MaxPathDepth == 0 && l.MaxSegmentLength == 0 {
+		return nil
+	}
+
+	d := json.NewDecoder(bytes.NewReader(payload))
+
+	if t, err := d.Token(); err != nil || t != json.Delim('{') {
+		return nil
+	}
+
+	for d.More() {
+		key, err := d.Token()
+		if err != nil {
+			return nil
+		}
+
+		if k, _ := key.(string); strings.EqualFold(k, "resources") {
+			err = l.scanResources(d)
+		} else {
+			err = skipJSONValue(d)
+		}
+
+		if err != nil {
+			if errors.Is(err, errRequestTooLarge) {
+				return err
+			}
+
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func (l RequestLimits) scanResources(d *json.Decoder) error {
+	t, err := d.Token()
+	if err != nil || t != json.Delim('[') {
+		return skipJSONDelimited(d, t, err)
+	}
+
+	for count := 1; d.More(); count++ {
+		if l.MaxResources > 0 && count > l.MaxResources {
+			return fmt.Errorf("%w: more than %d resources", errRequestTooLarge, l.MaxResources)
+		}
+
+		if t, err = d.Token(); err != nil || t != json.Delim('{') {
+			if err = skipJSONDelimited(d, t, err); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		for d.More() {
+			key, err := d.Token()
+			if err != nil {
+				return err
+			}
+
+			if k, _ := key.(string); strings.EqualFold(k, "path") {
+				err = l.scanPath(d)
+			} else {
+				err = skipJSONValue(d)
+			}
+
+			if err != nil {
+				return err
+			}
+		}
+
+		// end of the resource
+		if _, err = d.Token(); err != nil {
+			return err
+		}
+	}
+
+	_, err = d.Token()
+
+	return err
+}
+
+func (l RequestLimits) scanPath(d *json.Decoder) error {
+	t, err := d.Token()
+	if err != nil || t != json.Delim('[') {
+		return skipJSONDelimited(d, t, err)
+	}
+
+	for depth := 1; d.More(); depth++ {
+		if l.MaxPathDepth > 0 && depth > l.MaxPathDepth {
+			return fmt.Errorf("%w: path deeper than %d segments", errRequestTooLarge, l.MaxPathDepth)
+		}
+
+		if t, err = d.Token(); err != nil {
+			return err
+		}
+
+		if segment, ok := t.(string); ok && l.MaxSegmentLength > 0 && len(segment) > l.MaxSegmentLength {
+			return fmt.Errorf("%w: path segment longer than %d bytes", errRequestTooLarge, l.MaxSegmentLength)
+		}
+
+		if err = skipJSONDelimited(d, t, nil); err != nil {
+			return err
+		}
+	}
+
+	_, err = d.Token()
+
+	return err
+}
+
+func skipJSONValue(d *json.Decoder) error {
+	t, err := d.Token()
+
+	return skipJSONDelimited(d, t, err)
+}
+
+// skipJSONDelimited skips the rest of an object or an array whose first token t has been read
+func skipJSONDelimited(d *json.Decoder, t json.Token, err error) error {
+	if err != nil {
+		return err
+	}
+
+	if t != json.Delim('{') && t != json.Delim('[') {
+		return nil
+	}
+
+	for depth := 1; depth > 0; {
+		if t, err = d.Token(); err != nil {
+			return err
+		}
+
+		switch t {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+	}
+
+	return nil
+}
+
+type RateLimits struct {
+	LockRatePerConnection float64 `json:"lockRatePerConnection"` // lock requests per second. 0 means no limit
+	LockRatePerIP         float64 `json:"lockRatePerIp"`         // lock requests per second. 0 means no limit
+	LockBurst             int     `json:"lockBurst"`             // lock requests above the rates allowed at once
+	MaxConnectionsPerIP   int     `json:"maxConnectionsPerIp"`   // 0 means no limit
+	MaxPendingPerClient   int     `json:"maxPendingPerClient"`   // pending groups per client identity (remote IP for anonymous clients). 0 means no limit
+}
+
+var DefaultRateLimits = RateLimits{LockBurst: 10}
+
+// rejectedStats counts the requests rejected by the rate limits
+type rejectedStats struct {
+	LockRate      int64 // lock requests exceeding the rate of the connection or the remote IP
+	Connections   int64 // connections exceeding the cap per remote IP
+	PendingGroups int64 // lock requests exceeding the cap of pending groups per client
+}
+
+var rateLimits = DefaultRateLimits
+var ipLockLimiter *ratelimit.Limiter // nil if there is no limit
+var rateLimitsMx = sync.RWMutex{}
+
+var connectionsPerIP = make(map[string]int)
+var connectionsPerIPMx = sync.Mutex{}
+
+var pendingPerClient = make(map[string]int) // enqueued groups by client key
+var pendingPerClientMx = sync.Mutex{}
+
+var rejected = make(map[string]*rejectedStats) // by namespace. RESP connections are counted under "". Dropped when the namespace is closed
+var rejectedTotal = rejectedStats{}
+var rejectedMx = sync.Mutex{}
+
+// SetRateLimits applies the limits to new requests. The buckets of the remote IPs are reset
+func SetRateLimits(limits RateLimits) {
+	rateLimitsMx.Lock()
+	defer rateLimitsMx.Unlock()
+
+	rateLimits = limits
+	ipLockLimiter = nil
+
+	if limits.LockRatePerIP > 0 {
+		ipLockLimiter = ratelimit.NewLimiter(limits.LockRatePerIP, limits.LockBurst)
+	}
+}
+
+func currentRateLimits() (RateLimits, *ratelimit.Limiter) {
+	rateLimitsMx.RLock()
+	defer rateLimitsMx.RUnlock()
+
+	return rateLimits, ipLockLimiter
+}
+
+// remoteIP returns the host of the address, or the address itself if it has no port (e.g. Unix domain sockets)
+func remoteIP(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+
+	return host
+}
+
+// clientKey identifies the client for the cap of pending groups
+func clientKey(identity, remoteAddr string) string {
+	if identity != "" {
+		return "client:" + identity
+	}
+
+	return "ip:" + remoteIP(remoteAddr)
+}
+
+// lockLimiter checks lock requests of a connection (or of HTTP API v2 requests, without the bucket of the connection)
+type lockLimiter struct {
+	bucket *ratelimit.Bucket // nil if there is no limit
+}
+
+func newLockLimiter() *lockLimiter {
+	limits, _ := currentRateLimits()
+
+	l := &lockLimiter{}
+
+	if limits.LockRatePerConnection > 0 {
+		l.bucket = ratelimit.NewBucket(limits.LockRatePerConnection, limits.LockBurst)
+	}
+
+	return l
+}
+
+// checkLock takes tokens for the lock request and checks the cap of pending groups of the client
+func (l *lockLimiter) checkLock(namespace, identity, remoteAddr string) error {
+	limits, ipLimiter := currentRateLimits()
+
+	if l != nil && l.bucket != nil && !l.bucket.Allow() {
+		countRejected(namespace, func(s *rejectedStats) { s.LockRate++ })
+		return fmt.Errorf("%w: more than %v lock requests per second on the connection", errRateLimited, limits.LockRatePerConnection)
+	}
+
+	if ipLimiter != nil && !ipLimiter.Allow(remoteIP(remoteAddr)) {
+		countRejected(namespace, func(s *rejectedStats) { s.LockRate++ })
+		return fmt.Errorf("%w: more than %v lock requests per second from %s", errRateLimited, limits.LockRatePerIP, remoteIP(remoteAddr))
+	}
+
+	if limits.MaxPendingPerClient > 0 && pendingGroupsOf(clientKey(identity, remoteAddr)) >= limits.MaxPendingPerClient {
+		countRejected(namespace, func(s *rejectedStats) { s.PendingGroups++ })
+		return fmt.Errorf("%w: client has %d pending locks", errRateLimited, limits.MaxPendingPerClient)
+	}
+
+	return nil
+}
+
+// pendingGroupsOf returns the number of enqueued groups of the client's connections and leases
+func pendingGroupsOf(key string) int {
+	pendingPerClientMx.Lock()
+	defer pendingPerClientMx.Unlock()
+
+	return pendingPerClient[key]
+}
+
+// countPending adds delta to the enqueued groups of the client. It is called when a connection or a lease gets enqueued and when it stops being enqueued
+func countPending(key string, delta int) {
+	pendingPerClientMx.Lock()
+	defer pendingPerClientMx.Unlock()
+
+	if pendingPerClient[key] += delta; pendingPerClient[key] <= 0 {
+		delete(pendingPerClient, key)
+	}
+}
+
+// acquireConnectionSlot counts the connection of the remote IP. Call releaseConnectionSlot when the connection is closed
+func acquireConnectionSlot(namespace, remoteAddr string) error {
+	limits, _ := currentRateLimits()
+	ip := remoteIP(remoteAddr)
+
+	connectionsPerIPMx.Lock()
+	defer connectionsPerIPMx.Unlock()
+
+	if limits.MaxConnectionsPerIP > 0 && connectionsPerIP[ip] >= limits.MaxConnectionsPerIP {
+		countRejected(namespace, func(s *rejectedStats) { s.Connections++ })
+		return fmt.Errorf("%w: %s has %d connections", errRateLimited, ip, limits.MaxConnectionsPerIP)
+	}
+
+	connectionsPerIP[ip]++
+
+	return nil
+}
+
+func releaseConnectionSlot(remoteAddr string) {
+	ip := remoteIP(remoteAddr)
+
+	connectionsPerIPMx.Lock()
+	defer connectionsPerIPMx.Unlock()
+
+	if connectionsPerIP[ip]--; connectionsPerIP[ip] <= 0 {
+		delete(connectionsPerIP, ip)
+	}
+}
+
+func countRejected(namespace string, count func(s *rejectedStats)) {
+	rejectedMx.Lock()
+	defer rejectedMx.Unlock()
+
+	s, ok := rejected[namespace]
+	if !ok {
+		s = &rejectedStats{}
+		rejected[namespace] = s
+	}
+
+	count(s)
+	count(&rejectedTotal)
+}
+
+// forgetRejected drops the counters of the closed namespace. The totals are kept
+func forgetRejected(namespace string) {
+	rejectedMx.Lock()
+	defer rejectedMx.Unlock()
+
+	delete(rejected, namespace)
+}
+
+func namespaceRejected(namespace string) rejectedStats {
+	rejectedMx.Lock()
+	defer rejectedMx.Unlock()
+
+	if s, ok := rejected[namespace]; ok {
+		return *s
+	}
+
+	return rejectedStats{}
+}
+
+// writeRateLimited answers HTTP requests rejected by the rate limits
+func writeRateLimited(w http.ResponseWriter, err error) {
+	w.Header().Set("Retry-After", "1")
+	w.WriteHeader(http.StatusTooManyRequests)
+	w.Write([]byte(err.Error()))
+}
+
+type limitsResponse struct {
+	Limits   RateLimits    `json:"limits"`
+	Rejected rejectedStats `json:"rejected"` // totals of all namespaces
+}
+
+func (s *Server) limitsHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+
+	limits, _ := currentRateLimits()
+
+	rejectedMx.Lock()
+	total := rejectedTotal
+	rejectedMx.Unlock()
+
+	writeJSON(w, http.StatusOK, limitsResponse{Limits: limits, Rejected: total})
+}
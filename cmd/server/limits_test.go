@@ -0,0 +1,246 @@
+package main_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	main "github.com/locktopus-project/locktopus/cmd/server"
+	"github.com/locktopus-project/locktopus/internal/auth"
+	"github.com/locktopus-project/locktopus/internal/constants"
+	ns "github.com/locktopus-project/locktopus/internal/namespace"
+)
+
+const rateLimitedCode = 3007
+
+const limitedToken = "limited-secret"
+
+func startLimitsTestServer(t *testing.T, limits main.RateLimits) string {
+	authenticator, err := auth.NewAuthenticator(auth.Config{
+		Tokens: []auth.TokenConfig{
+			{Name: "admin", Token: adminToken, Admin: true, Namespaces: []auth.NamespaceGrant{{Pattern: "*"}}},
+			{Name: "limited", Token: limitedToken, Namespaces: []auth.NamespaceGrant{{Pattern: "*"}}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("cannot make authenticator: %s", err)
+	}
+
+	main.SetRateLimits(limits)
+	t.Cleanup(func() { main.SetRateLimits(main.DefaultRateLimits) })
+
+	return startTestServer(t, main.ServerParameters{Authenticator: authenticator})
+}
+
+func dialLimited(address, namespace string) (*websocket.Conn, *http.Response, error) {
+	return websocket.DefaultDialer.Dial(fmt.Sprintf("ws://%s/v1?%s=%s&%s=%s", address, constants.NamespaceQueryParameterName, namespace, constants.AccessTokenQueryParameterName, limitedToken), nil)
+}
+
+func writeLock(t *testing.T, conn *websocket.Conn, path string) {
+	err := conn.WriteJSON(map[string]interface{}{
+		"action":    "lock",
+		"resources": []map[string]interface{}{{"type": "write", "path": []string{path}}},
+	})
+	if err != nil {
+		t.Fatalf("cannot write request: %s", err)
+	}
+}
+
+func expectClosedWith(t *testing.T, conn *websocket.Conn, code int) {
+	var err error
+
+	for err == nil {
+		_, _, err = conn.ReadMessage()
+	}
+
+	if !websocket.IsCloseError(err, code) {
+		t.Fatalf("connection should be closed with code %d, got: %s", code, err)
+	}
+}
+
+func namespaceRejected(t *testing.T, address, namespace string) map[string]int64 {
+	res, err := http.Get(fmt.Sprintf("http://%s/stats_v1?%s=%s&%s=%s", address, constants.NamespaceQueryParameterName, namespace, constants.AccessTokenQueryParameterName, adminToken))
+	if err != nil {
+		t.Fatalf("cannot query Locktopus server: %s", err)
+	}
+	defer res.Body.Close()
+
+	stats := struct {
+		Rejected map[string]int64
+	}{}
+
+	if err = json.NewDecoder(res.Body).Decode(&stats); err != nil {
+		t.Fatalf("cannot parse response body: %s", err)
+	}
+
+	return stats.Rejected
+}
+
+func TestLimits_LockRatePerConnection(t *testing.T) {
+	namespace := "limits_rate"
+	address := startLimitsTestServer(t, main.RateLimits{LockRatePerConnection: 0.1, LockBurst: 2})
+
+	conn, _, err := dialLimited(address, namespace)
+	if err != nil {
+		t.Fatalf("cannot connect to Locktopus server: %s", err)
+	}
+	defer conn.Close()
+
+	for i := 0; i < 2; i++ {
+		writeLock(t, conn, "a")
+		conn.ReadMessage()
+
+		if err = conn.WriteJSON(map[string]interface{}{"action": "release"}); err != nil {
+			t.Fatalf("cannot write request: %s", err)
+		}
+		conn.ReadMessage()
+	}
+
+	writeLock(t, conn, "a")
+	expectClosedWith(t, conn, rateLimitedCode)
+
+	if rejected := namespaceRejected(t, address, namespace); rejected["LockRate"] != 1 {
+		t.Fatalf("expected 1 rejected lock request, got %v", rejected)
+	}
+}
+
+func TestLimits_MaxPendingPerClient(t *testing.T) {
+	namespace := "limits_pending"
+	address := startLimitsTestServer(t, main.RateLimits{MaxPendingPerClient: 1})
+
+	holder, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://%s/v1?%s=%s&%s=%s", address, constants.NamespaceQueryParameterName, namespace, constants.AccessTokenQueryParameterName, adminToken), nil)
+	if err != nil {
+		t.Fatalf("cannot connect to Locktopus server: %s", err)
+	}
+	defer holder.Close()
+
+	writeLock(t, holder, "a")
+	holder.ReadMessage()
+
+	waiter, _, err := dialLimited(address, namespace)
+	if err != nil {
+		t.Fatalf("cannot connect to Locktopus server: %s", err)
+	}
+	defer waiter.Close()
+
+	writeLock(t, waiter, "a")
+	if _, message, _ := waiter.ReadMessage(); !json.Valid(message) {
+		t.Fatalf("unexpected response: %s", message)
+	}
+
+	// the waiter's lock is pending, so the client has reached the cap
+	another, _, err := dialLimited(address, namespace)
+	if err != nil {
+		t.Fatalf("cannot connect to Locktopus server: %s", err)
+	}
+	defer another.Close()
+
+	writeLock(t, another, "b")
+	expectClosedWith(t, another, rateLimitedCode)
+
+	if rejected := namespaceRejected(t, address, namespace); rejected["PendingGroups"] != 1 {
+		t.Fatalf("expected 1 rejected lock request, got %v", rejected)
+	}
+
+	if err = holder.WriteJSON(map[string]interface{}{"action": "release"}); err != nil {
+		t.Fatalf("cannot write request: %s", err)
+	}
+
+	// the waiter's lock is acquired, so the client is below the cap again
+	waiter.ReadMessage()
+
+	last, _, err := dialLimited(address, namespace)
+	if err != nil {
+		t.Fatalf("cannot connect to Locktopus server: %s", err)
+	}
+	defer last.Close()
+
+	writeLock(t, last, "b")
+	if _, message, err := last.ReadMessage(); err != nil || !json.Valid(message) {
+		t.Fatalf("lock should not be rejected once the pending lock is acquired: %s", err)
+	}
+}
+
+func TestLimits_RejectedAreDroppedWithNamespace(t *testing.T) {
+	namespace := "limits_rejected_deleted"
+	address := startLimitsTestServer(t, main.RateLimits{LockRatePerConnection: 0.1, LockBurst: 1})
+
+	conn, _, err := dialLimited(address, namespace)
+	if err != nil {
+		t.Fatalf("cannot connect to Locktopus server: %s", err)
+	}
+	defer conn.Close()
+
+	writeLock(t, conn, "a")
+	conn.ReadMessage()
+
+	if err = conn.WriteJSON(map[string]interface{}{"action": "release"}); err != nil {
+		t.Fatalf("cannot write request: %s", err)
+	}
+	conn.ReadMessage()
+
+	writeLock(t, conn, "a")
+	expectClosedWith(t, conn, rateLimitedCode)
+
+	if rejected := namespaceRejected(t, address, namespace); rejected["LockRate"] != 1 {
+		t.Fatalf("expected 1 rejected lock request, got %v", rejected)
+	}
+
+	if status := adminRequest(t, address, http.MethodDelete, "/namespaces/"+namespace, nil); status != http.StatusAccepted {
+		t.Fatalf("Status code is not 202")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for ns.GetNamespaceStatistics(namespace) != nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("namespace should be closed after the last connection is closed")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the namespace is created again without the rejections of the closed one
+	conn, _, err = dialLimited(address, namespace)
+	if err != nil {
+		t.Fatalf("cannot connect to Locktopus server: %s", err)
+	}
+	defer conn.Close()
+
+	if rejected := namespaceRejected(t, address, namespace); rejected["LockRate"] != 0 {
+		t.Fatalf("rejections of the closed namespace should be dropped, got %v", rejected)
+	}
+}
+
+func TestLimits_MaxConnectionsPerIP(t *testing.T) {
+	namespace := "limits_connections"
+	address := startLimitsTestServer(t, main.RateLimits{MaxConnectionsPerIP: 2})
+
+	// connections left by other tests count as well, so the cap is reached within 3 connections
+	for i := 0; ; i++ {
+		conn, res, err := dialLimited(address, namespace)
+		if err == nil {
+			defer conn.Close()
+
+			if i == 2 {
+				t.Fatalf("third connection should be rejected")
+			}
+
+			continue
+		}
+
+		if res == nil || res.StatusCode != http.StatusTooManyRequests {
+			t.Fatalf("expected status 429, got %v: %s", res, err)
+		}
+
+		break
+	}
+
+	if rejected := namespaceRejected(t, address, namespace); rejected["Connections"] != 1 {
+		t.Fatalf("expected 1 rejected connection, got %v", rejected)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+type listenerKind int
+
+const (
+	listenerTCP listenerKind = iota
+	listenerUnix
+	listenerTLS
+)
+
+type listener struct {
+	kind    listenerKind
+	address string
+}
+
+func (l listener) String() string {
+	switch l.kind {
+	case listenerUnix:
+		return "unix://" + l.address
+	case listenerTLS:
+		return "tls://" + l.address
+	}
+
+	return "tcp://" + l.address
+}
+
+func (s *Server) listeners() []listener {
+	list := []listener{{kind: listenerTCP, address: s.Addr}}
+
+	for _, path := range s.params.UnixSockets {
+		list = append(list, listener{kind: listenerUnix, address: path})
+	}
+
+	if s.params.TLSPort != "" {
+		list = append(list, listener{kind: listenerTLS, address: fmt.Sprintf("%s:%s", s.params.Hostname, s.params.TLSPort)})
+	}
+
+	return list
+}
+
+// serve blocks until the listener is closed. The listener passed by the previous process is used if there is one (see upgrade.go)
+func (s *Server) serve(l listener) error {
+	var config *tls.Config
+
+	if l.kind == listenerTLS {
+		var err error
+		if config, err = makeTLSConfig(s.params.TLSCertFile, s.params.TLSKeyFile, s.params.TLSClientCAFile); err != nil {
+			return err
+		}
+	}
+
+	ln, err := listen(l.String(), func() (net.Listener, error) {
+		if l.kind == listenerUnix {
+			return listenUnix(l.address, s.params.UnixSocketMode)
+		}
+
+		return net.Listen("tcp", l.address)
+	})
+	if err != nil {
+		return err
+	}
+
+	if config != nil {
+		return s.Serve(tls.NewListener(ln, config))
+	}
+
+	return s.Serve(ln)
+}
+
+const staleSocketDialTimeout = time.Second
+
+// listenUnix creates a Unix domain socket. A stale socket file left by a crashed process is removed.
+// The socket file is removed when the listener is closed.
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		conn, err := net.DialTimeout("unix", path, staleSocketDialTimeout)
+		if err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("socket %s is in use by another process", path)
+		}
+
+		if err = os.Remove(path); err != nil {
+			return nil, fmt.Errorf("cannot remove stale socket %s: %w", path, err)
+		}
+	} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if mode != 0 {
+		if err = os.Chmod(path, mode); err != nil {
+			ln.Close()
+			return nil, fmt.Errorf("cannot set permissions of socket %s: %w", path, err)
+		}
+	}
+
+	return ln, nil
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/locktopus-project/locktopus/internal/audit"
+	ns "github.com/locktopus-project/locktopus/internal/namespace"
+	"github.com/locktopus-project/locktopus/internal/webhook"
+	ml "github.com/locktopus-project/locktopus/pkg/multilocker"
+)
+
+// Lock events are delivered to the webhooks of the namespace policy, written to the audit log and streamed to the event subscribers.
+// A lock is tracked from the moment it is enqueued if the audit log is enabled, the namespace has event subscribers or any webhook matches its resources,
+// so later events of the lock carry its holder, resources and the time it has been held.
+
+type lockEvent string
+
+const (
+	lockEnqueued  lockEvent = "enqueued"
+	lockAcquired  lockEvent = "acquired"
+	lockReleased  lockEvent = "released"
+	lockAbandoned lockEvent = "abandoned" // released by the server after the client has disconnected or the lease has expired
+	lockRevoked   lockEvent = "revoked"   // released with the admin API
+)
+
+var webhookEvents = map[lockEvent]string{
+	lockEnqueued:  webhook.EventEnqueued,
+	lockAcquired:  webhook.EventAcquired,
+	lockReleased:  webhook.EventReleased,
+	lockAbandoned: webhook.EventReleased,
+	lockRevoked:   webhook.EventRevoked,
+}
+
+var auditEvents = map[lockEvent]string{
+	lockEnqueued:  audit.EventLock,
+	lockAcquired:  audit.EventAcquire,
+	lockReleased:  audit.EventRelease,
+	lockAbandoned: audit.EventAbandon,
+	lockRevoked:   audit.EventRevoke,
+}
+
+type groupKey struct {
+	namespace string
+	id        int64
+}
+
+// lockHolder is the client of a lock. connID is -1 for leases and restored locks
+type lockHolder struct {
+	connID     int64
+	remoteAddr string
+	client     string
+}
+
+var noHolder = lockHolder{connID: -1}
+
+type trackedLock struct {
+	hooks      []webhook.Hook // matching the resources
+	resources  []resource
+	metadata   ml.Metadata
+	holder     lockHolder
+	enqueuedAt time.Time
+	acquiredAt time.Time
+	timers     []*time.Timer // deliver "held" events
+}
+
+var trackedLocks = make(map[groupKey]*trackedLock)
+var trackedLocksMx = sync.Mutex{}
+
+// trackLock starts tracking the lock if the audit log is enabled, the namespace has event subscribers or any webhook of the namespace matches its resources
+func trackLock(namespace string, id int64, resources []resource, metadata ml.Metadata, holder lockHolder) {
+	webhooksMx.RLock()
+	webhooksEnabled := webhooks != nil
+	webhooksMx.RUnlock()
+
+	auditMx.RLock()
+	auditEnabled := auditLog != nil
+	auditMx.RUnlock()
+
+	t := &trackedLock{resources: resources, metadata: metadata, holder: holder, enqueuedAt: time.Now()}
+
+	if webhooksEnabled {
+		paths := make([][]string, len(resources))
+		for i, r := range resources {
+			paths[i] = r.Path
+		}
+
+		for _, h := range ns.GetPolicy(namespace).Webhooks {
+			if h.Matches(paths) {
+				t.hooks = append(t.hooks, h)
+			}
+		}
+	}
+
+	if len(t.hooks) == 0 && !auditEnabled && !hasEventSubscribers(namespace) {
+		return
+	}
+
+	trackedLocksMx.Lock()
+	trackedLocks[groupKey{namespace: namespace, id: id}] = t
+	trackedLocksMx.Unlock()
+}
+
+// setLockHolder updates the holder of a tracked lock, e.g. when a restored session is resumed
+func setLockHolder(namespace string, id int64, holder lockHolder) {
+	trackedLocksMx.Lock()
+	defer trackedLocksMx.Unlock()
+
+	if t, ok := trackedLocks[groupKey{namespace: namespace, id: id}]; ok {
+		t.holder = holder
+	}
+}
+
+// notifyLock reports the event of a tracked lock. Acquired events start the "held" timers, releases stop tracking
+func notifyLock(namespace string, id int64, event lockEvent) {
+	key := groupKey{namespace: namespace, id: id}
+	now := time.Now()
+
+	trackedLocksMx.Lock()
+
+	t, ok := trackedLocks[key]
+	if !ok || (event == lockAcquired && !t.acquiredAt.IsZero()) {
+		trackedLocksMx.Unlock()
+		return
+	}
+
+	switch event {
+	case lockAcquired:
+		t.acquiredAt = now
+
+		for _, h := range t.hooks {
+			if h.HeldAfterMs > 0 && h.Wants(webhook.EventHeld) {
+				h := h
+				t.timers = append(t.timers, time.AfterFunc(time.Duration(h.HeldAfterMs)*time.Millisecond, func() {
+					notifyHeld(key, t, h)
+				}))
+			}
+		}
+	case lockReleased, lockAbandoned, lockRevoked:
+		delete(trackedLocks, key)
+
+		for _, timer := range t.timers {
+			timer.Stop()
+		}
+	}
+
+	holder := t.holder
+	acquiredAt := t.acquiredAt
+
+	trackedLocksMx.Unlock()
+
+	e := webhook.Event{Event: webhookEvents[event], Namespace: namespace, GroupID: id, Resources: webhookResources(t.resources), Time: now}
+
+	if event != lockAcquired && !acquiredAt.IsZero() {
+		held := now.Sub(acquiredAt).Milliseconds()
+		e.HeldMs = &held
+	}
+
+	for _, h := range t.hooks {
+		if h.Wants(e.Event) {
+			deliver(h, e)
+		}
+	}
+
+	publishLockEvent(namespace, streamedLockEvent{Event: event, GroupID: id, Resources: t.resources, Time: now, HeldMs: e.HeldMs})
+
+	r := audit.Record{Time: now, Event: auditEvents[event], Namespace: namespace, GroupID: id, RemoteAddr: holder.remoteAddr, Client: holder.client, Resources: auditResources(t.resources), Owner: t.metadata.Owner, Labels: t.metadata.Labels}
+
+	if holder.connID >= 0 {
+		r.ConnectionID = &holder.connID
+	}
+
+	if event != lockEnqueued {
+		r.EnqueuedAt = &t.enqueuedAt
+	}
+
+	if event != lockAcquired && !acquiredAt.IsZero() {
+		r.AcquiredAt = &acquiredAt
+	}
+
+	writeAudit(r)
+}
+
+func notifyHeld(key groupKey, t *trackedLock, h webhook.Hook) {
+	now := time.Now()
+
+	trackedLocksMx.Lock()
+	// the lock may have been released meanwhile
+	tracked := trackedLocks[key] == t
+	trackedLocksMx.Unlock()
+
+	if !tracked {
+		return
+	}
+
+	held := now.Sub(t.acquiredAt).Milliseconds()
+
+	deliver(h, webhook.Event{Event: webhook.EventHeld, Namespace: key.namespace, GroupID: key.id, Resources: webhookResources(t.resources), Time: now, HeldMs: &held})
+}
+
+// untrackLocks stops tracking all locks, e.g. when they are handed over to another process
+func untrackLocks() {
+	trackedLocksMx.Lock()
+	defer trackedLocksMx.Unlock()
+
+	for _, t := range trackedLocks {
+		for _, timer := range t.timers {
+			timer.Stop()
+		}
+	}
+
+	trackedLocks = make(map[groupKey]*trackedLock)
+}
+
+func webhookResources(resources []resource) []webhook.Resource {
+	list := make([]webhook.Resource, len(resources))
+	for i, r := range resources {
+		list[i] = webhook.Resource{T: r.T, Path: r.Path}
+	}
+
+	return list
+}
+
+func auditResources(resources []resource) []audit.Resource {
+	list := make([]audit.Resource, len(resources))
+	for i, r := range resources {
+		list[i] = audit.Resource{T: r.T, Path: r.Path}
+	}
+
+	return list
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	ml "github.com/locktopus-project/locktopus/pkg/multilocker"
+)
+
+// Locks may carry the owner and a few labels (e.g. job name, host or trace ID) that are shown in the logs, the admin API and the audit log.
+// Statistics can be aggregated by a label (see statsV1Handler)
+
+const maxLabels = 16
+const maxMetadataLength = 256 // bytes of the owner, a label name or a label value
+
+func checkMetadata(owner string, labels map[string]string) error {
+	if len(owner) > maxMetadataLength {
+		return fmt.Errorf("%w: owner longer than %d bytes", errRequestTooLarge, maxMetadataLength)
+	}
+
+	if len(labels) > maxLabels {
+		return fmt.Errorf("%w: more than %d labels", errRequestTooLarge, maxLabels)
+	}
+
+	for name, value := range labels {
+		if name == "" {
+			return errors.New("label name should not be empty")
+		}
+
+		if len(name) > maxMetadataLength || len(value) > maxMetadataLength {
+			return fmt.Errorf("%w: label '%.32s' longer than %d bytes", errRequestTooLarge, name, maxMetadataLength)
+		}
+	}
+
+	return nil
+}
+
+// formatMetadata returns the owner and the labels to be appended to the log details, e.g. ", owner = worker-1, labels = host=a job=billing"
+func formatMetadata(m ml.Metadata) string {
+	s := ""
+
+	if m.Owner != "" {
+		s += fmt.Sprintf(", owner = %s", m.Owner)
+	}
+
+	if len(m.Labels) == 0 {
+		return s
+	}
+
+	labels := make([]string, 0, len(m.Labels))
+	for name, value := range m.Labels {
+		labels = append(labels, name+"="+value)
+	}
+
+	sort.Strings(labels)
+
+	return s + fmt.Sprintf(", labels = %s", strings.Join(labels, " "))
+}
@@ -0,0 +1,105 @@
+package main_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/websocket"
+
+	main "github.com/locktopus-project/locktopus/cmd/server"
+	"github.com/locktopus-project/locktopus/internal/constants"
+	locktopusclient "github.com/locktopus-project/locktopus/pkg/client/v1"
+)
+
+func TestMetadata_GroupsAndStatsByLabel(t *testing.T) {
+	namespace := "metadata_groups"
+	address := startAdminTestServer(t)
+
+	holder := connectWebhookClient(t, address, namespace)
+	holder.AddLockResource(locktopusclient.LockTypeWrite, "a")
+	holder.SetLockOwner("worker-1")
+	holder.SetLockLabel("job", "billing")
+	if err := holder.Lock(); err != nil {
+		t.Fatalf("cannot lock: %s", err)
+	}
+
+	waiter := connectWebhookClient(t, address, namespace)
+	waiter.AddLockResource(locktopusclient.LockTypeWrite, "a")
+	waiter.SetLockLabel("job", "billing")
+	if err := waiter.Lock(); err != nil {
+		t.Fatalf("cannot lock: %s", err)
+	}
+
+	anonymous := connectWebhookClient(t, address, namespace)
+	anonymous.AddLockResource(locktopusclient.LockTypeWrite, "b")
+	if err := anonymous.Lock(); err != nil {
+		t.Fatalf("cannot lock: %s", err)
+	}
+
+	groups := []struct {
+		Owner  string            `json:"owner"`
+		Labels map[string]string `json:"labels"`
+	}{}
+	adminRequest(t, address, http.MethodGet, "/namespaces/"+namespace+"/groups", &groups)
+
+	if len(groups) != 3 || groups[0].Owner != "worker-1" || groups[0].Labels["job"] != "billing" || groups[1].Owner != "" || groups[1].Labels["job"] != "billing" || groups[2].Labels != nil {
+		t.Fatalf("unexpected groups: %+v", groups)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, fmt.Sprintf("http://%s/stats_v1?%s=%s&%s=job", address, constants.NamespaceQueryParameterName, namespace, constants.ByLabelQueryParameterName), nil)
+	req.Header.Set("Authorization", "Bearer "+authToken)
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("cannot query Locktopus server: %s", err)
+	}
+	defer res.Body.Close()
+
+	stats := struct {
+		ByLabel map[string]struct{ GroupsPending, GroupsAcquired int64 }
+	}{}
+
+	if err = json.NewDecoder(res.Body).Decode(&stats); err != nil {
+		t.Fatalf("cannot parse statistics: %s", err)
+	}
+
+	expected := map[string]struct{ GroupsPending, GroupsAcquired int64 }{"billing": {GroupsPending: 1, GroupsAcquired: 1}, "": {GroupsAcquired: 1}}
+	if !reflect.DeepEqual(stats.ByLabel, expected) {
+		t.Fatalf("statistics by label are %+v, expected %+v", stats.ByLabel, expected)
+	}
+}
+
+func TestMetadata_TooManyLabels(t *testing.T) {
+	address := startTestServer(t, main.ServerParameters{})
+
+	labels := map[string]string{}
+	for i := 0; i <= 16; i++ {
+		labels[fmt.Sprintf("label%d", i)] = "value"
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(v1URL(address, "metadata_limits"), nil)
+	if err != nil {
+		t.Fatalf("cannot connect to Locktopus server: %s", err)
+	}
+	defer conn.Close()
+
+	err = conn.WriteJSON(map[string]interface{}{
+		"action":    "lock",
+		"resources": []map[string]interface{}{{"type": "write", "path": []string{"a"}}},
+		"labels":    labels,
+	})
+	if err != nil {
+		t.Fatalf("cannot write request: %s", err)
+	}
+
+	for err == nil {
+		_, _, err = conn.ReadMessage()
+	}
+
+	if !websocket.IsCloseError(err, requestTooLargeCode) {
+		t.Fatalf("connection should be closed with code %d, got: %s", requestTooLargeCode, err)
+	}
+}
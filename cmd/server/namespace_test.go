@@ -0,0 +1,198 @@
+package main_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	main "github.com/locktopus-project/locktopus/cmd/server"
+	"github.com/locktopus-project/locktopus/internal/auth"
+	"github.com/locktopus-project/locktopus/internal/constants"
+	ns "github.com/locktopus-project/locktopus/internal/namespace"
+	locktopusclient "github.com/locktopus-project/locktopus/pkg/client/v1"
+)
+
+const adminToken = "admin-secret"
+
+const limitExceededCode = 3002
+
+func startAdminTestServer(t *testing.T) string {
+	return startAdminTestServerWithParameters(t, main.ServerParameters{})
+}
+
+func startAdminTestServerWithParameters(t *testing.T, params main.ServerParameters) string {
+	authenticator, err := auth.NewAuthenticator(auth.Config{
+		Tokens: []auth.TokenConfig{
+			{Name: "admin", Token: adminToken, Admin: true, Namespaces: []auth.NamespaceGrant{{Pattern: "*"}}},
+			{Name: "user", Token: authToken, Namespaces: []auth.NamespaceGrant{{Pattern: "*"}}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("cannot make authenticator: %s", err)
+	}
+
+	params.Authenticator = authenticator
+
+	return startTestServer(t, params)
+}
+
+func putNamespacePolicy(t *testing.T, address, token, namespace string, policy map[string]interface{}) int {
+	serialized, err := json.Marshal(policy)
+	if err != nil {
+		t.Fatalf("cannot serialize request body: %s", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("http://%s/admin/namespaces/%s", address, namespace), bytes.NewReader(serialized))
+	if err != nil {
+		t.Fatalf("cannot make request: %s", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("cannot query Locktopus server: %s", err)
+	}
+
+	resp.Body.Close()
+
+	return resp.StatusCode
+}
+
+func TestNamespace_AdminTokenIsRequired(t *testing.T) {
+	address := startAdminTestServer(t)
+
+	if status := putNamespacePolicy(t, address, authToken, "policy_forbidden", map[string]interface{}{}); status != http.StatusForbidden {
+		t.Fatalf("Status code is not 403")
+	}
+}
+
+func TestNamespace_PolicyTooLarge(t *testing.T) {
+	address := startAdminTestServerWithParameters(t, main.ServerParameters{RequestLimits: main.RequestLimits{MaxMessageSize: 64}})
+
+	policy := map[string]interface{}{"autoCreate": true, "description": strings.Repeat("a", 64)}
+
+	if status := putNamespacePolicy(t, address, adminToken, "policy_too_large", policy); status != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Status code is not 413")
+	}
+}
+
+func TestNamespace_AutoCreationDisabled(t *testing.T) {
+	address := startAdminTestServer(t)
+
+	if status := putNamespacePolicy(t, address, adminToken, "policy_closed_*", map[string]interface{}{"autoCreate": false}); status != http.StatusOK {
+		t.Fatalf("Status code is not 200")
+	}
+
+	_, err := locktopusclient.MakeClient(locktopusclient.ConnectionOptions{
+		Url:   fmt.Sprintf("ws://%s/v1?%s=%s", address, constants.NamespaceQueryParameterName, "policy_closed_typo"),
+		Token: authToken,
+	})
+	if err == nil {
+		t.Fatalf("client should not be able to connect to an unknown namespace")
+	}
+
+	if status := putNamespacePolicy(t, address, adminToken, "policy_closed_defined", map[string]interface{}{"autoCreate": false}); status != http.StatusOK {
+		t.Fatalf("Status code is not 200")
+	}
+
+	client, err := locktopusclient.MakeClient(locktopusclient.ConnectionOptions{
+		Url:   fmt.Sprintf("ws://%s/v1?%s=%s", address, constants.NamespaceQueryParameterName, "policy_closed_defined"),
+		Token: authToken,
+	})
+	if err != nil {
+		t.Fatalf("cannot connect to a defined namespace: %s", err)
+	}
+
+	client.Close()
+}
+
+func TestNamespace_MaxGroupSize(t *testing.T) {
+	address := startAdminTestServer(t)
+
+	if status := putNamespacePolicy(t, address, adminToken, "policy_limited", map[string]interface{}{"maxGroupSize": 1}); status != http.StatusOK {
+		t.Fatalf("Status code is not 200")
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://%s/v1?%s=%s&%s=%s", address, constants.NamespaceQueryParameterName, "policy_limited", constants.AccessTokenQueryParameterName, authToken), nil)
+	if err != nil {
+		t.Fatalf("cannot connect to Locktopus server: %s", err)
+	}
+	defer conn.Close()
+
+	err = conn.WriteJSON(map[string]interface{}{
+		"action": "lock",
+		"resources": []map[string]interface{}{
+			{"type": "write", "path": []string{"a"}},
+			{"type": "write", "path": []string{"b"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("cannot write request: %s", err)
+	}
+
+	for err == nil {
+		_, _, err = conn.ReadMessage()
+	}
+
+	if !websocket.IsCloseError(err, limitExceededCode) {
+		t.Fatalf("connection should be closed with code %d, got: %s", limitExceededCode, err)
+	}
+}
+
+func TestNamespace_Delete(t *testing.T) {
+	address := startAdminTestServer(t)
+	namespace := "policy_deleted"
+
+	client, err := locktopusclient.MakeClient(locktopusclient.ConnectionOptions{
+		Url:   fmt.Sprintf("ws://%s/v1?%s=%s", address, constants.NamespaceQueryParameterName, namespace),
+		Token: authToken,
+	})
+	if err != nil {
+		t.Fatalf("cannot connect to Locktopus server: %s", err)
+	}
+
+	req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("http://%s/admin/namespaces/%s", address, namespace), nil)
+	if err != nil {
+		t.Fatalf("cannot make request: %s", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("cannot query Locktopus server: %s", err)
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("Status code is not 202")
+	}
+
+	_, err = locktopusclient.MakeClient(locktopusclient.ConnectionOptions{
+		Url:   fmt.Sprintf("ws://%s/v1?%s=%s", address, constants.NamespaceQueryParameterName, namespace),
+		Token: authToken,
+	})
+	if err == nil {
+		t.Fatalf("client should not be able to connect to a namespace being deleted")
+	}
+
+	client.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for ns.GetNamespaceStatistics(namespace) != nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("namespace should be closed after the last connection is closed")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
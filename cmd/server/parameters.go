@@ -4,25 +4,108 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	f "github.com/jessevdk/go-flags"
+	"github.com/locktopus-project/locktopus/internal/audit"
+	"github.com/locktopus-project/locktopus/internal/auth"
 	constants "github.com/locktopus-project/locktopus/internal/constants"
+	"github.com/locktopus-project/locktopus/internal/webhook"
 )
 
 var port string
+var unixSockets []string
+var unixSocketMode os.FileMode
+var tlsPort string
+var tlsCertFile string
+var tlsKeyFile string
+var tlsClientCAFile string
+var respPort string
+var authenticator *auth.Authenticator
+
+const authTokensReloadInterval = 5 * time.Second
+
+var namespaceIdleTimeout time.Duration
+
+var walDir string
+var resumeGrace = 30 * time.Second
+
+var clusterParams = ClusterParameters{ElectionTimeout: time.Second}
+
+var upgradeSocket string
+
+var webhookOptions = webhook.DefaultOptions
+
+var auditOptions = audit.Options{MaxSize: 100 << 20, RotateInterval: 24 * time.Hour, MaxFiles: 30}
+
+var httpReadTimeout = defaultHTTPReadTimeout
+var httpWriteTimeout = defaultHTTPWriteTimeout
+var httpIdleTimeout = defaultHTTPIdleTimeout
+var allowedOrigins []string
+var webSocketOptions = WebSocketOptions{HandshakeTimeout: 10 * time.Second}
+var requestLimits = RequestLimits{MaxMessageSize: 1 << 20}
+
+var shardMapPath string
+var shardNodeIDParam string
+
 var hostname string
 var statInterval = 0
 var defaultAbandonTimeout = time.Millisecond * constants.DefaultAbandonTimeoutMs
+var defaultPendingAbandonTimeout *time.Duration // nil means defaultAbandonTimeout
 
 var arguments struct {
-	Help                 bool   `short:"h" long:"help" description:"Show help message and exit"`
-	Host                 string `short:"H" long:"host" description:"Hostname for listening. Overrides env var LOCKTOPUS_HOST. Default: 0.0.0.0"`
-	Port                 string `short:"p" long:"port" description:"Port to listen on. Overrides env var LOCKTOPUS_PORT. Default: 9009"`
-	LogClients           string `long:"log-clients" description:"Log client sessions (true/false). Overrides env var LOCKTOPUS_LOG_CLIENTS. Default: false"`
-	LogLocks             string `long:"log-locks" description:"Log locks caused by client sessions (true/false). Overrides env var LOCKTOPUS_LOG_LOCKS. Default: false"`
-	StatisticsInterval   string `long:"stats-interval" description:"Log usage statistics every N>0 seconds. Overrides env var LOCKTOPUS_STATS_INTERVAL. Default: 0 (never)"`
-	GlobalAbandonTimeout string `long:"default-abandon-timeout" description:"Default abandon timeout (ms) used for releasing closed connections not released by clients. Overrides env var LOCKTOPUS_DEFAULT_ABANDON_TIMEOUT. Default: 60000"`
+	Help                  bool     `short:"h" long:"help" description:"Show help message and exit"`
+	Config                string   `long:"config" description:"Path to the JSON config file with long option names as keys. Options given with flags and env vars override it. Reloaded on SIGHUP. Overrides env var LOCKTOPUS_CONFIG. Default: \"\" (none)"`
+	Host                  string   `short:"H" long:"host" description:"Hostname for listening. Overrides env var LOCKTOPUS_HOST. Default: 0.0.0.0"`
+	Port                  string   `short:"p" long:"port" description:"Port to listen on. Overrides env var LOCKTOPUS_PORT. Default: 9009"`
+	UnixSockets           []string `long:"unix-socket" description:"Path of a Unix domain socket to listen on. Can be repeated. Overrides env var LOCKTOPUS_UNIX_SOCKETS (comma-separated list). Default: none"`
+	UnixSocketMode        string   `long:"unix-socket-mode" description:"Permissions (octal) of the created Unix domain sockets, e.g. 0660. Overrides env var LOCKTOPUS_UNIX_SOCKET_MODE. Default: depends on umask"`
+	TLSPort               string   `long:"tls-port" description:"Port to listen on for TLS connections. Requires --tls-cert and --tls-key. Overrides env var LOCKTOPUS_TLS_PORT. Default: \"\" (disabled)"`
+	TLSCert               string   `long:"tls-cert" description:"Path to the TLS certificate (PEM). Overrides env var LOCKTOPUS_TLS_CERT"`
+	TLSKey                string   `long:"tls-key" description:"Path to the TLS private key (PEM). Overrides env var LOCKTOPUS_TLS_KEY"`
+	TLSClientCA           string   `long:"tls-client-ca" description:"Path to the CA certificate (PEM) for verifying client certificates. If provided, TLS clients must present a certificate, and its subject is used as the client identity. Overrides env var LOCKTOPUS_TLS_CLIENT_CA"`
+	AuthTokens            string   `long:"auth-tokens" description:"Path to the JSON file with access tokens. If provided, clients must authenticate with a bearer token. The file is reloaded on changes. Overrides env var LOCKTOPUS_AUTH_TOKENS. Default: \"\" (no authentication)"`
+	Namespaces            string   `long:"namespaces" description:"Path to the JSON file with namespace policies (limits, defaults, auto-creation). Overrides env var LOCKTOPUS_NAMESPACES. Default: \"\" (namespaces are created on first use, no limits)"`
+	NamespaceIdleTimeout  string   `long:"namespace-idle-timeout" description:"Delete namespaces having no connections and locks for N>0 ms. Namespaces defined with exact names are kept. Overrides env var LOCKTOPUS_NAMESPACE_IDLE_TIMEOUT. Default: 0 (never)"`
+	WALDir                string   `long:"wal-dir" description:"Directory for the write-ahead log. If provided, locks of durable namespaces survive server restarts. Overrides env var LOCKTOPUS_WAL_DIR. Default: \"\" (disabled)"`
+	WALResumeGrace        string   `long:"wal-resume-grace" description:"Time (ms) for clients to resume their locks restored from the write-ahead log or by a new cluster leader. Overrides env var LOCKTOPUS_WAL_RESUME_GRACE. Default: 30000"`
+	ClusterNodeID         string   `long:"cluster-node-id" description:"ID of this node in the cluster. If provided, locks are replicated among the nodes listed in --cluster-peers. Overrides env var LOCKTOPUS_CLUSTER_NODE_ID. Default: \"\" (no cluster)"`
+	ClusterPeers          string   `long:"cluster-peers" description:"Comma-separated list of all the cluster nodes as <id>=<url>, e.g. a=http://10.0.0.1:9009,b=http://10.0.0.2:9009. Overrides env var LOCKTOPUS_CLUSTER_PEERS"`
+	ClusterDir            string   `long:"cluster-dir" description:"Directory for the Raft log of this node. Overrides env var LOCKTOPUS_CLUSTER_DIR"`
+	ClusterSecret         string   `long:"cluster-secret" description:"Shared secret authenticating requests between the cluster nodes. Overrides env var LOCKTOPUS_CLUSTER_SECRET. Default: \"\" (not authenticated)"`
+	ClusterElection       string   `long:"cluster-election-timeout" description:"Time (ms) without a leader after which a new leader is elected. Overrides env var LOCKTOPUS_CLUSTER_ELECTION_TIMEOUT. Default: 1000"`
+	UpgradeSocket         string   `long:"upgrade-socket" description:"Path of the Unix domain socket for zero-downtime upgrades. A new process started with the same socket takes over the listeners and the locks of durable namespaces from the running one. Overrides env var LOCKTOPUS_UPGRADE_SOCKET. Default: \"\" (disabled)"`
+	Shards                string   `long:"shards" description:"Path to the JSON shard map assigning namespaces to servers. If provided, requests for namespaces of other servers are redirected there. Requires --shard-node-id. Overrides env var LOCKTOPUS_SHARDS. Default: \"\" (no sharding)"`
+	ShardNodeID           string   `long:"shard-node-id" description:"ID of this server in the shard map. Overrides env var LOCKTOPUS_SHARD_NODE_ID"`
+	WebhookQueueSize      string   `long:"webhook-queue-size" description:"Max number of webhook deliveries waiting to be sent. Events are dropped when the queue is full. Overrides env var LOCKTOPUS_WEBHOOK_QUEUE_SIZE. Default: 1000"`
+	WebhookMaxAttempts    string   `long:"webhook-max-attempts" description:"Max number of attempts to deliver a webhook event. The delay between attempts starts at 1s and doubles. Overrides env var LOCKTOPUS_WEBHOOK_MAX_ATTEMPTS. Default: 5"`
+	AuditLog              string   `long:"audit-log" description:"Path of the audit log file. If provided, lock events are written there as JSON lines. Overrides env var LOCKTOPUS_AUDIT_LOG. Default: \"\" (disabled)"`
+	AuditMaxSize          string   `long:"audit-max-size" description:"Rotate the audit log when it exceeds N>0 MB. Overrides env var LOCKTOPUS_AUDIT_MAX_SIZE. Default: 100"`
+	AuditRotateInterval   string   `long:"audit-rotate-interval" description:"Rotate the audit log every N ms. Overrides env var LOCKTOPUS_AUDIT_ROTATE_INTERVAL. Default: 86400000 (daily), 0 means never"`
+	AuditMaxFiles         string   `long:"audit-max-files" description:"Number of rotated audit log files to keep. Overrides env var LOCKTOPUS_AUDIT_MAX_FILES. Default: 30, 0 means all"`
+	AuditRetention        string   `long:"audit-retention" description:"Remove rotated audit log files older than N ms. Overrides env var LOCKTOPUS_AUDIT_RETENTION. Default: 0 (keep)"`
+	LockRatePerConn       string   `long:"lock-rate-per-connection" description:"Max lock requests per second on a connection. Overrides env var LOCKTOPUS_LOCK_RATE_PER_CONNECTION. Default: 0 (no limit)"`
+	LockRatePerIP         string   `long:"lock-rate-per-ip" description:"Max lock requests per second from a remote IP. Overrides env var LOCKTOPUS_LOCK_RATE_PER_IP. Default: 0 (no limit)"`
+	LockBurst             string   `long:"lock-burst" description:"Number of lock requests allowed at once above the rate limits. Overrides env var LOCKTOPUS_LOCK_BURST. Default: 10"`
+	MaxConnectionsPerIP   string   `long:"max-connections-per-ip" description:"Max concurrent connections from a remote IP. Overrides env var LOCKTOPUS_MAX_CONNECTIONS_PER_IP. Default: 0 (no limit)"`
+	MaxPendingPerClient   string   `long:"max-pending-per-client" description:"Max pending (enqueued) locks of a client identity, or of a remote IP for anonymous clients. Overrides env var LOCKTOPUS_MAX_PENDING_PER_CLIENT. Default: 0 (no limit)"`
+	HTTPReadTimeout       string   `long:"http-read-timeout" description:"Max time (ms) for reading an HTTP request, including the WebSocket handshake request. Overrides env var LOCKTOPUS_HTTP_READ_TIMEOUT. Default: 15000"`
+	HTTPWriteTimeout      string   `long:"http-write-timeout" description:"Max time (ms) for writing an HTTP response. Long polls and event streams end 1s before it. Overrides env var LOCKTOPUS_HTTP_WRITE_TIMEOUT. Default: 15000, should exceed 1000"`
+	HTTPIdleTimeout       string   `long:"http-idle-timeout" description:"Max time (ms) to wait for the next request on a keep-alive HTTP connection. Overrides env var LOCKTOPUS_HTTP_IDLE_TIMEOUT. Default: 60000"`
+	AllowedOrigins        []string `long:"allowed-origin" description:"Origin allowed for browser clients, e.g. https://app.example.com, or * for any. Applies to WebSocket upgrades and CORS. Can be repeated. Overrides env var LOCKTOPUS_ALLOWED_ORIGINS (comma-separated list). Default: none (any origin)"`
+	WSHandshakeTimeout    string   `long:"ws-handshake-timeout" description:"Max time (ms) for completing the WebSocket handshake. Overrides env var LOCKTOPUS_WS_HANDSHAKE_TIMEOUT. Default: 10000, 0 means no timeout"`
+	WSCompression         string   `long:"ws-compression" description:"Negotiate per-message deflate compression with WebSocket clients (true/false). Overrides env var LOCKTOPUS_WS_COMPRESSION. Default: false"`
+	MaxMessageSize        string   `long:"max-message-size" description:"Max size (bytes) of a WebSocket message, an HTTP API v2 request body or the arguments of a RESP command. Overrides env var LOCKTOPUS_MAX_MESSAGE_SIZE. Default: 1048576, 0 means no limit"`
+	MaxResources          string   `long:"max-resources" description:"Max number of resources in a lock request. Overrides env var LOCKTOPUS_MAX_RESOURCES. Default: 0 (no limit)"`
+	MaxPathDepth          string   `long:"max-path-depth" description:"Max number of segments in a resource path. Overrides env var LOCKTOPUS_MAX_PATH_DEPTH. Default: 0 (no limit)"`
+	MaxSegmentLength      string   `long:"max-segment-length" description:"Max length (bytes) of a resource path segment. Overrides env var LOCKTOPUS_MAX_SEGMENT_LENGTH. Default: 0 (no limit)"`
+	RespPort              string   `long:"resp-port" description:"Port to listen on for RESP (Redis protocol) clients. Overrides env var LOCKTOPUS_RESP_PORT. Default: \"\" (disabled)"`
+	LogClients            string   `long:"log-clients" description:"Log client sessions (true/false). Overrides env var LOCKTOPUS_LOG_CLIENTS. Default: false"`
+	LogLocks              string   `long:"log-locks" description:"Log locks caused by client sessions (true/false). Overrides env var LOCKTOPUS_LOG_LOCKS. Default: false"`
+	StatisticsInterval    string   `long:"stats-interval" description:"Log usage statistics every N>0 seconds. Overrides env var LOCKTOPUS_STATS_INTERVAL. Default: 0 (never)"`
+	GlobalAbandonTimeout  string   `long:"default-abandon-timeout" description:"Default abandon timeout (ms) used for releasing closed connections not released by clients. Overrides env var LOCKTOPUS_DEFAULT_ABANDON_TIMEOUT. Default: 60000"`
+	PendingAbandonTimeout string   `long:"pending-abandon-timeout" description:"Abandon timeout (ms) for locks still enqueued when their connection closes. 0 cancels them right away, so they do not block the queue. Overrides env var LOCKTOPUS_PENDING_ABANDON_TIMEOUT. Default: same as default-abandon-timeout"`
 }
 
 func parseArguments() {
@@ -41,17 +124,133 @@ func parseArguments() {
 		os.Exit(0)
 	}
 
+	if path := resolveStringParameter(arguments.Config, "CONFIG", ""); path != "" {
+		if configValues, err = loadConfigFile(path); err != nil {
+			mainLogger.Errorf("Cannot load config: %s", err)
+			os.Exit(1)
+			return
+		}
+	}
+
 	port = resolveStringParameter(arguments.Port, "PORT", constants.DefaultServerPort)
-	hostname = resolveStringParameter(arguments.Host, "HOST", constants.DefaultServerHost)
+	respPort = resolveStringParameter(arguments.RespPort, "RESP_PORT", "")
+	unixSockets = resolveListParameter(arguments.UnixSockets, "UNIX_SOCKETS")
+	tlsPort = resolveStringParameter(arguments.TLSPort, "TLS_PORT", "")
+	tlsCertFile = resolveStringParameter(arguments.TLSCert, "TLS_CERT", "")
+	tlsKeyFile = resolveStringParameter(arguments.TLSKey, "TLS_KEY", "")
+	tlsClientCAFile = resolveStringParameter(arguments.TLSClientCA, "TLS_CLIENT_CA", "")
+
+	if tlsPort != "" && (tlsCertFile == "" || tlsKeyFile == "") {
+		mainLogger.Errorf("Both tls-cert and tls-key are required for listening on tls-port")
+		os.Exit(1)
+		return
+	}
+
+	if resolveStringParameter(arguments.AuthTokens, "AUTH_TOKENS", "") != "" || configObject("AUTH_TOKENS") != nil {
+		authenticator, _ = auth.NewAuthenticator(auth.Config{})
+	}
+
+	// log options, rate limits, auth-tokens and namespaces
+	if err = applyReloadableOptions(); err != nil {
+		mainLogger.Errorf("Cannot apply options: %s", err)
+		os.Exit(1)
+		return
+	}
+
+	if authenticator != nil {
+		authenticator.Watch(authTokensReloadInterval, func(err error) {
+			if err != nil {
+				mainLogger.Errorf("Cannot reload auth-tokens, keeping the previous tokens: %s", err)
+				return
+			}
+
+			mainLogger.Info("Reloaded auth-tokens")
+		})
+	}
+
+	if v := resolveStringParameter(arguments.NamespaceIdleTimeout, "NAMESPACE_IDLE_TIMEOUT", ""); v != "" {
+		timeoutMs, err := strconv.Atoi(v)
+
+		if err != nil || timeoutMs < 0 {
+			mainLogger.Errorf("Cannot parse namespace-idle-timeout value: %v", v)
+			os.Exit(1)
+			return
+		}
+
+		namespaceIdleTimeout = time.Millisecond * time.Duration(timeoutMs)
+	}
+
+	walDir = resolveStringParameter(arguments.WALDir, "WAL_DIR", "")
+
+	if v := resolveStringParameter(arguments.WALResumeGrace, "WAL_RESUME_GRACE", ""); v != "" {
+		graceMs, err := strconv.Atoi(v)
+
+		if err != nil || graceMs < 0 {
+			mainLogger.Errorf("Cannot parse wal-resume-grace value: %v", v)
+			os.Exit(1)
+			return
+		}
+
+		resumeGrace = time.Millisecond * time.Duration(graceMs)
+	}
+
+	upgradeSocket = resolveStringParameter(arguments.UpgradeSocket, "UPGRADE_SOCKET", "")
+
+	parseAuditArguments()
+	parseRequestArguments()
 
-	if resolveBoolParameter(arguments.LogClients, "LOG_CLIENTS", false) {
-		apiLogger.Disable()
+	if v := resolveStringParameter(arguments.WebhookQueueSize, "WEBHOOK_QUEUE_SIZE", ""); v != "" {
+		size, err := strconv.Atoi(v)
+
+		if err != nil || size <= 0 {
+			mainLogger.Errorf("Cannot parse webhook-queue-size value: %v", v)
+			os.Exit(1)
+			return
+		}
+
+		webhookOptions.QueueSize = size
 	}
 
-	if resolveBoolParameter(arguments.LogLocks, "LOG_LOCKS", false) {
-		lockLogger.Disable()
+	if v := resolveStringParameter(arguments.WebhookMaxAttempts, "WEBHOOK_MAX_ATTEMPTS", ""); v != "" {
+		attempts, err := strconv.Atoi(v)
+
+		if err != nil || attempts <= 0 {
+			mainLogger.Errorf("Cannot parse webhook-max-attempts value: %v", v)
+			os.Exit(1)
+			return
+		}
+
+		webhookOptions.MaxAttempts = attempts
 	}
 
+	clusterParams.NodeID = resolveStringParameter(arguments.ClusterNodeID, "CLUSTER_NODE_ID", "")
+
+	if clusterParams.NodeID != "" {
+		parseClusterArguments()
+	}
+
+	shardMapPath = resolveStringParameter(arguments.Shards, "SHARDS", "")
+	shardNodeIDParam = resolveStringParameter(arguments.ShardNodeID, "SHARD_NODE_ID", "")
+
+	if shardMapPath != "" && shardNodeIDParam == "" {
+		mainLogger.Errorf("shard-node-id is required with shards")
+		os.Exit(1)
+		return
+	}
+
+	if v := resolveStringParameter(arguments.UnixSocketMode, "UNIX_SOCKET_MODE", ""); v != "" {
+		mode, err := strconv.ParseUint(v, 8, 32)
+
+		if err != nil {
+			mainLogger.Errorf("Cannot parse unix-socket-mode value: %s", err)
+			os.Exit(1)
+			return
+		}
+
+		unixSocketMode = os.FileMode(mode)
+	}
+	hostname = resolveStringParameter(arguments.Host, "HOST", constants.DefaultServerHost)
+
 	if v := resolveStringParameter(arguments.StatisticsInterval, "STATS_INTERVAL", ""); v != "" {
 		interval, err := strconv.Atoi(v)
 
@@ -75,6 +274,278 @@ func parseArguments() {
 
 		defaultAbandonTimeout = time.Millisecond * time.Duration(timeoutMs)
 	}
+
+	if v := resolveStringParameter(arguments.PendingAbandonTimeout, "PENDING_ABANDON_TIMEOUT", ""); v != "" {
+		timeoutMs, err := strconv.Atoi(v)
+
+		if err != nil || timeoutMs < 0 {
+			mainLogger.Errorf("Cannot parse pending-abandon-timeout value: %s", v)
+			os.Exit(1)
+			return
+		}
+
+		timeout := time.Millisecond * time.Duration(timeoutMs)
+		defaultPendingAbandonTimeout = &timeout
+	}
+}
+
+func parseAuditArguments() {
+	auditOptions.Path = resolveStringParameter(arguments.AuditLog, "AUDIT_LOG", "")
+
+	if v := resolveStringParameter(arguments.AuditMaxSize, "AUDIT_MAX_SIZE", ""); v != "" {
+		size, err := strconv.Atoi(v)
+
+		if err != nil || size <= 0 {
+			mainLogger.Errorf("Cannot parse audit-max-size value: %v", v)
+			os.Exit(1)
+			return
+		}
+
+		auditOptions.MaxSize = int64(size) << 20
+	}
+
+	if v := resolveStringParameter(arguments.AuditRotateInterval, "AUDIT_ROTATE_INTERVAL", ""); v != "" {
+		intervalMs, err := strconv.Atoi(v)
+
+		if err != nil || intervalMs < 0 {
+			mainLogger.Errorf("Cannot parse audit-rotate-interval value: %v", v)
+			os.Exit(1)
+			return
+		}
+
+		auditOptions.RotateInterval = time.Millisecond * time.Duration(intervalMs)
+	}
+
+	if v := resolveStringParameter(arguments.AuditMaxFiles, "AUDIT_MAX_FILES", ""); v != "" {
+		files, err := strconv.Atoi(v)
+
+		if err != nil || files < 0 {
+			mainLogger.Errorf("Cannot parse audit-max-files value: %v", v)
+			os.Exit(1)
+			return
+		}
+
+		auditOptions.MaxFiles = files
+	}
+
+	if v := resolveStringParameter(arguments.AuditRetention, "AUDIT_RETENTION", ""); v != "" {
+		retentionMs, err := strconv.Atoi(v)
+
+		if err != nil || retentionMs < 0 {
+			mainLogger.Errorf("Cannot parse audit-retention value: %v", v)
+			os.Exit(1)
+			return
+		}
+
+		auditOptions.Retention = time.Millisecond * time.Duration(retentionMs)
+	}
+}
+
+func resolveRateLimits() (RateLimits, error) {
+	limits := DefaultRateLimits
+
+	if v := resolveStringParameter(arguments.LockRatePerConn, "LOCK_RATE_PER_CONNECTION", ""); v != "" {
+		rate, err := strconv.ParseFloat(v, 64)
+
+		if err != nil || rate < 0 {
+			return limits, fmt.Errorf("cannot parse lock-rate-per-connection value: %v", v)
+		}
+
+		limits.LockRatePerConnection = rate
+	}
+
+	if v := resolveStringParameter(arguments.LockRatePerIP, "LOCK_RATE_PER_IP", ""); v != "" {
+		rate, err := strconv.ParseFloat(v, 64)
+
+		if err != nil || rate < 0 {
+			return limits, fmt.Errorf("cannot parse lock-rate-per-ip value: %v", v)
+		}
+
+		limits.LockRatePerIP = rate
+	}
+
+	if v := resolveStringParameter(arguments.LockBurst, "LOCK_BURST", ""); v != "" {
+		burst, err := strconv.Atoi(v)
+
+		if err != nil || burst <= 0 {
+			return limits, fmt.Errorf("cannot parse lock-burst value: %v", v)
+		}
+
+		limits.LockBurst = burst
+	}
+
+	if v := resolveStringParameter(arguments.MaxConnectionsPerIP, "MAX_CONNECTIONS_PER_IP", ""); v != "" {
+		connections, err := strconv.Atoi(v)
+
+		if err != nil || connections < 0 {
+			return limits, fmt.Errorf("cannot parse max-connections-per-ip value: %v", v)
+		}
+
+		limits.MaxConnectionsPerIP = connections
+	}
+
+	if v := resolveStringParameter(arguments.MaxPendingPerClient, "MAX_PENDING_PER_CLIENT", ""); v != "" {
+		pending, err := strconv.Atoi(v)
+
+		if err != nil || pending < 0 {
+			return limits, fmt.Errorf("cannot parse max-pending-per-client value: %v", v)
+		}
+
+		limits.MaxPendingPerClient = pending
+	}
+
+	return limits, nil
+}
+
+func parseRequestArguments() {
+	if v := resolveStringParameter(arguments.HTTPReadTimeout, "HTTP_READ_TIMEOUT", ""); v != "" {
+		timeoutMs, err := strconv.Atoi(v)
+
+		if err != nil || timeoutMs <= 0 {
+			mainLogger.Errorf("Cannot parse http-read-timeout value: %v", v)
+			os.Exit(1)
+			return
+		}
+
+		httpReadTimeout = time.Millisecond * time.Duration(timeoutMs)
+	}
+
+	if v := resolveStringParameter(arguments.HTTPWriteTimeout, "HTTP_WRITE_TIMEOUT", ""); v != "" {
+		timeoutMs, err := strconv.Atoi(v)
+
+		if err != nil || timeoutMs <= 1000 {
+			mainLogger.Errorf("Cannot parse http-write-timeout value: %v", v)
+			os.Exit(1)
+			return
+		}
+
+		httpWriteTimeout = time.Millisecond * time.Duration(timeoutMs)
+	}
+
+	if v := resolveStringParameter(arguments.HTTPIdleTimeout, "HTTP_IDLE_TIMEOUT", ""); v != "" {
+		timeoutMs, err := strconv.Atoi(v)
+
+		if err != nil || timeoutMs <= 0 {
+			mainLogger.Errorf("Cannot parse http-idle-timeout value: %v", v)
+			os.Exit(1)
+			return
+		}
+
+		httpIdleTimeout = time.Millisecond * time.Duration(timeoutMs)
+	}
+
+	allowedOrigins = resolveListParameter(arguments.AllowedOrigins, "ALLOWED_ORIGINS")
+
+	if v := resolveStringParameter(arguments.WSHandshakeTimeout, "WS_HANDSHAKE_TIMEOUT", ""); v != "" {
+		timeoutMs, err := strconv.Atoi(v)
+
+		if err != nil || timeoutMs < 0 {
+			mainLogger.Errorf("Cannot parse ws-handshake-timeout value: %v", v)
+			os.Exit(1)
+			return
+		}
+
+		webSocketOptions.HandshakeTimeout = time.Millisecond * time.Duration(timeoutMs)
+	}
+
+	webSocketOptions.Compression = resolveBoolParameter(arguments.WSCompression, "WS_COMPRESSION", false)
+
+	if v := resolveStringParameter(arguments.MaxMessageSize, "MAX_MESSAGE_SIZE", ""); v != "" {
+		size, err := strconv.ParseInt(v, 10, 64)
+
+		if err != nil || size < 0 {
+			mainLogger.Errorf("Cannot parse max-message-size value: %v", v)
+			os.Exit(1)
+			return
+		}
+
+		requestLimits.MaxMessageSize = size
+	}
+
+	if v := resolveStringParameter(arguments.MaxResources, "MAX_RESOURCES", ""); v != "" {
+		resources, err := strconv.Atoi(v)
+
+		if err != nil || resources < 0 {
+			mainLogger.Errorf("Cannot parse max-resources value: %v", v)
+			os.Exit(1)
+			return
+		}
+
+		requestLimits.MaxResources = resources
+	}
+
+	if v := resolveStringParameter(arguments.MaxPathDepth, "MAX_PATH_DEPTH", ""); v != "" {
+		depth, err := strconv.Atoi(v)
+
+		if err != nil || depth < 0 {
+			mainLogger.Errorf("Cannot parse max-path-depth value: %v", v)
+			os.Exit(1)
+			return
+		}
+
+		requestLimits.MaxPathDepth = depth
+	}
+
+	if v := resolveStringParameter(arguments.MaxSegmentLength, "MAX_SEGMENT_LENGTH", ""); v != "" {
+		length, err := strconv.Atoi(v)
+
+		if err != nil || length < 0 {
+			mainLogger.Errorf("Cannot parse max-segment-length value: %v", v)
+			os.Exit(1)
+			return
+		}
+
+		requestLimits.MaxSegmentLength = length
+	}
+}
+
+func parseClusterArguments() {
+	if walDir != "" {
+		mainLogger.Errorf("Cluster mode and wal-dir cannot be used together: locks are replicated with the Raft log")
+		os.Exit(1)
+		return
+	}
+
+	if upgradeSocket != "" {
+		mainLogger.Errorf("Cluster mode and upgrade-socket cannot be used together: upgrade the nodes one by one instead")
+		os.Exit(1)
+		return
+	}
+
+	clusterParams.Peers = make(map[string]string)
+
+	for _, peer := range strings.Split(resolveStringParameter(arguments.ClusterPeers, "CLUSTER_PEERS", ""), ",") {
+		id, address, ok := strings.Cut(strings.TrimSpace(peer), "=")
+		if !ok || id == "" || address == "" {
+			mainLogger.Errorf("Cannot parse cluster-peers value: %q, expected <id>=<url>", peer)
+			os.Exit(1)
+			return
+		}
+
+		clusterParams.Peers[id] = address
+	}
+
+	clusterParams.Dir = resolveStringParameter(arguments.ClusterDir, "CLUSTER_DIR", "")
+	if clusterParams.Dir == "" {
+		mainLogger.Errorf("cluster-dir is required in cluster mode")
+		os.Exit(1)
+		return
+	}
+
+	clusterParams.Secret = resolveStringParameter(arguments.ClusterSecret, "CLUSTER_SECRET", "")
+	clusterParams.ResumeGrace = resumeGrace
+
+	if v := resolveStringParameter(arguments.ClusterElection, "CLUSTER_ELECTION_TIMEOUT", ""); v != "" {
+		timeoutMs, err := strconv.Atoi(v)
+
+		if err != nil || timeoutMs <= 0 {
+			mainLogger.Errorf("Cannot parse cluster-election-timeout value: %v", v)
+			os.Exit(1)
+			return
+		}
+
+		clusterParams.ElectionTimeout = time.Millisecond * time.Duration(timeoutMs)
+	}
 }
 
 func getEnvVar(name string) string {
@@ -94,9 +565,25 @@ func resolveStringParameter(argValue, envName, def string) string {
 		return e
 	}
 
+	if c := configValue(envName); c != "" {
+		return c
+	}
+
 	return def
 }
 
+func resolveListParameter(argValues []string, envName string) []string {
+	if len(argValues) > 0 {
+		return argValues
+	}
+
+	if e := getEnvVar(envName); e != "" {
+		return strings.Split(e, ",")
+	}
+
+	return configList(envName)
+}
+
 var trueStrings = []string{"true", "1", "yes", "y"}
 
 func resolveBoolParameter(argValue, envName string, def bool) bool {
@@ -108,6 +595,10 @@ func resolveBoolParameter(argValue, envName string, def bool) bool {
 		return contains(trueStrings, e)
 	}
 
+	if c := configValue(envName); c != "" {
+		return contains(trueStrings, c)
+	}
+
 	return def
 }
 
@@ -5,46 +5,193 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
 
 	// internal
+	"github.com/locktopus-project/locktopus/internal/auth"
+	"github.com/locktopus-project/locktopus/internal/constants"
 	ns "github.com/locktopus-project/locktopus/internal/namespace"
+	"github.com/locktopus-project/locktopus/pkg/raft"
 )
 
 const numberOfPosixSignals = 28
 
+const defaultHTTPReadTimeout = time.Second * 15
+const defaultHTTPWriteTimeout = time.Second * 15
+const defaultHTTPIdleTimeout = time.Second * 60
+
 type apiHandler struct {
 	version        string
-	handler        func(w http.ResponseWriter, r *http.Request, defaultAbandonTimeout time.Duration)
+	handler        func(w http.ResponseWriter, r *http.Request, s *Server)
+	router         func(r *mux.Router, s *Server) // used instead of handler by APIs with several routes under the version prefix
 	connStrExample string
 }
 
 func main() {
 	parseArguments()
 
-	server := MakeServer(
-		ServerParameters{
-			Hostname:              hostname,
-			Port:                  port,
-			DefaultAbandonTimeout: defaultAbandonTimeout,
-		},
-	)
+	params := ServerParameters{
+		Hostname:                     hostname,
+		Port:                         port,
+		UnixSockets:                  unixSockets,
+		UnixSocketMode:               unixSocketMode,
+		TLSPort:                      tlsPort,
+		TLSCertFile:                  tlsCertFile,
+		TLSKeyFile:                   tlsKeyFile,
+		TLSClientCAFile:              tlsClientCAFile,
+		RespPort:                     respPort,
+		DefaultAbandonTimeout:        defaultAbandonTimeout,
+		DefaultPendingAbandonTimeout: defaultPendingAbandonTimeout,
+		Authenticator:                authenticator,
+		HTTPReadTimeout:              httpReadTimeout,
+		HTTPWriteTimeout:             httpWriteTimeout,
+		HTTPIdleTimeout:              httpIdleTimeout,
+		AllowedOrigins:               allowedOrigins,
+		WebSocket:                    webSocketOptions,
+		RequestLimits:                requestLimits,
+	}
+
+	// restored locks are tracked for webhooks and the audit log
+	StartWebhooks(webhookOptions)
+	defer StopWebhooks()
+
+	if auditOptions.Path != "" {
+		if err := StartAudit(auditOptions); err != nil {
+			mainLogger.Errorf("Cannot open audit log: %s", err)
+			os.Exit(1)
+		}
+
+		defer StopAudit()
+	}
+
+	var upgrade *PendingUpgrade
+
+	if upgradeSocket != "" {
+		var err error
+
+		// the running server closes its write-ahead log before handing over
+		if upgrade, err = ReceiveUpgrade(upgradeSocket); err != nil {
+			mainLogger.Errorf("Cannot take over the running server: %s", err)
+			os.Exit(1)
+		}
+	}
+
+	if walDir != "" {
+		if err := OpenWAL(walDir, resumeGrace); err != nil {
+			mainLogger.Errorf("Cannot open write-ahead log: %s", err)
+			os.Exit(1)
+		}
+
+		defer CloseWAL()
+	}
+
+	if clusterParams.NodeID != "" {
+		if err := StartCluster(clusterParams); err != nil {
+			mainLogger.Errorf("Cannot start cluster node: %s", err)
+			os.Exit(1)
+		}
+
+		defer StopCluster()
+	}
+
+	if upgradeSocket != "" {
+		EnableUpgrades(resumeGrace)
+	}
+
+	if upgrade != nil {
+		upgrade.Restore()
+
+		if err := upgrade.Ready(); err != nil {
+			mainLogger.Errorf("Running server has cancelled the upgrade: %s", err)
+			os.Exit(1)
+		}
+
+		mainLogger.Info("Took over the running server")
+	}
+
+	if shardMapPath != "" {
+		if err := LoadShardMap(shardMapPath, shardNodeIDParam); err != nil {
+			mainLogger.Errorf("Cannot load shard map: %s", err)
+			os.Exit(1)
+		}
+	}
+
+	if namespaceIdleTimeout > 0 {
+		ns.StartGC(namespaceIdleTimeout)
+	}
+
+	server := MakeServer(params)
 	listenErr := StartListening(server)
 
+	var respServer *RespServer
+	var respListenErr <-chan error
+
+	if respPort != "" {
+		respServer = MakeRespServer(params)
+		respListenErr = StartRespListening(respServer)
+	}
+
+	var upgradeListenErr <-chan error
+
+	if upgradeSocket != "" {
+		configured := []string{"upgrade://" + upgradeSocket}
+		for _, l := range server.listeners() {
+			configured = append(configured, l.String())
+		}
+
+		if respServer != nil {
+			configured = append(configured, "resp://"+respServer.Addr)
+		}
+
+		closeInheritedListeners(configured)
+
+		upgradeListenErr = StartUpgradeListening(upgradeSocket)
+	}
+
+	go func() {
+		for range getDrainSignals() {
+			startDrain()
+		}
+	}()
+
+	go func() {
+		for range getReloadSignals() {
+			if err := ReloadConfig(); err != nil {
+				mainLogger.Errorf("Cannot reload config, keeping the previous options: %s", err)
+				continue
+			}
+
+			mainLogger.Infof("Reloaded options: %s", strings.Join(reloadableOptions, ", "))
+		}
+	}()
+
 	exitCode := 0
 
 	select {
 	case err := <-listenErr:
-		mainLogger.Error(fmt.Errorf("HTTP listener error: %w", err))
+		mainLogger.Error(err)
+		exitCode = 1
+	case err := <-respListenErr:
+		mainLogger.Error(err)
 		exitCode = 1
+	case err := <-upgradeListenErr:
+		mainLogger.Error(err)
+		exitCode = 1
+	case <-upgradeCompleted:
+		mainLogger.Info("Handed over to the new process, releasing the local locks")
+		releaseHandedOver()
 	case s := <-getSignals():
 		mainLogger.Infof("Received signal: %s", s)
 	}
 
+	startDrain()
+
 	mainLogger.Info("Waiting for existing locks to be released... Send SIGINT or SIGTERM again to force exit")
 	select {
 	case <-ns.CloseNamespaces():
@@ -54,12 +201,37 @@ func main() {
 		exitCode = 1
 	}
 
+	closeSessions()
+
 	mainLogger.Info("Closing HTTP server...")
 	server.Close()
 
+	if respServer != nil {
+		mainLogger.Info("Closing RESP server...")
+		respServer.Close()
+	}
+
 	mainLogger.Info("Exit with code", exitCode)
 }
 
+// getDrainSignals returns SIGUSR1, which starts draining without exiting
+func getDrainSignals() <-chan os.Signal {
+	ch := make(chan os.Signal, 1)
+
+	signal.Notify(ch, syscall.SIGUSR1)
+
+	return ch
+}
+
+// getReloadSignals returns SIGHUP, which reloads the config
+func getReloadSignals() <-chan os.Signal {
+	ch := make(chan os.Signal, 1)
+
+	signal.Notify(ch, syscall.SIGHUP)
+
+	return ch
+}
+
 func getSignals() <-chan os.Signal {
 	ch := make(chan os.Signal, numberOfPosixSignals)
 
@@ -69,37 +241,95 @@ func getSignals() <-chan os.Signal {
 }
 
 type ServerParameters struct {
-	Hostname              string
-	Port                  string
-	DefaultAbandonTimeout time.Duration
+	Hostname                     string
+	Port                         string
+	UnixSockets                  []string    // paths of Unix domain sockets to listen on in addition to TCP
+	UnixSocketMode               os.FileMode // if not 0, permissions are applied to the created sockets
+	TLSPort                      string      // if provided, TLS is served on this port in addition to plain TCP
+	TLSCertFile                  string
+	TLSKeyFile                   string
+	TLSClientCAFile              string // if provided, TLS clients must present a certificate signed by this CA. The certificate subject is used as the client identity
+	RespPort                     string
+	DefaultAbandonTimeout        time.Duration
+	DefaultPendingAbandonTimeout *time.Duration      // for locks still enqueued when the client disconnects. 0 cancels them right away. If nil, DefaultAbandonTimeout is used
+	Authenticator                *auth.Authenticator // if provided, clients must present a token
+	HTTPReadTimeout              time.Duration       // 0 means 15s
+	HTTPWriteTimeout             time.Duration       // 0 means 15s. Should exceed 1s: long polls and event streams end 1s before it
+	HTTPIdleTimeout              time.Duration       // 0 means 60s
+	AllowedOrigins               []string            // Origin headers allowed for browser clients (WebSocket upgrades and CORS). "*" allows any origin, none allows all
+	WebSocket                    WebSocketOptions
+	RequestLimits                RequestLimits
+}
+
+// Server serves the same router on all listeners configured by ServerParameters.
+// Use MakeServer to create one and StartListening to start serving.
+type Server struct {
+	*http.Server
+	params   ServerParameters
+	upgrader websocket.Upgrader
 }
 
-func MakeServer(params ServerParameters) http.Server {
+func MakeServer(params ServerParameters) *Server {
 	hostname := params.Hostname
 	port := params.Port
-	defaultAbandonTimeout := params.DefaultAbandonTimeout
+
+	s := &Server{
+		params:   params,
+		upgrader: makeUpgrader(params.WebSocket, params.AllowedOrigins),
+	}
+
+	// the rejection counters of closed namespaces are not kept
+	ns.SetClosedHandler(forgetRejected)
 
 	r := mux.NewRouter()
 
 	for _, apiHandler := range apiHandlers {
 		ah := apiHandler
 
+		if ah.router != nil {
+			ah.router(r.PathPrefix(ah.version).Subrouter(), s)
+			continue
+		}
+
 		r.HandleFunc(ah.version, func(w http.ResponseWriter, r *http.Request) {
-			ah.handler(w, r, defaultAbandonTimeout)
+			ah.handler(w, r, s)
 		})
 	}
 
-	r.HandleFunc("/", greetingsHandler)
+	if cluster != nil {
+		r.PathPrefix(raft.HTTPPathPrefix + "/").Handler(raft.NewHTTPHandler(cluster.node, cluster.secret))
+	}
+
+	r.HandleFunc(constants.ShardMapPath, shardMapHandler)
+	r.HandleFunc("/healthz", healthzHandler)
+	r.HandleFunc("/readyz", readyzHandler)
+	r.HandleFunc("/dashboard", s.dashboardHandler).Methods(http.MethodGet)
+	r.HandleFunc("/", s.greetingsHandler)
 
-	server := http.Server{
+	s.Server = &http.Server{
 		Addr:         fmt.Sprintf("%s:%s", hostname, port),
-		WriteTimeout: time.Second * 15,
-		ReadTimeout:  time.Second * 15,
-		IdleTimeout:  time.Second * 60,
-		Handler:      handlers.CORS()(r),
+		WriteTimeout: durationOrDefault(params.HTTPWriteTimeout, defaultHTTPWriteTimeout),
+		ReadTimeout:  durationOrDefault(params.HTTPReadTimeout, defaultHTTPReadTimeout),
+		IdleTimeout:  durationOrDefault(params.HTTPIdleTimeout, defaultHTTPIdleTimeout),
+		Handler: handlers.CORS(handlers.AllowedOriginValidator(func(origin string) bool {
+			return originAllowed(params.AllowedOrigins, origin)
+		}))(clusterRedirect(r)),
 	}
 
-	return server
+	return s
+}
+
+// maxResponseDuration keeps long polls and event streams within the write timeout
+func (s *Server) maxResponseDuration() time.Duration {
+	return s.WriteTimeout - time.Second
+}
+
+func durationOrDefault(d, defaultValue time.Duration) time.Duration {
+	if d == 0 {
+		return defaultValue
+	}
+
+	return d
 }
 
 var apiHandlers = []apiHandler{
@@ -113,11 +343,26 @@ var apiHandlers = []apiHandler{
 		handler:        statsV1Handler,
 		connStrExample: "http://host:port/stats_v1?namespace=default",
 	},
+	{
+		version:        "/events_v1",
+		handler:        eventsV1Handler,
+		connStrExample: "http://host:port/events_v1?namespace=default",
+	},
+	{
+		version:        "/v2",
+		router:         apiV2Router,
+		connStrExample: "http://host:port/v2/namespaces/default/locks",
+	},
+	{
+		version:        "/admin",
+		router:         apiAdminRouter,
+		connStrExample: "http://host:port/admin/namespaces/default",
+	},
 }
 
 var lastConnID int64 = -1
 
-func StartListening(server http.Server) <-chan error {
+func StartListening(server *Server) <-chan error {
 	if statInterval > 0 {
 		go func() {
 			for {
@@ -132,25 +377,41 @@ func StartListening(server http.Server) <-chan error {
 		}()
 	}
 
-	mainLogger.Info("Starting listening on", server.Addr)
+	listeners := server.listeners()
 
-	ch := make(chan error)
+	ch := make(chan error, len(listeners))
 
-	go func() {
-		ch <- fmt.Errorf("HTTP listener error: %w", server.ListenAndServe())
-	}()
+	for _, l := range listeners {
+		mainLogger.Infof("Starting listening on %s", l)
+
+		go func(l listener) {
+			err := keepServing(l.String(), func() error {
+				return server.serve(l)
+			})
+
+			if err != nil {
+				ch <- fmt.Errorf("HTTP listener error (%s): %w", l, err)
+			}
+		}(l)
+	}
 
 	return ch
 }
 
-func greetingsHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) greetingsHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("Welcome to LOCKTOPUS server!\n\nAvailable API versions: \n"))
 	for _, apiHandler := range apiHandlers {
 		w.Write([]byte(fmt.Sprintf("%s\te.g. %s\n", apiHandler.version, apiHandler.connStrExample)))
 	}
 
+	w.Write([]byte("\nDashboard: http://host:port/dashboard\n"))
+
 	w.Write([]byte("\nServer parameters:\n"))
-	w.Write([]byte(fmt.Sprintf("Default abandon timeout: %s\n", hostname)))
+	w.Write([]byte(fmt.Sprintf("Default abandon timeout: %v\n", s.params.DefaultAbandonTimeout)))
+
+	if s.params.DefaultPendingAbandonTimeout != nil {
+		w.Write([]byte(fmt.Sprintf("Default abandon timeout of enqueued locks: %v\n", *s.params.DefaultPendingAbandonTimeout)))
+	}
 
 	namespaces := ns.GetNamespaces()
 	if len(namespaces) == 0 {
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -12,6 +13,8 @@ import (
 )
 
 var serverAddress = os.Getenv("SERVER_ADDRESS")
+var respServerAddress = os.Getenv("RESP_SERVER_ADDRESS")
+var unixSocketPath = os.Getenv("SERVER_UNIX_SOCKET")
 
 var defaultHostname = "localhost"
 
@@ -25,17 +28,32 @@ func TestMain(m *testing.M) {
 			log.Fatalf("Cannot find free port: %s", err)
 		}
 
+		freeRespPort, err := internal.FindFreePort()
+		if err != nil {
+			log.Fatalf("Cannot find free port: %s", err)
+		}
+
 		serverAddress = fmt.Sprintf("%s:%s", defaultHostname, freePort)
+		respServerAddress = fmt.Sprintf("%s:%s", defaultHostname, freeRespPort)
+		unixSocketPath = filepath.Join(os.TempDir(), fmt.Sprintf("locktopus_test_%s.sock", freePort))
 
-		server := main.MakeServer(main.ServerParameters{
+		params := main.ServerParameters{
 			Hostname:              defaultHostname,
 			Port:                  freePort,
+			UnixSockets:           []string{unixSocketPath},
+			RespPort:              freeRespPort,
 			DefaultAbandonTimeout: 60 * time.Second,
-		})
+		}
 
+		server := main.MakeServer(params)
 		main.StartListening(server)
 
 		defer server.Close()
+
+		respServer := main.MakeRespServer(params)
+		main.StartRespListening(respServer)
+
+		defer respServer.Close()
 	}
 
 	err := internal.EnsureServerAvailability(fmt.Sprintf("http://%s", serverAddress), connTimeoutMs, connPollIntervalMs)
@@ -45,3 +63,30 @@ func TestMain(m *testing.M) {
 
 	m.Run()
 }
+
+// startTestServer runs a separate server instance for tests that need non-default parameters. Hostname and Port are set by the helper.
+func startTestServer(t *testing.T, params main.ServerParameters) string {
+	port, err := internal.FindFreePort()
+	if err != nil {
+		t.Fatalf("cannot find free port: %s", err)
+	}
+
+	params.Hostname = defaultHostname
+	params.Port = port
+
+	if params.DefaultAbandonTimeout == 0 {
+		params.DefaultAbandonTimeout = time.Second
+	}
+
+	server := main.MakeServer(params)
+	main.StartListening(server)
+	t.Cleanup(func() { server.Close() })
+
+	address := fmt.Sprintf("%s:%s", defaultHostname, port)
+
+	if err = internal.EnsureServerAvailability(fmt.Sprintf("http://%s", address), connTimeoutMs, connPollIntervalMs); err != nil {
+		t.Fatalf("Cannot ensure server availability: %s", err)
+	}
+
+	return address
+}
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/locktopus-project/locktopus/internal/constants"
+	ns "github.com/locktopus-project/locktopus/internal/namespace"
+	"github.com/locktopus-project/locktopus/pkg/shardmap"
+)
+
+// With a shard map, every server owns a part of the namespaces. Requests for namespaces owned by other servers are redirected there.
+// The map is the same file on all the servers. Moving a namespace pins it to the new server in the next map version,
+// which is pushed to all the servers with the admin API.
+
+// shardMoveRetryAfter is the retry hint given to clients while their namespace is being moved
+const shardMoveRetryAfter = time.Second
+
+// shardPushTimeout limits pushing the shard map to a server
+const shardPushTimeout = 5 * time.Second
+
+var errShardMapOutdated = errors.New("shard map version is not greater than the current one")
+
+// shardMap is nil unless sharding is enabled
+var shardMap atomic.Pointer[shardmap.Map]
+var shardNodeID string
+var shardMapFile string // if not empty, map updates are saved there
+var shardMx = sync.Mutex{}
+
+// movingNamespaces maps namespaces being moved away to their new nodes
+var movingNamespaces = make(map[string]string)
+var movingMx = sync.Mutex{}
+
+// moveMx serializes moves, since each of them increments the map version
+var moveMx = sync.Mutex{}
+
+// LoadShardMap enables sharding with the map file. Map updates received with the admin API are saved to the file
+func LoadShardMap(path string, nodeID string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	m, err := shardmap.Parse(data)
+	if err != nil {
+		return err
+	}
+
+	if err = SetShardMap(m, nodeID); err != nil {
+		return err
+	}
+
+	shardMapFile = path
+
+	return nil
+}
+
+// SetShardMap enables sharding with the map, this server being nodeID. A nil map disables sharding
+func SetShardMap(m *shardmap.Map, nodeID string) error {
+	if m != nil {
+		if _, ok := m.Node(nodeID); !ok {
+			return fmt.Errorf("node %s is not in the shard map", nodeID)
+		}
+	}
+
+	shardMx.Lock()
+	defer shardMx.Unlock()
+
+	shardNodeID = nodeID
+	shardMap.Store(m)
+
+	return nil
+}
+
+// applyShardMap replaces the map with a newer version and saves it
+func applyShardMap(m *shardmap.Map) error {
+	shardMx.Lock()
+	defer shardMx.Unlock()
+
+	if current := shardMap.Load(); current != nil && m.Version <= current.Version {
+		return fmt.Errorf("%w (%d <= %d)", errShardMapOutdated, m.Version, current.Version)
+	}
+
+	if shardMapFile != "" {
+		if err := saveShardMap(m); err != nil {
+			return fmt.Errorf("cannot save shard map: %w", err)
+		}
+	}
+
+	shardMap.Store(m)
+
+	mainLogger.Infof("Applied shard map [version = %d]", m.Version)
+
+	return nil
+}
+
+func saveShardMap(m *shardmap.Map) error {
+	serialized, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(shardMapFile), ".shards-*")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(serialized); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), shardMapFile)
+}
+
+func movingTarget(namespace string) (string, bool) {
+	movingMx.Lock()
+	defer movingMx.Unlock()
+
+	target, ok := movingNamespaces[namespace]
+
+	return target, ok
+}
+
+// misroutedNode returns the node owning the namespace if it is not this one
+func misroutedNode(namespace string) (shardmap.Node, bool) {
+	m := shardMap.Load()
+	if m == nil {
+		return shardmap.Node{}, false
+	}
+
+	owner := m.Locate(namespace)
+
+	return owner, owner.ID != shardNodeID
+}
+
+// redirectMisrouted redirects requests for namespaces owned by other nodes. Namespaces being moved away get 503 with a retry hint
+func redirectMisrouted(w http.ResponseWriter, r *http.Request, namespace string) bool {
+	m := shardMap.Load()
+	if m == nil {
+		return false
+	}
+
+	if target, ok := movingTarget(namespace); ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(shardMoveRetryAfter.Seconds())))
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(fmt.Sprintf("Namespace %s is being moved to %s, retry later", namespace, target)))
+		return true
+	}
+
+	owner := m.Locate(namespace)
+	if owner.ID == shardNodeID {
+		return false
+	}
+
+	w.Header().Set(constants.ShardMapVersionHeaderName, strconv.FormatInt(m.Version, 10))
+	http.Redirect(w, r, strings.TrimSuffix(owner.URL, "/")+r.URL.RequestURI(), http.StatusTemporaryRedirect)
+
+	return true
+}
+
+// shardError returns the RESP error for namespaces owned by other nodes
+func shardError(namespace string) error {
+	if _, ok := movingTarget(namespace); ok {
+		return fmt.Errorf("TRYAGAIN namespace %s is being moved", namespace)
+	}
+
+	if owner, ok := misroutedNode(namespace); ok {
+		return fmt.Errorf("MOVED %s", owner.URL)
+	}
+
+	return nil
+}
+
+func shardMapHandler(w http.ResponseWriter, r *http.Request) {
+	m := shardMap.Load()
+	if m == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Sharding is not enabled"))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, m)
+}
+
+type adminShardsResponse struct {
+	Map    *shardmap.Map     `json:"map"`
+	Node   string            `json:"node"`
+	Moving map[string]string `json:"moving"` // new nodes of the namespaces being moved away
+}
+
+func (s *Server) getShardsHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+
+	m := shardMap.Load()
+	if m == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Sharding is not enabled"))
+		return
+	}
+
+	movingMx.Lock()
+	moving := make(map[string]string, len(movingNamespaces))
+	for k, v := range movingNamespaces {
+		moving[k] = v
+	}
+	movingMx.Unlock()
+
+	writeJSON(w, http.StatusOK, adminShardsResponse{
+		Map:    m,
+		Node:   shardNodeID,
+		Moving: moving,
+	})
+}
+
+// putShardsHandler replaces the shard map with a newer version. A namespace moved here is passed with its last group ID,
+// so group IDs keep growing on the new node
+func (s *Server) putShardsHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+
+	if refuseInCluster(w, "Shard map cannot be changed with the admin API in cluster mode: update the map file of every node") {
+		return
+	}
+
+	if shardMap.Load() == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Sharding is not enabled"))
+		return
+	}
+
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("Cannot read request body: %s", err)))
+		return
+	}
+
+	m, err := shardmap.Parse(data)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	if _, ok := m.Node(shardNodeID); !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("Node %s is not in the shard map", shardNodeID)))
+		return
+	}
+
+	namespace := r.URL.Query().Get("namespace")
+
+	var lastGroupID int64
+	if namespace != "" {
+		if lastGroupID, err = strconv.ParseInt(r.URL.Query().Get("last-group-id"), 10, 64); err != nil || lastGroupID < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("URL parameter 'last-group-id' should be a non-negative integer"))
+			return
+		}
+	}
+
+	if err = applyShardMap(m); err != nil {
+		if errors.Is(err, errShardMapOutdated) {
+			w.WriteHeader(http.StatusConflict)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	if namespace != "" {
+		ns.SetLastGroupID(namespace, lastGroupID)
+	}
+
+	writeJSON(w, http.StatusOK, m)
+}
+
+type moveNamespaceRequest struct {
+	Node string `json:"node"`
+}
+
+// moveNamespaceHandler moves the namespace to another node once it drains. Meanwhile, new requests for the namespace are refused
+func (s *Server) moveNamespaceHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+
+	if refuseInCluster(w, "Shard map cannot be changed with the admin API in cluster mode: update the map file of every node") {
+		return
+	}
+
+	namespace := mux.Vars(r)["namespace"]
+
+	m := shardMap.Load()
+	if m == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Sharding is not enabled"))
+		return
+	}
+
+	req := moveNamespaceRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("Cannot parse request body: %s", err)))
+		return
+	}
+
+	target, ok := m.Node(req.Node)
+	if !ok || target.ID == shardNodeID {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("Field 'node' should be the ID of another node in the shard map, got %q", req.Node)))
+		return
+	}
+
+	if owner := m.Locate(namespace); owner.ID != shardNodeID {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(fmt.Sprintf("Namespace %s is located on %s", namespace, owner.ID)))
+		return
+	}
+
+	movingMx.Lock()
+	if moving, ok := movingNamespaces[namespace]; ok {
+		movingMx.Unlock()
+
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(fmt.Sprintf("Namespace %s is already being moved to %s", namespace, moving)))
+		return
+	}
+
+	movingNamespaces[namespace] = target.ID
+	movingMx.Unlock()
+
+	mainLogger.Infof("Moving namespace %s to %s once it drains", namespace, target.ID)
+
+	go moveNamespace(namespace, target, r.Header.Get("Authorization"))
+
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte(fmt.Sprintf("Namespace %s will be moved to %s once it drains", namespace, target.ID)))
+}
+
+// moveNamespace waits for the namespace to drain, then pushes the map with the namespace pinned to the target node,
+// to the target first. The move is cancelled if the target does not accept the map
+func moveNamespace(namespace string, target shardmap.Node, authorization string) {
+	defer func() {
+		movingMx.Lock()
+		delete(movingNamespaces, namespace)
+		movingMx.Unlock()
+	}()
+
+	if deleted, err := ns.DeleteNamespace(namespace); err == nil {
+		<-deleted
+	}
+
+	moveMx.Lock()
+	defer moveMx.Unlock()
+
+	next, err := shardMap.Load().WithNamespace(namespace, target.ID)
+	if err != nil {
+		mainLogger.Errorf("Cannot move namespace %s: %s", namespace, err)
+		return
+	}
+
+	if err = pushShardMap(target, next, authorization, namespace, ns.LastGroupID(namespace)); err != nil {
+		mainLogger.Errorf("Cannot move namespace %s, %s has not accepted the shard map: %s", namespace, target.ID, err)
+		return
+	}
+
+	for _, n := range next.Nodes {
+		if n.ID == target.ID || n.ID == shardNodeID {
+			continue
+		}
+
+		if err = pushShardMap(n, next, authorization, "", 0); err != nil {
+			// the node keeps redirecting to this one, which redirects to the target
+			mainLogger.Errorf("Cannot push shard map to %s: %s", n.ID, err)
+		}
+	}
+
+	if err = applyShardMap(next); err != nil {
+		mainLogger.Errorf("Cannot apply shard map: %s", err)
+		return
+	}
+
+	mainLogger.Infof("Moved namespace %s to %s [shard map version = %d]", namespace, target.ID, next.Version)
+}
+
+func pushShardMap(n shardmap.Node, m *shardmap.Map, authorization string, namespace string, lastGroupID int64) error {
+	serialized, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	address := strings.TrimSuffix(n.URL, "/") + "/admin/shards"
+
+	if namespace != "" {
+		values := url.Values{}
+		values.Set("namespace", namespace)
+		values.Set("last-group-id", strconv.FormatInt(lastGroupID, 10))
+
+		address += "?" + values.Encode()
+	}
+
+	req, err := http.NewRequest(http.MethodPut, address, bytes.NewReader(serialized))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	res, err := (&http.Client{Timeout: shardPushTimeout}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("%s: %s", res.Status, body)
+	}
+
+	return nil
+}
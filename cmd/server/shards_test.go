@@ -0,0 +1,272 @@
+package main_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	main "github.com/locktopus-project/locktopus/cmd/server"
+	"github.com/locktopus-project/locktopus/internal/constants"
+	locktopusclient "github.com/locktopus-project/locktopus/pkg/client/v1"
+	"github.com/locktopus-project/locktopus/pkg/shardmap"
+)
+
+// noRedirectClient returns redirect responses instead of following them
+var noRedirectClient = &http.Client{
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+func setShardMap(t *testing.T, nodeID string, nodes map[string]string, pinned map[string]string) *shardmap.Map {
+	m := map[string]interface{}{"version": 1, "namespaces": pinned}
+
+	list := []map[string]string{}
+	for id, u := range nodes {
+		list = append(list, map[string]string{"id": id, "url": u})
+	}
+
+	m["nodes"] = list
+
+	serialized, err := json.Marshal(m)
+	if err != nil {
+		t.Fatalf("cannot serialize shard map: %s", err)
+	}
+
+	sm, err := shardmap.Parse(serialized)
+	if err != nil {
+		t.Fatalf("cannot parse shard map: %s", err)
+	}
+
+	if err = main.SetShardMap(sm, nodeID); err != nil {
+		t.Fatalf("cannot set shard map: %s", err)
+	}
+
+	t.Cleanup(func() {
+		main.SetShardMap(nil, "")
+	})
+
+	return sm
+}
+
+func TestShards_RedirectMisroutedNamespace(t *testing.T) {
+	setShardMap(t, "self", map[string]string{
+		"self":  "http://" + serverAddress,
+		"other": "http://127.0.0.1:1",
+	}, map[string]string{"shards_away": "other", "shards_here": "self"})
+
+	res, err := noRedirectClient.Get(fmt.Sprintf("http://%s/stats_v1?%s=shards_away", serverAddress, constants.NamespaceQueryParameterName))
+	if err != nil {
+		t.Fatalf("cannot query Locktopus server: %s", err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusTemporaryRedirect {
+		t.Fatalf("misrouted request should be redirected, got %d", res.StatusCode)
+	}
+
+	if location := res.Header.Get("Location"); location != "http://127.0.0.1:1/stats_v1?namespace=shards_away" {
+		t.Fatalf("unexpected redirect location: %s", location)
+	}
+
+	if version := res.Header.Get(constants.ShardMapVersionHeaderName); version != "1" {
+		t.Fatalf("redirect should carry shard map version 1, got %q", version)
+	}
+
+	res, err = noRedirectClient.Post(fmt.Sprintf("http://%s/v2/namespaces/shards_away/locks", serverAddress), "application/json", strings.NewReader(`{"resources": [{"type": "write", "path": ["a"]}], "leaseMs": 1000}`))
+	if err != nil {
+		t.Fatalf("cannot query Locktopus server: %s", err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusTemporaryRedirect {
+		t.Fatalf("misrouted lease should be redirected, got %d", res.StatusCode)
+	}
+
+	client, err := locktopusclient.MakeClient(locktopusclient.ConnectionOptions{
+		Url: fmt.Sprintf("ws://%s/v1?%s=shards_here", serverAddress, constants.NamespaceQueryParameterName),
+	})
+	if err != nil {
+		t.Fatalf("namespace located on this server should be served: %s", err)
+	}
+	client.Close()
+
+	res, err = http.Get(fmt.Sprintf("http://%s%s", serverAddress, constants.ShardMapPath))
+	if err != nil {
+		t.Fatalf("cannot query Locktopus server: %s", err)
+	}
+	defer res.Body.Close()
+
+	m := shardmap.Map{}
+	if err = json.NewDecoder(res.Body).Decode(&m); err != nil || m.Version != 1 || len(m.Nodes) != 2 {
+		t.Fatalf("unexpected shard map: %+v (%v)", m, err)
+	}
+}
+
+func TestShards_ClientRoutesByShardMap(t *testing.T) {
+	var redirects, fetches int64
+
+	var m *shardmap.Map
+
+	// the server the client is configured with does not own the namespace anymore
+	stale := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == constants.ShardMapPath {
+			atomic.AddInt64(&fetches, 1)
+
+			serialized, _ := json.Marshal(m)
+			w.Write(serialized)
+			return
+		}
+
+		atomic.AddInt64(&redirects, 1)
+
+		w.Header().Set(constants.ShardMapVersionHeaderName, "1")
+		http.Redirect(w, r, fmt.Sprintf("http://%s%s", serverAddress, r.URL.RequestURI()), http.StatusTemporaryRedirect)
+	}))
+	defer stale.Close()
+
+	m = setShardMap(t, "owner", map[string]string{
+		"stale": stale.URL,
+		"owner": "http://" + serverAddress,
+	}, map[string]string{"shards_routed": "owner"})
+
+	host, port, _ := strings.Cut(strings.TrimPrefix(stale.URL, "http://"), ":")
+	portNumber, _ := strconv.Atoi(port)
+
+	for i := 0; i < 3; i++ {
+		client, err := locktopusclient.MakeClient(locktopusclient.ConnectionOptions{
+			Host:      host,
+			Port:      portNumber,
+			Namespace: "shards_routed",
+		})
+		if err != nil {
+			t.Fatalf("cannot connect to Locktopus server: %s", err)
+		}
+
+		client.Close()
+	}
+
+	if n := atomic.LoadInt64(&redirects); n != 1 {
+		t.Fatalf("client should be redirected once and then connect to the owner directly, got %d redirects", n)
+	}
+
+	if n := atomic.LoadInt64(&fetches); n != 1 {
+		t.Fatalf("client should fetch the shard map once, got %d", n)
+	}
+}
+
+func TestShards_MoveNamespace(t *testing.T) {
+	address := startAdminTestServer(t)
+
+	pushed := make(chan *http.Request, 1)
+
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		pushed <- r
+		w.Write(body)
+	}))
+	defer target.Close()
+
+	setShardMap(t, "self", map[string]string{
+		"self":   "http://" + address,
+		"target": target.URL,
+	}, map[string]string{"shards_moved": "self"})
+
+	client, err := locktopusclient.MakeClient(locktopusclient.ConnectionOptions{
+		Url:   fmt.Sprintf("ws://%s/v1?%s=shards_moved", address, constants.NamespaceQueryParameterName),
+		Token: authToken,
+	})
+	if err != nil {
+		t.Fatalf("cannot connect to Locktopus server: %s", err)
+	}
+
+	client.AddLockResource(locktopusclient.LockTypeWrite, "a")
+	if err = client.Lock(); err != nil {
+		t.Fatalf("cannot lock: %s", err)
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, fmt.Sprintf("http://%s/admin/namespaces/shards_moved/move", address), strings.NewReader(`{"node": "target"}`))
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("cannot query Locktopus server: %s", err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusAccepted {
+		t.Fatalf("move should be accepted, got %d", res.StatusCode)
+	}
+
+	statsURL := fmt.Sprintf("http://%s/stats_v1?%s=shards_moved", address, constants.NamespaceQueryParameterName)
+
+	res, err = noRedirectClient.Get(statsURL)
+	if err != nil {
+		t.Fatalf("cannot query Locktopus server: %s", err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusServiceUnavailable || res.Header.Get("Retry-After") == "" {
+		t.Fatalf("namespace being moved should be refused with a retry hint, got %d", res.StatusCode)
+	}
+
+	if err = client.Release(); err != nil {
+		t.Fatalf("cannot release: %s", err)
+	}
+	client.Close()
+
+	select {
+	case r := <-pushed:
+		if r.Method != http.MethodPut || r.URL.Path != "/admin/shards" {
+			t.Fatalf("unexpected request to the target: %s %s", r.Method, r.URL.Path)
+		}
+
+		if r.URL.Query().Get("namespace") != "shards_moved" || r.URL.Query().Get("last-group-id") != "1" {
+			t.Fatalf("target should receive the namespace with its last group ID, got %s", r.URL.RawQuery)
+		}
+
+		if r.Header.Get("Authorization") != "Bearer "+adminToken {
+			t.Fatalf("shard map should be pushed with the caller's credentials")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("shard map has not been pushed to the target")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for {
+		res, err = noRedirectClient.Get(statsURL)
+		if err != nil {
+			t.Fatalf("cannot query Locktopus server: %s", err)
+		}
+		res.Body.Close()
+
+		if res.StatusCode == http.StatusTemporaryRedirect {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("moved namespace should be redirected to the target, got %d", res.StatusCode)
+		}
+
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if location := res.Header.Get("Location"); !strings.HasPrefix(location, target.URL) {
+		t.Fatalf("moved namespace should be redirected to the target, got %s", location)
+	}
+
+	if version := res.Header.Get(constants.ShardMapVersionHeaderName); version != "2" {
+		t.Fatalf("shard map version should be incremented, got %s", version)
+	}
+}
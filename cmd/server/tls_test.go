@@ -0,0 +1,156 @@
+package main_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	main "github.com/locktopus-project/locktopus/cmd/server"
+	"github.com/locktopus-project/locktopus/internal/constants"
+	internal "github.com/locktopus-project/locktopus/internal/utils"
+	locktopusclient "github.com/locktopus-project/locktopus/pkg/client/v1"
+)
+
+const tlsNamespaceName = "tls_namespace"
+const tlsClientName = "test-client"
+
+type testCertificate struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func makeTestCertificate(t *testing.T, template *x509.Certificate, parent *testCertificate) *testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("cannot generate key: %s", err)
+	}
+
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	parentCert, parentKey := template, key
+	if parent != nil {
+		parentCert, parentKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parentCert, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("cannot create certificate: %s", err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("cannot parse certificate: %s", err)
+	}
+
+	return &testCertificate{cert: cert, key: key, der: der}
+}
+
+func (c *testCertificate) writePEM(t *testing.T, dir, name string) (certFile, keyFile string) {
+	keyDer, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatalf("cannot marshal key: %s", err)
+	}
+
+	certFile = filepath.Join(dir, name+".crt")
+	keyFile = filepath.Join(dir, name+".key")
+
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+
+	return certFile, keyFile
+}
+
+func (c *testCertificate) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+func TestTLS_ClientCertificate(t *testing.T) {
+	dir := t.TempDir()
+
+	ca := makeTestCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+
+	server := makeTestCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: defaultHostname},
+		DNSNames:    []string{defaultHostname},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca)
+
+	client := makeTestCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: tlsClientName},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca)
+
+	caFile, _ := ca.writePEM(t, dir, "ca")
+	certFile, keyFile := server.writePEM(t, dir, "server")
+
+	tlsPort, err := internal.FindFreePort()
+	if err != nil {
+		t.Fatalf("cannot find free port: %s", err)
+	}
+
+	address := startTestServer(t, main.ServerParameters{
+		TLSPort:         tlsPort,
+		TLSCertFile:     certFile,
+		TLSKeyFile:      keyFile,
+		TLSClientCAFile: caFile,
+	})
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	url := fmt.Sprintf("wss://%s:%s/v1?%s=%s", defaultHostname, tlsPort, constants.NamespaceQueryParameterName, tlsNamespaceName)
+
+	_, err = locktopusclient.MakeClient(locktopusclient.ConnectionOptions{
+		Url:       url,
+		TLSConfig: &tls.Config{RootCAs: roots},
+	})
+	if err == nil {
+		t.Fatalf("client without certificate should not be able to connect")
+	}
+
+	lc, err := locktopusclient.MakeClient(locktopusclient.ConnectionOptions{
+		Url:       url,
+		TLSConfig: &tls.Config{RootCAs: roots, Certificates: []tls.Certificate{client.tlsCertificate()}},
+	})
+	if err != nil {
+		t.Fatalf("cannot connect to Locktopus server: %s", err)
+	}
+	defer lc.Close()
+
+	resp, err := http.Get(fmt.Sprintf("http://%s/stats_v1?%s=%s", address, constants.NamespaceQueryParameterName, tlsNamespaceName))
+	if err != nil {
+		t.Fatalf("cannot query Locktopus server: %s", err)
+	}
+	defer resp.Body.Close()
+
+	stats := struct{ Clients map[string]int64 }{}
+	if err = json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		t.Fatalf("cannot parse response body: %s", err)
+	}
+
+	if stats.Clients["CN="+tlsClientName] != 1 {
+		t.Fatalf("client identity should be counted in stats: %v", stats.Clients)
+	}
+}
@@ -0,0 +1,493 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/locktopus-project/locktopus/internal/handoff"
+	ns "github.com/locktopus-project/locktopus/internal/namespace"
+	"github.com/locktopus-project/locktopus/internal/wal"
+)
+
+// With an upgrade socket, a new binary started with the same parameters takes over the running server without closing its ports.
+// The running server freezes the lock records, passes its listeners, the records and the namespace state set with the admin API
+// to the new process, and closes its sessions once the new process is ready. Clients resume their locks in the new process
+// with their session keys, the same way as after restart with the write-ahead log.
+
+// upgradeDialTimeout limits connecting to the running server and receiving its state
+const upgradeDialTimeout = 10 * time.Second
+
+// upgradeReadyTimeout limits waiting for the new process to load the state. The handoff is cancelled afterwards
+const upgradeReadyTimeout = 30 * time.Second
+
+const upgradingReason = "server upgrading, reconnect now"
+
+var errUpgrading = fmt.Errorf("%w: server upgrading", errDraining)
+
+// journalFrozen is set while the lock records are handed over. New locks of durable namespaces are refused meanwhile,
+// and releases are buffered in frozenReleases: they are applied to the handed over state if made before it is taken,
+// and written when the handoff is cancelled
+var journalFrozen = false
+var journalMx = sync.RWMutex{}
+
+var frozenReleases []wal.Record
+var frozenReleasesMx = sync.Mutex{}
+
+// inheritedListeners are passed by the previous process, by listener name
+var inheritedListeners = make(map[string]*os.File)
+
+// activeListeners can be handed over to the next process, by listener name
+var activeListeners = make(map[string]net.Listener)
+
+// detachedListeners have been closed for the handoff, by listener name
+var detachedListeners = make(map[string]*handoffAttempt)
+
+var listenersMx = sync.Mutex{}
+
+// upgradeCompleted is closed once the server has been handed over to the new process
+var upgradeCompleted = make(chan struct{})
+
+type handoffAttempt struct {
+	done      chan struct{}
+	completed bool
+}
+
+// memoryJournal keeps the lock records of the namespaces not written to the write-ahead log for the handoff
+type memoryJournal struct {
+	mx    sync.Mutex
+	table *wal.Table
+}
+
+func (j *memoryJournal) Append(r wal.Record) error {
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+
+	j.mx.Lock()
+	defer j.mx.Unlock()
+
+	j.table.Apply(r)
+
+	return nil
+}
+
+func (j *memoryJournal) state() wal.State {
+	j.mx.Lock()
+	defer j.mx.Unlock()
+
+	return j.table.State()
+}
+
+// seed applies the state received from the previous process
+func (j *memoryJournal) seed(state wal.State) {
+	j.mx.Lock()
+	defer j.mx.Unlock()
+
+	j.table.Load(state)
+}
+
+// EnableUpgrades makes the namespaces not written to the write-ahead log keep their lock records in memory, so they can be handed over
+func EnableUpgrades(resumeGrace time.Duration) {
+	upgradeJournal = &memoryJournal{table: wal.NewTable()}
+
+	if journalLog == nil {
+		walResumeGrace = resumeGrace
+	}
+}
+
+// upgradeState is passed to the new process. The shard map is not included, since the new process reads it from the map file
+type upgradeState struct {
+	// the locks of the namespaces not written to the write-ahead log, and the group ID sequences of all namespaces
+	wal.State
+
+	// the policies defined with the admin API
+	Policies []ns.Policy `json:"policies,omitempty"`
+
+	Freezes []ns.FrozenNamespace `json:"freezes,omitempty"`
+}
+
+// PendingUpgrade is the state received from the running server. The new process serves once Ready returns
+type PendingUpgrade struct {
+	conn  *handoff.Conn
+	state upgradeState
+}
+
+// ReceiveUpgrade takes over the listeners and the state of the server running with the same upgrade socket.
+// It returns nil if there is no such server
+func ReceiveUpgrade(path string) (*PendingUpgrade, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, nil
+	}
+
+	c, err := handoff.Dial(path, upgradeDialTimeout)
+	if err != nil {
+		// a stale socket is removed when listening
+		return nil, nil
+	}
+
+	files, serialized, err := c.Receive(upgradeDialTimeout)
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("cannot receive state of the running server: %w", err)
+	}
+
+	u := &PendingUpgrade{conn: c}
+
+	if err = json.Unmarshal(serialized, &u.state); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("cannot parse state of the running server: %w", err)
+	}
+
+	inheritListeners(files)
+
+	mainLogger.Infof("Received %d listeners and %d locks from the running server", len(files), len(u.state.Groups))
+
+	return u, nil
+}
+
+// Restore defines the received policies and makes the received locks and freezes.
+// The locks of durable namespaces have been restored from the write-ahead log already, if it is enabled
+func (u *PendingUpgrade) Restore() {
+	for _, p := range u.state.Policies {
+		if err := ns.DefinePolicy(p); err != nil {
+			mainLogger.Errorf("Cannot define namespace policy %s: %s", p.Name, err)
+		}
+	}
+
+	if upgradeJournal != nil {
+		upgradeJournal.seed(u.state.State)
+	}
+
+	restoreState(u.state.State, true, u.state.Freezes)
+}
+
+// Ready waits for the running server to stop serving. An error means the running server keeps serving
+func (u *PendingUpgrade) Ready() error {
+	defer u.conn.Close()
+
+	return u.conn.Ready(upgradeReadyTimeout)
+}
+
+func inheritListeners(files []handoff.File) {
+	listenersMx.Lock()
+	defer listenersMx.Unlock()
+
+	for _, f := range files {
+		inheritedListeners[f.Name] = f.File
+	}
+}
+
+// closeInheritedListeners closes the listeners passed by the previous process but not configured anymore
+func closeInheritedListeners(configured []string) {
+	listenersMx.Lock()
+	defer listenersMx.Unlock()
+
+	for name, f := range inheritedListeners {
+		if contains(configured, name) {
+			continue
+		}
+
+		mainLogger.Infof("Closing listener %s not configured anymore", name)
+		f.Close()
+		delete(inheritedListeners, name)
+	}
+}
+
+// listen returns the listener passed by the previous process or creates one. The listener is registered for the next handoff
+func listen(name string, create func() (net.Listener, error)) (net.Listener, error) {
+	listenersMx.Lock()
+	f, ok := inheritedListeners[name]
+	delete(inheritedListeners, name)
+	listenersMx.Unlock()
+
+	var ln net.Listener
+	var err error
+
+	if ok {
+		ln, err = net.FileListener(f)
+		f.Close()
+	} else {
+		ln, err = create()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	listenersMx.Lock()
+	activeListeners[name] = ln
+	listenersMx.Unlock()
+
+	return ln, nil
+}
+
+// keepServing calls serve until it fails outside a handoff, or the listener is handed over.
+// After a cancelled handoff, serve is called again with the listener taken back.
+func keepServing(name string, serve func() error) error {
+	for {
+		err := serve()
+
+		listenersMx.Lock()
+		a, ok := detachedListeners[name]
+		delete(detachedListeners, name)
+		listenersMx.Unlock()
+
+		if !ok {
+			return err
+		}
+
+		<-a.done
+
+		if a.completed {
+			return nil
+		}
+	}
+}
+
+// detachListeners duplicates the listeners and closes them, so connections wait in the backlog until the new process accepts them
+func detachListeners(a *handoffAttempt) ([]handoff.File, error) {
+	listenersMx.Lock()
+	defer listenersMx.Unlock()
+
+	files := make([]handoff.File, 0, len(activeListeners))
+
+	for name, ln := range activeListeners {
+		filer, ok := ln.(interface{ File() (*os.File, error) })
+
+		var f *os.File
+		err := fmt.Errorf("listener %s cannot be handed over", name)
+
+		if ok {
+			f, err = filer.File()
+		}
+
+		if err != nil {
+			for _, f := range files {
+				f.File.Close()
+			}
+
+			return nil, err
+		}
+
+		files = append(files, handoff.File{Name: name, File: f})
+	}
+
+	for name, ln := range activeListeners {
+		if ul, ok := ln.(*net.UnixListener); ok {
+			// the socket file is used by the new process
+			ul.SetUnlinkOnClose(false)
+		}
+
+		detachedListeners[name] = a
+		ln.Close()
+	}
+
+	activeListeners = make(map[string]net.Listener)
+
+	return files, nil
+}
+
+func freezeJournal() {
+	journalMx.Lock()
+	defer journalMx.Unlock()
+
+	journalFrozen = true
+}
+
+// thawJournal opens the write-ahead log closed for a cancelled handoff again and writes the releases made meanwhile
+func thawJournal() {
+	journalMx.Lock()
+	defer journalMx.Unlock()
+
+	reopenWAL()
+	writeFrozenReleases()
+	journalFrozen = false
+}
+
+func bufferFrozenRelease(r wal.Record) {
+	frozenReleasesMx.Lock()
+	defer frozenReleasesMx.Unlock()
+
+	frozenReleases = append(frozenReleases, r)
+}
+
+// writeFrozenReleases writes the buffered releases. It must be called with journalMx locked
+func writeFrozenReleases() {
+	frozenReleasesMx.Lock()
+	list := frozenReleases
+	frozenReleases = nil
+	frozenReleasesMx.Unlock()
+
+	for _, r := range list {
+		if err := journalLog.Append(r); err != nil {
+			mainLogger.Errorf("Cannot write lock record [namespace = %s, id = %d, type = %s]: %s", r.Namespace, r.ID, r.Type, err)
+		}
+	}
+}
+
+// handoffState returns the locks kept in memory, the group ID sequences of all namespaces and the namespace state set with the admin API.
+// The releases made since the journal has been frozen are written to the log first, so the new process does not restore the released locks
+func handoffState() upgradeState {
+	journalMx.RLock()
+	writeFrozenReleases()
+	journalMx.RUnlock()
+
+	state := upgradeState{
+		State:    wal.State{LastIDs: make(map[string]int64)},
+		Policies: ns.DefinedPolicies(),
+		Freezes:  ns.GetFreezes(),
+	}
+
+	if upgradeJournal != nil {
+		state.State = upgradeJournal.state()
+	}
+
+	if walLog != nil {
+		for name, id := range walLog.State().LastIDs {
+			if id > state.LastIDs[name] {
+				state.LastIDs[name] = id
+			}
+		}
+	}
+
+	for _, stats := range ns.GetStatistics() {
+		if stats.Stats.LastGroupID > state.LastIDs[stats.Name] {
+			state.LastIDs[stats.Name] = stats.Stats.LastGroupID
+		}
+	}
+
+	return state
+}
+
+// StartUpgradeListening accepts upgrade requests of new processes on the Unix domain socket
+func StartUpgradeListening(path string) <-chan error {
+	name := "upgrade://" + path
+	ch := make(chan error, 1)
+
+	go func() {
+		err := keepServing(name, func() error {
+			ln, err := listen(name, func() (net.Listener, error) {
+				return listenUnix(path, 0600)
+			})
+			if err != nil {
+				return err
+			}
+
+			for {
+				c, err := handoff.Accept(ln.(*net.UnixListener))
+				if errors.Is(err, net.ErrClosed) {
+					return err
+				}
+
+				if err != nil {
+					mainLogger.Errorf("Invalid upgrade request: %s", err)
+					continue
+				}
+
+				if err = handOver(c); err != nil {
+					mainLogger.Errorf("Upgrade has been cancelled, keep serving: %s", err)
+				}
+			}
+		})
+
+		if err != nil {
+			ch <- fmt.Errorf("upgrade listener error: %w", err)
+		}
+	}()
+
+	mainLogger.Infof("Waiting for upgrades on unix://%s", path)
+
+	return ch
+}
+
+// handOver passes the listeners and the lock records to the new process. If the new process does not get ready, the server keeps serving
+func handOver(c *handoff.Conn) (err error) {
+	defer c.Close()
+
+	mainLogger.Info("Handing over to a new process...")
+
+	freezeJournal()
+
+	state := handoffState()
+
+	serialized, err := json.Marshal(state)
+	if err != nil {
+		thawJournal()
+		return err
+	}
+
+	if walLog != nil {
+		// the new process opens the log
+		walLog.Close()
+	}
+
+	a := &handoffAttempt{done: make(chan struct{})}
+
+	files, err := detachListeners(a)
+	if err != nil {
+		thawJournal()
+		close(a.done)
+
+		return err
+	}
+
+	if err = c.Send(files, serialized); err == nil {
+		if err = c.WaitReady(upgradeReadyTimeout); err == nil {
+			err = c.Go()
+		}
+	}
+
+	if err != nil {
+		inheritListeners(files)
+		thawJournal()
+		close(a.done)
+
+		return err
+	}
+
+	for _, f := range files {
+		f.File.Close()
+	}
+
+	a.completed = true
+	close(a.done)
+	close(upgradeCompleted)
+
+	mainLogger.Infof("Handed over %d listeners and %d locks to the new process", len(files), len(state.Groups))
+
+	return nil
+}
+
+// reopenWAL opens the write-ahead log closed for a cancelled handoff. The locks are kept in memory, so they are not restored again
+func reopenWAL() {
+	if walLog == nil {
+		return
+	}
+
+	log, _, err := wal.Open(walLog.Dir())
+	if err != nil {
+		mainLogger.Errorf("Cannot open write-ahead log again: %s", err)
+		return
+	}
+
+	walLog = log
+	journalLog = log
+
+	log.StartSnapshots(walSnapshotInterval, func(err error) {
+		mainLogger.Errorf("Cannot write WAL snapshot: %s", err)
+	})
+}
+
+// releaseHandedOver closes the sessions and releases the locks, which are kept by the new process.
+// Webhooks are not notified, since the locks are not released for their clients
+func releaseHandedOver() {
+	untrackLocks()
+	disconnectAll(errUpgrading)
+	revokeLeases()
+	dropRestoredSessions()
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	ns "github.com/locktopus-project/locktopus/internal/namespace"
+	"github.com/locktopus-project/locktopus/internal/wal"
+	ml "github.com/locktopus-project/locktopus/pkg/multilocker"
+)
+
+// The lock records are handed over from process to process, so a lock carried through one upgrade is kept as is by the next one
+func TestUpgrade_MemoryJournalKeepsGroupsAcrossHandoffs(t *testing.T) {
+	enqueuedAt := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	acquiredAt := enqueuedAt.Add(time.Second)
+
+	first := &memoryJournal{table: wal.NewTable()}
+	first.Append(wal.Record{Type: wal.RecordEnqueued, Namespace: "deploy", ID: 7, Time: enqueuedAt, Resources: []wal.Resource{{T: "write", Path: []string{"prod"}}}, Owner: "deployer-1", Labels: map[string]string{"job": "deploy"}, Session: "key", LeaseMs: 60000})
+	first.Append(wal.Record{Type: wal.RecordAcquired, Namespace: "deploy", ID: 7, Time: acquiredAt})
+
+	second := &memoryJournal{table: wal.NewTable()}
+	second.seed(first.state())
+	second.Append(wal.Record{Type: wal.RecordEnqueued, Namespace: "deploy", ID: 8, Time: acquiredAt, Resources: []wal.Resource{{T: "write", Path: []string{"prod"}}}, Owner: "deployer-2"})
+
+	third := &memoryJournal{table: wal.NewTable()}
+	third.seed(second.state())
+
+	expected := []wal.Group{
+		{Namespace: "deploy", ID: 7, Resources: []wal.Resource{{T: "write", Path: []string{"prod"}}}, Owner: "deployer-1", Labels: map[string]string{"job": "deploy"}, Session: "key", LeaseMs: 60000, EnqueuedAt: enqueuedAt, AcquiredAt: &acquiredAt},
+		{Namespace: "deploy", ID: 8, Resources: []wal.Resource{{T: "write", Path: []string{"prod"}}}, Owner: "deployer-2", EnqueuedAt: acquiredAt},
+	}
+
+	state := third.state()
+
+	if !reflect.DeepEqual(state.Groups, expected) {
+		t.Fatalf("groups after two handoffs are %+v, expected %+v", state.Groups, expected)
+	}
+
+	if state.LastIDs["deploy"] != 8 {
+		t.Fatalf("last group ID should be kept, got %v", state.LastIDs)
+	}
+}
+
+// Releases made while the log is frozen are written before the state is handed over, or once the handoff is cancelled
+func TestUpgrade_FrozenReleasesAreKept(t *testing.T) {
+	j := &memoryJournal{table: wal.NewTable()}
+
+	journalLog = j
+	t.Cleanup(func() {
+		journalLog = nil
+		journalFrozen = false
+	})
+
+	journal := &lockJournal{namespace: "deploy"}
+
+	for _, id := range []int64{1, 2} {
+		if err := journal.enqueued(id, []resource{{T: "write", Path: []string{"prod"}}}, ml.Metadata{}, "key", 0); err != nil {
+			t.Fatalf("cannot write lock record: %s", err)
+		}
+	}
+
+	freezeJournal()
+
+	journal.released(1)
+
+	// the new process reads the log
+	handoffState()
+
+	if state := j.state(); len(state.Groups) != 1 || state.Groups[0].ID != 2 {
+		t.Fatalf("lock released before the state is taken should not be handed over: %+v", state.Groups)
+	}
+
+	journal.released(2)
+
+	thawJournal()
+
+	if state := j.state(); len(state.Groups) != 0 {
+		t.Fatalf("lock released during a cancelled handoff should be released in the journal: %+v", state.Groups)
+	}
+}
+
+// Locks of namespaces that are not durable are handed over along with the policies and the freezes set with the admin API
+func TestUpgrade_HandsOverNamespaceState(t *testing.T) {
+	upgradeJournal = &memoryJournal{table: wal.NewTable()}
+	walResumeGrace = time.Minute
+	t.Cleanup(func() {
+		upgradeJournal = nil
+		dropRestoredSessions()
+	})
+
+	journal := journalFor("upgrade_handed_over")
+	if journal == nil || !journal.inMemory {
+		t.Fatalf("locks should be kept in memory for the handoff: %+v", journal)
+	}
+
+	if err := journal.enqueued(5, []resource{{T: "write", Path: []string{"a"}}}, ml.Metadata{}, "key", 0); err != nil {
+		t.Fatalf("cannot write lock record: %s", err)
+	}
+
+	if err := ns.DefinePolicy(ns.Policy{Name: "upgrade_handed_over", MaxGroupSize: 3}); err != nil {
+		t.Fatalf("cannot define policy: %s", err)
+	}
+
+	if _, err := ns.Freeze("upgrade_handed_over", ns.FreezeModeReject); err != nil {
+		t.Fatalf("cannot freeze: %s", err)
+	}
+	defer ns.Thaw("upgrade_handed_over")
+
+	state := handoffState()
+
+	if len(state.Groups) != 1 || state.Groups[0].ID != 5 || state.Groups[0].Session != "key" {
+		t.Fatalf("lock should be handed over: %+v", state.Groups)
+	}
+
+	policies := map[string]ns.Policy{}
+	for _, p := range state.Policies {
+		policies[p.Name] = p
+	}
+
+	if policies["upgrade_handed_over"].MaxGroupSize != 3 {
+		t.Fatalf("policy should be handed over: %+v", state.Policies)
+	}
+
+	freezes := map[string]ns.FrozenNamespace{}
+	for _, f := range state.Freezes {
+		freezes[f.Name] = f
+	}
+
+	if f, ok := freezes["upgrade_handed_over"]; !ok || f.Mode != ns.FreezeModeReject || f.After != ns.LastGroupID("upgrade_handed_over")-1 {
+		t.Fatalf("freeze should be handed over: %+v", state.Freezes)
+	}
+}
+
+// The barrier of a frozen namespace is made again between the locks made before and after the freeze
+func TestUpgrade_RestoresNamespaceState(t *testing.T) {
+	// the group ID sequence of the namespace is kept, so it is unique for repeated runs
+	namespace := fmt.Sprintf("upgrade_restored_%d", time.Now().UnixNano())
+
+	upgradeJournal = &memoryJournal{table: wal.NewTable()}
+	walResumeGrace = time.Minute
+	t.Cleanup(func() {
+		upgradeJournal = nil
+		dropRestoredSessions()
+	})
+
+	resources := []wal.Resource{{T: "write", Path: []string{"a"}}}
+
+	u := &PendingUpgrade{state: upgradeState{
+		State: wal.State{
+			Groups: []wal.Group{
+				{Namespace: namespace, ID: 3, Resources: resources, Session: "before"},
+				{Namespace: namespace, ID: 5, Resources: resources, Session: "after"},
+			},
+			LastIDs: map[string]int64{namespace: 5},
+		},
+		Policies: []ns.Policy{{Name: namespace, MaxGroupSize: 3}},
+		Freezes:  []ns.FrozenNamespace{{Name: namespace, Mode: ns.FreezeModeQueue, After: 3}},
+	}}
+
+	u.Restore()
+
+	if ns.GetPolicy(namespace).MaxGroupSize != 3 {
+		t.Fatalf("policy should be defined: %+v", ns.GetPolicy(namespace))
+	}
+
+	if status, _, _ := ns.GetFreezeStatus(namespace); !status.Frozen {
+		t.Fatalf("namespace should be frozen")
+	}
+
+	before := takeRestoredSession(namespace, "before")
+
+	// the session is left waiting, so its acquisition is written by the server rather than by a connection resuming it
+	restoredSessionsMx.Lock()
+	after := restoredSessions["after"]
+	restoredSessionsMx.Unlock()
+
+	if before == nil || after == nil || !after.journal.inMemory {
+		t.Fatalf("locks should be restored with the in-memory journal")
+	}
+
+	before.lock.Acquire().Unlock()
+
+	select {
+	case <-after.lock.Ready():
+		t.Fatalf("lock made after the freeze should wait for thaw")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := ns.Thaw(namespace); err != nil {
+		t.Fatalf("cannot thaw: %s", err)
+	}
+
+	select {
+	case <-after.lock.Ready():
+	case <-time.After(time.Second):
+		t.Fatalf("lock should be acquired after thaw")
+	}
+
+	// the acquisition is written in the background
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if groups := upgradeJournal.state().Groups; len(groups) == 2 && groups[1].AcquiredAt != nil {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("acquisition should be written to the in-memory journal: %+v", upgradeJournal.state().Groups)
+		}
+	}
+}
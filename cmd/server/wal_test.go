@@ -0,0 +1,238 @@
+package main_test
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	main "github.com/locktopus-project/locktopus/cmd/server"
+	ns "github.com/locktopus-project/locktopus/internal/namespace"
+	"github.com/locktopus-project/locktopus/internal/wal"
+	locktopusclient "github.com/locktopus-project/locktopus/pkg/client/v1"
+)
+
+func TestWAL_ResumeRestoredLocks(t *testing.T) {
+	const namespace = "wal_namespace"
+
+	dir := t.TempDir()
+
+	log, _, err := wal.Open(dir)
+	if err != nil {
+		t.Fatalf("cannot open WAL: %s", err)
+	}
+
+	resources := []wal.Resource{{T: "write", Path: []string{"a"}}}
+
+	for _, r := range []wal.Record{
+		{Type: wal.RecordEnqueued, Namespace: namespace, ID: 5, Resources: resources, Session: "key1", LeaseMs: 1000},
+		{Type: wal.RecordEnqueued, Namespace: namespace, ID: 7, Resources: resources, Session: "key2", LeaseMs: 1000},
+		{Type: wal.RecordAcquired, Namespace: namespace, ID: 5},
+	} {
+		if err = log.Append(r); err != nil {
+			t.Fatalf("cannot append WAL record: %s", err)
+		}
+	}
+
+	log.Close()
+
+	if err = ns.DefinePolicy(ns.Policy{Name: namespace, AutoCreate: true, Durable: true}); err != nil {
+		t.Fatalf("cannot define policy: %s", err)
+	}
+
+	if err = main.OpenWAL(dir, 5*time.Second); err != nil {
+		t.Fatalf("cannot restore WAL: %s", err)
+	}
+	defer main.CloseWAL()
+
+	host, portStr, _ := strings.Cut(serverAddress, ":")
+	port, _ := strconv.Atoi(portStr)
+
+	resume := func(key string) (*locktopusclient.LocktopusClient, error) {
+		return locktopusclient.MakeClient(locktopusclient.ConnectionOptions{
+			Host:          host,
+			Port:          port,
+			Namespace:     namespace,
+			ResumeSession: key,
+		})
+	}
+
+	if _, err = resume("unknown"); err == nil {
+		t.Fatalf("unknown session should not be resumed")
+	}
+
+	second, err := resume("key2")
+	if err != nil {
+		t.Fatalf("cannot resume session: %s", err)
+	}
+	defer second.Close()
+
+	if second.LockID() != "7" || second.IsAcquired() || second.Session() != "key2" {
+		t.Fatalf("second lock should be restored as enqueued with ID 7, got %s", second.LockID())
+	}
+
+	first, err := resume("key1")
+	if err != nil {
+		t.Fatalf("cannot resume session: %s", err)
+	}
+	defer first.Close()
+
+	if first.LockID() != "5" || !first.IsAcquired() {
+		t.Fatalf("first lock should be restored as acquired with ID 5, got %s", first.LockID())
+	}
+
+	if err = first.Release(); err != nil {
+		t.Fatalf("cannot release: %s", err)
+	}
+
+	if err = second.Acquire(); err != nil || !second.IsAcquired() {
+		t.Fatalf("second lock should be acquired after the first is released: %v", err)
+	}
+
+	if err = second.Release(); err != nil {
+		t.Fatalf("cannot release: %s", err)
+	}
+
+	second.AddLockResource(locktopusclient.LockTypeWrite, "a")
+
+	if err = second.Lock(); err != nil {
+		t.Fatalf("cannot lock: %s", err)
+	}
+
+	if id, _ := strconv.Atoi(second.LockID()); id <= 7 {
+		t.Fatalf("new lock ID should continue the restored sequence, got %s", second.LockID())
+	}
+
+	if second.Session() == "" {
+		t.Fatalf("lock in a durable namespace should have a session key")
+	}
+}
+
+// A restored lock that is not resumed leaves the queue without waiting to be acquired
+func TestWAL_UnresumedEnqueuedLockStopsBlocking(t *testing.T) {
+	const namespace = "wal_unresumed"
+
+	dir := t.TempDir()
+
+	log, _, err := wal.Open(dir)
+	if err != nil {
+		t.Fatalf("cannot open WAL: %s", err)
+	}
+
+	for _, r := range []wal.Record{
+		{Type: wal.RecordEnqueued, Namespace: namespace, ID: 5, Resources: []wal.Resource{{T: "write", Path: []string{"a"}}}, Session: "key1", LeaseMs: 1000},
+		{Type: wal.RecordEnqueued, Namespace: namespace, ID: 7, Resources: []wal.Resource{{T: "write", Path: []string{"a"}}, {T: "write", Path: []string{"b"}}}, Session: "key2", LeaseMs: 1000},
+		{Type: wal.RecordAcquired, Namespace: namespace, ID: 5},
+	} {
+		if err = log.Append(r); err != nil {
+			t.Fatalf("cannot append WAL record: %s", err)
+		}
+	}
+
+	log.Close()
+
+	if err = ns.DefinePolicy(ns.Policy{Name: namespace, AutoCreate: true, Durable: true}); err != nil {
+		t.Fatalf("cannot define policy: %s", err)
+	}
+
+	if err = main.OpenWAL(dir, 100*time.Millisecond); err != nil {
+		t.Fatalf("cannot restore WAL: %s", err)
+	}
+	defer main.CloseWAL()
+
+	host, portStr, _ := strings.Cut(serverAddress, ":")
+	port, _ := strconv.Atoi(portStr)
+
+	first, err := locktopusclient.MakeClient(locktopusclient.ConnectionOptions{Host: host, Port: port, Namespace: namespace, ResumeSession: "key1"})
+	if err != nil {
+		t.Fatalf("cannot resume session: %s", err)
+	}
+	defer first.Close()
+
+	// the second lock is never resumed, while the first one is kept
+	other, err := locktopusclient.MakeClient(locktopusclient.ConnectionOptions{Host: host, Port: port, Namespace: namespace})
+	if err != nil {
+		t.Fatalf("cannot connect to Locktopus server: %s", err)
+	}
+	defer other.Close()
+
+	other.AddLockResource(locktopusclient.LockTypeWrite, "b")
+
+	if err = other.Lock(); err != nil {
+		t.Fatalf("cannot lock: %s", err)
+	}
+
+	acquired := make(chan error, 1)
+
+	go func() {
+		acquired <- other.Acquire()
+	}()
+
+	select {
+	case err = <-acquired:
+		if err != nil {
+			t.Fatalf("cannot acquire: %s", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("lock should be acquired once the unresumed lock is released, although the lock before it is still held")
+	}
+}
+
+// An idle namespace keeps its group ID sequence in the snapshot, so fencing tokens are not reused after a restart
+func TestWAL_SnapshotKeepsSequenceOfIdleNamespace(t *testing.T) {
+	const namespace = "wal_idle"
+
+	dir := t.TempDir()
+
+	log, _, err := wal.Open(dir)
+	if err != nil {
+		t.Fatalf("cannot open WAL: %s", err)
+	}
+
+	for _, r := range []wal.Record{
+		{Type: wal.RecordEnqueued, Namespace: namespace, ID: 5, Resources: []wal.Resource{{T: "write", Path: []string{"a"}}}, Session: "key1", LeaseMs: 1000},
+		{Type: wal.RecordAcquired, Namespace: namespace, ID: 5},
+		{Type: wal.RecordReleased, Namespace: namespace, ID: 5},
+	} {
+		if err = log.Append(r); err != nil {
+			t.Fatalf("cannot append WAL record: %s", err)
+		}
+	}
+
+	log.Close()
+
+	// opening compacts the log into a snapshot, and the next opening reads the snapshot only
+	if log, _, err = wal.Open(dir); err != nil {
+		t.Fatalf("cannot open WAL: %s", err)
+	}
+
+	log.Close()
+
+	if err = ns.DefinePolicy(ns.Policy{Name: namespace, AutoCreate: true, Durable: true}); err != nil {
+		t.Fatalf("cannot define policy: %s", err)
+	}
+
+	if err = main.OpenWAL(dir, time.Second); err != nil {
+		t.Fatalf("cannot restore WAL: %s", err)
+	}
+	defer main.CloseWAL()
+
+	host, portStr, _ := strings.Cut(serverAddress, ":")
+	port, _ := strconv.Atoi(portStr)
+
+	client, err := locktopusclient.MakeClient(locktopusclient.ConnectionOptions{Host: host, Port: port, Namespace: namespace})
+	if err != nil {
+		t.Fatalf("cannot connect to Locktopus server: %s", err)
+	}
+	defer client.Close()
+
+	client.AddLockResource(locktopusclient.LockTypeWrite, "a")
+
+	if err = client.Lock(); err != nil {
+		t.Fatalf("cannot lock: %s", err)
+	}
+
+	if id, _ := strconv.Atoi(client.LockID()); id <= 5 {
+		t.Fatalf("new lock ID should continue the sequence kept in the snapshot, got %s", client.LockID())
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/locktopus-project/locktopus/internal/webhook"
+)
+
+// Lock events are delivered to the webhooks of the namespace policy (see internal/webhook and lockevents.go)
+
+// webhooks is nil if webhooks are disabled
+var webhooks *webhook.Dispatcher
+var webhooksMx = sync.RWMutex{}
+
+// StartWebhooks enables delivering lock events. The previous dispatcher, if any, is closed
+func StartWebhooks(options webhook.Options) {
+	options.OnFailure = func(url string, e webhook.Event, err error) {
+		mainLogger.Warnf("Cannot deliver webhook [url = %s, event = %s, namespace = %s, group = %d]: %s", url, e.Event, e.Namespace, e.GroupID, err)
+	}
+
+	webhooksMx.Lock()
+	prev := webhooks
+	webhooks = webhook.NewDispatcher(options)
+	webhooksMx.Unlock()
+
+	if prev != nil {
+		prev.Close()
+	}
+}
+
+func StopWebhooks() {
+	webhooksMx.Lock()
+	prev := webhooks
+	webhooks = nil
+	webhooksMx.Unlock()
+
+	if prev != nil {
+		prev.Close()
+	}
+}
+
+func deliver(h webhook.Hook, e webhook.Event) {
+	webhooksMx.RLock()
+	defer webhooksMx.RUnlock()
+
+	if webhooks == nil {
+		return
+	}
+
+	if err := webhooks.Send(h, e); err != nil {
+		mainLogger.Warnf("Dropped webhook [url = %s, event = %s, namespace = %s, group = %d]: %s", h.URL, e.Event, e.Namespace, e.GroupID, err)
+	}
+}
+
+func (s *Server) webhookStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+
+	webhooksMx.RLock()
+	d := webhooks
+	webhooksMx.RUnlock()
+
+	if d == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Webhooks are disabled"))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, d.Stats())
+}
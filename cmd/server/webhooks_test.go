@@ -0,0 +1,242 @@
+package main_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	main "github.com/locktopus-project/locktopus/cmd/server"
+	"github.com/locktopus-project/locktopus/internal/constants"
+	ns "github.com/locktopus-project/locktopus/internal/namespace"
+	"github.com/locktopus-project/locktopus/internal/webhook"
+	locktopusclient "github.com/locktopus-project/locktopus/pkg/client/v1"
+)
+
+const webhookSecret = "webhook-secret"
+
+type receivedEvent struct {
+	event    webhook.Event
+	header   http.Header
+	verified bool
+}
+
+// startWebhookReceiver enables webhooks and returns the URL of a receiver collecting the deliveries.
+// The first failures requests are answered with 500
+func startWebhookReceiver(t *testing.T, failures int64) (string, <-chan receivedEvent) {
+	ch := make(chan receivedEvent, 100)
+	var requests int64
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&requests, 1) <= failures {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+
+		e := receivedEvent{header: r.Header}
+		e.verified = r.Header.Get(webhook.SignatureHeaderName) == webhook.Sign(webhookSecret, r.Header.Get(webhook.TimestampHeaderName), body)
+
+		if err := json.Unmarshal(body, &e.event); err != nil {
+			t.Errorf("cannot parse webhook body: %s", err)
+		}
+
+		ch <- e
+	}))
+	t.Cleanup(receiver.Close)
+
+	options := webhook.DefaultOptions
+	options.Backoff = 10 * time.Millisecond
+
+	main.StartWebhooks(options)
+	t.Cleanup(main.StopWebhooks)
+
+	return receiver.URL, ch
+}
+
+func defineWebhookPolicy(t *testing.T, namespace string, h webhook.Hook) {
+	if err := ns.DefinePolicy(ns.Policy{Name: namespace, AutoCreate: true, Webhooks: []webhook.Hook{h}}); err != nil {
+		t.Fatalf("cannot define policy: %s", err)
+	}
+}
+
+// expectWebhooks returns the events by type. Events are not guaranteed to be delivered in order
+func expectWebhooks(t *testing.T, ch <-chan receivedEvent, events ...string) map[string]receivedEvent {
+	received := make(map[string]receivedEvent)
+
+	for len(received) < len(events) {
+		select {
+		case e := <-ch:
+			if !contains(events, e.event.Event) {
+				t.Fatalf("unexpected event: %+v", e.event)
+			}
+
+			if !e.verified {
+				t.Fatalf("%s event has an invalid signature", e.event.Event)
+			}
+
+			received[e.event.Event] = e
+		case <-time.After(5 * time.Second):
+			t.Fatalf("events have not been delivered, got %d of %v", len(received), events)
+		}
+	}
+
+	return received
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+func connectWebhookClient(t *testing.T, address, namespace string) *locktopusclient.LocktopusClient {
+	client, err := locktopusclient.MakeClient(locktopusclient.ConnectionOptions{
+		Url:   fmt.Sprintf("ws://%s/v1?%s=%s", address, constants.NamespaceQueryParameterName, namespace),
+		Token: authToken,
+	})
+	if err != nil {
+		t.Fatalf("cannot connect to Locktopus server: %s", err)
+	}
+
+	t.Cleanup(func() { client.Close() })
+
+	return client
+}
+
+func TestWebhooks_DeliverLockEvents(t *testing.T) {
+	namespace := "webhooks_events"
+	url, ch := startWebhookReceiver(t, 0)
+
+	defineWebhookPolicy(t, namespace, webhook.Hook{URL: url, PathPrefix: []string{"deploy", "prod"}, Secret: webhookSecret})
+
+	// not under the prefix
+	client := connectWebhookClient(t, serverAddress, namespace)
+
+	client.AddLockResource(locktopusclient.LockTypeWrite, "deploy", "staging")
+	if err := client.Lock(); err != nil {
+		t.Fatalf("cannot lock: %s", err)
+	}
+
+	if err := client.Release(); err != nil {
+		t.Fatalf("cannot release: %s", err)
+	}
+
+	client = connectWebhookClient(t, serverAddress, namespace)
+
+	client.AddLockResource(locktopusclient.LockTypeWrite, "deploy", "prod", "api")
+	if err := client.Lock(); err != nil {
+		t.Fatalf("cannot lock: %s", err)
+	}
+
+	id := client.LockID()
+
+	time.Sleep(50 * time.Millisecond)
+
+	if err := client.Release(); err != nil {
+		t.Fatalf("cannot release: %s", err)
+	}
+
+	received := expectWebhooks(t, ch, webhook.EventEnqueued, webhook.EventAcquired, webhook.EventReleased)
+
+	enqueued := received[webhook.EventEnqueued]
+
+	if fmt.Sprintf("%d", enqueued.event.GroupID) != id || enqueued.event.Namespace != namespace {
+		t.Fatalf("unexpected lock in the event: %+v", enqueued.event)
+	}
+
+	if len(enqueued.event.Resources) != 1 || len(enqueued.event.Resources[0].Path) != 3 {
+		t.Fatalf("event should carry the resources of the lock: %+v", enqueued.event.Resources)
+	}
+
+	if enqueued.header.Get(webhook.EventHeaderName) != webhook.EventEnqueued || enqueued.header.Get(webhook.DeliveryHeaderName) != enqueued.event.ID {
+		t.Fatalf("unexpected webhook headers: %v", enqueued.header)
+	}
+
+	released := received[webhook.EventReleased]
+	if released.event.HeldMs == nil || *released.event.HeldMs < 50 {
+		t.Fatalf("released event should carry the time the lock has been held: %+v", released.event)
+	}
+
+	select {
+	case e := <-ch:
+		t.Fatalf("unexpected event: %+v", e.event)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestWebhooks_RetryAndHeld(t *testing.T) {
+	namespace := "webhooks_held"
+	url, ch := startWebhookReceiver(t, 2)
+
+	defineWebhookPolicy(t, namespace, webhook.Hook{URL: url, Events: []string{webhook.EventHeld, webhook.EventRevoked}, HeldAfterMs: 100, Secret: webhookSecret})
+
+	address := startAdminTestServer(t)
+	client := connectWebhookClient(t, address, namespace)
+
+	client.AddLockResource(locktopusclient.LockTypeWrite, "deploy", "prod")
+	if err := client.Lock(); err != nil {
+		t.Fatalf("cannot lock: %s", err)
+	}
+
+	// the first attempts fail
+	held := expectWebhooks(t, ch, webhook.EventHeld)[webhook.EventHeld]
+	if held.event.HeldMs == nil || *held.event.HeldMs < 100 {
+		t.Fatalf("held event should be delivered after heldAfterMs: %+v", held.event)
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, fmt.Sprintf("http://%s/admin/namespaces/%s/groups/%s/release", address, namespace, client.LockID()), nil)
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("cannot query Locktopus server: %s", err)
+	}
+	res.Body.Close()
+
+	expectWebhooks(t, ch, webhook.EventRevoked)
+
+	// the delivery is counted once the response has been read
+	deadline := time.Now().Add(5 * time.Second)
+
+	for {
+		req, _ = http.NewRequest(http.MethodGet, fmt.Sprintf("http://%s/admin/webhooks", address), nil)
+		req.Header.Set("Authorization", "Bearer "+adminToken)
+
+		res, err = http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("cannot query Locktopus server: %s", err)
+		}
+
+		stats := webhook.Stats{}
+		err = json.NewDecoder(res.Body).Decode(&stats)
+		res.Body.Close()
+
+		if err != nil {
+			t.Fatalf("cannot parse webhook stats: %s", err)
+		}
+
+		if stats.Failed != 0 || stats.Dropped != 0 || stats.Delivered > 2 {
+			t.Fatalf("unexpected webhook stats: %+v", stats)
+		}
+
+		if stats.Delivered == 2 {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("deliveries have not been counted: %+v", stats)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type WebSocketOptions struct {
+	HandshakeTimeout time.Duration // 0 means no timeout
+	Compression      bool          // negotiate per-message deflate with clients supporting it
+}
+
+func makeUpgrader(options WebSocketOptions, allowedOrigins []string) websocket.Upgrader {
+	return websocket.Upgrader{
+		HandshakeTimeout:  options.HandshakeTimeout,
+		EnableCompression: options.Compression,
+		CheckOrigin: func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+
+			// only browsers send the header
+			return origin == "" || originAllowed(allowedOrigins, origin)
+		},
+	}
+}
+
+func originAllowed(allowed []string, origin string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+
+	for _, a := range allowed {
+		if a == "*" || strings.EqualFold(a, origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// readMessage reads the next message. Messages exceeding maxSize (if not 0) are rejected before being read completely
+func readMessage(conn *websocket.Conn, maxSize int64) ([]byte, error) {
+	_, r, err := conn.NextReader()
+	if err != nil {
+		return nil, err
+	}
+
+	if maxSize == 0 {
+		return io.ReadAll(r)
+	}
+
+	payload, err := io.ReadAll(io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(payload)) > maxSize {
+		return nil, fmt.Errorf("%w: message exceeds %d bytes", errRequestTooLarge, maxSize)
+	}
+
+	return payload, nil
+}
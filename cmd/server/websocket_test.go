@@ -0,0 +1,152 @@
+package main_test
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+
+	main "github.com/locktopus-project/locktopus/cmd/server"
+	"github.com/locktopus-project/locktopus/internal/constants"
+)
+
+const requestTooLargeCode = 3008
+
+func v1URL(address, namespace string) string {
+	return fmt.Sprintf("ws://%s/v1?%s=%s", address, constants.NamespaceQueryParameterName, namespace)
+}
+
+func TestWebSocket_AllowedOrigins(t *testing.T) {
+	address := startTestServer(t, main.ServerParameters{AllowedOrigins: []string{"https://app.example.com"}})
+
+	for origin, status := range map[string]int{"": http.StatusSwitchingProtocols, "https://app.example.com": http.StatusSwitchingProtocols, "https://evil.example.com": http.StatusForbidden} {
+		header := http.Header{}
+		if origin != "" {
+			header.Set("Origin", origin)
+		}
+
+		conn, res, err := websocket.DefaultDialer.Dial(v1URL(address, "ws_origins"), header)
+		if err == nil {
+			conn.Close()
+		}
+
+		if res == nil || res.StatusCode != status {
+			t.Fatalf("expected status %d for origin '%s', got %v: %v", status, origin, res, err)
+		}
+	}
+}
+
+func TestWebSocket_RequestTooLarge(t *testing.T) {
+	address := startTestServer(t, main.ServerParameters{RequestLimits: main.RequestLimits{MaxMessageSize: 256, MaxResources: 2, MaxPathDepth: 3, MaxSegmentLength: 8}})
+
+	requests := map[string]interface{}{
+		"message size": map[string]interface{}{
+			"action":    "lock",
+			"resources": []map[string]interface{}{{"type": "write", "path": []string{strings.Repeat("a", 300)}}},
+		},
+		"resources": map[string]interface{}{
+			"action": "lock",
+			"resources": []map[string]interface{}{
+				{"type": "write", "path": []string{"a"}},
+				{"type": "write", "path": []string{"b"}},
+				{"type": "write", "path": []string{"c"}},
+			},
+		},
+		// the limits are checked before decoding, so the invalid action is not reported
+		"resources of an invalid request": map[string]interface{}{
+			"action": 5,
+			"resources": []map[string]interface{}{
+				{"type": "write", "path": []string{"a"}},
+				{"type": "write", "path": []string{"b"}},
+				{"type": "write", "path": []string{"c"}},
+			},
+		},
+		"path depth": map[string]interface{}{
+			"action":    "lock",
+			"resources": []map[string]interface{}{{"type": "write", "path": []string{"a", "b", "c", "d"}}},
+		},
+		"segment length": map[string]interface{}{
+			"action":    "lock",
+			"resources": []map[string]interface{}{{"type": "write", "path": []string{"abcdefghi"}}},
+		},
+	}
+
+	for name, request := range requests {
+		conn, _, err := websocket.DefaultDialer.Dial(v1URL(address, "ws_too_large"), nil)
+		if err != nil {
+			t.Fatalf("cannot connect to Locktopus server: %s", err)
+		}
+
+		if err = conn.WriteJSON(request); err != nil {
+			t.Fatalf("cannot write request: %s", err)
+		}
+
+		for err == nil {
+			_, _, err = conn.ReadMessage()
+		}
+
+		conn.Close()
+
+		if !websocket.IsCloseError(err, requestTooLargeCode) {
+			t.Fatalf("%s: connection should be closed with code %d, got: %s", name, requestTooLargeCode, err)
+		}
+	}
+}
+
+func TestWebSocket_Compression(t *testing.T) {
+	address := startTestServer(t, main.ServerParameters{WebSocket: main.WebSocketOptions{Compression: true}})
+
+	dialer := websocket.Dialer{EnableCompression: true}
+
+	conn, res, err := dialer.Dial(v1URL(address, "ws_compression"), nil)
+	if err != nil {
+		t.Fatalf("cannot connect to Locktopus server: %s", err)
+	}
+	defer conn.Close()
+
+	if !strings.Contains(res.Header.Get("Sec-WebSocket-Extensions"), "permessage-deflate") {
+		t.Fatalf("compression should be negotiated: %v", res.Header)
+	}
+
+	writeLock(t, conn, "a")
+
+	if _, message, err := conn.ReadMessage(); err != nil || !bytes.Contains(message, []byte(`"acquired"`)) {
+		t.Fatalf("lock should be acquired: %s %v", message, err)
+	}
+}
+
+func TestV2_BodyTooLarge(t *testing.T) {
+	address := startTestServer(t, main.ServerParameters{RequestLimits: main.RequestLimits{MaxMessageSize: 64}})
+
+	body := fmt.Sprintf(`{"resources": [{"type": "write", "path": ["%s"]}], "leaseMs": 1000}`, strings.Repeat("a", 64))
+
+	res, err := http.Post(fmt.Sprintf("http://%s/v2/namespaces/ws_too_large/locks", address), "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("cannot query Locktopus server: %s", err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected status 413, got %d", res.StatusCode)
+	}
+}
+
+func TestV2_ResourcesCheckedBeforeDecoding(t *testing.T) {
+	address := startTestServer(t, main.ServerParameters{RequestLimits: main.RequestLimits{MaxResources: 1}})
+
+	// an invalid lease is not reported, since the resources are checked first
+	body := `{"resources": [{"type": "write", "path": ["a"]}, {"type": "write", "path": ["b"]}], "leaseMs": "invalid"}`
+
+	res, err := http.Post(fmt.Sprintf("http://%s/v2/namespaces/ws_too_large/locks", address), "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("cannot query Locktopus server: %s", err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected status 413, got %d", res.StatusCode)
+	}
+}
@@ -0,0 +1,347 @@
+// Package audit writes lock events to an append-only file as JSON lines.
+//
+// The file is rotated when it exceeds the size limit or gets older than the rotation interval. Rotated files are named
+// after the time of rotation, e.g. audit-20240101T100000.000.log, with a sequence suffix if several files are rotated
+// within a millisecond (audit-20240101T100000.000-1.log), and removed according to the retention settings.
+// Records are queued without blocking and written by a single goroutine, so a slow disk never delays locking:
+// records exceeding the queue are dropped and counted.
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	EventLock    = "lock"
+	EventAcquire = "acquire"
+	EventRelease = "release"
+	EventAbandon = "abandon" // released by the server after the client has disconnected or the lease has expired
+	EventRevoke  = "revoke"  // released with the admin API
+)
+
+const rotatedTimeFormat = "20060102T150405.000"
+
+type Resource struct {
+	T    string   `json:"type"`
+	Path []string `json:"path"`
+}
+
+// Record is a line of the audit log
+type Record struct {
+	Time         time.Time         `json:"time"`
+	Event        string            `json:"event"`
+	Namespace    string            `json:"namespace"`
+	GroupID      int64             `json:"groupId"`
+	ConnectionID *int64            `json:"connectionId,omitempty"` // nil for leases of HTTP API v2 and restored locks
+	RemoteAddr   string            `json:"remoteAddr,omitempty"`
+	Client       string            `json:"client,omitempty"` // client identity, if the client is authenticated
+	Resources    []Resource        `json:"resources"`
+	Owner        string            `json:"owner,omitempty"`  // given by the client with the lock
+	Labels       map[string]string `json:"labels,omitempty"` // given by the client with the lock
+	EnqueuedAt   *time.Time        `json:"enqueuedAt,omitempty"`
+	AcquiredAt   *time.Time        `json:"acquiredAt,omitempty"`
+}
+
+type Options struct {
+	Path           string
+	MaxSize        int64         // bytes. 0 means no size limit
+	RotateInterval time.Duration // 0 means no time-based rotation
+	MaxFiles       int           // number of rotated files to keep. 0 means no limit
+	Retention      time.Duration // age of rotated files to keep. 0 means no limit
+	QueueSize      int
+	OnError        func(err error)
+}
+
+// Log is the audit log. Use Open to create one
+type Log struct {
+	options Options
+	queue   chan Record
+	done    chan struct{}
+
+	mx     sync.RWMutex
+	closed bool
+
+	file     *os.File
+	w        *bufio.Writer
+	size     int64
+	openedAt time.Time
+
+	written int64
+	dropped int64
+}
+
+// Stats are counted since the log has been opened
+type Stats struct {
+	Written int64 `json:"written"`
+	Dropped int64 `json:"dropped"`
+	Queued  int   `json:"queued"`
+}
+
+// Open opens the file for appending and starts writing
+func Open(options Options) (*Log, error) {
+	l := &Log{
+		options: options,
+		queue:   make(chan Record, options.QueueSize),
+		done:    make(chan struct{}),
+	}
+
+	if err := os.MkdirAll(filepath.Dir(options.Path), 0755); err != nil {
+		return nil, err
+	}
+
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+
+	go l.run()
+
+	return l, nil
+}
+
+// Write queues the record without blocking. It returns false if the record has been dropped
+func (l *Log) Write(r Record) bool {
+	l.mx.RLock()
+	defer l.mx.RUnlock()
+
+	if l.closed {
+		return false
+	}
+
+	select {
+	case l.queue <- r:
+		return true
+	default:
+		atomic.AddInt64(&l.dropped, 1)
+		return false
+	}
+}
+
+// Close writes the queued records and closes the file
+func (l *Log) Close() error {
+	l.mx.Lock()
+	if l.closed {
+		l.mx.Unlock()
+		return nil
+	}
+
+	l.closed = true
+	close(l.queue)
+	l.mx.Unlock()
+
+	<-l.done
+
+	return l.closeFile()
+}
+
+func (l *Log) Stats() Stats {
+	return Stats{
+		Written: atomic.LoadInt64(&l.written),
+		Dropped: atomic.LoadInt64(&l.dropped),
+		Queued:  len(l.queue),
+	}
+}
+
+func (l *Log) run() {
+	defer close(l.done)
+
+	for r := range l.queue {
+		if err := l.write(r); err != nil {
+			l.fail(err)
+		}
+
+		if len(l.queue) > 0 {
+			continue
+		}
+
+		if err := l.w.Flush(); err != nil {
+			l.fail(err)
+		}
+	}
+}
+
+func (l *Log) write(r Record) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	line = append(line, '\n')
+
+	if l.shouldRotate(int64(len(line))) {
+		if err = l.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := l.w.Write(line)
+	l.size += int64(n)
+
+	if err == nil {
+		atomic.AddInt64(&l.written, 1)
+	}
+
+	return err
+}
+
+func (l *Log) shouldRotate(next int64) bool {
+	if l.size == 0 {
+		return false
+	}
+
+	if l.options.MaxSize > 0 && l.size+next > l.options.MaxSize {
+		return true
+	}
+
+	return l.options.RotateInterval > 0 && time.Since(l.openedAt) >= l.options.RotateInterval
+}
+
+func (l *Log) open() error {
+	f, err := os.OpenFile(l.options.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	l.file = f
+	l.w = bufio.NewWriter(f)
+	l.size = info.Size()
+	l.openedAt = time.Now()
+
+	return nil
+}
+
+func (l *Log) closeFile() error {
+	if err := l.w.Flush(); err != nil {
+		l.file.Close()
+		return err
+	}
+
+	if err := l.file.Sync(); err != nil {
+		l.file.Close()
+		return err
+	}
+
+	return l.file.Close()
+}
+
+// rotate renames the current file after the time of rotation, opens a new one and removes the files beyond retention
+func (l *Log) rotate() error {
+	rotated := l.rotatedPath()
+
+	err := l.closeFile()
+	if err == nil {
+		err = os.Rename(l.options.Path, rotated)
+	}
+
+	if err != nil {
+		// keep writing to the current file
+		if openErr := l.open(); openErr != nil {
+			return openErr
+		}
+
+		return err
+	}
+
+	if err := l.open(); err != nil {
+		return err
+	}
+
+	return l.removeExpired()
+}
+
+// rotatedPath returns the name for the file rotated now. A rotated file is never overwritten, since renaming replaces the target
+func (l *Log) rotatedPath() string {
+	ext := filepath.Ext(l.options.Path)
+	base := fmt.Sprintf("%s-%s", strings.TrimSuffix(l.options.Path, ext), time.Now().UTC().Format(rotatedTimeFormat))
+
+	rotated := base + ext
+	for seq := 1; ; seq++ {
+		if _, err := os.Lstat(rotated); os.IsNotExist(err) {
+			return rotated
+		}
+
+		rotated = fmt.Sprintf("%s-%d%s", base, seq, ext)
+	}
+}
+
+func (l *Log) removeExpired() error {
+	files, err := RotatedFiles(l.options.Path)
+	if err != nil {
+		return err
+	}
+
+	for i, f := range files {
+		expired := l.options.MaxFiles > 0 && i < len(files)-l.options.MaxFiles
+
+		if l.options.Retention > 0 {
+			if info, err := os.Stat(f); err == nil && time.Since(info.ModTime()) > l.options.Retention {
+				expired = true
+			}
+		}
+
+		if expired {
+			if err = os.Remove(f); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (l *Log) fail(err error) {
+	if l.options.OnError != nil {
+		l.options.OnError(err)
+	}
+}
+
+// RotatedFiles returns the rotated files of the log at path, the oldest first
+func RotatedFiles(path string) ([]string, error) {
+	ext := filepath.Ext(path)
+
+	prefix := strings.TrimSuffix(path, ext) + "-"
+
+	files, err := filepath.Glob(prefix + "*" + ext)
+	if err != nil {
+		return nil, err
+	}
+
+	// files rotated within the same millisecond are ordered by their sequence suffix
+	sort.Slice(files, func(i, j int) bool {
+		iTime, iSeq := rotatedOrder(files[i], prefix, ext)
+		jTime, jSeq := rotatedOrder(files[j], prefix, ext)
+
+		if iTime != jTime {
+			return iTime < jTime
+		}
+
+		return iSeq < jSeq
+	})
+
+	return files, nil
+}
+
+// rotatedOrder splits the name of the rotated file into the time of rotation and the sequence number (0 if there is none)
+func rotatedOrder(file, prefix, ext string) (string, int) {
+	name := strings.TrimSuffix(strings.TrimPrefix(file, prefix), ext)
+
+	rotatedAt, suffix, _ := strings.Cut(name, "-")
+	seq, _ := strconv.Atoi(suffix)
+
+	return rotatedAt, seq
+}
@@ -0,0 +1,337 @@
+// Package auth implements bearer token authentication with per-namespace and per-path access rules.
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	ml "github.com/locktopus-project/locktopus/pkg/multilocker"
+)
+
+var ErrUnauthenticated = errors.New("invalid or missing token")
+var ErrForbidden = errors.New("access denied")
+
+// Config is the content of the tokens file, e.g.
+//
+//	{
+//	  "tokens": [
+//	    {
+//	      "name": "billing",
+//	      "token": "secret",
+//	      "namespaces": [
+//	        {"pattern": "billing-*", "paths": [{"prefix": ["invoices"], "types": ["read", "write"]}]},
+//	        {"pattern": "shared"}
+//	      ]
+//	    }
+//	  ]
+//	}
+type Config struct {
+	Tokens []TokenConfig `json:"tokens"`
+}
+
+type TokenConfig struct {
+	Name       string           `json:"name"`
+	Token      string           `json:"token"`
+	Admin      bool             `json:"admin,omitempty"` // grants access to the admin API
+	Namespaces []NamespaceGrant `json:"namespaces"`
+}
+
+type NamespaceGrant struct {
+	Pattern string      `json:"pattern"`         // namespace name or pattern (see path.Match)
+	Paths   []PathGrant `json:"paths,omitempty"` // if empty, any resource can be locked
+}
+
+type PathGrant struct {
+	Prefix []string `json:"prefix"`          // resources within this subtree can be locked
+	Types  []string `json:"types,omitempty"` // allowed lock types (read/write). If empty, any
+}
+
+// Token is an authenticated client
+type Token struct {
+	Name   string
+	Admin  bool
+	grants []NamespaceGrant
+	types  [][][]ml.LockType
+}
+
+// Authenticator holds tokens loaded from a file or applied directly. All methods are thread-safe.
+type Authenticator struct {
+	mx      sync.RWMutex
+	file    string
+	modTime time.Time
+	tokens  map[[sha256.Size]byte]*Token
+}
+
+func NewAuthenticator(config Config) (*Authenticator, error) {
+	a := &Authenticator{}
+
+	if err := a.apply(config); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// LoadAuthenticator reads the tokens file. Use Watch to reload it on changes.
+func LoadAuthenticator(file string) (*Authenticator, error) {
+	a := &Authenticator{file: file}
+
+	if _, err := a.reload(); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// Watch checks the tokens file for changes every interval and reloads it. On error, the previous tokens are kept.
+func (a *Authenticator) Watch(interval time.Duration, onReload func(err error)) {
+	go func() {
+		for {
+			time.Sleep(interval)
+
+			if reloaded, err := a.reload(); reloaded || err != nil {
+				onReload(err)
+			}
+		}
+	}()
+}
+
+// Authenticate returns the token granted by the raw token string
+func (a *Authenticator) Authenticate(token string) (*Token, error) {
+	if token == "" {
+		return nil, ErrUnauthenticated
+	}
+
+	a.mx.RLock()
+	defer a.mx.RUnlock()
+
+	t, ok := a.tokens[sha256.Sum256([]byte(token))]
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+
+	return t, nil
+}
+
+// AuthorizeNamespace checks that the token grants access to the namespace
+func (t *Token) AuthorizeNamespace(namespace string) error {
+	if t.namespaceGrant(namespace) < 0 {
+		return fmt.Errorf("%w: token '%s' has no access to namespace '%s'", ErrForbidden, t.Name, namespace)
+	}
+
+	return nil
+}
+
+// AuthorizeAdmin checks that the token grants access to the admin API
+func (t *Token) AuthorizeAdmin() error {
+	if !t.Admin {
+		return fmt.Errorf("%w: token '%s' is not an admin token", ErrForbidden, t.Name)
+	}
+
+	return nil
+}
+
+// AuthorizeLocks checks that the token grants locking all the resources in the namespace
+func (t *Token) AuthorizeLocks(namespace string, resourceLocks []ml.ResourceLock) error {
+	i := t.namespaceGrant(namespace)
+	if i < 0 {
+		return fmt.Errorf("%w: token '%s' has no access to namespace '%s'", ErrForbidden, t.Name, namespace)
+	}
+
+	grant := t.grants[i]
+	if len(grant.Paths) == 0 {
+		return nil
+	}
+
+	for _, rl := range resourceLocks {
+		if !t.pathGranted(i, rl) {
+			return fmt.Errorf("%w: token '%s' cannot %s-lock path %v in namespace '%s'", ErrForbidden, t.Name, rl.LockType, rl.Path, namespace)
+		}
+	}
+
+	return nil
+}
+
+func (t *Token) namespaceGrant(namespace string) int {
+	for i, g := range t.grants {
+		if ok, _ := path.Match(g.Pattern, namespace); ok {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func (t *Token) pathGranted(grantIndex int, rl ml.ResourceLock) bool {
+	for j, pg := range t.grants[grantIndex].Paths {
+		if !hasPrefix(rl.Path, pg.Prefix) {
+			continue
+		}
+
+		types := t.types[grantIndex][j]
+		if len(types) == 0 {
+			return true
+		}
+
+		for _, lt := range types {
+			if lt == rl.LockType {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func hasPrefix(p, prefix []string) bool {
+	if len(prefix) > len(p) {
+		return false
+	}
+
+	for i := range prefix {
+		if p[i] != prefix[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Load replaces the tokens with the ones of the file, which is watched from now on. On error, the previous tokens are kept
+func (a *Authenticator) Load(file string) error {
+	a.mx.Lock()
+	previous := a.file
+	a.file = file
+	a.modTime = time.Time{}
+	a.mx.Unlock()
+
+	if _, err := a.reload(); err != nil {
+		a.mx.Lock()
+		a.file = previous
+		a.mx.Unlock()
+
+		return err
+	}
+
+	return nil
+}
+
+// Apply replaces the tokens. The tokens file (if any) is not watched anymore
+func (a *Authenticator) Apply(config Config) error {
+	if err := a.apply(config); err != nil {
+		return err
+	}
+
+	a.mx.Lock()
+	a.file = ""
+	a.mx.Unlock()
+
+	return nil
+}
+
+func (a *Authenticator) reload() (bool, error) {
+	a.mx.RLock()
+	file := a.file
+	modTime := a.modTime
+	a.mx.RUnlock()
+
+	if file == "" {
+		return false, nil
+	}
+
+	fi, err := os.Stat(file)
+	if err != nil {
+		return false, fmt.Errorf("cannot read tokens file: %w", err)
+	}
+
+	if fi.ModTime().Equal(modTime) {
+		return false, nil
+	}
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return false, fmt.Errorf("cannot read tokens file: %w", err)
+	}
+
+	config := Config{}
+	if err = json.Unmarshal(content, &config); err != nil {
+		return false, fmt.Errorf("cannot parse tokens file %s: %w", file, err)
+	}
+
+	if err = a.apply(config); err != nil {
+		return false, fmt.Errorf("invalid tokens file %s: %w", file, err)
+	}
+
+	a.mx.Lock()
+	a.modTime = fi.ModTime()
+	a.mx.Unlock()
+
+	return true, nil
+}
+
+func (a *Authenticator) apply(config Config) error {
+	tokens := make(map[[sha256.Size]byte]*Token, len(config.Tokens))
+
+	for i, tc := range config.Tokens {
+		if tc.Token == "" {
+			return fmt.Errorf("token #%d (%s) is empty", i, tc.Name)
+		}
+
+		t := &Token{
+			Name:   tc.Name,
+			Admin:  tc.Admin,
+			grants: tc.Namespaces,
+			types:  make([][][]ml.LockType, len(tc.Namespaces)),
+		}
+
+		for j, ng := range tc.Namespaces {
+			if _, err := path.Match(ng.Pattern, ""); err != nil {
+				return fmt.Errorf("token '%s': invalid namespace pattern '%s': %w", tc.Name, ng.Pattern, err)
+			}
+
+			t.types[j] = make([][]ml.LockType, len(ng.Paths))
+
+			for k, pg := range ng.Paths {
+				for _, s := range pg.Types {
+					lt, err := parseLockType(s)
+					if err != nil {
+						return fmt.Errorf("token '%s': %w", tc.Name, err)
+					}
+
+					t.types[j][k] = append(t.types[j][k], lt)
+				}
+			}
+		}
+
+		key := sha256.Sum256([]byte(tc.Token))
+		if _, ok := tokens[key]; ok {
+			return fmt.Errorf("token '%s' is duplicated", tc.Name)
+		}
+
+		tokens[key] = t
+	}
+
+	a.mx.Lock()
+	a.tokens = tokens
+	a.mx.Unlock()
+
+	return nil
+}
+
+func parseLockType(s string) (ml.LockType, error) {
+	switch strings.ToLower(s) {
+	case "r", "read":
+		return ml.LockTypeRead, nil
+	case "w", "write":
+		return ml.LockTypeWrite, nil
+	}
+
+	return ml.LockTypeRead, fmt.Errorf("invalid lock type: %s", s)
+}
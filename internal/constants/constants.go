@@ -4,7 +4,16 @@ const EnvPrefix = "LOCKTOPUS_"
 
 const NamespaceQueryParameterName = "namespace"
 const AbandonTimeoutQueryParameterName = "abandon-timeout-ms"
+const PendingAbandonTimeoutQueryParameterName = "pending-abandon-timeout-ms"
+const AccessTokenQueryParameterName = "access-token"
+const ResumeQueryParameterName = "resume"
+const ByLabelQueryParameterName = "by-label"
+const NotifyContentionQueryParameterName = "notify-contention"
 
 const DefaultServerPort = "9009"
 const DefaultServerHost = "0.0.0.0"
 const DefaultAbandonTimeoutMs = 60 * 1000
+
+// ShardMapPath serves the shard map of sharded servers. Redirects of misrouted requests carry the map version in ShardMapVersionHeaderName
+const ShardMapPath = "/shards"
+const ShardMapVersionHeaderName = "Locktopus-Shard-Map-Version"
@@ -0,0 +1,252 @@
+// Package handoff passes listening sockets and state from a running process to its replacement over a Unix domain socket.
+//
+// The new process connects to the socket of the old one and sends a request. The old process replies with its listeners
+// (file descriptors passed with SCM_RIGHTS) and the state. The new process loads the state and sends "ready",
+// then waits for "go" before serving, so the old process can cancel the handoff and keep serving if the new one fails to start.
+package handoff
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+const (
+	msgRequest = "upgrade"
+	msgReady   = "ready"
+	msgGo      = "go"
+)
+
+// maxFiles limits the number of descriptors received at once
+const maxFiles = 64
+
+// File is a listener passed to the new process. The name identifies the listener, e.g. tcp://0.0.0.0:9009
+type File struct {
+	Name string
+	File *os.File
+}
+
+// Conn is the connection between the old and the new process
+type Conn struct {
+	conn *net.UnixConn
+	r    *bufio.Reader
+}
+
+type header struct {
+	Names       []string `json:"names"`
+	StateLength int      `json:"stateLength"`
+}
+
+// Dial connects to the socket of the running process. It is used by the new process
+func Dial(path string, timeout time.Duration) (*Conn, error) {
+	c, err := net.DialTimeout("unix", path, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Conn{conn: c.(*net.UnixConn)}, nil
+}
+
+// Accept waits for the upgrade request on the socket of the running process
+func Accept(ln *net.UnixListener) (*Conn, error) {
+	c, err := ln.AcceptUnix()
+	if err != nil {
+		return nil, err
+	}
+
+	conn := &Conn{conn: c, r: bufio.NewReader(c)}
+
+	if err = conn.expect(msgRequest, 0); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// Receive requests the listeners and the state of the running process. It is used by the new process
+func (c *Conn) Receive(timeout time.Duration) ([]File, []byte, error) {
+	c.conn.SetDeadline(time.Now().Add(timeout))
+	defer c.conn.SetDeadline(time.Time{})
+
+	if err := c.send(msgRequest); err != nil {
+		return nil, nil, err
+	}
+
+	buf := make([]byte, 64*1024)
+	oob := make([]byte, syscall.CmsgSpace(maxFiles*4))
+
+	n, oobn, _, _, err := c.conn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot read listeners: %w", err)
+	}
+
+	fds, err := parseRights(oob[:oobn])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the rest of the message is read from the stream
+	c.r = bufio.NewReader(io.MultiReader(bytes.NewReader(buf[:n]), c.conn))
+
+	h := header{}
+	if err = c.readFrame(&h); err != nil {
+		closeFds(fds)
+		return nil, nil, err
+	}
+
+	if len(h.Names) != len(fds) {
+		closeFds(fds)
+		return nil, nil, fmt.Errorf("received %d listeners, expected %d", len(fds), len(h.Names))
+	}
+
+	state := make([]byte, h.StateLength)
+	if _, err = io.ReadFull(c.r, state); err != nil {
+		closeFds(fds)
+		return nil, nil, fmt.Errorf("cannot read state: %w", err)
+	}
+
+	files := make([]File, len(fds))
+	for i, fd := range fds {
+		files[i] = File{Name: h.Names[i], File: os.NewFile(uintptr(fd), h.Names[i])}
+	}
+
+	return files, state, nil
+}
+
+// Send passes the listeners and the state to the new process. It is used by the old process
+func (c *Conn) Send(files []File, state []byte) error {
+	h := header{StateLength: len(state)}
+	fds := make([]int, len(files))
+
+	for i, f := range files {
+		h.Names = append(h.Names, f.Name)
+		fds[i] = int(f.File.Fd())
+	}
+
+	serialized, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+
+	frame := make([]byte, 4, 4+len(serialized))
+	binary.BigEndian.PutUint32(frame, uint32(len(serialized)))
+	frame = append(frame, serialized...)
+
+	n, _, err := c.conn.WriteMsgUnix(frame, syscall.UnixRights(fds...), nil)
+	if err != nil {
+		return fmt.Errorf("cannot send listeners: %w", err)
+	}
+
+	if _, err = c.conn.Write(frame[n:]); err != nil {
+		return err
+	}
+
+	_, err = c.conn.Write(state)
+
+	return err
+}
+
+// Ready tells the old process that the new one has loaded the state, and waits for it to stop serving.
+// An error means the handoff has been cancelled, and the old process keeps serving.
+func (c *Conn) Ready(timeout time.Duration) error {
+	if err := c.send(msgReady); err != nil {
+		return err
+	}
+
+	return c.expect(msgGo, timeout)
+}
+
+// WaitReady waits for the new process to load the state
+func (c *Conn) WaitReady(timeout time.Duration) error {
+	return c.expect(msgReady, timeout)
+}
+
+// Go lets the new process serve
+func (c *Conn) Go() error {
+	return c.send(msgGo)
+}
+
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Conn) send(msg string) error {
+	_, err := c.conn.Write([]byte(msg + "\n"))
+	return err
+}
+
+func (c *Conn) expect(msg string, timeout time.Duration) error {
+	if timeout > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(timeout))
+		defer c.conn.SetReadDeadline(time.Time{})
+	}
+
+	if c.r == nil {
+		c.r = bufio.NewReader(c.conn)
+	}
+
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+
+	if line != msg+"\n" {
+		return fmt.Errorf("unexpected handoff message %q, expected %q", line, msg)
+	}
+
+	return nil
+}
+
+func (c *Conn) readFrame(v interface{}) error {
+	size := make([]byte, 4)
+	if _, err := io.ReadFull(c.r, size); err != nil {
+		return err
+	}
+
+	content := make([]byte, binary.BigEndian.Uint32(size))
+	if _, err := io.ReadFull(c.r, content); err != nil {
+		return err
+	}
+
+	return json.Unmarshal(content, v)
+}
+
+func parseRights(oob []byte) ([]int, error) {
+	messages, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil, err
+	}
+
+	fds := []int{}
+
+	for _, m := range messages {
+		rights, err := syscall.ParseUnixRights(&m)
+		if err != nil {
+			closeFds(fds)
+			return nil, err
+		}
+
+		fds = append(fds, rights...)
+	}
+
+	if len(fds) == 0 && len(messages) > 0 {
+		return nil, errors.New("no descriptors received")
+	}
+
+	return fds, nil
+}
+
+func closeFds(fds []int) {
+	for _, fd := range fds {
+		syscall.Close(fd)
+	}
+}
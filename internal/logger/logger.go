@@ -2,13 +2,14 @@ package logger
 
 import (
 	"os"
+	"sync/atomic"
 
 	golog "github.com/withmandala/go-log"
 )
 
 type Logger struct {
-	enabled bool
-	l       *golog.Logger
+	disabled int32 // accessed atomically, so loggers can be switched while in use
+	l        *golog.Logger
 }
 
 func NewLogger() Logger {
@@ -17,17 +18,29 @@ func NewLogger() Logger {
 	l.WithDebug()
 
 	return Logger{
-		enabled: true,
-		l:       l,
+		l: l,
 	}
 }
 
 func (l *Logger) Disable() {
-	l.enabled = false
+	l.SetEnabled(false)
+}
+
+func (l *Logger) SetEnabled(enabled bool) {
+	disabled := int32(1)
+	if enabled {
+		disabled = 0
+	}
+
+	atomic.StoreInt32(&l.disabled, disabled)
+}
+
+func (l *Logger) enabled() bool {
+	return atomic.LoadInt32(&l.disabled) == 0
 }
 
 func (l *Logger) Info(args ...interface{}) {
-	if !l.enabled {
+	if !l.enabled() {
 		return
 	}
 
@@ -35,7 +48,7 @@ func (l *Logger) Info(args ...interface{}) {
 }
 
 func (l *Logger) Infof(format string, args ...interface{}) {
-	if !l.enabled {
+	if !l.enabled() {
 		return
 	}
 
@@ -43,7 +56,7 @@ func (l *Logger) Infof(format string, args ...interface{}) {
 }
 
 func (l *Logger) Warn(args ...interface{}) {
-	if !l.enabled {
+	if !l.enabled() {
 		return
 	}
 
@@ -51,7 +64,7 @@ func (l *Logger) Warn(args ...interface{}) {
 }
 
 func (l *Logger) Warnf(format string, args ...interface{}) {
-	if !l.enabled {
+	if !l.enabled() {
 		return
 	}
 
@@ -59,7 +72,7 @@ func (l *Logger) Warnf(format string, args ...interface{}) {
 }
 
 func (l *Logger) Error(args ...interface{}) {
-	if !l.enabled {
+	if !l.enabled() {
 		return
 	}
 
@@ -67,7 +80,7 @@ func (l *Logger) Error(args ...interface{}) {
 }
 
 func (l *Logger) Errorf(format string, args ...interface{}) {
-	if !l.enabled {
+	if !l.enabled() {
 		return
 	}
 
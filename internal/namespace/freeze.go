@@ -0,0 +1,139 @@
+package namespace
+
+import (
+	"errors"
+	"fmt"
+
+	ml "github.com/locktopus-project/locktopus/pkg/multilocker"
+)
+
+var ErrNamespaceFrozen = errors.New("namespace is frozen")
+
+type FreezeMode string
+
+const (
+	FreezeModeQueue  FreezeMode = "queue"  // new locks are enqueued behind the maintenance barrier
+	FreezeModeReject FreezeMode = "reject" // new locks are rejected with ErrNamespaceFrozen
+)
+
+// freeze is a maintenance barrier: a write lock on the root of the namespace.
+// It is acquired once all the locks made before the freeze are released, and it holds back all the locks made after.
+type freeze struct {
+	mode    FreezeMode
+	barrier *ml.Lock
+}
+
+type FreezeStatus struct {
+	Frozen  bool       `json:"frozen"`
+	Mode    FreezeMode `json:"mode,omitempty"`
+	Drained bool       `json:"drained"` // all the locks made before the freeze have been released
+}
+
+// Freeze puts the barrier into the namespace. The returned channel is closed when the namespace drains.
+// Freezing a frozen namespace changes its mode.
+func Freeze(name string, mode FreezeMode) (<-chan struct{}, error) {
+	if mode != FreezeModeQueue && mode != FreezeModeReject {
+		return nil, fmt.Errorf("invalid freeze mode: %s", mode)
+	}
+
+	mx.Lock()
+	defer mx.Unlock()
+
+	ns, ok := namespaces[name]
+	if !ok || ns.deleting {
+		return nil, fmt.Errorf("%w: %s", ErrNamespaceNotFound, name)
+	}
+
+	if ns.freeze != nil {
+		ns.freeze.mode = mode
+		return ns.freeze.barrier.Ready(), nil
+	}
+
+	ns.freeze = &freeze{
+		mode:    mode,
+		barrier: ns.m.Lock([]ml.ResourceLock{ml.NewResourceLock(ml.LockTypeWrite, []string{})}),
+	}
+
+	return ns.freeze.barrier.Ready(), nil
+}
+
+// Thaw removes the barrier, so the locks enqueued behind it can be acquired. The namespace stops being frozen at once,
+// but a barrier still waiting for the locks made before the freeze keeps its place in the queue: the locks made after the freeze
+// (and before the drain) are acquired only after the drain.
+func Thaw(name string) error {
+	mx.Lock()
+	defer mx.Unlock()
+
+	ns, ok := namespaces[name]
+	if !ok || ns.freeze == nil {
+		return fmt.Errorf("namespace %s is not frozen", name)
+	}
+
+	ns.thaw()
+
+	return nil
+}
+
+// thaw releases the barrier. It must be called with mx locked
+func (ns *namespace) thaw() {
+	if ns.freeze == nil {
+		return
+	}
+
+	barrier := ns.freeze.barrier
+	ns.freeze = nil
+
+	// the barrier may still wait for the locks made before the freeze. Cancelling would not help, since a cancelled lock
+	// keeps blocking the locks behind it until it is granted
+	go func() {
+		barrier.Acquire().Unlock()
+	}()
+}
+
+// FrozenNamespace describes the freeze of a namespace, so it can be made again in another process
+type FrozenNamespace struct {
+	Name  string     `json:"name"`
+	Mode  FreezeMode `json:"mode"`
+	After int64      `json:"after"` // ID of the last group made before the freeze
+}
+
+// GetFreezes returns the frozen namespaces
+func GetFreezes() []FrozenNamespace {
+	mx.Lock()
+	defer mx.Unlock()
+
+	list := []FrozenNamespace{}
+
+	for name, ns := range namespaces {
+		if ns.freeze != nil {
+			list = append(list, FrozenNamespace{Name: name, Mode: ns.freeze.mode, After: ns.freeze.barrier.ID() - 1})
+		}
+	}
+
+	return list
+}
+
+// GetFreezeStatus returns the freeze state of the namespace and a channel closed when the namespace drains (nil if not frozen)
+func GetFreezeStatus(name string) (FreezeStatus, <-chan struct{}, error) {
+	mx.Lock()
+	defer mx.Unlock()
+
+	ns, ok := namespaces[name]
+	if !ok {
+		return FreezeStatus{}, nil, fmt.Errorf("%w: %s", ErrNamespaceNotFound, name)
+	}
+
+	if ns.freeze == nil {
+		return FreezeStatus{}, nil, nil
+	}
+
+	status := FreezeStatus{Frozen: true, Mode: ns.freeze.mode}
+
+	select {
+	case <-ns.freeze.barrier.Ready():
+		status.Drained = true
+	default:
+	}
+
+	return status, ns.freeze.barrier.Ready(), nil
+}
@@ -1,46 +1,428 @@
 package namespace
 
 import (
+	"fmt"
 	"sync"
+	"time"
 
 	ml "github.com/locktopus-project/locktopus/pkg/multilocker"
 )
 
-var namespaces = make(map[string]*ml.MultiLocker)
+type namespace struct {
+	m        *ml.MultiLocker
+	policy   Policy
+	users    int64     // number of GetNamespace calls not paired with ReleaseNamespace yet
+	lastUsed time.Time // when the namespace was last seen busy
+	deleting bool      // the namespace is closed once it drains. It cannot be used meanwhile
+	freeze   *freeze   // nil if the namespace is not frozen
+}
+
+var namespaces = make(map[string]*namespace)
+
+// policies are matched in order, exact names first
+var policies []Policy
+
+// definedPolicies are the policies defined with DefinePolicy since the policies were replaced last time
+var definedPolicies []Policy
+
+// closing is set by CloseNamespaces. Namespaces cannot be used afterwards
+var closing = false
+
+// resetting is set by ResetNamespaces until the namespaces are closed
+var resetting = false
+
+// lastGroupIDs keeps the group ID sequences of closed namespaces, so group IDs never repeat when a namespace is created again
+var lastGroupIDs = make(map[string]int64)
+
+// closedHandler is called with the name of each namespace closed by GC, DeleteNamespace or ResetNamespaces
+var closedHandler func(name string)
 
 var mx = sync.Mutex{}
 
+// deletePollInterval is how often a namespace being deleted is checked for being drained
+const deletePollInterval = 100 * time.Millisecond
+
 type NamespaceEntry struct {
 	Name      string
 	Namespace *ml.MultiLocker
 }
 
 func GetNamespaces() []NamespaceEntry {
+	mx.Lock()
+	defer mx.Unlock()
+
 	list := make([]NamespaceEntry, 0, len(namespaces))
 
-	for name, m := range namespaces {
+	for name, ns := range namespaces {
 		list = append(list, NamespaceEntry{
 			Name:      name,
-			Namespace: m,
+			Namespace: ns.m,
 		})
 	}
 
 	return list
 }
 
-func GetNamespace(name string) (*ml.MultiLocker, bool) {
-	var ns *ml.MultiLocker
-	var ok bool
+// GetNamespace returns the namespace and whether it has just been created.
+// ErrNamespaceNotFound is returned if the namespace does not exist and its policy does not allow auto-creation, or if it is being deleted.
+// A successful call must be paired with ReleaseNamespace when the caller no longer uses the namespace, so it can be garbage collected.
+func GetNamespace(name string) (*ml.MultiLocker, bool, error) {
+	mx.Lock()
+	defer mx.Unlock()
+
+	if closing {
+		return nil, false, fmt.Errorf("%w: %s (server is shutting down)", ErrNamespaceNotFound, name)
+	}
+
+	if resetting {
+		return nil, false, fmt.Errorf("%w: %s (namespaces are being reset)", ErrNamespaceNotFound, name)
+	}
+
+	if ns, ok := namespaces[name]; ok {
+		if ns.deleting {
+			return nil, false, fmt.Errorf("%w: %s (namespace is being deleted)", ErrNamespaceNotFound, name)
+		}
+
+		ns.users++
+
+		return ns.m, false, nil
+	}
+
+	policy := findPolicy(name)
+	if !policy.AutoCreate {
+		return nil, false, fmt.Errorf("%w: %s", ErrNamespaceNotFound, name)
+	}
+
+	ns := createNamespace(name, policy)
+	ns.users++
+
+	return ns.m, true, nil
+}
+
+// ReleaseNamespace marks the end of using the namespace obtained with GetNamespace
+func ReleaseNamespace(name string) {
+	mx.Lock()
+	defer mx.Unlock()
+
+	if ns, ok := namespaces[name]; ok {
+		ns.users--
+		ns.lastUsed = time.Now()
+	}
+}
+
+// DeleteNamespace forbids new usages of the namespace and closes it once it has no users and locks.
+// The exact-named policy of the namespace is removed as well. The returned channel is closed when the namespace is closed.
+func DeleteNamespace(name string) (<-chan struct{}, error) {
+	mx.Lock()
+	defer mx.Unlock()
+
+	ns, ok := namespaces[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNamespaceNotFound, name)
+	}
+
+	policies = removePolicy(policies, name)
+	definedPolicies = removePolicy(definedPolicies, name)
+
+	ch := make(chan struct{})
+
+	if ns.deleting {
+		// the namespace is already being drained by another call
+		go func() {
+			for !isDeleted(name, ns) {
+				time.Sleep(deletePollInterval)
+			}
+
+			close(ch)
+		}()
+
+		return ch, nil
+	}
+
+	ns.deleting = true
+	ns.thaw()
+
+	go func() {
+		for !deleteIfDrained(name, ns) {
+			time.Sleep(deletePollInterval)
+		}
+
+		close(ch)
+	}()
+
+	return ch, nil
+}
+
+// SetClosedHandler sets the function called when a namespace is closed by GC, DeleteNamespace or ResetNamespaces,
+// so the state kept for the namespace outside this package can be dropped. It is called with the namespaces locked,
+// so it must not call this package.
+func SetClosedHandler(handler func(name string)) {
+	mx.Lock()
+	defer mx.Unlock()
+
+	closedHandler = handler
+}
+
+// notifyClosed must be called with mx locked
+func notifyClosed(name string) {
+	if closedHandler != nil {
+		closedHandler(name)
+	}
+}
+
+// StartGC deletes namespaces that have had no users and locks for idleTimeout.
+// Namespaces with exact-named policies are never collected, since they are not auto-created again.
+func StartGC(idleTimeout time.Duration) {
+	go func() {
+		for {
+			time.Sleep(idleTimeout / 2)
+
+			collectGarbage(idleTimeout)
+		}
+	}()
+}
+
+func collectGarbage(idleTimeout time.Duration) {
+	mx.Lock()
+	defer mx.Unlock()
+
+	now := time.Now()
+
+	for name, ns := range namespaces {
+		if ns.deleting || ns.users > 0 || (ns.policy.Name == name && !ns.policy.isPattern()) {
+			continue
+		}
+
+		if stats := ns.m.Statistics(); stats.GroupsPending > 0 || stats.GroupsAcquired > 0 {
+			ns.lastUsed = now
+			continue
+		}
+
+		if now.Sub(ns.lastUsed) >= idleTimeout {
+			delete(namespaces, name)
+			lastGroupIDs[name] = ns.m.Statistics().LastGroupID
+			notifyClosed(name)
+			go ns.m.Close()
+		}
+	}
+}
+
+// deleteIfDrained removes the namespace and closes it if it has no users and locks
+func deleteIfDrained(name string, ns *namespace) bool {
+	mx.Lock()
+
+	if namespaces[name] != ns {
+		// closed by CloseNamespaces
+		mx.Unlock()
+		return true
+	}
+
+	if stats := ns.m.Statistics(); ns.users > 0 || stats.GroupsPending > 0 || stats.GroupsAcquired > 0 {
+		mx.Unlock()
+		return false
+	}
+
+	delete(namespaces, name)
+	lastGroupIDs[name] = ns.m.Statistics().LastGroupID
+	notifyClosed(name)
+	mx.Unlock()
 
+	ns.m.Close()
+
+	return true
+}
+
+func isDeleted(name string, ns *namespace) bool {
+	mx.Lock()
+	defer mx.Unlock()
+
+	return namespaces[name] != ns
+}
+
+// GetPolicy returns the policy of the namespace. For unknown namespaces, the policy they would be created with is returned.
+func GetPolicy(name string) Policy {
 	mx.Lock()
 	defer mx.Unlock()
 
-	if ns, ok = namespaces[name]; !ok {
-		ns = ml.NewMultilocker()
-		namespaces[name] = ns
+	if ns, ok := namespaces[name]; ok {
+		return ns.policy
 	}
 
-	return ns, !ok
+	return findPolicy(name)
+}
+
+// SetPolicies replaces all policies. Namespaces with exact names are created.
+func SetPolicies(list []Policy) error {
+	for _, p := range list {
+		if err := p.validate(); err != nil {
+			return err
+		}
+	}
+
+	mx.Lock()
+	defer mx.Unlock()
+
+	policies = append([]Policy{}, list...)
+	definedPolicies = nil
+
+	applyPolicies()
+
+	return nil
+}
+
+// DefinePolicy adds the policy or replaces the one with the same name. If the name is exact, the namespace is created.
+func DefinePolicy(p Policy) error {
+	if err := p.validate(); err != nil {
+		return err
+	}
+
+	mx.Lock()
+	defer mx.Unlock()
+
+	policies = replacePolicy(policies, p)
+	definedPolicies = replacePolicy(definedPolicies, p)
+
+	applyPolicies()
+
+	return nil
+}
+
+// DefinedPolicies returns the policies defined with DefinePolicy, e.g. with the admin API, since the policies were replaced last time
+func DefinedPolicies() []Policy {
+	mx.Lock()
+	defer mx.Unlock()
+
+	return append([]Policy{}, definedPolicies...)
+}
+
+// replacePolicy replaces the policy with the same name or appends p
+func replacePolicy(list []Policy, p Policy) []Policy {
+	for i := range list {
+		if list[i].Name == p.Name {
+			list[i] = p
+			return list
+		}
+	}
+
+	return append(list, p)
+}
+
+func removePolicy(list []Policy, name string) []Policy {
+	for i := range list {
+		if list[i].Name == name {
+			return append(list[:i:i], list[i+1:]...)
+		}
+	}
+
+	return list
+}
+
+// CheckLock returns ErrLimitExceeded if locking the resources violates the namespace policy
+func CheckLock(name string, resourceLocks []ml.ResourceLock) error {
+	mx.Lock()
+	ns, ok := namespaces[name]
+
+	var policy Policy
+	frozen := false
+
+	if ok {
+		policy = ns.policy
+		frozen = ns.freeze != nil && ns.freeze.mode == FreezeModeReject
+	}
+	mx.Unlock()
+
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNamespaceNotFound, name)
+	}
+
+	if frozen {
+		return fmt.Errorf("%w: %s", ErrNamespaceFrozen, name)
+	}
+
+	if err := policy.checkResources(resourceLocks); err != nil {
+		return err
+	}
+
+	if policy.MaxPendingGroups > 0 && ns.m.Statistics().GroupsPending >= policy.MaxPendingGroups {
+		return fmt.Errorf("%w: namespace has %d pending locks", ErrLimitExceeded, policy.MaxPendingGroups)
+	}
+
+	return nil
+}
+
+// findPolicy returns the first matching policy, exact names first. If none matches, defaultPolicy is used,
+// so auto-creation of unknown namespaces is disabled by defining a "*" policy with autoCreate = false.
+func findPolicy(name string) Policy {
+	for _, p := range policies {
+		if !p.isPattern() && p.Name == name {
+			return p
+		}
+	}
+
+	for _, p := range policies {
+		if p.isPattern() && p.matches(name) {
+			return p
+		}
+	}
+
+	return defaultPolicy
+}
+
+func applyPolicies() {
+	for name, ns := range namespaces {
+		ns.policy = findPolicy(name)
+	}
+
+	for _, p := range policies {
+		if _, ok := namespaces[p.Name]; !p.isPattern() && !ok {
+			createNamespace(p.Name, p)
+		}
+	}
+}
+
+func createNamespace(name string, policy Policy) *namespace {
+	ns := &namespace{
+		m:        ml.NewMultilocker(),
+		policy:   policy,
+		lastUsed: time.Now(),
+	}
+
+	if id, ok := lastGroupIDs[name]; ok {
+		ns.m.SetLastGroupID(id)
+		delete(lastGroupIDs, name)
+	}
+
+	namespaces[name] = ns
+
+	return ns
+}
+
+// SetLastGroupID makes the namespace continue group IDs after id. If the namespace does not exist, id is used when it is created.
+// Group IDs are never decreased.
+func SetLastGroupID(name string, id int64) {
+	mx.Lock()
+	defer mx.Unlock()
+
+	if ns, ok := namespaces[name]; ok {
+		ns.m.SetLastGroupID(id)
+		return
+	}
+
+	if id > lastGroupIDs[name] {
+		lastGroupIDs[name] = id
+	}
+}
+
+// LastGroupID returns the last group ID of the namespace, including closed namespaces
+func LastGroupID(name string) int64 {
+	mx.Lock()
+	defer mx.Unlock()
+
+	if ns, ok := namespaces[name]; ok {
+		return ns.m.Statistics().LastGroupID
+	}
+
+	return lastGroupIDs[name]
 }
 
 type NamespaceStatistics struct {
@@ -49,10 +431,13 @@ type NamespaceStatistics struct {
 }
 
 func GetStatistics() []NamespaceStatistics {
+	mx.Lock()
+	defer mx.Unlock()
+
 	list := make([]NamespaceStatistics, 0, len(namespaces))
 
-	for name, ml := range namespaces {
-		stats := ml.Statistics()
+	for name, ns := range namespaces {
+		stats := ns.m.Statistics()
 
 		list = append(list, NamespaceStatistics{
 			Name:  name,
@@ -68,23 +453,46 @@ func GetNamespaceStatistics(name string) *ml.MultilockerStatistics {
 	defer mx.Unlock()
 
 	if ns, ok := namespaces[name]; ok {
-		stats := ns.Statistics()
+		stats := ns.m.Statistics()
 		return &stats
 	}
 
 	return nil
 }
 
+// GetNamespaceStatisticsByLabel returns nil if the namespace does not exist
+func GetNamespaceStatisticsByLabel(name, label string) map[string]ml.LabelStatistics {
+	mx.Lock()
+	defer mx.Unlock()
+
+	if ns, ok := namespaces[name]; ok {
+		return ns.m.StatisticsByLabel(label)
+	}
+
+	return nil
+}
+
+// CloseNamespaces closes all namespaces. The returned channel is closed when all the locks have been released.
 func CloseNamespaces() <-chan struct{} {
+	mx.Lock()
+	closing = true
+	list := namespaces
+	namespaces = make(map[string]*namespace)
+
+	for _, ns := range list {
+		ns.thaw()
+	}
+	mx.Unlock()
+
 	ch := make(chan struct{})
 
 	wg := sync.WaitGroup{}
-	for _, m := range namespaces {
+	for _, ns := range list {
 		wg.Add(1)
 		go func(m *ml.MultiLocker) {
 			m.Close()
 			wg.Done()
-		}(m)
+		}(ns.m)
 	}
 
 	go func() {
@@ -94,3 +502,63 @@ func CloseNamespaces() <-chan struct{} {
 
 	return ch
 }
+
+// ResetNamespaces closes all namespaces once they have no users and locks, and creates the ones with exact-named policies again.
+// Namespaces cannot be used meanwhile. Group ID sequences are forgotten. The returned channel is closed when the namespaces are reset.
+func ResetNamespaces() <-chan struct{} {
+	mx.Lock()
+	resetting = true
+
+	for _, ns := range namespaces {
+		ns.thaw()
+	}
+	mx.Unlock()
+
+	ch := make(chan struct{})
+
+	go func() {
+		for !resetIfDrained() {
+			time.Sleep(deletePollInterval)
+		}
+
+		close(ch)
+	}()
+
+	return ch
+}
+
+func resetIfDrained() bool {
+	mx.Lock()
+
+	if closing {
+		resetting = false
+		mx.Unlock()
+
+		return true
+	}
+
+	for _, ns := range namespaces {
+		if stats := ns.m.Statistics(); ns.users > 0 || stats.GroupsPending > 0 || stats.GroupsAcquired > 0 {
+			mx.Unlock()
+			return false
+		}
+	}
+
+	list := namespaces
+	namespaces = make(map[string]*namespace)
+	lastGroupIDs = make(map[string]int64)
+	resetting = false
+
+	for name := range list {
+		notifyClosed(name)
+	}
+
+	applyPolicies()
+	mx.Unlock()
+
+	for _, ns := range list {
+		ns.m.Close()
+	}
+
+	return true
+}
@@ -0,0 +1,163 @@
+package namespace
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/locktopus-project/locktopus/internal/webhook"
+	ml "github.com/locktopus-project/locktopus/pkg/multilocker"
+)
+
+var ErrNamespaceNotFound = errors.New("namespace not found")
+var ErrLimitExceeded = errors.New("namespace limit exceeded")
+
+// Policy defines limits and defaults of namespaces. Name may be a pattern (see path.Match) to apply the policy to several namespaces.
+// Zero limits mean "unlimited".
+type Policy struct {
+	Name                           string `json:"name"`
+	DefaultAbandonTimeoutMs        *int64 `json:"defaultAbandonTimeoutMs,omitempty"`        // if not provided, the server's default is used
+	DefaultPendingAbandonTimeoutMs *int64 `json:"defaultPendingAbandonTimeoutMs,omitempty"` // for locks still enqueued when the client disconnects. 0 cancels them right away. If not provided, the server's default is used
+	MaxAbandonTimeoutMs            int64  `json:"maxAbandonTimeoutMs,omitempty"`            // limits both abandon timeouts
+	MaxGroupSize                   int    `json:"maxGroupSize,omitempty"`                   // max number of resources in a lock
+	MaxPathDepth                   int    `json:"maxPathDepth,omitempty"`                   // max number of segments in a resource path
+	MaxPendingGroups               int64  `json:"maxPendingGroups,omitempty"`               // max number of enqueued locks. The check is not atomic with locking, so the limit may be slightly exceeded under concurrency
+	AutoCreate                     bool   `json:"autoCreate"`                               // whether matching namespaces are created on first use. Namespaces with exact names are created when defined
+	Durable                        bool   `json:"durable,omitempty"`                        // whether locks are written to the write-ahead log (if enabled) to survive server restarts
+
+	Webhooks []webhook.Hook `json:"webhooks,omitempty"` // endpoints notified about lock events
+}
+
+// Config is the content of the namespaces file, e.g.
+//
+//	{
+//	  "namespaces": [
+//	    {"name": "billing", "maxGroupSize": 10, "maxPendingGroups": 1000},
+//	    {"name": "tmp-*", "defaultAbandonTimeoutMs": 0, "autoCreate": true}
+//	  ]
+//	}
+type Config struct {
+	Namespaces []Policy `json:"namespaces"`
+}
+
+// LoadPolicies reads the namespaces file and replaces all policies
+func LoadPolicies(file string) error {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	config := Config{}
+	if err = json.Unmarshal(content, &config); err != nil {
+		return fmt.Errorf("cannot parse %s: %w", file, err)
+	}
+
+	return SetPolicies(config.Namespaces)
+}
+
+// defaultPolicy is used for namespaces that match no policy
+var defaultPolicy = Policy{Name: "*", AutoCreate: true}
+
+func (p Policy) isPattern() bool {
+	return strings.ContainsAny(p.Name, `*?[\`)
+}
+
+func (p Policy) matches(name string) bool {
+	if !p.isPattern() {
+		return p.Name == name
+	}
+
+	ok, _ := path.Match(p.Name, name)
+
+	return ok
+}
+
+func (p Policy) validate() error {
+	if p.Name == "" {
+		return errors.New("policy name is required")
+	}
+
+	if _, err := path.Match(p.Name, ""); err != nil {
+		return fmt.Errorf("invalid namespace pattern '%s': %w", p.Name, err)
+	}
+
+	if p.DefaultAbandonTimeoutMs != nil && *p.DefaultAbandonTimeoutMs < 0 {
+		return fmt.Errorf("policy '%s': defaultAbandonTimeoutMs should be >= 0", p.Name)
+	}
+
+	if p.DefaultPendingAbandonTimeoutMs != nil && *p.DefaultPendingAbandonTimeoutMs < 0 {
+		return fmt.Errorf("policy '%s': defaultPendingAbandonTimeoutMs should be >= 0", p.Name)
+	}
+
+	if p.MaxAbandonTimeoutMs < 0 || p.MaxGroupSize < 0 || p.MaxPathDepth < 0 || p.MaxPendingGroups < 0 {
+		return fmt.Errorf("policy '%s': limits should be >= 0", p.Name)
+	}
+
+	if p.DefaultAbandonTimeoutMs != nil && p.MaxAbandonTimeoutMs > 0 && *p.DefaultAbandonTimeoutMs > p.MaxAbandonTimeoutMs {
+		return fmt.Errorf("policy '%s': defaultAbandonTimeoutMs exceeds maxAbandonTimeoutMs", p.Name)
+	}
+
+	if p.DefaultPendingAbandonTimeoutMs != nil && p.MaxAbandonTimeoutMs > 0 && *p.DefaultPendingAbandonTimeoutMs > p.MaxAbandonTimeoutMs {
+		return fmt.Errorf("policy '%s': defaultPendingAbandonTimeoutMs exceeds maxAbandonTimeoutMs", p.Name)
+	}
+
+	for _, h := range p.Webhooks {
+		if err := h.Validate(); err != nil {
+			return fmt.Errorf("policy '%s': %w", p.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// AbandonTimeout returns the namespace default, or def if it is not defined. The result is limited by MaxAbandonTimeoutMs.
+func (p Policy) AbandonTimeout(def time.Duration) time.Duration {
+	return p.limitAbandonTimeout(p.DefaultAbandonTimeoutMs, def)
+}
+
+// PendingAbandonTimeout returns the namespace default for enqueued locks, or def if it is not defined. The result is limited by MaxAbandonTimeoutMs.
+func (p Policy) PendingAbandonTimeout(def time.Duration) time.Duration {
+	return p.limitAbandonTimeout(p.DefaultPendingAbandonTimeoutMs, def)
+}
+
+func (p Policy) limitAbandonTimeout(timeoutMs *int64, def time.Duration) time.Duration {
+	timeout := def
+	if timeoutMs != nil {
+		timeout = time.Duration(*timeoutMs) * time.Millisecond
+	}
+
+	if max := time.Duration(p.MaxAbandonTimeoutMs) * time.Millisecond; max > 0 && timeout > max {
+		timeout = max
+	}
+
+	return timeout
+}
+
+// CheckAbandonTimeout returns an error if the timeout requested by a client exceeds the limit
+func (p Policy) CheckAbandonTimeout(timeout time.Duration) error {
+	if p.MaxAbandonTimeoutMs > 0 && timeout > time.Duration(p.MaxAbandonTimeoutMs)*time.Millisecond {
+		return fmt.Errorf("%w: abandon timeout should not exceed %d ms", ErrLimitExceeded, p.MaxAbandonTimeoutMs)
+	}
+
+	return nil
+}
+
+func (p Policy) checkResources(resourceLocks []ml.ResourceLock) error {
+	if p.MaxGroupSize > 0 && len(resourceLocks) > p.MaxGroupSize {
+		return fmt.Errorf("%w: a lock should not contain more than %d resources", ErrLimitExceeded, p.MaxGroupSize)
+	}
+
+	if p.MaxPathDepth > 0 {
+		for _, rl := range resourceLocks {
+			if len(rl.Path) > p.MaxPathDepth {
+				return fmt.Errorf("%w: path %v is deeper than %d segments", ErrLimitExceeded, rl.Path, p.MaxPathDepth)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,105 @@
+// Package ratelimit implements token buckets, alone and keyed (e.g. by remote IP).
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Bucket holds up to burst tokens and is refilled with rate tokens per second. Use NewBucket to create one
+type Bucket struct {
+	mx     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewBucket returns a full bucket
+func NewBucket(rate float64, burst int) *Bucket {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &Bucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// Allow takes a token. It returns false if the bucket is empty
+func (b *Bucket) Allow() bool {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	b.refill(time.Now())
+
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+
+	return true
+}
+
+// full returns true if the bucket has been refilled completely, so it is the same as a new one
+func (b *Bucket) full(now time.Time) bool {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	b.refill(now)
+
+	return b.tokens >= b.burst
+}
+
+func (b *Bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+
+	b.last = now
+}
+
+// cleanupInterval is how often the full buckets of a Limiter are forgotten
+const cleanupInterval = time.Minute
+
+// Limiter keeps a bucket per key. Use NewLimiter to create one
+type Limiter struct {
+	mx          sync.Mutex
+	rate        float64
+	burst       int
+	buckets     map[string]*Bucket
+	lastCleanup time.Time
+}
+
+func NewLimiter(rate float64, burst int) *Limiter {
+	return &Limiter{rate: rate, burst: burst, buckets: make(map[string]*Bucket), lastCleanup: time.Now()}
+}
+
+// Allow takes a token from the bucket of the key
+func (l *Limiter) Allow(key string) bool {
+	l.mx.Lock()
+
+	now := time.Now()
+
+	if now.Sub(l.lastCleanup) >= cleanupInterval {
+		l.lastCleanup = now
+
+		for k, b := range l.buckets {
+			if b.full(now) {
+				delete(l.buckets, k)
+			}
+		}
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = NewBucket(l.rate, l.burst)
+		l.buckets[key] = b
+	}
+
+	l.mx.Unlock()
+
+	return b.Allow()
+}
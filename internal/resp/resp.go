@@ -0,0 +1,230 @@
+// Package resp implements the subset of RESP (REdis Serialization Protocol) needed to serve Redis-compatible clients.
+// See https://redis.io/docs/reference/protocol-spec/
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const maxArrayLength = 1024 * 1024
+const maxBulkLength = 1024 * 1024
+
+var ErrProtocol = errors.New("protocol error")
+var ErrTooLarge = errors.New("command too large")
+
+// Reader reads client commands. Both multi-bulk (redis-cli, client libraries) and inline (telnet) forms are supported.
+type Reader struct {
+	r              *bufio.Reader
+	maxCommandSize int64
+}
+
+// NewReader returns a reader failing with ErrTooLarge on commands whose arguments exceed maxCommandSize bytes.
+// With 0, commands are limited by the protocol limits only. Lines are never longer than a bulk string can be.
+func NewReader(r io.Reader, maxCommandSize int64) *Reader {
+	return &Reader{r: bufio.NewReader(r), maxCommandSize: maxCommandSize}
+}
+
+// ReadCommand returns the next command with its arguments. Empty inline commands are skipped.
+func (r *Reader) ReadCommand() ([]string, error) {
+	for {
+		line, err := r.readLine()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(line) == 0 {
+			continue
+		}
+
+		if line[0] != '*' {
+			args := strings.Fields(line)
+			if len(args) == 0 {
+				continue
+			}
+
+			return args, nil
+		}
+
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n > maxArrayLength {
+			return nil, fmt.Errorf("%w: invalid multibulk length", ErrProtocol)
+		}
+
+		if n <= 0 {
+			continue
+		}
+
+		if r.maxCommandSize > 0 && int64(n) > r.maxCommandSize {
+			return nil, fmt.Errorf("%w: command has more than %d arguments", ErrTooLarge, r.maxCommandSize)
+		}
+
+		size := int64(0)
+		args := make([]string, n)
+
+		for i := range args {
+			if args[i], err = r.readBulkString(r.maxCommandSize - size); err != nil {
+				return nil, err
+			}
+
+			size += int64(len(args[i]))
+		}
+
+		return args, nil
+	}
+}
+
+// readLine fails with ErrTooLarge once the line exceeds the command size limit, without reading the rest of it
+func (r *Reader) readLine() (string, error) {
+	limit := int64(maxBulkLength)
+	if r.maxCommandSize > 0 && r.maxCommandSize < limit {
+		limit = r.maxCommandSize
+	}
+
+	line := []byte{}
+
+	for {
+		chunk, err := r.r.ReadSlice('\n')
+		line = append(line, chunk...)
+
+		// the limit does not include CRLF
+		if int64(len(line)) > limit+2 {
+			return "", fmt.Errorf("%w: line exceeds %d bytes", ErrTooLarge, limit)
+		}
+
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+
+		if err != nil {
+			return "", err
+		}
+
+		return strings.TrimSuffix(strings.TrimSuffix(string(line), "\n"), "\r"), nil
+	}
+}
+
+// readBulkString fails with ErrTooLarge if the string is longer than maxSize, unless the command size is not limited
+func (r *Reader) readBulkString(maxSize int64) (string, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return "", err
+	}
+
+	if len(line) == 0 || line[0] != '$' {
+		return "", fmt.Errorf("%w: expected '$', got '%s'", ErrProtocol, line)
+	}
+
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 0 || n > maxBulkLength {
+		return "", fmt.Errorf("%w: invalid bulk length", ErrProtocol)
+	}
+
+	if r.maxCommandSize > 0 && int64(n) > maxSize {
+		return "", fmt.Errorf("%w: command exceeds %d bytes", ErrTooLarge, r.maxCommandSize)
+	}
+
+	buf := make([]byte, n+2)
+	if _, err = io.ReadFull(r.r, buf); err != nil {
+		return "", err
+	}
+
+	if buf[n] != '\r' || buf[n+1] != '\n' {
+		return "", fmt.Errorf("%w: bulk string is not terminated with CRLF", ErrProtocol)
+	}
+
+	return string(buf[:n]), nil
+}
+
+// Writer writes server replies. Each call results in a single Write on the underlying writer.
+// Writer is not thread-safe.
+type Writer struct {
+	w io.Writer
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+func (w *Writer) WriteSimpleString(s string) error {
+	return w.write([]byte("+" + s + "\r\n"))
+}
+
+func (w *Writer) WriteError(s string) error {
+	return w.write([]byte("-" + strings.NewReplacer("\r", " ", "\n", " ").Replace(s) + "\r\n"))
+}
+
+func (w *Writer) WriteInteger(i int64) error {
+	return w.write([]byte(":" + strconv.FormatInt(i, 10) + "\r\n"))
+}
+
+func (w *Writer) WriteBulkString(s string) error {
+	return w.write(appendBulkString(nil, s))
+}
+
+func (w *Writer) WriteNull() error {
+	return w.write([]byte("$-1\r\n"))
+}
+
+// WriteArray writes an array of bulk strings.
+func (w *Writer) WriteArray(items ...string) error {
+	return w.write(appendAggregate(nil, '*', items))
+}
+
+// WritePush writes an out-of-band message. For RESP3 clients it is a push type, for RESP2 clients it is an array (same as Pub/Sub messages).
+func (w *Writer) WritePush(resp3 bool, items ...string) error {
+	prefix := byte('*')
+	if resp3 {
+		prefix = '>'
+	}
+
+	return w.write(appendAggregate(nil, prefix, items))
+}
+
+func (w *Writer) write(b []byte) error {
+	_, err := w.w.Write(b)
+
+	return err
+}
+
+func appendAggregate(b []byte, prefix byte, items []string) []byte {
+	b = append(b, prefix)
+	b = strconv.AppendInt(b, int64(len(items)), 10)
+	b = append(b, '\r', '\n')
+
+	for _, item := range items {
+		b = appendBulkString(b, item)
+	}
+
+	return b
+}
+
+func appendBulkString(b []byte, s string) []byte {
+	b = append(b, '$')
+	b = strconv.AppendInt(b, int64(len(s)), 10)
+	b = append(b, '\r', '\n')
+	b = append(b, s...)
+
+	return append(b, '\r', '\n')
+}
+
+// WriteMap writes a map of bulk strings. For RESP2 clients it is a flat array of keys and values.
+func (w *Writer) WriteMap(resp3 bool, pairs ...string) error {
+	if !resp3 {
+		return w.WriteArray(pairs...)
+	}
+
+	b := []byte{'%'}
+	b = strconv.AppendInt(b, int64(len(pairs)/2), 10)
+	b = append(b, '\r', '\n')
+
+	for _, item := range pairs {
+		b = appendBulkString(b, item)
+	}
+
+	return w.write(b)
+}
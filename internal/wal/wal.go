@@ -0,0 +1,378 @@
+// Package wal implements an append-only log of lock groups with periodic snapshots,
+// so the lock queues of durable namespaces survive server restarts.
+package wal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const logFileName = "wal.log"
+const snapshotFileName = "snapshot.json"
+
+type RecordType string
+
+const (
+	RecordEnqueued RecordType = "enqueued"
+	RecordAcquired RecordType = "acquired"
+	RecordReleased RecordType = "released"
+)
+
+type Resource struct {
+	T    string   `json:"type"`
+	Path []string `json:"path"`
+}
+
+// Record is a line of the log
+type Record struct {
+	Type      RecordType `json:"type"`
+	Namespace string     `json:"ns"`
+	ID        int64      `json:"id"`
+	Time      time.Time  `json:"ts"`
+
+	// the fields below are set for RecordEnqueued only
+	Resources []Resource        `json:"resources,omitempty"`
+	Owner     string            `json:"owner,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Session   string            `json:"session,omitempty"` // resume key of a connection-bound lock. Empty for leases
+	LeaseMs   int64             `json:"leaseMs,omitempty"` // lease duration, or abandon timeout of a connection-bound lock
+}
+
+// Group is a lock that has not been released
+type Group struct {
+	Namespace  string            `json:"ns"`
+	ID         int64             `json:"id"`
+	Resources  []Resource        `json:"resources"`
+	Owner      string            `json:"owner,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	Session    string            `json:"session,omitempty"`
+	LeaseMs    int64             `json:"leaseMs,omitempty"`
+	EnqueuedAt time.Time         `json:"enqueuedAt"`
+	AcquiredAt *time.Time        `json:"acquiredAt,omitempty"`
+}
+
+// State is the content of a snapshot
+type State struct {
+	Groups  []Group          `json:"groups"`  // ordered by namespace and ID, i.e. in the queue order
+	LastIDs map[string]int64 `json:"lastIds"` // the last group ID of each namespace ever used
+}
+
+// Log is safe for concurrent use
+type Log struct {
+	mx     sync.Mutex
+	dir    string
+	file   *os.File
+	table  *Table
+	closed bool
+}
+
+type groupKey struct {
+	namespace string
+	id        int64
+}
+
+// Table keeps the groups that have not been released. It is not safe for concurrent use
+type Table struct {
+	groups map[groupKey]*Group
+	last   map[string]int64
+}
+
+func NewTable() *Table {
+	return &Table{
+		groups: make(map[groupKey]*Group),
+		last:   make(map[string]int64),
+	}
+}
+
+// Open reads the snapshot and the log from dir and returns the recovered state.
+// The state is compacted into a new snapshot, and the log is started anew.
+func Open(dir string) (*Log, State, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, State{}, err
+	}
+
+	l := &Log{
+		dir:   dir,
+		table: NewTable(),
+	}
+
+	if err := l.readSnapshot(); err != nil {
+		return nil, State{}, err
+	}
+
+	if err := l.replay(); err != nil {
+		return nil, State{}, err
+	}
+
+	if err := l.compact(); err != nil {
+		return nil, State{}, err
+	}
+
+	return l, l.table.State(), nil
+}
+
+// Append writes the record and syncs the log file
+func (l *Log) Append(r Record) error {
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+
+	serialized, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	if l.closed {
+		return errors.New("log is closed")
+	}
+
+	l.table.Apply(r)
+
+	if _, err = l.file.Write(append(serialized, '\n')); err != nil {
+		return err
+	}
+
+	return l.file.Sync()
+}
+
+// Snapshot writes the current state to the snapshot file and truncates the log
+func (l *Log) Snapshot() error {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	if l.closed {
+		return errors.New("log is closed")
+	}
+
+	return l.compact()
+}
+
+// StartSnapshots makes a snapshot every interval until the log is closed
+func (l *Log) StartSnapshots(interval time.Duration, onError func(err error)) {
+	go func() {
+		for {
+			time.Sleep(interval)
+
+			err := l.Snapshot()
+
+			l.mx.Lock()
+			closed := l.closed
+			l.mx.Unlock()
+
+			if closed {
+				return
+			}
+
+			if err != nil {
+				onError(err)
+			}
+		}
+	}()
+}
+
+// State returns the groups that have not been released
+func (l *Log) State() State {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	return l.table.State()
+}
+
+func (l *Log) Dir() string {
+	return l.dir
+}
+
+func (l *Log) Close() error {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	if l.closed {
+		return nil
+	}
+
+	l.closed = true
+
+	return l.file.Close()
+}
+
+func (t *Table) Apply(r Record) {
+	key := groupKey{namespace: r.Namespace, id: r.ID}
+
+	if r.ID > t.last[r.Namespace] {
+		t.last[r.Namespace] = r.ID
+	}
+
+	switch r.Type {
+	case RecordEnqueued:
+		t.groups[key] = &Group{
+			Namespace:  r.Namespace,
+			ID:         r.ID,
+			Resources:  r.Resources,
+			Owner:      r.Owner,
+			Labels:     r.Labels,
+			Session:    r.Session,
+			LeaseMs:    r.LeaseMs,
+			EnqueuedAt: r.Time,
+		}
+	case RecordAcquired:
+		if g, ok := t.groups[key]; ok && g.AcquiredAt == nil {
+			acquiredAt := r.Time
+			g.AcquiredAt = &acquiredAt
+		}
+	case RecordReleased:
+		delete(t.groups, key)
+	}
+}
+
+// Load adds the groups and the last group IDs of the state, e.g. of a snapshot
+func (t *Table) Load(s State) {
+	for i := range s.Groups {
+		g := s.Groups[i]
+		t.groups[groupKey{namespace: g.Namespace, id: g.ID}] = &g
+	}
+
+	for ns, id := range s.LastIDs {
+		if id > t.last[ns] {
+			t.last[ns] = id
+		}
+	}
+}
+
+// State returns the groups and the last group IDs of all the namespaces seen
+func (t *Table) State() State {
+	s := State{
+		Groups:  make([]Group, 0, len(t.groups)),
+		LastIDs: make(map[string]int64),
+	}
+
+	for _, g := range t.groups {
+		s.Groups = append(s.Groups, *g)
+	}
+
+	sort.Slice(s.Groups, func(i, j int) bool {
+		if s.Groups[i].Namespace != s.Groups[j].Namespace {
+			return s.Groups[i].Namespace < s.Groups[j].Namespace
+		}
+
+		return s.Groups[i].ID < s.Groups[j].ID
+	})
+
+	for ns, id := range t.last {
+		s.LastIDs[ns] = id
+	}
+
+	return s
+}
+
+func (l *Log) readSnapshot() error {
+	content, err := os.ReadFile(filepath.Join(l.dir, snapshotFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	s := State{}
+	if err = json.Unmarshal(content, &s); err != nil {
+		return fmt.Errorf("cannot parse snapshot: %w", err)
+	}
+
+	l.table.Load(s)
+
+	return nil
+}
+
+// replay applies the log records. A partially written last line (after a crash) is ignored.
+func (l *Log) replay() error {
+	f, err := os.Open(filepath.Join(l.dir, logFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+
+	for {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		record := Record{}
+		if err = json.Unmarshal(line, &record); err != nil {
+			return fmt.Errorf("cannot parse log record: %w", err)
+		}
+
+		l.table.Apply(record)
+	}
+}
+
+// compact writes the snapshot atomically and starts a new log. It must be called with mx locked
+func (l *Log) compact() error {
+	// the sequences of idle namespaces are kept too, so group IDs are never reused as fencing tokens after a restart
+	state := l.table.State()
+
+	serialized, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(l.dir, snapshotFileName+".tmp")
+
+	if err = writeFileSync(tmp, serialized); err != nil {
+		return err
+	}
+
+	if err = os.Rename(tmp, filepath.Join(l.dir, snapshotFileName)); err != nil {
+		return err
+	}
+
+	if l.file != nil {
+		l.file.Close()
+	}
+
+	l.file, err = os.OpenFile(filepath.Join(l.dir, logFileName), os.O_CREATE|os.O_TRUNC|os.O_WRONLY|os.O_APPEND, 0600)
+
+	return err
+}
+
+func writeFileSync(name string, content []byte) error {
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err = f.Write(content); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,338 @@
+// Package webhook delivers lock events to HTTP endpoints.
+//
+// Events are queued without blocking and posted as JSON by a pool of workers. Failed deliveries are retried with exponential backoff.
+// Events are dropped if the queue is full, so a slow endpoint never slows down locking.
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	SignatureHeaderName = "Locktopus-Signature" // sha256=<hex HMAC of "<timestamp>.<body>">, if the hook has a secret
+	TimestampHeaderName = "Locktopus-Timestamp" // Unix time of the delivery attempt
+	EventHeaderName     = "Locktopus-Event"
+	DeliveryHeaderName  = "Locktopus-Delivery" // the same for all attempts of the event, for deduplication
+)
+
+const (
+	EventEnqueued = "enqueued"
+	EventAcquired = "acquired"
+	EventReleased = "released"
+	EventRevoked  = "revoked" // released with the admin API
+	EventHeld     = "held"    // still acquired after HeldAfterMs
+)
+
+var events = []string{EventEnqueued, EventAcquired, EventReleased, EventRevoked, EventHeld}
+
+// maxBackoff limits the delay between attempts
+const maxBackoff = time.Minute
+
+// Hook is a webhook of a namespace policy, e.g.
+//
+//	{"url": "https://ops.example.com/locks", "pathPrefix": ["deploy", "prod"], "events": ["held"], "heldAfterMs": 3600000, "secret": "..."}
+type Hook struct {
+	URL         string   `json:"url"`
+	PathPrefix  []string `json:"pathPrefix,omitempty"`  // the hook is notified about locks having a resource under the prefix. Default: all locks
+	Events      []string `json:"events,omitempty"`      // default: all events except "held"
+	Secret      string   `json:"secret,omitempty"`      // if provided, deliveries are signed
+	HeldAfterMs int64    `json:"heldAfterMs,omitempty"` // if provided, "held" is delivered when a lock is still acquired after this time
+}
+
+func (h Hook) Validate() error {
+	u, err := url.Parse(h.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid webhook url '%s'", h.URL)
+	}
+
+	for _, e := range h.Events {
+		if !contains(events, e) {
+			return fmt.Errorf("webhook %s: unknown event '%s'", h.URL, e)
+		}
+	}
+
+	if h.HeldAfterMs < 0 {
+		return fmt.Errorf("webhook %s: heldAfterMs should be >= 0", h.URL)
+	}
+
+	if h.HeldAfterMs == 0 && contains(h.Events, EventHeld) {
+		return fmt.Errorf("webhook %s: event 'held' requires heldAfterMs", h.URL)
+	}
+
+	return nil
+}
+
+// Wants returns true if the hook is subscribed to the event
+func (h Hook) Wants(event string) bool {
+	if len(h.Events) == 0 {
+		return event != EventHeld || h.HeldAfterMs > 0
+	}
+
+	return contains(h.Events, event)
+}
+
+// Matches returns true if any of the paths is under the prefix of the hook
+func (h Hook) Matches(paths [][]string) bool {
+	for _, p := range paths {
+		if len(p) < len(h.PathPrefix) {
+			continue
+		}
+
+		matches := true
+		for i, segment := range h.PathPrefix {
+			if p[i] != segment {
+				matches = false
+				break
+			}
+		}
+
+		if matches {
+			return true
+		}
+	}
+
+	return false
+}
+
+type Resource struct {
+	T    string   `json:"type"`
+	Path []string `json:"path"`
+}
+
+// Event is the body of a delivery
+type Event struct {
+	ID        string     `json:"id"`
+	Event     string     `json:"event"`
+	Namespace string     `json:"namespace"`
+	GroupID   int64      `json:"groupId"`
+	Resources []Resource `json:"resources"`
+	Time      time.Time  `json:"time"`
+	HeldMs    *int64     `json:"heldMs,omitempty"` // for released, revoked and held events of acquired locks
+}
+
+type Options struct {
+	QueueSize   int
+	Workers     int
+	MaxAttempts int
+	Backoff     time.Duration // delay before the second attempt. It is doubled for each next attempt
+	Timeout     time.Duration // of a single attempt
+	OnFailure   func(url string, e Event, err error)
+}
+
+var DefaultOptions = Options{
+	QueueSize:   1000,
+	Workers:     4,
+	MaxAttempts: 5,
+	Backoff:     time.Second,
+	Timeout:     10 * time.Second,
+}
+
+var ErrQueueFull = errors.New("webhook queue is full")
+
+type delivery struct {
+	hook    Hook
+	event   Event
+	body    []byte
+	attempt int
+}
+
+// Dispatcher delivers events. Use NewDispatcher to create one
+type Dispatcher struct {
+	options Options
+	client  *http.Client
+	queue   chan delivery
+
+	mx     sync.RWMutex
+	closed bool
+	wg     sync.WaitGroup
+
+	delivered int64
+	failed    int64
+	dropped   int64
+}
+
+// Stats are counted since the dispatcher has been created
+type Stats struct {
+	Delivered int64 `json:"delivered"`
+	Failed    int64 `json:"failed"`  // given up after all attempts
+	Dropped   int64 `json:"dropped"` // not queued, since the queue was full
+	Queued    int   `json:"queued"`
+}
+
+func NewDispatcher(options Options) *Dispatcher {
+	d := &Dispatcher{
+		options: options,
+		client:  &http.Client{Timeout: options.Timeout},
+		queue:   make(chan delivery, options.QueueSize),
+	}
+
+	for i := 0; i < options.Workers; i++ {
+		d.wg.Add(1)
+		go d.work()
+	}
+
+	return d
+}
+
+// Send queues the event for the hook without blocking
+func (d *Dispatcher) Send(h Hook, e Event) error {
+	if e.ID == "" {
+		e.ID = newEventID()
+	}
+
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	return d.enqueue(delivery{hook: h, event: e, body: body, attempt: 1})
+}
+
+func (d *Dispatcher) enqueue(dl delivery) error {
+	d.mx.RLock()
+	defer d.mx.RUnlock()
+
+	if d.closed {
+		return ErrQueueFull
+	}
+
+	select {
+	case d.queue <- dl:
+		return nil
+	default:
+		atomic.AddInt64(&d.dropped, 1)
+		return ErrQueueFull
+	}
+}
+
+// Close stops the workers. Queued events and pending retries are dropped
+func (d *Dispatcher) Close() {
+	d.mx.Lock()
+	if d.closed {
+		d.mx.Unlock()
+		return
+	}
+
+	d.closed = true
+	close(d.queue)
+	d.mx.Unlock()
+
+	d.wg.Wait()
+}
+
+func (d *Dispatcher) Stats() Stats {
+	return Stats{
+		Delivered: atomic.LoadInt64(&d.delivered),
+		Failed:    atomic.LoadInt64(&d.failed),
+		Dropped:   atomic.LoadInt64(&d.dropped),
+		Queued:    len(d.queue),
+	}
+}
+
+func (d *Dispatcher) work() {
+	defer d.wg.Done()
+
+	for dl := range d.queue {
+		err := d.post(dl)
+		if err == nil {
+			atomic.AddInt64(&d.delivered, 1)
+			continue
+		}
+
+		if dl.attempt >= d.options.MaxAttempts {
+			atomic.AddInt64(&d.failed, 1)
+
+			if d.options.OnFailure != nil {
+				d.options.OnFailure(dl.hook.URL, dl.event, err)
+			}
+
+			continue
+		}
+
+		delay := d.options.Backoff << (dl.attempt - 1)
+		if delay > maxBackoff || delay <= 0 {
+			delay = maxBackoff
+		}
+
+		dl.attempt++
+
+		time.AfterFunc(delay, func() {
+			if err := d.enqueue(dl); err != nil && d.options.OnFailure != nil {
+				d.options.OnFailure(dl.hook.URL, dl.event, err)
+			}
+		})
+	}
+}
+
+func (d *Dispatcher) post(dl delivery) error {
+	req, err := http.NewRequest(http.MethodPost, dl.hook.URL, bytes.NewReader(dl.body))
+	if err != nil {
+		return err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeaderName, dl.event.Event)
+	req.Header.Set(DeliveryHeaderName, dl.event.ID)
+	req.Header.Set(TimestampHeaderName, timestamp)
+
+	if dl.hook.Secret != "" {
+		req.Header.Set(SignatureHeaderName, Sign(dl.hook.Secret, timestamp, dl.body))
+	}
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64*1024))
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("unexpected response status: %s", res.Status)
+	}
+
+	return nil
+}
+
+// Sign returns the signature header value. Receivers compute it the same way to verify a delivery
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func newEventID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
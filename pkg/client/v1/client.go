@@ -1,10 +1,14 @@
 package client
 
 import (
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"net"
+	"net/http"
 	"net/url"
+	"strings"
 	"sync/atomic"
 	"time"
 
@@ -17,19 +21,29 @@ import (
 type LocktopusClient struct {
 	conn      *websocket.Conn
 	lr        []resource
+	owner     string
+	labels    map[string]string
 	acquired  atomic.Bool
 	lockID    string
+	session   string
 	responses chan result
 	released  chan struct{}
+	revoked   chan struct{}
+	contended chan Contention
 }
 
 type ConnectionOptions struct {
-	Url                 string // if provided, other options are ignored
-	Host                string
-	Port                int
-	Namespace           string
-	Secure              bool
-	ForceCloseTimeoutMs *int // if provided, server will keep the lock for this time after client disconnects without releasing it
+	Url                   string // if provided, other options are ignored. Use unix:///path/to/socket?namespace=... to connect via Unix domain socket
+	Host                  string // hostname or unix:///path/to/socket
+	Port                  int    // not used with Unix domain sockets
+	Namespace             string
+	Secure                bool
+	TLSConfig             *tls.Config // if provided, used for wss:// connections (implies Secure). Set Certificates for mutual TLS
+	Token                 string      // access token, if the server requires authentication
+	ForceCloseTimeoutMs   *int        // if provided, server will keep the lock for this time after client disconnects without releasing it
+	PendingCloseTimeoutMs *int        // like ForceCloseTimeoutMs, for the lock not acquired yet. 0 makes the server cancel it right away
+	ResumeSession         string      // if provided, the lock restored by the server after restart is resumed. See Session()
+	NotifyContention      bool        // if true, the server notifies the holder when other locks become blocked on it. See Contended()
 }
 
 type LockType = ml.LockType
@@ -44,19 +58,33 @@ const version = "v1"
 // MakeClient establishes a connection to the Locktopus server and returns LocktopusClient.
 func MakeClient(options ConnectionOptions) (*LocktopusClient, error) {
 	address := options.Url
+	dialer := websocket.DefaultDialer
+	routed := !strings.HasPrefix(address, unixScheme) && !strings.HasPrefix(options.Host, unixScheme)
+
+	if strings.HasPrefix(address, unixScheme) {
+		u, err := url.Parse(address)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse Url: %w", err)
+		}
+
+		dialer = unixSocketDialer(u.Path)
+		address = fmt.Sprintf("ws://localhost/%s?%s", version, u.RawQuery)
+	}
 
 	if address == "" {
+		isUnix := strings.HasPrefix(options.Host, unixScheme)
+
 		switch true {
 		case options.Host == "":
 			return nil, fmt.Errorf("parameter Host is required")
-		case options.Port == 0:
+		case options.Port == 0 && !isUnix:
 			return nil, fmt.Errorf("parameter Port is required")
 		case options.Namespace == "":
 			return nil, fmt.Errorf("parameter Namespace is required")
 		}
 
 		s := ""
-		if options.Secure {
+		if options.Secure || options.TLSConfig != nil {
 			s = "s"
 		}
 
@@ -67,13 +95,58 @@ func MakeClient(options ConnectionOptions) (*LocktopusClient, error) {
 			values.Set(constants.AbandonTimeoutQueryParameterName, fmt.Sprintf("%d", *options.ForceCloseTimeoutMs))
 		}
 
-		fmt.Sprintln(values.Encode())
+		if options.PendingCloseTimeoutMs != nil {
+			values.Set(constants.PendingAbandonTimeoutQueryParameterName, fmt.Sprintf("%d", *options.PendingCloseTimeoutMs))
+		}
+
+		if options.ResumeSession != "" {
+			values.Set(constants.ResumeQueryParameterName, options.ResumeSession)
+		}
 
-		address = fmt.Sprintf("ws%s://%s:%d/%s?%s", s, options.Host, options.Port, version, values.Encode())
+		if options.NotifyContention {
+			values.Set(constants.NotifyContentionQueryParameterName, "true")
+		}
+
+		if isUnix {
+			dialer = unixSocketDialer(strings.TrimPrefix(options.Host, unixScheme))
+			address = fmt.Sprintf("ws%s://localhost/%s?%s", s, version, values.Encode())
+		} else {
+			address = fmt.Sprintf("ws%s://%s:%d/%s?%s", s, options.Host, options.Port, version, values.Encode())
+		}
+	}
+
+	if options.TLSConfig != nil {
+		d := *dialer
+		d.TLSClientConfig = options.TLSConfig
+		dialer = &d
 	}
 
-	conn, r, err := websocket.DefaultDialer.Dial(address, nil)
+	var header http.Header
+	if options.Token != "" {
+		header = http.Header{"Authorization": []string{"Bearer " + options.Token}}
+	}
+
+	configured := address
+
+	if routed {
+		namespace := options.Namespace
+		if u, err := url.Parse(address); err == nil && options.Url != "" {
+			namespace = u.Query().Get(constants.NamespaceQueryParameterName)
+		}
+
+		address = routeAddress(address, namespace)
+	}
+
+	conn, r, err := dial(dialer, address, header, func(r *http.Response) {
+		if routed {
+			refreshShardMap(configured, r, header, options.TLSConfig)
+		}
+	})
 	if err != nil {
+		if r == nil {
+			return nil, fmt.Errorf("cannot connect: %w", err)
+		}
+
 		body, readErr := ioutil.ReadAll(r.Body)
 		if readErr != nil {
 			err = fmt.Errorf("cannot read response body after handshake error: %w", err)
@@ -92,10 +165,37 @@ func MakeClient(options ConnectionOptions) (*LocktopusClient, error) {
 	go lc.readResponses(lc.responses)
 
 	lc.released = make(chan struct{}, 1)
+	lc.revoked = make(chan struct{}, 1)
+	lc.contended = make(chan Contention, 1)
+
+	if options.ResumeSession != "" {
+		// the server starts a resumed session with the state of the lock
+		res := <-lc.responses
+		if res.err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("cannot read resumed lock: %w", res.err)
+		}
+
+		lc.lockID = res.data.ID
+		lc.session = res.data.Session
+		lc.acquired.Store(res.data.State == "acquired")
+	}
 
 	return &lc, nil
 }
 
+const unixScheme = "unix://"
+
+func unixSocketDialer(path string) *websocket.Dialer {
+	dialer := *websocket.DefaultDialer
+
+	dialer.NetDial = func(network, addr string) (net.Conn, error) {
+		return net.Dial("unix", path)
+	}
+
+	return &dialer
+}
+
 const closeMessage = "close"
 
 func (c *LocktopusClient) Close() error {
@@ -117,6 +217,20 @@ func (c *LocktopusClient) AddLockResource(lockType LockType, resources ...string
 	})
 }
 
+// SetLockOwner sets the owner of the locks made by next Lock() calls. It is shown in the server logs, admin API and audit log.
+func (c *LocktopusClient) SetLockOwner(owner string) {
+	c.owner = owner
+}
+
+// SetLockLabel sets a label (e.g. job name, host or trace ID) of the locks made by next Lock() calls. Server statistics can be aggregated by a label.
+func (c *LocktopusClient) SetLockLabel(name, value string) {
+	if c.labels == nil {
+		c.labels = make(map[string]string)
+	}
+
+	c.labels[name] = value
+}
+
 // Lock locks added resources. Use IsAcquired() to check if lock has been acquired.
 func (c *LocktopusClient) Lock() (err error) {
 	select {
@@ -124,10 +238,22 @@ func (c *LocktopusClient) Lock() (err error) {
 	default:
 	}
 
+	select {
+	case <-c.revoked:
+	default:
+	}
+
+	select {
+	case <-c.contended:
+	default:
+	}
+
 	var response responseMessage
 	msg := requestMessage{
 		Action:    actionLock,
 		Resources: c.lr,
+		Owner:     c.owner,
+		Labels:    c.labels,
 	}
 
 	err = c.conn.WriteJSON(msg)
@@ -152,6 +278,7 @@ func (c *LocktopusClient) Lock() (err error) {
 
 	c.acquired.Store(response.State == "acquired")
 	c.lockID = response.ID
+	c.session = response.Session
 
 	return nil
 }
@@ -165,8 +292,33 @@ func (c *LocktopusClient) LockID() string {
 	return c.lockID
 }
 
+// Session returns the key for resuming the lock after server restart (see ConnectionOptions.ResumeSession).
+// It is empty unless the namespace is durable.
+func (c *LocktopusClient) Session() string {
+	return c.session
+}
+
 var ErrReleasedBeforeAcquired = errors.New("cannot release lock before it has been locked")
 var ErrUnexpectedResponse = errors.New("unexpected response")
+var ErrRevoked = errors.New("lock has been revoked by the server")
+
+// Revoked returns a channel that receives a value when the lock is released by the server administrator.
+// Release() should still be called before the next Lock().
+func (c *LocktopusClient) Revoked() <-chan struct{} {
+	return c.revoked
+}
+
+// Contention describes the locks waiting for the acquired lock
+type Contention struct {
+	Waiters int        // number of locks that have been blocked on the lock since it was acquired
+	Paths   [][]string // paths where they conflict with the lock's resources
+}
+
+// Contended returns a channel that receives the current Contention whenever another lock becomes blocked on the acquired lock,
+// so a holder that can yield may release early. Requires ConnectionOptions.NotifyContention. Only the latest Contention is kept if the channel is not read in time.
+func (c *LocktopusClient) Contended() <-chan Contention {
+	return c.contended
+}
 
 // Acquire is used to wait until the lock is acquired. If IsAcquired() returns true after calling Lock(), calling Acquire() is no-op.
 func (c *LocktopusClient) Acquire() (err error) {
@@ -181,6 +333,8 @@ func (c *LocktopusClient) Acquire() (err error) {
 	case res = <-c.responses:
 	case <-c.released:
 		return ErrReleasedBeforeAcquired
+	case <-c.revoked:
+		return ErrRevoked
 	}
 
 	response = res.data
@@ -267,6 +421,31 @@ func (c *LocktopusClient) readResponses(ch chan<- result) {
 			err = fmt.Errorf("cannot read JSON message: %s", err)
 		}
 
+		if err == nil && response.Action == actionRevoked {
+			c.acquired.Store(false)
+
+			select {
+			case c.revoked <- struct{}{}:
+			default:
+			}
+
+			continue
+		}
+
+		if err == nil && response.Action == actionContended {
+			select {
+			case <-c.contended:
+			default:
+			}
+
+			select {
+			case c.contended <- Contention{Waiters: response.Waiters, Paths: response.Paths}:
+			default:
+			}
+
+			continue
+		}
+
 		ch <- result{
 			data: response,
 			err:  err,
@@ -277,3 +456,58 @@ func (c *LocktopusClient) readResponses(ch chan<- result) {
 		}
 	}
 }
+
+// maxRedirects limits following redirects to cluster leaders and shard owners
+const maxRedirects = 3
+
+// dial follows redirects of the handshake, e.g. from a cluster follower to the leader or to the server owning the namespace.
+// onRedirect is called with each redirect response. Like net/http, the Authorization header is not sent to another host or port,
+// and redirects from wss:// to ws:// are refused
+func dial(dialer *websocket.Dialer, address string, header http.Header, onRedirect func(r *http.Response)) (*websocket.Conn, *http.Response, error) {
+	for i := 0; ; i++ {
+		conn, r, err := dialer.Dial(address, header)
+		if err == nil || r == nil || i == maxRedirects {
+			return conn, r, err
+		}
+
+		if r.StatusCode != http.StatusTemporaryRedirect && r.StatusCode != http.StatusPermanentRedirect {
+			return conn, r, err
+		}
+
+		current, parseErr := url.Parse(address)
+		if parseErr != nil {
+			return conn, r, err
+		}
+
+		location, parseErr := r.Location()
+		if parseErr != nil {
+			return conn, r, err
+		}
+
+		location = current.ResolveReference(location)
+		location.Scheme = wsScheme(location.Scheme)
+
+		if current.Scheme == "wss" && location.Scheme != "wss" {
+			return nil, nil, fmt.Errorf("refused redirect from %s to insecure %s", origin(current), origin(location))
+		}
+
+		onRedirect(r)
+
+		if !strings.EqualFold(location.Hostname(), current.Hostname()) || location.Port() != current.Port() {
+			header = withoutAuthorization(header)
+		}
+
+		address = location.String()
+	}
+}
+
+func withoutAuthorization(header http.Header) http.Header {
+	if header.Get("Authorization") == "" {
+		return header
+	}
+
+	h := header.Clone()
+	h.Del("Authorization")
+
+	return h
+}
@@ -0,0 +1,131 @@
+package client
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/locktopus-project/locktopus/internal/constants"
+	"github.com/locktopus-project/locktopus/pkg/shardmap"
+)
+
+// Sharded servers redirect clients to the server owning the namespace. The client then fetches the shard map from the server it was
+// configured with and connects to the owning servers directly afterwards. The map is fetched again when a redirect shows a newer version.
+
+const shardMapFetchTimeout = 5 * time.Second
+
+// shardMaps are cached by the origin (scheme and host) of the configured server
+var shardMaps = make(map[string]*shardmap.Map)
+var shardMapsMx = sync.Mutex{}
+
+// routeAddress replaces the host of the WebSocket address with the one of the server owning the namespace, if the map is known
+func routeAddress(address string, namespace string) string {
+	u, err := url.Parse(address)
+	if err != nil {
+		return address
+	}
+
+	shardMapsMx.Lock()
+	m := shardMaps[origin(u)]
+	shardMapsMx.Unlock()
+
+	if m == nil {
+		return address
+	}
+
+	owner, err := url.Parse(m.Locate(namespace).URL)
+	if err != nil {
+		return address
+	}
+
+	u.Scheme = wsScheme(owner.Scheme)
+	u.Host = owner.Host
+
+	return u.String()
+}
+
+// refreshShardMap fetches the map from the configured server if the redirect shows a newer version than the cached one
+func refreshShardMap(address string, r *http.Response, header http.Header, tlsConfig *tls.Config) {
+	version, err := strconv.ParseInt(r.Header.Get(constants.ShardMapVersionHeaderName), 10, 64)
+	if err != nil {
+		return
+	}
+
+	u, err := url.Parse(address)
+	if err != nil {
+		return
+	}
+
+	key := origin(u)
+
+	shardMapsMx.Lock()
+	cached := shardMaps[key]
+	shardMapsMx.Unlock()
+
+	if cached != nil && cached.Version >= version {
+		return
+	}
+
+	m, err := fetchShardMap(httpScheme(u.Scheme)+"://"+u.Host+constants.ShardMapPath, header, tlsConfig)
+	if err != nil {
+		// redirects keep working without the map
+		return
+	}
+
+	shardMapsMx.Lock()
+	if cached = shardMaps[key]; cached == nil || cached.Version < m.Version {
+		shardMaps[key] = m
+	}
+	shardMapsMx.Unlock()
+}
+
+func fetchShardMap(address string, header http.Header, tlsConfig *tls.Config) (*shardmap.Map, error) {
+	req, err := http.NewRequest(http.MethodGet, address, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	client := &http.Client{Timeout: shardMapFetchTimeout}
+	if tlsConfig != nil {
+		client.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("cannot fetch shard map: %s: %s", res.Status, body)
+	}
+
+	return shardmap.Parse(body)
+}
+
+func origin(u *url.URL) string {
+	return httpScheme(u.Scheme) + "://" + u.Host
+}
+
+func httpScheme(scheme string) string {
+	return strings.Replace(scheme, "ws", "http", 1)
+}
+
+func wsScheme(scheme string) string {
+	return strings.Replace(scheme, "http", "ws", 1)
+}
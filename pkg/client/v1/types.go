@@ -5,13 +5,17 @@ package client
 type action string
 
 const (
-	actionLock    action = "lock"
-	actionRelease action = "release"
+	actionLock      action = "lock"
+	actionRelease   action = "release"
+	actionRevoked   action = "revoked"
+	actionContended action = "contended"
 )
 
 type requestMessage struct {
-	Action    action     `json:"action"`
-	Resources []resource `json:"resources,omitempty"`
+	Action    action            `json:"action"`
+	Resources []resource        `json:"resources,omitempty"`
+	Owner     string            `json:"owner,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
 }
 
 type resource struct {
@@ -20,7 +24,10 @@ type resource struct {
 }
 
 type responseMessage struct {
-	ID     string `json:"id"`
-	Action action `json:"action"`
-	State  string `json:"state"`
+	ID      string     `json:"id"`
+	Action  action     `json:"action"`
+	State   string     `json:"state"`
+	Session string     `json:"session,omitempty"`
+	Waiters int        `json:"waiters,omitempty"`
+	Paths   [][]string `json:"paths,omitempty"`
 }
@@ -1,9 +1,34 @@
 package multilocker
 
+import "sync"
+
+// Metadata describes the holder of a group, e.g. the job name, host or trace ID. MultiLocker does not interpret it.
+type Metadata struct {
+	Owner  string
+	Labels map[string]string
+}
+
 type Lock struct {
-	ch chan struct{}
-	u  *Unlocker
-	id int64
+	ch       chan struct{}
+	u        *Unlocker
+	id       int64
+	metadata Metadata
+
+	mx        sync.Mutex
+	ready     bool
+	cancelled bool
+	cancel    chan struct{} // closed by Cancel()
+	dropped   chan struct{} // closed when the resources of the cancelled group have been released
+
+	contention     Contention
+	contendedPaths map[string]struct{}
+	contended      chan Contention
+}
+
+// Contention describes the groups blocked on an acquired group
+type Contention struct {
+	Waiters int        // number of groups that have been blocked on the group since it was acquired
+	Paths   [][]string // paths where the waiters conflict with the group's resources: a path of the group or the waiter containing the other one
 }
 
 // Acquire waits until Lock is acquired and returns corresponding Unlocker.
@@ -22,14 +47,95 @@ func (l *Lock) Ready() <-chan struct{} {
 	return l.ch
 }
 
+// Cancelled returns chan that is closed when the lock is cancelled. A cancelled lock never gets ready,
+// so select on both channels to wait for a lock that may be cancelled.
+func (l *Lock) Cancelled() <-chan struct{} {
+	return l.cancel
+}
+
 // ID returns unique incremental ID of the group within the LockSpace instance
 func (l *Lock) ID() int64 {
 	return l.id
 }
 
-func (l *Lock) makeReady(u *Unlocker) {
+// Metadata returns the metadata the group has been locked with
+func (l *Lock) Metadata() Metadata {
+	return l.metadata
+}
+
+// Contended returns chan that receives the current Contention whenever a new group becomes blocked on the acquired group.
+// Only the latest Contention is kept if the chan is not read in time, so the receiver always gets the up-to-date state.
+func (l *Lock) Contended() <-chan Contention {
+	return l.contended
+}
+
+// Cancel gives up the lock if it has not been acquired yet: each resource of the group is released as soon as it is granted,
+// so the group does not hold some resources while waiting for the others. It returns false if the lock has already been acquired, in which case it should be unlocked as usual.
+// Do not call Acquire() or Unlock() after the lock has been cancelled.
+func (l *Lock) Cancel() bool {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	if l.ready {
+		return false
+	}
+
+	if !l.cancelled {
+		l.cancelled = true
+		close(l.cancel)
+	}
+
+	return true
+}
+
+func (l *Lock) isReady() bool {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	return l.ready
+}
+
+// contend counts a new waiter blocked on the paths (keyed by the concatenated tokens) and replaces the unread Contention
+func (l *Lock) contend(paths map[string][]string) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	l.contention.Waiters++
+
+	for key, path := range paths {
+		if _, ok := l.contendedPaths[key]; ok {
+			continue
+		}
+
+		l.contendedPaths[key] = struct{}{}
+		l.contention.Paths = append(l.contention.Paths, path)
+	}
+
+	c := l.contention
+	c.Paths = append([][]string(nil), l.contention.Paths...)
+
+	select {
+	case <-l.contended:
+	default:
+	}
+
+	l.contended <- c
+}
+
+// makeReady returns false if the lock has been cancelled
+func (l *Lock) makeReady(u *Unlocker) bool {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	if l.cancelled {
+		return false
+	}
+
+	l.ready = true
 	l.u = u
 	close(l.ch)
+
+	return true
 }
 
 // Unlocker is used to release the lock acquired by Lock().
@@ -4,6 +4,7 @@
 package multilocker
 
 import (
+	"fmt"
 	"sync"
 	"sync/atomic"
 	"unsafe"
@@ -43,6 +44,7 @@ const (
 type lockRef struct {
 	t refType
 	v *dagLock.Vertex
+	l *Lock // group of the vertex
 }
 
 type MultiLocker struct {
@@ -56,6 +58,7 @@ type MultiLocker struct {
 	closed        int32
 	statistics    statistics
 	lastLockID    int64
+	groups        map[int64]*Lock // unreleased groups by ID
 }
 
 // MultilockerStatistics represents current (non-cumulative) state of MultiLocker
@@ -71,6 +74,12 @@ type MultilockerStatistics struct {
 	PathCount      int64 // number of unique paths requested. There is a refStack with lockRefs for each path. Initially, MultiLocker has PathCount = 1 (for the root segment)
 }
 
+// LabelStatistics represents current groups having a value of a label (see StatisticsByLabel)
+type LabelStatistics struct {
+	GroupsPending  int64
+	GroupsAcquired int64
+}
+
 type statistics struct {
 	groupsPending       int64
 	groupsAcquired      int64
@@ -87,6 +96,7 @@ func NewMultilocker() *MultiLocker {
 		garbage:       make(chan [][]token, garbageBufferSize),
 		activeLockers: &sync.WaitGroup{},
 		cleaned:       make(chan struct{}),
+		groups:        make(map[int64]*Lock),
 	}
 
 	multilocker.rootRef = multilocker.tokenizeSegments([]string{""})[0]
@@ -109,6 +119,21 @@ func (ml *MultiLocker) Close() {
 	<-ml.cleaned
 }
 
+// SetLastGroupID sets the sequence number of the last group, so the next group gets id + 1.
+// It is used to restore groups with their original IDs. The sequence cannot go backwards.
+func (ml *MultiLocker) SetLastGroupID(id int64) error {
+	ml.mx.Lock()
+	defer ml.mx.Unlock()
+
+	if id < ml.lastLockID {
+		return fmt.Errorf("group id %d is less than the last one (%d)", id, ml.lastLockID)
+	}
+
+	ml.lastLockID = id
+
+	return nil
+}
+
 func (ml *MultiLocker) Statistics() MultilockerStatistics {
 	ml.mx.Lock()
 	defer ml.mx.Unlock()
@@ -132,10 +157,46 @@ func (ml *MultiLocker) Statistics() MultilockerStatistics {
 	return s
 }
 
+// StatisticsByLabel counts the groups by the values of the label. Groups without the label are counted under "". Cancelled groups are not counted.
+func (ml *MultiLocker) StatisticsByLabel(label string) map[string]LabelStatistics {
+	ml.mx.Lock()
+	defer ml.mx.Unlock()
+
+	stats := make(map[string]LabelStatistics)
+
+	for _, l := range ml.groups {
+		l.mx.Lock()
+		ready, cancelled := l.ready, l.cancelled
+		l.mx.Unlock()
+
+		if cancelled {
+			continue
+		}
+
+		value := l.metadata.Labels[label]
+		s := stats[value]
+
+		if ready {
+			s.GroupsAcquired++
+		} else {
+			s.GroupsPending++
+		}
+
+		stats[value] = s
+	}
+
+	return stats
+}
+
 // Lock is used to atomically lock a slice of ResourceLock's.
 // The lock will be acquired as soon as there are no precedent resources whose locks interfere with this ones by path and lock types.
 // If unlocker is not provided, it is made internally. In any case, the returned Lock can be used to receive the reference unlocker.
 func (ml *MultiLocker) Lock(resourceLocks []ResourceLock, unlocker ...*Unlocker) *Lock {
+	return ml.LockWithMetadata(resourceLocks, Metadata{}, unlocker...)
+}
+
+// LockWithMetadata is like Lock, but the group carries the metadata (see Lock.Metadata). Do not modify the labels afterwards.
+func (ml *MultiLocker) LockWithMetadata(resourceLocks []ResourceLock, metadata Metadata, unlocker ...*Unlocker) *Lock {
 	ml.activeLockers.Add(1)
 
 	if atomic.LoadInt32(&ml.closed) > 0 {
@@ -152,16 +213,29 @@ func (ml *MultiLocker) Lock(resourceLocks []ResourceLock, unlocker ...*Unlocker)
 		u = NewUnlocker()
 	}
 
-	locker := ml.lockResources(resourceLocks, u)
+	locker := ml.lockResources(resourceLocks, metadata, u)
 
 	return locker
 }
 
-func (ml *MultiLocker) lockResources(lockGroup []ResourceLock, u *Unlocker) *Lock {
+func (ml *MultiLocker) lockResources(lockGroup []ResourceLock, metadata Metadata, u *Unlocker) *Lock {
 	ml.mx.Lock()
 
 	ml.lastLockID++
-	lockID := ml.lastLockID
+
+	l := &Lock{
+		u:        u,
+		ch:       make(chan struct{}, 1),
+		id:       ml.lastLockID,
+		metadata: metadata,
+		cancel:   make(chan struct{}),
+		dropped:  make(chan struct{}),
+
+		contendedPaths: make(map[string]struct{}),
+		contended:      make(chan Contention, 1),
+	}
+
+	ml.groups[l.id] = l
 
 	atomic.AddInt64(&ml.statistics.groupsPending, 1)
 
@@ -175,8 +249,12 @@ func (ml *MultiLocker) lockResources(lockGroup []ResourceLock, u *Unlocker) *Loc
 
 	buffer := newTokenBuffer(tokenBufferInitialSize)
 
+	// paths of the acquired groups this group is blocked on, by group
+	var contended map[*Lock]map[string][]string
+
 	for i, tokenRefs := range tokenRefGroup {
 		lockType := lockGroup[i].LockType
+		resourcePath := lockGroup[i].Path
 		vertex := dagLock.NewVertex(lockType)
 		vAdded := false
 
@@ -188,6 +266,7 @@ func (ml *MultiLocker) lockResources(lockGroup []ResourceLock, u *Unlocker) *Loc
 		for i := range tokenRefs {
 			path := concatTokenRefs(tokenRefs[:i+1], buffer)
 
+			segmentCount := i // path without the root segment
 			refType := tail
 			isHead := false
 			if i == len(tokenRefs)-1 {
@@ -202,7 +281,7 @@ func (ml *MultiLocker) lockResources(lockGroup []ResourceLock, u *Unlocker) *Loc
 					vAdded = true
 				}
 
-				ml.lockSurface[path] = []lockRef{{t: refType, v: vertex}}
+				ml.lockSurface[path] = []lockRef{{t: refType, v: vertex, l: l}}
 
 				atomic.AddInt64(&ml.statistics.lockrefCount, 1)
 
@@ -233,6 +312,18 @@ func (ml *MultiLocker) lockResources(lockGroup []ResourceLock, u *Unlocker) *Loc
 				if !refInGroup && (refIsHead || isHead) {
 					ref.v.AddChild(vertex)
 
+					if (refIsWrite || lockType == LockTypeWrite) && ref.v.LockState() == dagLock.LockedByClient && ref.l.isReady() {
+						if contended == nil {
+							contended = make(map[*Lock]map[string][]string)
+						}
+
+						if contended[ref.l] == nil {
+							contended[ref.l] = make(map[string][]string)
+						}
+
+						contended[ref.l][path] = resourcePath[:segmentCount]
+					}
+
 					if !vAdded {
 						groupVertexes.Add(vertex)
 						vAdded = true
@@ -268,7 +359,7 @@ func (ml *MultiLocker) lockResources(lockGroup []ResourceLock, u *Unlocker) *Loc
 				}
 
 				if replaceCurrent {
-					existingRefs[i] = lockRef{t: refType, v: vertex}
+					existingRefs[i] = lockRef{t: refType, v: vertex, l: l}
 					preventAppend = true
 
 					if !vAdded {
@@ -280,7 +371,7 @@ func (ml *MultiLocker) lockResources(lockGroup []ResourceLock, u *Unlocker) *Loc
 			}
 
 			if replaceAll {
-				ml.lockSurface[path] = []lockRef{{t: refType, v: vertex}}
+				ml.lockSurface[path] = []lockRef{{t: refType, v: vertex, l: l}}
 				atomic.AddInt64(&ml.statistics.lockrefCount, int64(1-len(existingRefs)))
 				if !vAdded {
 					groupVertexes.Add(vertex)
@@ -294,7 +385,7 @@ func (ml *MultiLocker) lockResources(lockGroup []ResourceLock, u *Unlocker) *Loc
 			}
 
 			atomic.AddInt64(&ml.statistics.lockrefCount, 1)
-			ml.lockSurface[path] = append(existingRefs, lockRef{t: refType, v: vertex})
+			ml.lockSurface[path] = append(existingRefs, lockRef{t: refType, v: vertex, l: l})
 			if !vAdded {
 				groupVertexes.Add(vertex)
 				vAdded = true
@@ -302,17 +393,15 @@ func (ml *MultiLocker) lockResources(lockGroup []ResourceLock, u *Unlocker) *Loc
 		}
 	}
 
+	for holder, paths := range contended {
+		holder.contend(paths)
+	}
+
 	ml.mx.Unlock()
 
 	vertexes := groupVertexes.GetAll()
 
-	go ml.handleUnlocker(u, vertexes, lockGroup, tokenRefGroup)
-
-	l := Lock{
-		u:  u,
-		ch: make(chan struct{}, 1),
-		id: lockID,
-	}
+	go ml.handleUnlocker(l, u, vertexes, lockGroup, tokenRefGroup)
 
 	atomic.AddInt64(&ml.statistics.pendingVertexCount, int64(len(vertexes)))
 
@@ -327,34 +416,91 @@ func (ml *MultiLocker) lockResources(lockGroup []ResourceLock, u *Unlocker) *Loc
 			continue
 		// Otherwise, return non-acquired lock and do the locking in the background
 		default:
+			go ml.acquireVertexes(l, u, vertexes, i, vertexLock)
 
-			go func() {
-				<-vertexLock
-				atomic.AddInt64(&ml.statistics.pendingVertexCount, -1)
-				atomic.AddInt64(&ml.statistics.acquiredVertexCount, 1)
+			return l
+		}
+	}
 
-				for _, v := range vertexes[i+1:] {
-					v.Lock()
-					atomic.AddInt64(&ml.statistics.pendingVertexCount, -1)
-					atomic.AddInt64(&ml.statistics.acquiredVertexCount, 1)
-				}
+	l.makeReady(u)
 
-				atomic.AddInt64(&ml.statistics.groupsPending, -1)
-				atomic.AddInt64(&ml.statistics.groupsAcquired, 1)
+	atomic.AddInt64(&ml.statistics.groupsPending, -1)
+	atomic.AddInt64(&ml.statistics.groupsAcquired, 1)
 
-				l.makeReady(u)
-			}()
+	return l
+}
 
-			return &l
+// acquireVertexes locks the vertexes of the group one by one, starting from vertexes[i] whose lock has been requested with vertexLock.
+// If the lock is cancelled meanwhile, the vertexes are dropped.
+func (ml *MultiLocker) acquireVertexes(l *Lock, u *Unlocker, vertexes []*dagLock.Vertex, i int, vertexLock <-chan struct{}) {
+	for {
+		select {
+		case <-vertexLock:
+		case <-l.cancel:
+			atomic.AddInt64(&ml.statistics.groupsPending, -1)
+			ml.dropVertexes(l, vertexes[:i], vertexes[i:], vertexLock)
+
+			return
 		}
-	}
 
-	l.makeReady(u)
+		atomic.AddInt64(&ml.statistics.pendingVertexCount, -1)
+		atomic.AddInt64(&ml.statistics.acquiredVertexCount, 1)
+
+		if i++; i == len(vertexes) {
+			break
+		}
+
+		vertexLock = vertexes[i].LockChan()
+	}
 
 	atomic.AddInt64(&ml.statistics.groupsPending, -1)
 	atomic.AddInt64(&ml.statistics.groupsAcquired, 1)
 
-	return &l
+	if !l.makeReady(u) {
+		atomic.AddInt64(&ml.statistics.groupsAcquired, -1)
+		ml.dropVertexes(l, vertexes, nil, nil)
+	}
+}
+
+// dropVertexes releases the vertexes of a cancelled group: the acquired ones right away and the pending ones as soon as they are acquired,
+// so the group does not block other groups any longer than necessary. The lock of pending[0] has been requested with firstLock.
+func (ml *MultiLocker) dropVertexes(l *Lock, acquired []*dagLock.Vertex, pending []*dagLock.Vertex, firstLock <-chan struct{}) {
+	ml.mx.Lock()
+
+	for _, v := range acquired {
+		v.Unlock()
+	}
+
+	ml.mx.Unlock()
+
+	atomic.AddInt64(&ml.statistics.acquiredVertexCount, -int64(len(acquired)))
+
+	wg := sync.WaitGroup{}
+
+	for i, v := range pending {
+		vertexLock := firstLock
+		if i > 0 {
+			vertexLock = v.LockChan()
+		}
+
+		wg.Add(1)
+
+		go func(v *dagLock.Vertex, vertexLock <-chan struct{}) {
+			defer wg.Done()
+
+			<-vertexLock
+
+			ml.mx.Lock()
+			v.Unlock()
+			ml.mx.Unlock()
+
+			atomic.AddInt64(&ml.statistics.pendingVertexCount, -1)
+		}(v, vertexLock)
+	}
+
+	wg.Wait()
+
+	close(l.dropped)
 }
 
 func (ml *MultiLocker) tokenizeSegments(segments []string) []token {
@@ -457,25 +603,37 @@ func (ml *MultiLocker) cleanPaths() {
 	ml.cleaned <- struct{}{}
 }
 
-func (ml *MultiLocker) handleUnlocker(u *Unlocker, vertexes []*dagLock.Vertex, resourceLocks []ResourceLock, tokenRefGroup [][]token) {
-	unlockCallback := <-u.ch
+// handleUnlocker unlocks the group when its unlocker is called and cleans its paths. Vertexes of a cancelled group have been unlocked by dropVertexes
+func (ml *MultiLocker) handleUnlocker(l *Lock, u *Unlocker, vertexes []*dagLock.Vertex, resourceLocks []ResourceLock, tokenRefGroup [][]token) {
+	var unlockCallback chan struct{}
+
+	select {
+	case unlockCallback = <-u.ch:
+	case <-l.dropped:
+	}
 
 	ml.mx.Lock()
 
+	delete(ml.groups, l.id)
+
 	vertexesInUse := make([]*dagLock.Vertex, 0)
 
 	for _, v := range vertexes {
-		v.Unlock()
+		if unlockCallback != nil {
+			v.Unlock()
+		}
 
 		if !v.Useless() {
 			vertexesInUse = append(vertexesInUse, v)
 		}
 	}
 
-	atomic.AddInt64(&ml.statistics.acquiredVertexCount, -int64(len(vertexes)))
-	atomic.AddInt64(&ml.statistics.groupsAcquired, -1)
+	if unlockCallback != nil {
+		atomic.AddInt64(&ml.statistics.acquiredVertexCount, -int64(len(vertexes)))
+		atomic.AddInt64(&ml.statistics.groupsAcquired, -1)
 
-	close(unlockCallback)
+		close(unlockCallback)
+	}
 
 	for _, l := range resourceLocks {
 		ml.releaseSegments(l.Path)
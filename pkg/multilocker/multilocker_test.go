@@ -4,6 +4,7 @@ import (
 	"reflect"
 	"sync"
 	"testing"
+	"time"
 
 	ml "github.com/locktopus-project/locktopus/pkg/multilocker"
 	sliceAppender "github.com/locktopus-project/locktopus/pkg/slice_appender"
@@ -948,3 +949,157 @@ func TestLock_Complex_4(t *testing.T) {
 
 	assertOrder(t, order.Value(), []int{1, 2, 3, 3, 3, 3, 4, 4, 4, 4})
 }
+
+func TestLock_SetLastGroupID(t *testing.T) {
+	m := ml.NewMultilocker()
+
+	lr := ml.NewResourceLock(ml.LockTypeRead, []string{"a"})
+
+	if err := m.SetLastGroupID(10); err != nil {
+		t.Fatalf("cannot set last group id: %s", err)
+	}
+
+	l := m.Lock([]ml.ResourceLock{lr})
+	if l.ID() != 11 {
+		t.Errorf("Group id is %d, expected 11", l.ID())
+	}
+
+	if err := m.SetLastGroupID(5); err == nil {
+		t.Error("Sequence should not go backwards")
+	}
+
+	l.Acquire().Unlock()
+}
+
+func TestLock_CancelPendingGroup(t *testing.T) {
+	m := ml.NewMultilocker()
+
+	a := ml.NewResourceLock(ml.LockTypeWrite, []string{"a"})
+	b := ml.NewResourceLock(ml.LockTypeWrite, []string{"b"})
+
+	l1 := m.Lock([]ml.ResourceLock{a})
+	l2 := m.Lock([]ml.ResourceLock{a, b})
+	l3 := m.Lock([]ml.ResourceLock{b})
+
+	assertLockIsWaiting(t, l2)
+	assertLockIsWaiting(t, l3)
+
+	select {
+	case <-l2.Cancelled():
+		t.Fatal("Lock should not be cancelled yet")
+	default:
+	}
+
+	if !l2.Cancel() {
+		t.Fatal("Pending lock should be cancelled")
+	}
+
+	select {
+	case <-l2.Cancelled():
+	default:
+		t.Fatal("Cancelled chan should be closed")
+	}
+
+	// l3 does not wait for l1 anymore
+	select {
+	case <-l3.Ready():
+	case <-time.After(time.Second):
+		t.Fatal("Lock should not wait for the cancelled group")
+	}
+
+	l4 := m.Lock([]ml.ResourceLock{a})
+	assertLockIsWaiting(t, l4)
+
+	l1.Acquire().Unlock()
+	l3.Acquire().Unlock()
+	l4.Acquire().Unlock()
+
+	if l4.Cancel() {
+		t.Error("Acquired lock should not be cancelled")
+	}
+
+	m.Close()
+
+	if s := m.Statistics(); s.GroupsPending != 0 || s.GroupsAcquired != 0 || s.LocksPending != 0 || s.LocksAcquired != 0 {
+		t.Errorf("Expected no groups and locks, got %+v", s)
+	}
+}
+
+func TestStatistics_ByLabel(t *testing.T) {
+	m := ml.NewMultilocker()
+
+	a := []ml.ResourceLock{ml.NewResourceLock(ml.LockTypeWrite, []string{"a"})}
+
+	l1 := m.LockWithMetadata(a, ml.Metadata{Owner: "worker-1", Labels: map[string]string{"job": "billing"}})
+	l2 := m.LockWithMetadata(a, ml.Metadata{Labels: map[string]string{"job": "billing"}})
+	l3 := m.Lock(a)
+
+	if l1.Metadata().Owner != "worker-1" || l1.Metadata().Labels["job"] != "billing" {
+		t.Errorf("Unexpected metadata: %+v", l1.Metadata())
+	}
+
+	expected := map[string]ml.LabelStatistics{"billing": {GroupsPending: 1, GroupsAcquired: 1}, "": {GroupsPending: 1}}
+	if s := m.StatisticsByLabel("job"); !reflect.DeepEqual(s, expected) {
+		t.Errorf("Statistics are %v, expected %v", s, expected)
+	}
+
+	l1.Acquire().Unlock()
+	l2.Acquire().Unlock()
+	l3.Acquire().Unlock()
+
+	m.Close()
+
+	if s := m.StatisticsByLabel("job"); len(s) != 0 {
+		t.Errorf("Expected no groups, got %v", s)
+	}
+}
+
+func TestLock_Contended(t *testing.T) {
+	m := ml.NewMultilocker()
+
+	ab := ml.NewResourceLock(ml.LockTypeWrite, []string{"a", "b"})
+	readA := ml.NewResourceLock(ml.LockTypeRead, []string{"a"})
+	c := ml.NewResourceLock(ml.LockTypeRead, []string{"c"})
+
+	l1 := m.Lock([]ml.ResourceLock{ab, c})
+
+	// does not conflict with l1
+	l2 := m.Lock([]ml.ResourceLock{c})
+
+	select {
+	case <-l2.Ready():
+	default:
+		t.Fatal("Read lock of c should be acquired")
+	}
+
+	select {
+	case contention := <-l1.Contended():
+		t.Fatalf("Non-conflicting group should not contend, got %+v", contention)
+	default:
+	}
+
+	l3 := m.Lock([]ml.ResourceLock{readA})
+	assertLockIsWaiting(t, l3)
+
+	l4 := m.Lock([]ml.ResourceLock{ab})
+	assertLockIsWaiting(t, l4)
+
+	// only the latest contention is kept
+	expected := ml.Contention{Waiters: 2, Paths: [][]string{{"a"}, {"a", "b"}}}
+	if contention := <-l1.Contended(); !reflect.DeepEqual(contention, expected) {
+		t.Errorf("Contention is %+v, expected %+v", contention, expected)
+	}
+
+	select {
+	case contention := <-l3.Contended():
+		t.Fatalf("Waiting group should not be notified, got %+v", contention)
+	default:
+	}
+
+	l1.Acquire().Unlock()
+	l2.Acquire().Unlock()
+	l3.Acquire().Unlock()
+	l4.Acquire().Unlock()
+
+	m.Close()
+}
@@ -0,0 +1,140 @@
+package raft
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// HTTPPathPrefix is where the handler returned by NewHTTPHandler is expected to be mounted
+const HTTPPathPrefix = "/raft"
+
+const votePath = HTTPPathPrefix + "/vote"
+const appendPath = HTTPPathPrefix + "/append"
+const snapshotPath = HTTPPathPrefix + "/snapshot"
+
+// HTTPTransport sends requests as JSON over HTTP to the handlers of the peers
+type HTTPTransport struct {
+	addresses map[string]string // base URLs of the peers by ID, e.g. http://10.0.0.2:9009
+	secret    string
+	client    *http.Client
+}
+
+// NewHTTPTransport returns a transport for the peers with the given base URLs. If secret is provided, it is sent as a bearer token.
+func NewHTTPTransport(addresses map[string]string, secret string, client *http.Client) *HTTPTransport {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &HTTPTransport{addresses: addresses, secret: secret, client: client}
+}
+
+func (t *HTTPTransport) RequestVote(ctx context.Context, peer string, req VoteRequest) (VoteResponse, error) {
+	resp := VoteResponse{}
+	err := t.post(ctx, peer, votePath, req, &resp)
+
+	return resp, err
+}
+
+func (t *HTTPTransport) AppendEntries(ctx context.Context, peer string, req AppendRequest) (AppendResponse, error) {
+	resp := AppendResponse{}
+	err := t.post(ctx, peer, appendPath, req, &resp)
+
+	return resp, err
+}
+
+func (t *HTTPTransport) InstallSnapshot(ctx context.Context, peer string, req SnapshotRequest) (SnapshotResponse, error) {
+	resp := SnapshotResponse{}
+	err := t.post(ctx, peer, snapshotPath, req, &resp)
+
+	return resp, err
+}
+
+func (t *HTTPTransport) post(ctx context.Context, peer, path string, body, response interface{}) error {
+	address, ok := t.addresses[peer]
+	if !ok {
+		return fmt.Errorf("%w: unknown peer %s", ErrUnreachable, peer)
+	}
+
+	serialized, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(address, "/")+path, bytes.NewReader(serialized))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	if t.secret != "" {
+		req.Header.Set("Authorization", "Bearer "+t.secret)
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrUnreachable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s answered %d", ErrUnreachable, peer, resp.StatusCode)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(response)
+}
+
+// NewHTTPHandler serves the requests of HTTPTransport. If secret is provided, requests must present it as a bearer token.
+func NewHTTPHandler(n *Node, secret string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		if secret != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+secret)) != 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var response interface{}
+
+		switch r.URL.Path {
+		case votePath:
+			req := VoteRequest{}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			response = n.HandleRequestVote(req)
+		case appendPath:
+			req := AppendRequest{}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			response = n.HandleAppendEntries(req)
+		case snapshotPath:
+			req := SnapshotRequest{}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			response = n.HandleInstallSnapshot(req)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	})
+}
@@ -0,0 +1,951 @@
+// Package raft implements the Raft consensus algorithm: leader election and log replication among a fixed set of nodes.
+// Use NewNode() to create a node, Start() to run it and Stop() to finish the goroutines it spawns.
+// If Config.Snapshot is provided, the log is compacted into snapshots of the state machine, and lagging followers are sent the snapshot.
+// Membership changes are not supported.
+
+package raft
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+var ErrNotLeader = errors.New("node is not the leader")
+var ErrStopped = errors.New("node is stopped")
+
+const defaultElectionTimeout = time.Second
+
+const defaultSnapshotEntries = 1024
+
+// maxEntriesPerRequest limits the size of AppendEntries requests sent to lagging followers
+const maxEntriesPerRequest = 256
+
+// tickInterval is how often timeouts are checked
+const tickInterval = 10 * time.Millisecond
+
+type role int8
+
+const (
+	roleFollower  role = iota
+	roleCandidate role = iota
+	roleLeader    role = iota
+)
+
+var roleNames = []string{"follower", "candidate", "leader"}
+
+func (r role) String() string {
+	return roleNames[r]
+}
+
+// Entry is a record of the replicated log. Entries without Data are appended by new leaders and are not applied.
+type Entry struct {
+	Index uint64 `json:"index"`
+	Term  uint64 `json:"term"`
+	Data  []byte `json:"data,omitempty"`
+}
+
+// Snapshot is the state of the state machine after applying the entries up to Index
+type Snapshot struct {
+	Index uint64 `json:"index"`
+	Term  uint64 `json:"term"` // the term of the entry at Index
+	Data  []byte `json:"data"`
+}
+
+type Config struct {
+	ID                string
+	Peers             []string // IDs of all the nodes of the cluster, including ID
+	Transport         Transport
+	Storage           Storage       // MemoryStorage is used if not provided
+	Apply             func([]byte)  // called for committed entries in the log order, from a single goroutine
+	Snapshot          func() []byte // returns the state of the state machine. It is called from the goroutine calling Apply. Nil result skips the compaction
+	Restore           func([]byte)  // replaces the state of the state machine with a snapshot. It is called from the goroutine calling Apply, or by NewNode
+	SnapshotEntries   uint64        // the number of applied entries after which the log is compacted, 1024 by default
+	ElectionTimeout   time.Duration
+	HeartbeatInterval time.Duration // ElectionTimeout / 10 by default
+}
+
+// Status represents the current state of the node
+type Status struct {
+	ID            string `json:"id"`
+	Role          string `json:"role"`
+	Term          uint64 `json:"term"`
+	Leader        string `json:"leader"` // empty if the leader is unknown
+	Ready         bool   `json:"ready"`  // the node is the leader and has applied all the entries committed before its term
+	CommitIndex   uint64 `json:"commitIndex"`
+	LastIndex     uint64 `json:"lastIndex"`
+	SnapshotIndex uint64 `json:"snapshotIndex"`
+}
+
+type proposal struct {
+	term uint64
+	done chan error
+}
+
+type Node struct {
+	mx       sync.Mutex
+	id       string
+	peers    []string // other nodes
+	config   Config
+	storage  Storage
+	role     role
+	term     uint64
+	votedFor string
+	leader   string
+	log      []Entry // log[0] is a sentinel with the index and the term of the snapshot, so log[i].Index == snapshot.Index+i
+	snapshot Snapshot
+	restore  *Snapshot // the snapshot installed by the leader, until it is restored by applyCommitted
+
+	commitIndex uint64
+	lastApplied uint64
+	readyTerm   uint64 // the term in which the leader applied its first entry
+
+	electionDeadline time.Time
+	nextIndex        map[string]uint64
+	matchIndex       map[string]uint64
+	lastAck          map[string]time.Time // when followers last answered the leader
+	replicate        map[string]chan struct{}
+	pending          map[uint64]proposal
+
+	applyCh   chan struct{}
+	changed   chan struct{}
+	stopCh    chan struct{}
+	stopped   bool
+	waitGroup sync.WaitGroup
+}
+
+func NewNode(config Config) (*Node, error) {
+	if config.ID == "" {
+		return nil, errors.New("node ID is required")
+	}
+
+	if config.Transport == nil {
+		return nil, errors.New("transport is required")
+	}
+
+	if config.ElectionTimeout <= 0 {
+		config.ElectionTimeout = defaultElectionTimeout
+	}
+
+	if config.HeartbeatInterval <= 0 {
+		config.HeartbeatInterval = config.ElectionTimeout / 10
+	}
+
+	if config.Storage == nil {
+		config.Storage = NewMemoryStorage()
+	}
+
+	if config.SnapshotEntries == 0 {
+		config.SnapshotEntries = defaultSnapshotEntries
+	}
+
+	if config.Snapshot != nil && config.Restore == nil {
+		return nil, errors.New("restore is required to make snapshots")
+	}
+
+	n := &Node{
+		id:      config.ID,
+		config:  config,
+		storage: config.Storage,
+		log:     []Entry{{}},
+		pending: make(map[uint64]proposal),
+		applyCh: make(chan struct{}, 1),
+		changed: make(chan struct{}, 1),
+		stopCh:  make(chan struct{}),
+	}
+
+	member := false
+	for _, p := range config.Peers {
+		if p == config.ID {
+			member = true
+			continue
+		}
+
+		n.peers = append(n.peers, p)
+	}
+
+	if !member {
+		return nil, errors.New("peers should include the node itself")
+	}
+
+	hs, snapshot, entries, err := n.storage.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	n.term = hs.Term
+	n.votedFor = hs.VotedFor
+
+	if snapshot.Index > 0 {
+		if config.Restore == nil {
+			return nil, errors.New("storage has a snapshot, but restore is not provided")
+		}
+
+		config.Restore(snapshot.Data)
+
+		n.snapshot = snapshot
+		n.log[0] = Entry{Index: snapshot.Index, Term: snapshot.Term}
+		n.commitIndex = snapshot.Index
+		n.lastApplied = snapshot.Index
+	}
+
+	n.log = append(n.log, entries...)
+
+	return n, nil
+}
+
+// Start runs the node as a follower
+func (n *Node) Start() {
+	n.mx.Lock()
+	n.resetElectionDeadline()
+	n.mx.Unlock()
+
+	n.waitGroup.Add(2)
+	go n.tick()
+	go n.applyCommitted()
+}
+
+// Stop finishes the goroutines of the node. Pending proposals fail with ErrStopped
+func (n *Node) Stop() {
+	n.mx.Lock()
+	if n.stopped {
+		n.mx.Unlock()
+		return
+	}
+
+	n.stopped = true
+	close(n.stopCh)
+	n.failPending(ErrStopped)
+	n.mx.Unlock()
+
+	n.waitGroup.Wait()
+	close(n.changed)
+}
+
+// Changed signals changes of the role, term or readiness of the node. The channel is closed when the node is stopped.
+// Signals are coalesced, so Status() should be used to get the current state.
+func (n *Node) Changed() <-chan struct{} {
+	return n.changed
+}
+
+func (n *Node) Status() Status {
+	n.mx.Lock()
+	defer n.mx.Unlock()
+
+	return Status{
+		ID:            n.id,
+		Role:          n.role.String(),
+		Term:          n.term,
+		Leader:        n.leader,
+		Ready:         n.isReady(),
+		CommitIndex:   n.commitIndex,
+		LastIndex:     n.lastIndex(),
+		SnapshotIndex: n.snapshot.Index,
+	}
+}
+
+// Propose appends data to the log and waits until it is committed and applied on this node.
+// ErrNotLeader is returned if the node is not the leader or loses leadership meanwhile.
+// The entry may still be committed by the next leader in the latter case.
+func (n *Node) Propose(ctx context.Context, data []byte) error {
+	if len(data) == 0 {
+		return errors.New("data is empty")
+	}
+
+	n.mx.Lock()
+
+	if n.stopped {
+		n.mx.Unlock()
+		return ErrStopped
+	}
+
+	if n.role != roleLeader {
+		n.mx.Unlock()
+		return ErrNotLeader
+	}
+
+	index, err := n.appendEntry(data)
+	if err != nil {
+		n.mx.Unlock()
+		return err
+	}
+
+	done := make(chan error, 1)
+	n.pending[index] = proposal{term: n.term, done: done}
+	n.mx.Unlock()
+
+	select {
+	case err = <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (n *Node) tick() {
+	defer n.waitGroup.Done()
+
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-n.stopCh:
+			return
+		case <-ticker.C:
+		}
+
+		n.mx.Lock()
+
+		switch {
+		case n.role == roleLeader && !n.hasQuorum():
+			// a leader cut off from the majority steps down, so it stops serving clients before a new leader is elected
+			n.becomeFollower(n.term, "")
+		case n.role != roleLeader && time.Now().After(n.electionDeadline):
+			n.startElection()
+		}
+
+		n.mx.Unlock()
+	}
+}
+
+// hasQuorum returns true if the majority has answered within the election timeout. It must be called with mx locked
+func (n *Node) hasQuorum() bool {
+	acks := 1
+	for _, p := range n.peers {
+		if time.Since(n.lastAck[p]) < n.config.ElectionTimeout {
+			acks++
+		}
+	}
+
+	return acks >= n.quorum()
+}
+
+func (n *Node) quorum() int {
+	return (len(n.peers)+1)/2 + 1
+}
+
+func (n *Node) resetElectionDeadline() {
+	timeout := n.config.ElectionTimeout + time.Duration(rand.Int63n(int64(n.config.ElectionTimeout)))
+	n.electionDeadline = time.Now().Add(timeout)
+}
+
+// startElection must be called with mx locked
+func (n *Node) startElection() {
+	n.role = roleCandidate
+	n.term++
+	n.votedFor = n.id
+	n.leader = ""
+	n.resetElectionDeadline()
+	n.notify()
+
+	if err := n.saveHardState(); err != nil {
+		return
+	}
+
+	if len(n.peers) == 0 {
+		n.becomeLeader()
+		return
+	}
+
+	req := VoteRequest{
+		Term:         n.term,
+		Candidate:    n.id,
+		LastLogIndex: n.lastIndex(),
+		LastLogTerm:  n.lastTerm(),
+	}
+
+	votes := 1
+
+	for _, p := range n.peers {
+		go func(peer string) {
+			ctx, cancel := context.WithTimeout(context.Background(), n.config.ElectionTimeout)
+			defer cancel()
+
+			resp, err := n.config.Transport.RequestVote(ctx, peer, req)
+			if err != nil {
+				return
+			}
+
+			n.mx.Lock()
+			defer n.mx.Unlock()
+
+			if n.stopped {
+				return
+			}
+
+			if resp.Term > n.term {
+				n.becomeFollower(resp.Term, "")
+				return
+			}
+
+			if n.role != roleCandidate || n.term != req.Term || !resp.Granted {
+				return
+			}
+
+			votes++
+			if votes >= n.quorum() {
+				n.becomeLeader()
+			}
+		}(p)
+	}
+}
+
+// becomeLeader must be called with mx locked
+func (n *Node) becomeLeader() {
+	n.role = roleLeader
+	n.leader = n.id
+	n.nextIndex = make(map[string]uint64)
+	n.matchIndex = make(map[string]uint64)
+	n.lastAck = make(map[string]time.Time)
+	n.replicate = make(map[string]chan struct{})
+
+	now := time.Now()
+
+	for _, p := range n.peers {
+		n.nextIndex[p] = n.lastIndex() + 1
+		// followers are given the election timeout to answer before the leader steps down
+		n.lastAck[p] = now
+		n.replicate[p] = make(chan struct{}, 1)
+
+		n.waitGroup.Add(1)
+		go n.replicateTo(p, n.term, n.replicate[p])
+	}
+
+	n.notify()
+
+	// entries of previous terms are committed along with the first entry of the new term
+	n.appendEntry(nil)
+}
+
+// becomeFollower must be called with mx locked
+func (n *Node) becomeFollower(term uint64, leader string) {
+	if n.role == roleLeader {
+		n.failPending(ErrNotLeader)
+	}
+
+	changed := n.role != roleFollower || n.term != term || n.leader != leader
+
+	if term != n.term {
+		n.term = term
+		n.votedFor = ""
+		n.saveHardState()
+	}
+
+	n.role = roleFollower
+	n.leader = leader
+	n.resetElectionDeadline()
+
+	if changed {
+		n.notify()
+	}
+}
+
+// appendEntry appends an entry of the current term to the leader's log. It must be called with mx locked
+func (n *Node) appendEntry(data []byte) (uint64, error) {
+	e := Entry{Index: n.lastIndex() + 1, Term: n.term, Data: data}
+
+	if err := n.storage.Append([]Entry{e}); err != nil {
+		return 0, err
+	}
+
+	n.log = append(n.log, e)
+
+	for _, ch := range n.replicate {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+
+	n.advanceCommitIndex()
+
+	return e.Index, nil
+}
+
+// replicateTo sends entries and heartbeats to the peer until the node loses leadership of the term
+func (n *Node) replicateTo(peer string, term uint64, trigger <-chan struct{}) {
+	defer n.waitGroup.Done()
+
+	heartbeat := time.NewTicker(n.config.HeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		n.mx.Lock()
+		if n.stopped || n.role != roleLeader || n.term != term {
+			n.mx.Unlock()
+			return
+		}
+
+		// entries compacted into the snapshot are replaced with the snapshot
+		sendSnapshot := n.nextIndex[peer] <= n.snapshot.Index
+
+		var appendReq AppendRequest
+		var snapshotReq SnapshotRequest
+
+		if sendSnapshot {
+			snapshotReq = SnapshotRequest{Term: n.term, Leader: n.id, Snapshot: n.snapshot}
+		} else {
+			appendReq = n.appendRequest(peer)
+		}
+		n.mx.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), n.config.ElectionTimeout)
+		more := false
+
+		if sendSnapshot {
+			resp, err := n.config.Transport.InstallSnapshot(ctx, peer, snapshotReq)
+			if err == nil {
+				more = n.handleSnapshotResponse(peer, snapshotReq, resp)
+			}
+		} else {
+			resp, err := n.config.Transport.AppendEntries(ctx, peer, appendReq)
+			if err == nil {
+				more = n.handleAppendResponse(peer, appendReq, resp)
+			}
+		}
+
+		cancel()
+
+		if more {
+			continue
+		}
+
+		select {
+		case <-n.stopCh:
+			return
+		case <-trigger:
+		case <-heartbeat.C:
+		}
+	}
+}
+
+// appendRequest must be called with mx locked
+func (n *Node) appendRequest(peer string) AppendRequest {
+	next := n.nextIndex[peer]
+	last := n.lastIndex()
+
+	end := last + 1
+	if end-next > maxEntriesPerRequest {
+		end = next + maxEntriesPerRequest
+	}
+
+	entries := make([]Entry, end-next)
+	copy(entries, n.log[next-n.snapshot.Index:end-n.snapshot.Index])
+
+	return AppendRequest{
+		Term:         n.term,
+		Leader:       n.id,
+		PrevLogIndex: next - 1,
+		PrevLogTerm:  n.entry(next - 1).Term,
+		Entries:      entries,
+		LeaderCommit: n.commitIndex,
+	}
+}
+
+// handleAppendResponse returns true if the peer is behind and should be sent more entries right away
+func (n *Node) handleAppendResponse(peer string, req AppendRequest, resp AppendResponse) bool {
+	n.mx.Lock()
+	defer n.mx.Unlock()
+
+	if resp.Term > n.term {
+		n.becomeFollower(resp.Term, "")
+		return false
+	}
+
+	if n.role != roleLeader || n.term != req.Term {
+		return false
+	}
+
+	n.lastAck[peer] = time.Now()
+
+	if !resp.Success {
+		next := resp.ConflictIndex
+		if next < 1 {
+			next = 1
+		}
+
+		if next > n.lastIndex()+1 {
+			next = n.lastIndex() + 1
+		}
+
+		// retry right away only if the follower has pointed to another index, otherwise wait for the next heartbeat
+		retry := next != n.nextIndex[peer]
+		n.nextIndex[peer] = next
+
+		return retry
+	}
+
+	match := req.PrevLogIndex + uint64(len(req.Entries))
+	if match > n.matchIndex[peer] {
+		n.matchIndex[peer] = match
+	}
+
+	n.nextIndex[peer] = n.matchIndex[peer] + 1
+	n.advanceCommitIndex()
+
+	return n.nextIndex[peer] <= n.lastIndex()
+}
+
+// handleSnapshotResponse returns true if the peer should be sent the entries following the snapshot right away
+func (n *Node) handleSnapshotResponse(peer string, req SnapshotRequest, resp SnapshotResponse) bool {
+	n.mx.Lock()
+	defer n.mx.Unlock()
+
+	if resp.Term > n.term {
+		n.becomeFollower(resp.Term, "")
+		return false
+	}
+
+	if n.role != roleLeader || n.term != req.Term {
+		return false
+	}
+
+	n.lastAck[peer] = time.Now()
+
+	if !resp.Success {
+		return false
+	}
+
+	if req.Snapshot.Index > n.matchIndex[peer] {
+		n.matchIndex[peer] = req.Snapshot.Index
+	}
+
+	n.nextIndex[peer] = n.matchIndex[peer] + 1
+	n.advanceCommitIndex()
+
+	return n.nextIndex[peer] <= n.lastIndex()
+}
+
+// advanceCommitIndex commits the entries stored by the majority. Only entries of the current term are committed by counting replicas.
+// It must be called with mx locked
+func (n *Node) advanceCommitIndex() {
+	indexes := []uint64{n.lastIndex()}
+	for _, p := range n.peers {
+		indexes = append(indexes, n.matchIndex[p])
+	}
+
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i] > indexes[j] })
+
+	committed := indexes[n.quorum()-1]
+	if committed > n.commitIndex && n.entry(committed).Term == n.term {
+		n.commitIndex = committed
+		n.signalApply()
+	}
+}
+
+func (n *Node) signalApply() {
+	select {
+	case n.applyCh <- struct{}{}:
+	default:
+	}
+}
+
+// notify must be called with mx locked
+func (n *Node) notify() {
+	if n.stopped {
+		return
+	}
+
+	select {
+	case n.changed <- struct{}{}:
+	default:
+	}
+}
+
+// applyCommitted applies committed entries and resolves proposals
+func (n *Node) applyCommitted() {
+	defer n.waitGroup.Done()
+
+	for {
+		select {
+		case <-n.stopCh:
+			return
+		case <-n.applyCh:
+		}
+
+		n.mx.Lock()
+		restore := n.restore
+		n.restore = nil
+		n.mx.Unlock()
+
+		if restore != nil {
+			n.config.Restore(restore.Data)
+
+			n.mx.Lock()
+			n.lastApplied = restore.Index
+			n.mx.Unlock()
+		}
+
+		n.mx.Lock()
+
+		var entries []Entry
+		if n.restore != nil {
+			// another snapshot has been installed meanwhile
+			n.signalApply()
+		} else {
+			entries = append(entries, n.log[n.lastApplied+1-n.snapshot.Index:n.commitIndex+1-n.snapshot.Index]...)
+		}
+		n.mx.Unlock()
+
+		for _, e := range entries {
+			if e.Data != nil && n.config.Apply != nil {
+				n.config.Apply(e.Data)
+			}
+
+			n.mx.Lock()
+			n.lastApplied = e.Index
+
+			if p, ok := n.pending[e.Index]; ok {
+				delete(n.pending, e.Index)
+
+				if p.term == e.Term {
+					p.done <- nil
+				} else {
+					p.done <- ErrNotLeader
+				}
+			}
+
+			if n.role == roleLeader && e.Term == n.term && n.readyTerm != n.term {
+				n.readyTerm = n.term
+				n.notify()
+			}
+			n.mx.Unlock()
+		}
+
+		n.compact()
+	}
+}
+
+// compact replaces the applied entries with a snapshot once there are enough of them. It must be called from applyCommitted
+func (n *Node) compact() {
+	n.mx.Lock()
+	due := n.config.Snapshot != nil && n.restore == nil && n.lastApplied >= n.snapshot.Index+n.config.SnapshotEntries
+	n.mx.Unlock()
+
+	if !due {
+		return
+	}
+
+	data := n.config.Snapshot()
+	if data == nil {
+		return
+	}
+
+	n.mx.Lock()
+	defer n.mx.Unlock()
+
+	// the log may have been replaced by a snapshot installed meanwhile
+	if n.restore != nil || n.lastApplied <= n.snapshot.Index {
+		return
+	}
+
+	snapshot := Snapshot{Index: n.lastApplied, Term: n.entry(n.lastApplied).Term, Data: data}
+	entries := append([]Entry{}, n.log[n.lastApplied+1-n.snapshot.Index:]...)
+
+	if err := n.storage.SaveSnapshot(snapshot, entries); err != nil {
+		return
+	}
+
+	n.setSnapshot(snapshot, entries)
+}
+
+// setSnapshot replaces the log with the snapshot and the entries following it. It must be called with mx locked
+func (n *Node) setSnapshot(snapshot Snapshot, entries []Entry) {
+	n.snapshot = snapshot
+	n.log = append([]Entry{{Index: snapshot.Index, Term: snapshot.Term}}, entries...)
+}
+
+// failPending must be called with mx locked
+func (n *Node) failPending(err error) {
+	for index, p := range n.pending {
+		p.done <- err
+		delete(n.pending, index)
+	}
+}
+
+func (n *Node) isReady() bool {
+	return n.role == roleLeader && n.readyTerm == n.term
+}
+
+func (n *Node) lastIndex() uint64 {
+	return n.snapshot.Index + uint64(len(n.log)-1)
+}
+
+// entry returns the entry with the index, or the sentinel for the snapshot index. It must be called with mx locked
+func (n *Node) entry(index uint64) Entry {
+	return n.log[index-n.snapshot.Index]
+}
+
+func (n *Node) lastTerm() uint64 {
+	return n.log[len(n.log)-1].Term
+}
+
+func (n *Node) saveHardState() error {
+	return n.storage.SaveHardState(HardState{Term: n.term, VotedFor: n.votedFor})
+}
+
+// HandleRequestVote answers a candidate. It is called by transports
+func (n *Node) HandleRequestVote(req VoteRequest) VoteResponse {
+	n.mx.Lock()
+	defer n.mx.Unlock()
+
+	if n.stopped {
+		return VoteResponse{Term: n.term}
+	}
+
+	if req.Term > n.term {
+		n.becomeFollower(req.Term, "")
+	}
+
+	resp := VoteResponse{Term: n.term}
+
+	if req.Term < n.term || (n.votedFor != "" && n.votedFor != req.Candidate) {
+		return resp
+	}
+
+	upToDate := req.LastLogTerm > n.lastTerm() || (req.LastLogTerm == n.lastTerm() && req.LastLogIndex >= n.lastIndex())
+	if !upToDate {
+		return resp
+	}
+
+	n.votedFor = req.Candidate
+	if err := n.saveHardState(); err != nil {
+		return resp
+	}
+
+	n.resetElectionDeadline()
+	resp.Granted = true
+
+	return resp
+}
+
+// HandleAppendEntries stores the leader's entries. It is called by transports
+func (n *Node) HandleAppendEntries(req AppendRequest) AppendResponse {
+	n.mx.Lock()
+	defer n.mx.Unlock()
+
+	if n.stopped || req.Term < n.term {
+		return AppendResponse{Term: n.term}
+	}
+
+	if req.Term > n.term || n.role != roleFollower || n.leader != req.Leader {
+		n.becomeFollower(req.Term, req.Leader)
+	}
+
+	n.resetElectionDeadline()
+
+	resp := AppendResponse{Term: n.term}
+
+	if req.PrevLogIndex < n.snapshot.Index {
+		// the entries compacted into the snapshot are committed, so they match the leader's
+		skip := n.snapshot.Index - req.PrevLogIndex
+		if skip > uint64(len(req.Entries)) {
+			skip = uint64(len(req.Entries))
+		}
+
+		req.Entries = req.Entries[skip:]
+		req.PrevLogIndex = n.snapshot.Index
+		req.PrevLogTerm = n.snapshot.Term
+	}
+
+	if req.PrevLogIndex > n.lastIndex() {
+		resp.ConflictIndex = n.lastIndex() + 1
+		return resp
+	}
+
+	if t := n.entry(req.PrevLogIndex).Term; t != req.PrevLogTerm {
+		// skip the whole conflicting term
+		i := req.PrevLogIndex
+		for i > n.snapshot.Index+1 && n.entry(i-1).Term == t {
+			i--
+		}
+
+		resp.ConflictIndex = i
+
+		return resp
+	}
+
+	for i, e := range req.Entries {
+		if e.Index <= n.lastIndex() && n.entry(e.Index).Term == e.Term {
+			continue
+		}
+
+		// committed entries are never overwritten, since the leader has all of them
+		newEntries := req.Entries[i:]
+		if err := n.storage.Append(newEntries); err != nil {
+			return resp
+		}
+
+		n.log = append(n.log[:e.Index-n.snapshot.Index], newEntries...)
+
+		break
+	}
+
+	if req.LeaderCommit > n.commitIndex {
+		last := req.PrevLogIndex + uint64(len(req.Entries))
+		if req.LeaderCommit < last {
+			last = req.LeaderCommit
+		}
+
+		if last > n.commitIndex {
+			n.commitIndex = last
+			n.signalApply()
+		}
+	}
+
+	resp.Success = true
+
+	return resp
+}
+
+// HandleInstallSnapshot replaces the log with the leader's snapshot. It is called by transports
+func (n *Node) HandleInstallSnapshot(req SnapshotRequest) SnapshotResponse {
+	n.mx.Lock()
+	defer n.mx.Unlock()
+
+	if n.stopped || req.Term < n.term {
+		return SnapshotResponse{Term: n.term}
+	}
+
+	if req.Term > n.term || n.role != roleFollower || n.leader != req.Leader {
+		n.becomeFollower(req.Term, req.Leader)
+	}
+
+	n.resetElectionDeadline()
+
+	resp := SnapshotResponse{Term: n.term}
+	snapshot := req.Snapshot
+
+	// committed entries match the leader's
+	if snapshot.Index <= n.commitIndex {
+		resp.Success = true
+		return resp
+	}
+
+	if n.config.Restore == nil {
+		return resp
+	}
+
+	// the entries following the snapshot are kept if the log has the snapshot's last entry
+	var entries []Entry
+	if snapshot.Index <= n.lastIndex() && n.entry(snapshot.Index).Term == snapshot.Term {
+		entries = append(entries, n.log[snapshot.Index+1-n.snapshot.Index:]...)
+	}
+
+	if err := n.storage.SaveSnapshot(snapshot, entries); err != nil {
+		return resp
+	}
+
+	n.setSnapshot(snapshot, entries)
+	n.commitIndex = snapshot.Index
+	n.restore = &snapshot
+	n.signalApply()
+
+	resp.Success = true
+
+	return resp
+}
@@ -0,0 +1,392 @@
+package raft_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/locktopus-project/locktopus/pkg/raft"
+)
+
+const testElectionTimeout = 150 * time.Millisecond
+const testWaitTimeout = 5 * time.Second
+
+type testCluster struct {
+	t               *testing.T
+	network         *raft.InmemNetwork
+	nodes           map[string]*raft.Node
+	snapshotEntries uint64
+	mx              sync.Mutex
+	applied         map[string][]string // the state machine of each node, so snapshots are the applied entries
+}
+
+func makeTestCluster(t *testing.T, size int) *testCluster {
+	return makeSnapshottingTestCluster(t, size, 0)
+}
+
+func makeSnapshottingTestCluster(t *testing.T, size int, snapshotEntries uint64) *testCluster {
+	c := &testCluster{
+		t:               t,
+		network:         raft.NewInmemNetwork(),
+		nodes:           make(map[string]*raft.Node),
+		snapshotEntries: snapshotEntries,
+		applied:         make(map[string][]string),
+	}
+
+	peers := []string{}
+	for i := 1; i <= size; i++ {
+		peers = append(peers, fmt.Sprintf("node%d", i))
+	}
+
+	for _, id := range peers {
+		c.nodes[id] = c.makeNode(id, peers, nil)
+	}
+
+	t.Cleanup(func() {
+		for _, n := range c.nodes {
+			n.Stop()
+		}
+	})
+
+	return c
+}
+
+func (c *testCluster) makeNode(id string, peers []string, storage raft.Storage) *raft.Node {
+	n, err := raft.NewNode(raft.Config{
+		ID:              id,
+		Peers:           peers,
+		Transport:       c.network.Transport(id),
+		Storage:         storage,
+		ElectionTimeout: testElectionTimeout,
+		SnapshotEntries: c.snapshotEntries,
+		Apply: func(data []byte) {
+			c.mx.Lock()
+			c.applied[id] = append(c.applied[id], string(data))
+			c.mx.Unlock()
+		},
+		Snapshot: func() []byte {
+			c.mx.Lock()
+			defer c.mx.Unlock()
+
+			data, _ := json.Marshal(c.applied[id])
+
+			return data
+		},
+		Restore: func(data []byte) {
+			applied := []string{}
+			json.Unmarshal(data, &applied)
+
+			c.mx.Lock()
+			c.applied[id] = applied
+			c.mx.Unlock()
+		},
+	})
+	if err != nil {
+		c.t.Fatalf("cannot make node: %s", err)
+	}
+
+	c.network.Add(n)
+	n.Start()
+
+	return n
+}
+
+// waitLeader returns the ready leader among the nodes except the excluded one
+func (c *testCluster) waitLeader(excluded string) *raft.Node {
+	deadline := time.Now().Add(testWaitTimeout)
+
+	for time.Now().Before(deadline) {
+		for id, n := range c.nodes {
+			if id != excluded && n.Status().Ready {
+				return n
+			}
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	c.t.Fatalf("leader has not been elected")
+
+	return nil
+}
+
+func (c *testCluster) waitApplied(id string, expected []string) {
+	deadline := time.Now().Add(testWaitTimeout)
+
+	for time.Now().Before(deadline) {
+		c.mx.Lock()
+		applied := append([]string{}, c.applied[id]...)
+		c.mx.Unlock()
+
+		if reflect.DeepEqual(applied, expected) {
+			return
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	c.t.Fatalf("%s applied %v, expected %v", id, c.applied[id], expected)
+}
+
+func propose(t *testing.T, n *raft.Node, data string) {
+	ctx, cancel := context.WithTimeout(context.Background(), testWaitTimeout)
+	defer cancel()
+
+	if err := n.Propose(ctx, []byte(data)); err != nil {
+		t.Fatalf("cannot propose: %s", err)
+	}
+}
+
+func TestRaft_ElectsSingleLeader(t *testing.T) {
+	c := makeTestCluster(t, 3)
+
+	leader := c.waitLeader("")
+
+	// let the followers learn about the leader
+	time.Sleep(testElectionTimeout)
+
+	for id, n := range c.nodes {
+		status := n.Status()
+
+		if id != leader.Status().ID && status.Role == "leader" {
+			t.Fatalf("there should be a single leader")
+		}
+
+		if status.Leader != leader.Status().ID {
+			t.Fatalf("%s should know the leader", id)
+		}
+	}
+}
+
+func TestRaft_ReplicatesEntries(t *testing.T) {
+	for _, size := range []int{1, 3, 5} {
+		c := makeTestCluster(t, size)
+
+		leader := c.waitLeader("")
+
+		expected := []string{}
+		for i := 0; i < 10; i++ {
+			expected = append(expected, fmt.Sprintf("entry%d", i))
+			propose(t, leader, expected[i])
+		}
+
+		for id := range c.nodes {
+			c.waitApplied(id, expected)
+		}
+	}
+}
+
+func TestRaft_ProposeOnFollower(t *testing.T) {
+	c := makeTestCluster(t, 3)
+
+	leader := c.waitLeader("")
+
+	for id, n := range c.nodes {
+		if id == leader.Status().ID {
+			continue
+		}
+
+		if err := n.Propose(context.Background(), []byte("entry")); !errors.Is(err, raft.ErrNotLeader) {
+			t.Fatalf("follower should reject proposals, got %v", err)
+		}
+	}
+}
+
+func TestRaft_Failover(t *testing.T) {
+	c := makeTestCluster(t, 3)
+
+	oldLeader := c.waitLeader("")
+	oldID := oldLeader.Status().ID
+
+	propose(t, oldLeader, "before")
+
+	c.network.Disconnect(oldID)
+
+	newLeader := c.waitLeader(oldID)
+
+	propose(t, newLeader, "after")
+
+	// the isolated leader steps down, so it cannot serve clients
+	deadline := time.Now().Add(testWaitTimeout)
+	for oldLeader.Status().Role == "leader" {
+		if time.Now().After(deadline) {
+			t.Fatalf("isolated leader should step down")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := oldLeader.Propose(context.Background(), []byte("stale")); !errors.Is(err, raft.ErrNotLeader) {
+		t.Fatalf("isolated node should reject proposals, got %v", err)
+	}
+
+	c.network.Reconnect(oldID)
+
+	for id := range c.nodes {
+		c.waitApplied(id, []string{"before", "after"})
+	}
+}
+
+func TestRaft_NoCommitWithoutMajority(t *testing.T) {
+	c := makeTestCluster(t, 3)
+
+	leader := c.waitLeader("")
+	leaderID := leader.Status().ID
+
+	for id := range c.nodes {
+		if id != leaderID {
+			c.network.Disconnect(id)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*testElectionTimeout)
+	defer cancel()
+
+	if err := leader.Propose(ctx, []byte("lost")); err == nil {
+		t.Fatalf("entry should not be committed without majority")
+	}
+
+	for id := range c.nodes {
+		c.network.Reconnect(id)
+	}
+
+	leader = c.waitLeader("")
+	propose(t, leader, "entry")
+
+	// the lost entry may still be committed by the next leader, but all the nodes agree on the log
+	c.mx.Lock()
+	expected := append([]string{}, c.applied[leader.Status().ID]...)
+	c.mx.Unlock()
+
+	for id := range c.nodes {
+		c.waitApplied(id, expected)
+	}
+}
+
+func TestRaft_FileStorageRestart(t *testing.T) {
+	dir := t.TempDir()
+	c := &testCluster{t: t, network: raft.NewInmemNetwork(), nodes: make(map[string]*raft.Node), applied: make(map[string][]string)}
+	peers := []string{"node1"}
+
+	storage, err := raft.NewFileStorage(dir)
+	if err != nil {
+		t.Fatalf("cannot open storage: %s", err)
+	}
+
+	c.nodes["node1"] = c.makeNode("node1", peers, storage)
+	propose(t, c.waitLeader(""), "entry1")
+	propose(t, c.waitLeader(""), "entry2")
+
+	c.nodes["node1"].Stop()
+	storage.Close()
+
+	storage, err = raft.NewFileStorage(dir)
+	if err != nil {
+		t.Fatalf("cannot open storage: %s", err)
+	}
+	defer storage.Close()
+
+	c.applied = make(map[string][]string)
+	c.nodes["node1"] = c.makeNode("node1", peers, storage)
+	defer c.nodes["node1"].Stop()
+
+	leader := c.waitLeader("")
+
+	if leader.Status().Term < 2 {
+		t.Fatalf("term should be restored")
+	}
+
+	// entries of the previous term are applied once the new leader commits its first entry
+	c.waitApplied("node1", []string{"entry1", "entry2"})
+}
+
+func TestRaft_SnapshotCatchUp(t *testing.T) {
+	c := makeSnapshottingTestCluster(t, 3, 5)
+
+	leader := c.waitLeader("")
+	leaderID := leader.Status().ID
+
+	lagging := ""
+	for id := range c.nodes {
+		if id != leaderID {
+			lagging = id
+			break
+		}
+	}
+
+	c.network.Disconnect(lagging)
+
+	expected := []string{}
+	for i := 0; i < 20; i++ {
+		expected = append(expected, fmt.Sprintf("entry%d", i))
+		propose(t, leader, expected[i])
+	}
+
+	if leader.Status().SnapshotIndex == 0 {
+		t.Fatalf("leader should compact its log")
+	}
+
+	c.network.Reconnect(lagging)
+
+	// the follower is sent the snapshot, since the leader no longer has the entries it misses
+	for id := range c.nodes {
+		c.waitApplied(id, expected)
+	}
+
+	if c.nodes[lagging].Status().SnapshotIndex == 0 {
+		t.Fatalf("lagging follower should install the snapshot")
+	}
+}
+
+func TestRaft_FileStorageRestartWithSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	c := &testCluster{t: t, network: raft.NewInmemNetwork(), nodes: make(map[string]*raft.Node), snapshotEntries: 5, applied: make(map[string][]string)}
+	peers := []string{"node1"}
+
+	storage, err := raft.NewFileStorage(dir)
+	if err != nil {
+		t.Fatalf("cannot open storage: %s", err)
+	}
+
+	c.nodes["node1"] = c.makeNode("node1", peers, storage)
+
+	expected := []string{}
+	for i := 0; i < 12; i++ {
+		expected = append(expected, fmt.Sprintf("entry%d", i))
+		propose(t, c.waitLeader(""), expected[i])
+	}
+
+	if c.nodes["node1"].Status().SnapshotIndex == 0 {
+		t.Fatalf("log should be compacted")
+	}
+
+	c.nodes["node1"].Stop()
+	storage.Close()
+
+	storage, err = raft.NewFileStorage(dir)
+	if err != nil {
+		t.Fatalf("cannot open storage: %s", err)
+	}
+	defer storage.Close()
+
+	c.applied = make(map[string][]string)
+	c.nodes["node1"] = c.makeNode("node1", peers, storage)
+	defer c.nodes["node1"].Stop()
+
+	if c.nodes["node1"].Status().SnapshotIndex == 0 {
+		t.Fatalf("snapshot should be loaded")
+	}
+
+	c.waitLeader("")
+
+	// the snapshot is restored, and the entries following it are applied once the new leader commits its first entry
+	c.waitApplied("node1", expected)
+}
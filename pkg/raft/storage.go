@@ -0,0 +1,290 @@
+package raft
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// HardState must be persisted before the node answers requests, so the node never votes twice in a term
+type HardState struct {
+	Term     uint64 `json:"term"`
+	VotedFor string `json:"votedFor"`
+}
+
+// Storage persists the hard state, the snapshot and the log of a node
+type Storage interface {
+	// Load returns the entries following the snapshot. The snapshot is empty if none has been saved
+	Load() (HardState, Snapshot, []Entry, error)
+	SaveHardState(HardState) error
+	// Append stores the entries. Stored entries with the same or higher indexes are replaced
+	Append(entries []Entry) error
+	// SaveSnapshot stores the snapshot and replaces the log with the entries following it
+	SaveSnapshot(snapshot Snapshot, entries []Entry) error
+}
+
+// MemoryStorage keeps the state in memory. A restarted node with MemoryStorage has to be treated as a new node
+type MemoryStorage struct {
+	mx       sync.Mutex
+	hs       HardState
+	snapshot Snapshot
+	entries  []Entry
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{}
+}
+
+func (s *MemoryStorage) Load() (HardState, Snapshot, []Entry, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	return s.hs, s.snapshot, append([]Entry{}, s.entries...), nil
+}
+
+func (s *MemoryStorage) SaveHardState(hs HardState) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.hs = hs
+
+	return nil
+}
+
+func (s *MemoryStorage) Append(entries []Entry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.entries = append(s.entries[:entries[0].Index-1-s.snapshot.Index], entries...)
+
+	return nil
+}
+
+func (s *MemoryStorage) SaveSnapshot(snapshot Snapshot, entries []Entry) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.snapshot = snapshot
+	s.entries = append([]Entry{}, entries...)
+
+	return nil
+}
+
+const hardStateFileName = "state.json"
+const snapshotFileName = "snapshot.json"
+const logFileName = "raft.log"
+
+// FileStorage keeps the state in a directory. Entries are appended to a file as JSON lines and synced.
+// When entries are replaced, the replacing entries are appended, and the latest entry with the same index wins on load.
+// Saving a snapshot writes the snapshot file and then rewrites the log, so entries covered by the snapshot are ignored on load
+// if the node stops in between.
+type FileStorage struct {
+	mx   sync.Mutex
+	dir  string
+	file *os.File
+}
+
+func NewFileStorage(dir string) (*FileStorage, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, logFileName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileStorage{dir: dir, file: f}, nil
+}
+
+func (s *FileStorage) Load() (HardState, Snapshot, []Entry, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	hs := HardState{}
+	snapshot := Snapshot{}
+
+	if err := readJSONFile(filepath.Join(s.dir, hardStateFileName), &hs); err != nil {
+		return hs, snapshot, nil, fmt.Errorf("cannot read hard state: %w", err)
+	}
+
+	if err := readJSONFile(filepath.Join(s.dir, snapshotFileName), &snapshot); err != nil {
+		return hs, snapshot, nil, fmt.Errorf("cannot read snapshot: %w", err)
+	}
+
+	f, err := os.Open(filepath.Join(s.dir, logFileName))
+	if err != nil {
+		return hs, snapshot, nil, err
+	}
+	defer f.Close()
+
+	entries := []Entry{}
+	r := bufio.NewReader(f)
+
+	for {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// a partially written last line is ignored
+			return hs, snapshot, entries, nil
+		}
+
+		if err != nil {
+			return hs, snapshot, nil, err
+		}
+
+		e := Entry{}
+		if err = json.Unmarshal(line, &e); err != nil {
+			return hs, snapshot, nil, fmt.Errorf("cannot parse log entry: %w", err)
+		}
+
+		if e.Index <= snapshot.Index {
+			continue
+		}
+
+		if e.Index > snapshot.Index+uint64(len(entries))+1 {
+			return hs, snapshot, nil, fmt.Errorf("log entry %d is out of order", e.Index)
+		}
+
+		entries = append(entries[:e.Index-1-snapshot.Index], e)
+	}
+}
+
+// readJSONFile leaves v as is if the file does not exist
+func readJSONFile(name string, v interface{}) error {
+	content, err := os.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(content, v)
+}
+
+func (s *FileStorage) SaveHardState(hs HardState) error {
+	serialized, err := json.Marshal(hs)
+	if err != nil {
+		return err
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	return writeFileAtomic(filepath.Join(s.dir, hardStateFileName), serialized)
+}
+
+// writeFileAtomic writes and syncs a temporary file, and renames it to name
+func writeFileAtomic(name string, content []byte) error {
+	tmp := name + ".tmp"
+
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err = f.Write(content); err == nil {
+		err = f.Sync()
+	}
+
+	f.Close()
+
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, name)
+}
+
+func serializeEntries(entries []Entry) ([]byte, error) {
+	buf := []byte{}
+
+	for _, e := range entries {
+		serialized, err := json.Marshal(e)
+		if err != nil {
+			return nil, err
+		}
+
+		buf = append(append(buf, serialized...), '\n')
+	}
+
+	return buf, nil
+}
+
+func (s *FileStorage) Append(entries []Entry) error {
+	buf, err := serializeEntries(entries)
+	if err != nil {
+		return err
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if _, err = s.file.Write(buf); err != nil {
+		return err
+	}
+
+	return s.file.Sync()
+}
+
+func (s *FileStorage) SaveSnapshot(snapshot Snapshot, entries []Entry) error {
+	serialized, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	log, err := serializeEntries(entries)
+	if err != nil {
+		return err
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if err = writeFileAtomic(filepath.Join(s.dir, snapshotFileName), serialized); err != nil {
+		return err
+	}
+
+	// the new log is kept open for appending after it replaces the old one
+	name := filepath.Join(s.dir, logFileName)
+
+	f, err := os.OpenFile(name+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err = f.Write(log); err == nil {
+		err = f.Sync()
+	}
+
+	if err == nil {
+		err = os.Rename(name+".tmp", name)
+	}
+
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	s.file.Close()
+	s.file = f
+
+	return nil
+}
+
+func (s *FileStorage) Close() error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	return s.file.Close()
+}
@@ -0,0 +1,160 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+type VoteRequest struct {
+	Term         uint64 `json:"term"`
+	Candidate    string `json:"candidate"`
+	LastLogIndex uint64 `json:"lastLogIndex"`
+	LastLogTerm  uint64 `json:"lastLogTerm"`
+}
+
+type VoteResponse struct {
+	Term    uint64 `json:"term"`
+	Granted bool   `json:"granted"`
+}
+
+type AppendRequest struct {
+	Term         uint64  `json:"term"`
+	Leader       string  `json:"leader"`
+	PrevLogIndex uint64  `json:"prevLogIndex"`
+	PrevLogTerm  uint64  `json:"prevLogTerm"`
+	Entries      []Entry `json:"entries"`
+	LeaderCommit uint64  `json:"leaderCommit"`
+}
+
+type AppendResponse struct {
+	Term          uint64 `json:"term"`
+	Success       bool   `json:"success"`
+	ConflictIndex uint64 `json:"conflictIndex,omitempty"` // the index the leader should continue from if Success is false
+}
+
+// SnapshotRequest is sent to followers missing entries the leader has compacted
+type SnapshotRequest struct {
+	Term     uint64   `json:"term"`
+	Leader   string   `json:"leader"`
+	Snapshot Snapshot `json:"snapshot"`
+}
+
+type SnapshotResponse struct {
+	Term    uint64 `json:"term"`
+	Success bool   `json:"success"` // the follower has the entries up to the snapshot index
+}
+
+// Transport delivers requests of a node to its peers
+type Transport interface {
+	RequestVote(ctx context.Context, peer string, req VoteRequest) (VoteResponse, error)
+	AppendEntries(ctx context.Context, peer string, req AppendRequest) (AppendResponse, error)
+	InstallSnapshot(ctx context.Context, peer string, req SnapshotRequest) (SnapshotResponse, error)
+}
+
+var ErrUnreachable = errors.New("peer is unreachable")
+
+// InmemNetwork connects nodes running in the same process. Nodes can be disconnected to simulate network partitions.
+type InmemNetwork struct {
+	mx           sync.Mutex
+	nodes        map[string]*Node
+	disconnected map[string]bool
+}
+
+func NewInmemNetwork() *InmemNetwork {
+	return &InmemNetwork{
+		nodes:        make(map[string]*Node),
+		disconnected: make(map[string]bool),
+	}
+}
+
+// Transport returns the transport for the node with the given ID
+func (net *InmemNetwork) Transport(id string) Transport {
+	return &inmemTransport{net: net, from: id}
+}
+
+// Add makes the node reachable by its peers
+func (net *InmemNetwork) Add(n *Node) {
+	net.mx.Lock()
+	defer net.mx.Unlock()
+
+	net.nodes[n.id] = n
+}
+
+// Disconnect isolates the node from all the other nodes
+func (net *InmemNetwork) Disconnect(id string) {
+	net.mx.Lock()
+	defer net.mx.Unlock()
+
+	net.disconnected[id] = true
+}
+
+func (net *InmemNetwork) Reconnect(id string) {
+	net.mx.Lock()
+	defer net.mx.Unlock()
+
+	delete(net.disconnected, id)
+}
+
+func (net *InmemNetwork) route(from, to string) (*Node, error) {
+	net.mx.Lock()
+	defer net.mx.Unlock()
+
+	n, ok := net.nodes[to]
+	if !ok || net.disconnected[from] || net.disconnected[to] {
+		return nil, fmt.Errorf("%w: %s", ErrUnreachable, to)
+	}
+
+	return n, nil
+}
+
+type inmemTransport struct {
+	net  *InmemNetwork
+	from string
+}
+
+func (t *inmemTransport) RequestVote(ctx context.Context, peer string, req VoteRequest) (VoteResponse, error) {
+	n, err := t.net.route(t.from, peer)
+	if err != nil {
+		return VoteResponse{}, err
+	}
+
+	return n.HandleRequestVote(req), nil
+}
+
+func (t *inmemTransport) AppendEntries(ctx context.Context, peer string, req AppendRequest) (AppendResponse, error) {
+	n, err := t.net.route(t.from, peer)
+	if err != nil {
+		return AppendResponse{}, err
+	}
+
+	// entries are copied as they would be by a network transport
+	req.Entries = append([]Entry{}, req.Entries...)
+
+	resp := n.HandleAppendEntries(req)
+
+	// the response is dropped if the node has been disconnected meanwhile
+	if _, err = t.net.route(t.from, peer); err != nil {
+		return AppendResponse{}, err
+	}
+
+	return resp, nil
+}
+
+func (t *inmemTransport) InstallSnapshot(ctx context.Context, peer string, req SnapshotRequest) (SnapshotResponse, error) {
+	n, err := t.net.route(t.from, peer)
+	if err != nil {
+		return SnapshotResponse{}, err
+	}
+
+	req.Snapshot.Data = append([]byte{}, req.Snapshot.Data...)
+
+	resp := n.HandleInstallSnapshot(req)
+
+	if _, err = t.net.route(t.from, peer); err != nil {
+		return SnapshotResponse{}, err
+	}
+
+	return resp, nil
+}
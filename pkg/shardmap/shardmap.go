@@ -0,0 +1,148 @@
+// Package shardmap assigns namespaces to servers by consistent hashing.
+// Use Parse() to read a Map. Namespaces can be pinned to servers explicitly, e.g. after being moved.
+
+package shardmap
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// pointsPerNode is the number of points each node has on the hash ring. More points give a more even distribution
+const pointsPerNode = 128
+
+type Node struct {
+	ID  string `json:"id"`
+	URL string `json:"url"` // base URL of the server, e.g. http://10.0.0.1:9009
+}
+
+// Map is immutable. Use WithNamespace to make a changed copy.
+type Map struct {
+	Version    int64             `json:"version"` // greater versions replace smaller ones
+	Nodes      []Node            `json:"nodes"`
+	Namespaces map[string]string `json:"namespaces,omitempty"` // node IDs of pinned namespaces
+
+	ring []point
+}
+
+type point struct {
+	hash uint64
+	node int
+}
+
+func Parse(data []byte) (*Map, error) {
+	m := &Map{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("cannot parse shard map: %w", err)
+	}
+
+	if err := m.init(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func (m *Map) init() error {
+	if len(m.Nodes) == 0 {
+		return errors.New("shard map has no nodes")
+	}
+
+	ids := make(map[string]bool)
+
+	for i, n := range m.Nodes {
+		if n.ID == "" || n.URL == "" {
+			return errors.New("shard map nodes should have id and url")
+		}
+
+		if ids[n.ID] {
+			return fmt.Errorf("duplicate node %s in shard map", n.ID)
+		}
+
+		ids[n.ID] = true
+
+		for j := 0; j < pointsPerNode; j++ {
+			m.ring = append(m.ring, point{hash: hash(n.ID + "#" + strconv.Itoa(j)), node: i})
+		}
+	}
+
+	for namespace, id := range m.Namespaces {
+		if !ids[id] {
+			return fmt.Errorf("namespace %s is pinned to unknown node %s", namespace, id)
+		}
+	}
+
+	sort.Slice(m.ring, func(i, j int) bool {
+		return m.ring[i].hash < m.ring[j].hash
+	})
+
+	return nil
+}
+
+// Locate returns the node owning the namespace
+func (m *Map) Locate(namespace string) Node {
+	if id, ok := m.Namespaces[namespace]; ok {
+		if n, ok := m.Node(id); ok {
+			return n
+		}
+	}
+
+	h := hash(namespace)
+
+	i := sort.Search(len(m.ring), func(i int) bool {
+		return m.ring[i].hash >= h
+	})
+
+	if i == len(m.ring) {
+		i = 0
+	}
+
+	return m.Nodes[m.ring[i].node]
+}
+
+func (m *Map) Node(id string) (Node, bool) {
+	for _, n := range m.Nodes {
+		if n.ID == id {
+			return n, true
+		}
+	}
+
+	return Node{}, false
+}
+
+// WithNamespace returns a copy of the map with the namespace pinned to the node and the version incremented
+func (m *Map) WithNamespace(namespace, nodeID string) (*Map, error) {
+	c := &Map{
+		Version:    m.Version + 1,
+		Nodes:      m.Nodes,
+		Namespaces: make(map[string]string, len(m.Namespaces)+1),
+	}
+
+	for k, v := range m.Namespaces {
+		c.Namespaces[k] = v
+	}
+
+	c.Namespaces[namespace] = nodeID
+
+	if err := c.init(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func hash(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+
+	// FNV spreads similar strings poorly, so the bits are mixed with the splitmix64 finalizer
+	x := h.Sum64()
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+
+	return x ^ (x >> 31)
+}
@@ -0,0 +1,104 @@
+package shardmap_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/locktopus-project/locktopus/pkg/shardmap"
+)
+
+func parse(t *testing.T, s string) *shardmap.Map {
+	m, err := shardmap.Parse([]byte(s))
+	if err != nil {
+		t.Fatalf("cannot parse shard map: %s", err)
+	}
+
+	return m
+}
+
+const threeNodes = `{"version": 1, "nodes": [{"id": "a", "url": "http://a"}, {"id": "b", "url": "http://b"}, {"id": "c", "url": "http://c"}]}`
+const fourNodes = `{"version": 2, "nodes": [{"id": "a", "url": "http://a"}, {"id": "b", "url": "http://b"}, {"id": "c", "url": "http://c"}, {"id": "d", "url": "http://d"}]}`
+
+func TestShardMap_Distribution(t *testing.T) {
+	m := parse(t, threeNodes)
+
+	counts := make(map[string]int)
+	for i := 0; i < 3000; i++ {
+		counts[m.Locate(fmt.Sprintf("namespace%d", i)).ID]++
+	}
+
+	for _, id := range []string{"a", "b", "c"} {
+		if counts[id] < 600 || counts[id] > 1400 {
+			t.Fatalf("namespaces are distributed unevenly: %v", counts)
+		}
+	}
+}
+
+func TestShardMap_AddingNodeMovesNamespacesToIt(t *testing.T) {
+	before := parse(t, threeNodes)
+	after := parse(t, fourNodes)
+
+	moved := 0
+	for i := 0; i < 3000; i++ {
+		namespace := fmt.Sprintf("namespace%d", i)
+
+		from, to := before.Locate(namespace), after.Locate(namespace)
+		if from.ID == to.ID {
+			continue
+		}
+
+		if to.ID != "d" {
+			t.Fatalf("namespace %s moved from %s to %s instead of the new node", namespace, from.ID, to.ID)
+		}
+
+		moved++
+	}
+
+	if moved == 0 || moved > 1500 {
+		t.Fatalf("%d of 3000 namespaces moved", moved)
+	}
+}
+
+func TestShardMap_PinnedNamespace(t *testing.T) {
+	m := parse(t, threeNodes)
+
+	owner := m.Locate("billing")
+	target := "a"
+	if owner.ID == "a" {
+		target = "b"
+	}
+
+	pinned, err := m.WithNamespace("billing", target)
+	if err != nil {
+		t.Fatalf("cannot pin namespace: %s", err)
+	}
+
+	if pinned.Locate("billing").ID != target {
+		t.Fatalf("pinned namespace should be located on %s", target)
+	}
+
+	if pinned.Version != m.Version+1 {
+		t.Fatalf("version should be incremented")
+	}
+
+	if m.Locate("billing").ID != owner.ID {
+		t.Fatalf("original map should not be changed")
+	}
+
+	if _, err = m.WithNamespace("billing", "unknown"); err == nil {
+		t.Fatalf("namespace should not be pinned to unknown node")
+	}
+}
+
+func TestShardMap_Invalid(t *testing.T) {
+	for _, s := range []string{
+		`{"nodes": []}`,
+		`{"nodes": [{"id": "a"}]}`,
+		`{"nodes": [{"id": "a", "url": "http://a"}, {"id": "a", "url": "http://b"}]}`,
+		`{"nodes": [{"id": "a", "url": "http://a"}], "namespaces": {"x": "b"}}`,
+	} {
+		if _, err := shardmap.Parse([]byte(s)); err == nil {
+			t.Fatalf("shard map should be invalid: %s", s)
+		}
+	}
+}